@@ -5,12 +5,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"os"
 	"strings"
 
 	"crow/internal/agent"
+	llm2 "crow/internal/agent/llm"
 	"crow/internal/agent/llm/openai"
 	"crow/internal/agent/prompt"
 	"crow/internal/agent/react"
+	"crow/internal/agent/schema"
 	"crow/internal/config"
 	log2 "crow/pkg/log"
 	"crow/pkg/util"
@@ -23,7 +26,10 @@ func main() {
 	}
 
 	agt := NewCLI(cfg)
-	agt.InitAgent()
+	if err := agt.InitAgent(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to init agent: %v\n", err)
+		os.Exit(1)
+	}
 
 	var (
 		userPrompt string
@@ -55,6 +61,7 @@ func main() {
 
 type CLI struct {
 	cfg   *config.Config
+	name  string // 助手名称
 	agent agent.Provider
 	reply string
 	stop  chan struct{}
@@ -67,18 +74,26 @@ func NewCLI(cfg *config.Config) *CLI {
 	}
 }
 
-func (c *CLI) InitAgent() {
-	var llmCfg config.LLMConfig
-	if v, ok := c.cfg.SelectedModule["llm"]; ok {
-		if _, ok = c.cfg.LLM[v]; ok {
-			llmCfg = c.cfg.LLM[v]
-		}
+// InitAgent 初始化 agent，大模型配置有误时返回错误；MCP 不可用时仅使用内置工具继续对话
+func (c *CLI) InitAgent() error {
+	llmName := c.cfg.SelectedModule["llm"]
+	llmCfg, ok := c.cfg.LLM[llmName]
+	if !ok {
+		return fmt.Errorf("llm %q selected in selected_module is not configured", llmName)
+	}
+	if llmCfg.Model == "" || llmCfg.BaseURL == "" || llmCfg.APIKey == "" {
+		return fmt.Errorf("llm %q requires model, base_url and api_key", llmName)
 	}
 	llm := openai.NewOpenAI(llmCfg.Model, llmCfg.APIKey, llmCfg.BaseURL)
-	mcpReAct, err := react.NewMCPAgent(context.Background(), nil)
+	httpClient, err := llm2.NewHTTPClient(llmCfg.TLS.InsecureSkipVerify, llmCfg.TLS.CAFile, llmCfg.Proxy)
 	if err != nil {
-		fmt.Printf("failed to create mcp agent: %v\n", err)
-		return
+		return fmt.Errorf("llm %q tls or proxy config is invalid: %v", llmName, err)
+	}
+	llm.SetHTTPClient(httpClient)
+	mcpReAct, err := react.NewMCPAgent(context.Background(), nil, c.cfg.Tools)
+	if err != nil {
+		fmt.Printf("warning: failed to create mcp agent, continuing with built-in tools only: %v\n", err)
+		mcpReAct = react.NewBuiltinAgent(c.cfg.Tools)
 	}
 
 	type toolInfo struct {
@@ -99,18 +114,43 @@ func (c *CLI) InitAgent() {
 		toolPrompt += fmt.Sprintf(toolDesc, string(jsonData))
 	}
 
+	examples := make([]schema.Message, 0, len(c.cfg.Agent.Examples)*2)
+	for _, example := range c.cfg.Agent.Examples {
+		if example.User == "" || example.Assistant == "" {
+			continue
+		}
+		examples = append(examples, schema.UserMessage(example.User, ""), schema.AssistantMessage(example.Assistant, ""))
+	}
+
 	logger := log2.NewLogger(&log2.Option{
 		Hook:        nil,
 		Mode:        c.cfg.Server.Mode,
 		ServiceName: "crow",
-		EncodeType:  log2.EncodeTypeConsole,
+		EncodeType:  log2.ParseEncodeType(c.cfg.Log.Encoding, log2.EncodeTypeConsole),
 	})
-	c.agent = react.NewReActAgent("crow", logger, llm, mcpReAct,
-		react.WithSystemPrompt(fmt.Sprintf(prompt.SystemPrompt, toolPrompt)),
+	c.name = c.cfg.AssistantName
+	if c.name == "" {
+		c.name = prompt.DefaultAssistantName
+	}
+	opts := []react.Option{
+		react.WithSystemPrompt(prompt.NewSystemPrompt(c.name, toolPrompt)),
 		react.WithNextStepPrompt(prompt.NextStepPrompt),
+		react.WithSkipFirstStepPrompt(c.cfg.Agent.SkipFirstStepPrompt),
+		react.WithContentSanitizer(!c.cfg.Agent.DisableContentSanitizer),
 		react.WithMaxObserve(500),
-		react.WithMemoryMaxMessages(20))
+		react.WithStreamIdleTimeout(llmCfg.StreamIdleTimeout),
+		react.WithStopSequences(llmCfg.Stop...),
+		react.WithReasoning(llmCfg.ReasoningEffort, llmCfg.MaxReasoningTokens),
+		react.WithExamples(examples...),
+		react.WithMaxRunDuration(c.cfg.Agent.MaxRunDuration),
+		react.WithMemoryMaxMessages(c.cfg.Memory.MaxMessages),
+	}
+	if c.cfg.Agent.InjectDateTime {
+		opts = append(opts, react.WithInjectDateTime(c.cfg.Server.Timezone))
+	}
+	c.agent = react.NewReActAgent(c.name, logger, llm, mcpReAct, opts...)
 	c.agent.SetListener(c)
+	return nil
 }
 
 func (c *CLI) OnAgentResult(ctx context.Context, text string, state agent.State) bool {
@@ -118,7 +158,7 @@ func (c *CLI) OnAgentResult(ctx context.Context, text string, state agent.State)
 		return false
 	}
 	c.reply += text
-	fmt.Printf("\r【Crow】: %s", c.reply)
+	fmt.Printf("\r【%s】: %s", c.name, c.reply)
 
 	if state == agent.StateCompleted {
 		c.reply = ""
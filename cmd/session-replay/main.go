@@ -0,0 +1,81 @@
+// session-replay 重放 server.record_dir 录制的会话，用于复现问题
+//
+// 客户端消息按录制的时间点送入，ASR、大模型与TTS均使用回放录制结果的模拟服务，不访问任何服务商，
+// 重放结束后按消息类型逐条比对下发的消息与录制是否一致，不一致时以非0状态码退出
+//
+//	go run cmd/session-replay/main.go -f records/<会话ID>.jsonl [-speed 2] [-v]
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"crow/internal/config"
+	"crow/internal/handler"
+	"crow/internal/handler/replaytest"
+	log2 "crow/pkg/log"
+)
+
+func main() {
+	file := flag.String("f", "", "recording file written by server.record_dir")
+	speed := flag.Float64("speed", 1, "replay speed multiplier")
+	verbose := flag.Bool("v", false, "print every replayed outbound message")
+	flag.Parse()
+	if *file == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg := config.NewConfig()
+	if cfg == nil {
+		panic("failed to load config")
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open recording: %v\n", err)
+		os.Exit(1)
+	}
+	entries, err := handler.ReadRecording(f)
+	_ = f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	logger := log2.NewLogger(&log2.Option{
+		Mode:        cfg.Server.Mode,
+		ServiceName: "crow",
+		EncodeType:  log2.ParseEncodeType(cfg.Log.Encoding, log2.EncodeTypeConsole),
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var replayed []handler.RecordEntry
+	replaytest.Replay(ctx, cfg, logger, entries, *speed, func(entry handler.RecordEntry) {
+		replayed = append(replayed, entry)
+		if *verbose {
+			fmt.Printf("[%6dms] %s\n", entry.Time, replaytest.Summary(entry))
+		}
+	})
+
+	var recorded []handler.RecordEntry
+	for _, entry := range entries {
+		if entry.Dir == handler.RecordDirOut {
+			recorded = append(recorded, entry)
+		}
+	}
+	if diffs := replaytest.Compare(recorded, replayed); len(diffs) > 0 {
+		fmt.Printf("replay differs from recording (%d recorded, %d replayed outbound messages):\n", len(recorded), len(replayed))
+		for _, d := range diffs {
+			fmt.Println("  " + d)
+		}
+		os.Exit(1)
+	}
+	fmt.Printf("replay matches recording, %d outbound messages\n", len(replayed))
+}
@@ -10,6 +10,7 @@ import (
 	"syscall"
 	"time"
 
+	"crow/internal/agent/react"
 	"crow/internal/config"
 	"crow/internal/router"
 )
@@ -20,12 +21,14 @@ func main() {
 		panic("failed to load config")
 	}
 
-	r := router.NewRouter(cfg)
+	r, ws := router.NewRouter(cfg)
 	s := http.Server{
 		Addr:           cfg.Server.IP + ":" + cfg.Server.Port,
 		Handler:        r,
 		MaxHeaderBytes: 1 << 20,
 	}
+	// websocket 连接不受 Shutdown 管理，需要主动通知并关闭
+	s.RegisterOnShutdown(ws.Shutdown)
 
 	go func() {
 		if err := s.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
@@ -33,7 +36,16 @@ func main() {
 		}
 	}()
 
-	quit := make(chan os.Signal)
+	// 预先缓存MCP工具定义，避免首个会话承担连接全部MCP服务器的延迟
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := react.PreloadMCPTools(ctx, cfg.Tools); err != nil {
+			log.Printf("failed to preload mcp tools: %v", err)
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM) // 接收系统信号量
 	<-quit
 	log.Println("shutting down server...")
@@ -43,6 +55,7 @@ func main() {
 	if err := s.Shutdown(ctx); err != nil {
 		log.Fatal("server forced to shutdown:", err)
 	}
+	react.CloseSharedMCP()
 
 	log.Println("server exiting")
 }
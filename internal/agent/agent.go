@@ -21,6 +21,72 @@ type Listener interface {
 	OnAgentResult(ctx context.Context, text string, state State) bool
 }
 
+// TerminateListener 可选的监听者，用于获知交互已真正结束
+// 开启结束前确认时，用户确认没有其他需要或超时未回复后回调，回调可能来自计时器协程
+type TerminateListener interface {
+	// OnAgentTerminate 交互结束回调
+	// @param timeout 是否因超时未回复而结束
+	OnAgentTerminate(ctx context.Context, timeout bool)
+}
+
+// NotifyTerminate 若监听者实现了 TerminateListener，则回调交互结束
+func NotifyTerminate(ctx context.Context, listener Listener, timeout bool) {
+	if l, ok := listener.(TerminateListener); ok {
+		l.OnAgentTerminate(ctx, timeout)
+	}
+}
+
+// ToolCall 工具调用事件
+type ToolCall struct {
+	ID        string // 工具调用ID，同一次调用的开始与结束事件相同
+	Name      string // 工具名称
+	Arguments string // JSON格式的调用参数
+	Finished  bool   // false：开始执行，true：执行结束
+	Failed    bool   // 是否执行失败，仅在执行结束时有效
+	Result    string // 执行结果，仅在执行结束时有效
+}
+
+// ToolListener 可选的监听者，用于获知正在执行的工具，如在界面上展示“正在搜索……”
+// 每次执行工具前后各回调一次，结束交互等特殊工具不回调
+type ToolListener interface {
+	// OnAgentToolCall 工具调用回调
+	OnAgentToolCall(ctx context.Context, call ToolCall)
+}
+
+// NotifyToolCall 若监听者实现了 ToolListener，则回调工具调用事件
+func NotifyToolCall(ctx context.Context, listener Listener, call ToolCall) {
+	if l, ok := listener.(ToolListener); ok {
+		l.OnAgentToolCall(ctx, call)
+	}
+}
+
+// ReasoningListener 可选的监听者，用于获知推理模型的思考过程，如在调试面板中展示
+// 思考过程不会通过 OnAgentResult 回调，因此不会被播报
+type ReasoningListener interface {
+	// OnAgentReasoning 思考过程的分片回调
+	OnAgentReasoning(ctx context.Context, text string)
+}
+
+// NotifyReasoning 若监听者实现了 ReasoningListener，则回调思考过程
+func NotifyReasoning(ctx context.Context, listener Listener, text string) {
+	if l, ok := listener.(ReasoningListener); ok {
+		l.OnAgentReasoning(ctx, text)
+	}
+}
+
+// ToolChoiceSetter 可选的 Provider 扩展，用于指定下一轮对话的工具选择方式，如强制调用预订工具，或追问时不调用工具
+type ToolChoiceSetter interface {
+	// SetNextToolChoice 指定下一轮对话第一步的工具选择方式，只对下一轮生效，之后恢复默认
+	// @param choice none、auto、required 或工具名称（强制调用该工具）
+	SetNextToolChoice(choice string) error
+}
+
+// Closer 可选的 Provider 扩展，用于在会话结束时释放各轮对话之间保持的资源，如 MCP 服务器的连接
+type Closer interface {
+	// Close 释放资源，会话结束时调用一次
+	Close() error
+}
+
 // Provider Agent提供者
 // 服务端流式Agent，一次文本请求，多次响应
 type Provider interface {
@@ -0,0 +1,54 @@
+package llm
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"crow/pkg/netproxy"
+)
+
+var (
+	httpClientsLock sync.Mutex
+	// httpClients 按TLS及代理配置复用的 HTTP 客户端，使各会话共用连接池，k: 是否跳过证书校验、CA文件路径及代理地址
+	httpClients = map[string]*http.Client{}
+)
+
+// NewHTTPClient 创建请求模型服务的 HTTP 客户端，用于使用内部CA证书的私有化网关或需要经过代理的网络
+// 相同的配置复用同一个客户端
+// @param insecureSkipVerify 是否跳过证书校验，存在中间人攻击的风险，仅用于测试环境
+// @param caFile 额外信任的CA证书文件（PEM格式），在系统证书的基础上追加
+// @param proxy 单独指定的代理地址，direct 为不使用代理，为空则使用全局的代理设置
+func NewHTTPClient(insecureSkipVerify bool, caFile, proxy string) (*http.Client, error) {
+	key := fmt.Sprintf("%v|%s|%s", insecureSkipVerify, caFile, proxy)
+	httpClientsLock.Lock()
+	defer httpClientsLock.Unlock()
+	if client, ok := httpClients[key]; ok {
+		return client, nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca file: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid certificate found in ca file %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.Proxy = netproxy.Func(proxy)
+	client := &http.Client{Transport: transport}
+	httpClients[key] = client
+	return client, nil
+}
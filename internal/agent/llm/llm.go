@@ -2,19 +2,59 @@ package llm
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"crow/internal/agent/schema"
 )
 
+// ErrStreamStalled 流式响应在 Request.IdleTimeout 内没有收到任何分片，请求已被中止
+// 此时 Handle 仍会返回已收到的回复内容，已下发的分片不受影响
+var ErrStreamStalled = errors.New("llm stream stalled")
+
+// 推理模型的推理强度，强度越低，首个可见分片越早到达，消耗的推理token越少
+const (
+	ReasoningEffortLow    = "low"
+	ReasoningEffortMedium = "medium"
+	ReasoningEffortHigh   = "high"
+)
+
+var reasoningEffortLevels = map[string]int{ReasoningEffortLow: 1, ReasoningEffortMedium: 2, ReasoningEffortHigh: 3}
+
+// LimitReasoningEffort 在上限内选择推理强度
+// @param limit 推理强度的上限，为空则不限制
+// @param effort 期望的推理强度，无效或高于上限时使用上限
+func LimitReasoningEffort(limit, effort string) string {
+	level, ok := reasoningEffortLevels[effort]
+	if !ok {
+		return limit
+	}
+	if limitLevel, ok := reasoningEffortLevels[limit]; ok && level > limitLevel {
+		return limit
+	}
+	return effort
+}
+
 // Request 大模型请求
 type Request struct {
 	// IsSupportImages 是否支持图片
 	IsSupportImages bool
 	// Timeout 模型请求超时时间, 默认300秒
 	Timeout time.Duration
+	// IdleTimeout 流式响应中相邻两个分片（包括首个分片）的最长间隔，超过则中止请求并返回 ErrStreamStalled，0为不限制
+	IdleTimeout time.Duration
+	// Stop 停止序列，回复中出现任意一个时立即结束生成，停止序列本身不会输出
+	Stop []string
+	// ReasoningEffort 推理强度，low、medium 或 high，为空则使用服务商默认值，仅对推理模型生效
+	ReasoningEffort string
+	// MaxReasoningTokens 推理过程最多消耗的token数，0为不限制，仅对支持推理预算的服务商生效
+	MaxReasoningTokens int
+	// StreamReasoning 是否单独下发推理模型的思考过程，开启后可通过 DeltaReceiver.RecvDelta 接收，Recv 始终只返回回复内容
+	StreamReasoning bool
 	// ToolChoice 工具调用方式，默认auto
 	ToolChoice schema.ToolChoice
+	// ToolName 强制调用的工具名称，不为空时 ToolChoice 须为 required，模型只能调用该工具
+	ToolName string
 	// Tools // 需要调用的工具
 	Tools []schema.Tool
 	// SystemMessage 系统消息--可不设置
@@ -31,8 +71,23 @@ type Response struct {
 	ToolCalls []schema.ToolCall
 }
 
+// Delta 流式响应的分片，回复内容与思考过程同一时刻只有一个不为空
+type Delta struct {
+	Content   string // 回复内容
+	Reasoning string // 推理模型的思考过程，不应作为回复播报
+}
+
+// DeltaReceiver 可选的 LLM 扩展，支持接收包括思考过程在内的流式分片
+type DeltaReceiver interface {
+	// RecvDelta 接收模型响应的分片，与 Recv 二选一使用
+	// @return error: 接收过程中的错误，如果错误为 io.EOF 则表示模型响应结束
+	RecvDelta() (Delta, error)
+}
+
 // LLM 大模型接口，采用流式处理
 type LLM interface {
+	// Name 大模型接口的稳定标识，如 openai，用于日志与监控
+	Name() string
 	// Handle 处理用户请求
 	// @param messages: 请求的消息列表(上下文消息)
 	Handle(ctx context.Context, request *Request) (*Response, error)
@@ -41,6 +96,7 @@ type LLM interface {
 	// @return string: 模型响应的回复语
 	// @return error: 接收过程中的错误，如果错误为 io.EOF 则表示模型响应结束
 	Recv() (string, error)
-	// Reset 重置 LLM
+	// Reset 结束当前的回复，等待中的 Recv 返回 io.EOF，之后的内容不再写入，并为下一次请求准备新的回复
+	// 每次请求前调用，提前停止接收回复时也需调用，否则仍在输出的请求可能阻塞
 	Reset() error
 }
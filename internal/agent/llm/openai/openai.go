@@ -2,11 +2,14 @@ package openai
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"crow/internal/agent/llm"
@@ -14,9 +17,11 @@ import (
 
 	"github.com/openai/openai-go"
 	"github.com/openai/openai-go/option"
+	"github.com/openai/openai-go/shared"
 )
 
-const finalFlag = "--end--"
+// maxStopSequences 接口支持的最大停止序列数
+const maxStopSequences = 4
 
 type OpenAI struct {
 	model       string
@@ -27,13 +32,18 @@ type OpenAI struct {
 	apiVersion  string // Azure Openai version if AzureOpenai
 	baseURL     string
 
-	maxReties int // 最大重试次数
+	maxReties  int          // 最大重试次数
+	httpClient *http.Client // 自定义的 HTTP 客户端，为 nil 则使用默认客户端
+	// maxCompletionTokens 单次回复最多生成的token数（含推理过程），0为不传递，使用服务商的默认值
+	maxCompletionTokens int64
+	headers             map[string]string // 每次请求附加的请求头，如网关要求的租户标识、路由键
 	// token计算相关属性
 	totalInputTokens      int64
 	totalCompletionTokens int64
 	maxInputTokens        int64
 
-	replyCh chan string
+	replyCh chan reply
+	done    chan struct{} // Reset 时关闭，结束本轮回复的收发
 	lock    sync.Mutex
 }
 
@@ -44,10 +54,36 @@ func NewOpenAI(model, apiKey, baseUrl string) *OpenAI {
 		apiKey:    apiKey,
 		baseURL:   baseUrl,
 		maxReties: 3,
-		replyCh:   make(chan string, 10),
+		replyCh:   make(chan reply, 10),
+		done:      make(chan struct{}),
 	}
 }
 
+// reply 回复通道中的消息，以 end 标记本次回复结束而非约定的特殊文本，模型输出的任何内容都不会被误判为结束或作为标记下发
+type reply struct {
+	delta llm.Delta
+	end   bool
+}
+
+// SetMaxCompletionTokens 设置单次回复最多生成的token数，0为不限制
+func (o *OpenAI) SetMaxCompletionTokens(n int) {
+	o.maxCompletionTokens = int64(max(n, 0))
+}
+
+// SetHeaders 设置每次请求附加的请求头，用于按请求头鉴权或路由的网关
+func (o *OpenAI) SetHeaders(headers map[string]string) {
+	o.headers = headers
+}
+
+// SetHTTPClient 使用自定义的 HTTP 客户端请求模型服务，如需要信任内部CA证书的私有化网关
+func (o *OpenAI) SetHTTPClient(client *http.Client) {
+	o.httpClient = client
+}
+
+func (o *OpenAI) Name() string {
+	return "openai"
+}
+
 func (o *OpenAI) Handle(ctx context.Context, request *llm.Request) (*llm.Response, error) {
 	if request.ToolChoice == "" {
 		request.ToolChoice = schema.ToolChoiceAuto
@@ -80,34 +116,136 @@ func (o *OpenAI) Handle(ctx context.Context, request *llm.Request) (*llm.Respons
 		return nil, fmt.Errorf("failed to format messages: %v", err)
 	}
 
-	client := openai.NewClient(
+	clientOpts := []option.RequestOption{
 		option.WithBaseURL(o.baseURL),
 		option.WithAPIKey(o.apiKey),
 		option.WithMaxRetries(o.maxReties),
 		option.WithRequestTimeout(request.Timeout),
+	}
+	if o.httpClient != nil {
+		clientOpts = append(clientOpts, option.WithHTTPClient(o.httpClient))
+	}
+	for k, v := range o.headers {
+		clientOpts = append(clientOpts, option.WithHeader(k, v))
+	}
+	client := openai.NewClient(clientOpts...)
+	params := openai.ChatCompletionNewParams{
+		Model:       o.model,
+		Messages:    formattedMessages,
+		Temperature: openai.Float(o.temperature),
+		MaxTokens:   openai.Int(o.maxTokens),
+	}
+	// 未设置时不传递，部分服务商会将0视为不允许生成任何token而返回空回复
+	if o.maxCompletionTokens > 0 {
+		params.MaxCompletionTokens = openai.Int(o.maxCompletionTokens)
+	}
+	// 没有工具时不传递tool_choice，部分模型服务不允许单独设置该参数
+	if len(tools) > 0 {
+		params.Tools = tools
+		params.ToolChoice = openai.ChatCompletionToolChoiceOptionUnionParam{OfAuto: openai.String(string(request.ToolChoice))}
+		if request.ToolName != "" && request.ToolChoice == schema.ToolChoiceRequired {
+			params.ToolChoice = openai.ChatCompletionToolChoiceOptionUnionParam{
+				OfChatCompletionNamedToolChoice: &openai.ChatCompletionNamedToolChoiceParam{
+					Function: openai.ChatCompletionNamedToolChoiceFunctionParam{Name: request.ToolName},
+				},
+			}
+		}
+	}
+	// 接口最多支持4个停止序列，全部停止序列均会在客户端再次检查
+	if len(request.Stop) > 0 {
+		params.Stop = openai.ChatCompletionNewParamsStopUnion{OfStringArray: request.Stop[:min(len(request.Stop), maxStopSequences)]}
+	}
+	if request.ReasoningEffort != "" {
+		params.ReasoningEffort = shared.ReasoningEffort(request.ReasoningEffort)
+	}
+	var reqOpts []option.RequestOption
+	// 推理预算不是 OpenAI 的标准参数，以兼容模式服务（如 DashScope）的 thinking_budget 传递
+	if request.MaxReasoningTokens > 0 {
+		reqOpts = append(reqOpts, option.WithJSONSet("thinking_budget", request.MaxReasoningTokens))
+	}
+	// 本轮回复写入请求开始时的通道，Reset 之后的内容直接丢弃
+	replyCh, done := o.channels()
+	send := func(r reply) {
+		select {
+		case replyCh <- r:
+		case <-done:
+		}
+	}
+	stopScanner := llm.NewStopScanner(request.Stop)
+	stopped := false
+	// 流式响应停滞时中止请求，避免一直等到请求超时
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		stalled  int32
+		watchdog *time.Timer
 	)
-	stream := client.Chat.Completions.NewStreaming(ctx, openai.ChatCompletionNewParams{
-		Model:               o.model,
-		Messages:            formattedMessages,
-		Temperature:         openai.Float(o.temperature),
-		MaxTokens:           openai.Int(o.maxTokens),
-		MaxCompletionTokens: openai.Int(o.totalCompletionTokens),
-		Tools:               tools,
-		ToolChoice:          openai.ChatCompletionToolChoiceOptionUnionParam{OfAuto: openai.String(string(request.ToolChoice))},
-	})
+	if request.IdleTimeout > 0 {
+		watchdog = time.AfterFunc(request.IdleTimeout, func() {
+			atomic.StoreInt32(&stalled, 1)
+			cancel()
+		})
+	}
+
+	stream := client.Chat.Completions.NewStreaming(streamCtx, params, reqOpts...)
 	// 累加器
 	acc := openai.ChatCompletionAccumulator{}
 	for stream.Next() {
+		if watchdog != nil {
+			watchdog.Reset(request.IdleTimeout)
+		}
 		chunk := stream.Current()
 		acc.AddChunk(chunk)
 
 		// it's best to use chunks after handling JustFinished events
+		if request.StreamReasoning && len(chunk.Choices) > 0 {
+			if reasoning := reasoningContent(chunk.Choices[0].Delta); reasoning != "" {
+				send(reply{delta: llm.Delta{Reasoning: reasoning}})
+			}
+		}
 		if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
-			o.replyCh <- chunk.Choices[0].Delta.Content
+			var content string
+			content, stopped = stopScanner.Write(chunk.Choices[0].Delta.Content)
+			if content != "" {
+				send(reply{delta: llm.Delta{Content: content}})
+			}
+			if stopped {
+				// 遇到停止序列，不再接收后续内容
+				cancel()
+				break
+			}
 		}
 	}
-	o.replyCh <- finalFlag
+	if !stopped {
+		if content := stopScanner.Flush(); content != "" {
+			send(reply{delta: llm.Delta{Content: content}})
+		}
+	}
+	send(reply{end: true})
+	if watchdog != nil {
+		watchdog.Stop()
+	}
 
+	if atomic.LoadInt32(&stalled) == 1 && stream.Err() != nil {
+		// 工具调用的参数可能不完整，只保留已收到的回复内容
+		resp := &llm.Response{}
+		if len(acc.Choices) > 0 {
+			resp.Content = acc.Choices[0].Message.Content
+		}
+		return resp, fmt.Errorf("%w: no chunk received in %v", llm.ErrStreamStalled, request.IdleTimeout)
+	}
+	if stream.Err() != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		// 调用方设置的截止时间已到，同样只保留已收到的回复内容，由调用方决定如何答复
+		resp := &llm.Response{}
+		if len(acc.Choices) > 0 {
+			resp.Content = acc.Choices[0].Message.Content
+		}
+		return resp, fmt.Errorf("stream error: %w", ctx.Err())
+	}
+	if stopped {
+		// 停止序列之后的工具调用可能不完整，只保留停止序列之前的回复内容
+		return &llm.Response{Content: stopScanner.Content()}, nil
+	}
 	if stream.Err() != nil {
 		return nil, fmt.Errorf("stream error: %v", stream.Err())
 	}
@@ -131,21 +269,58 @@ func (o *OpenAI) Handle(ctx context.Context, request *llm.Request) (*llm.Respons
 }
 
 func (o *OpenAI) Recv() (string, error) {
-	reply, ok := <-o.replyCh
+	for {
+		delta, err := o.RecvDelta()
+		if err != nil {
+			return "", err
+		}
+		// 思考过程不作为回复返回
+		if delta.Reasoning == "" {
+			return delta.Content, nil
+		}
+	}
+}
+
+func (o *OpenAI) RecvDelta() (llm.Delta, error) {
+	replyCh, done := o.channels()
+	select {
+	case r := <-replyCh:
+		if r.end {
+			return llm.Delta{}, io.EOF
+		}
+		return r.delta, nil
+	case <-done:
+		return llm.Delta{}, io.EOF
+	}
+}
+
+// channels 当前轮次的回复通道
+func (o *OpenAI) channels() (chan reply, chan struct{}) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.replyCh, o.done
+}
+
+// reasoningContent 推理模型的思考过程，不是 OpenAI 的标准字段，兼容模式服务（如 DashScope、DeepSeek）以 reasoning_content 返回
+func reasoningContent(delta openai.ChatCompletionChunkChoiceDelta) string {
+	field, ok := delta.JSON.ExtraFields["reasoning_content"]
 	if !ok {
-		return "", io.EOF
+		return ""
 	}
-	if reply == finalFlag {
-		return "", io.EOF
+	var reasoning string
+	if err := json.Unmarshal([]byte(field.Raw()), &reasoning); err != nil {
+		return ""
 	}
-	return reply, nil
+	return reasoning
 }
 
+// Reset 结束本轮回复，等待中的 Recv 返回 io.EOF，并为之后的对话轮次准备新的回复通道
 func (o *OpenAI) Reset() error {
-	defer func() {
-		_ = recover()
-	}()
-	close(o.replyCh)
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	close(o.done)
+	o.replyCh = make(chan reply, 10)
+	o.done = make(chan struct{})
 	return nil
 }
 
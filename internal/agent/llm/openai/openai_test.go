@@ -0,0 +1,168 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"crow/internal/agent/llm"
+	"crow/internal/agent/schema"
+)
+
+// captureServer 模拟 OpenAI 兼容的流式接口，记录最近一次请求的请求体，回复为空
+func captureServer(t *testing.T) (*httptest.Server, func() map[string]any) {
+	t.Helper()
+	bodies := make(chan []byte, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(ts.Close)
+	return ts, func() map[string]any {
+		var params map[string]any
+		if err := json.Unmarshal(<-bodies, &params); err != nil {
+			t.Fatal(err)
+		}
+		return params
+	}
+}
+
+func TestMaxCompletionTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+		want any // nil 表示请求中不携带该参数
+	}{
+		{"unset", 0, nil},
+		{"negative", -1, nil},
+		{"set", 256, float64(256)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, lastRequest := captureServer(t)
+			o := NewOpenAI("test", "key", ts.URL)
+			o.SetMaxCompletionTokens(tt.n)
+
+			request := &llm.Request{Messages: []schema.Message{schema.UserMessage("你好", "")}}
+			if _, err := o.Handle(context.Background(), request); err != nil {
+				t.Fatalf("handle: %v", err)
+			}
+			got, ok := lastRequest()["max_completion_tokens"]
+			if tt.want == nil {
+				// 未设置时不传递，部分服务商会将0视为不允许生成任何token
+				if ok {
+					t.Fatalf("max_completion_tokens = %v, want omitted", got)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Fatalf("max_completion_tokens = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamIdleTimeout(t *testing.T) {
+	// 下发首个分片后不再响应，直至请求被中止
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = fmt.Fprint(w, `data: {"id":"chatcmpl-test","object":"chat.completion.chunk","model":"test","choices":[{"index":0,"delta":{"content":"今天"}}]}`+"\n\n")
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer ts.Close()
+	o := NewOpenAI("test", "key", ts.URL)
+
+	start := time.Now()
+	resp, err := o.Handle(context.Background(), &llm.Request{
+		IdleTimeout: 100 * time.Millisecond,
+		Messages:    []schema.Message{schema.UserMessage("今天天气怎么样", "")},
+	})
+	if !errors.Is(err, llm.ErrStreamStalled) {
+		t.Fatalf("handle error = %v, want ErrStreamStalled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("handle took %v, want it aborted soon after idle timeout", elapsed)
+	}
+	// 停滞前已收到的回复内容仍会返回
+	if resp == nil || resp.Content != "今天" {
+		t.Fatalf("response = %+v, want partial content", resp)
+	}
+}
+
+func TestStopSequence(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		// 服务端未遵守 stop 参数，且停止序列跨越两个分片
+		for _, content := range []string{"好的。\nUs", "er: 你好"} {
+			_, _ = fmt.Fprintf(w, `data: {"id":"chatcmpl-test","object":"chat.completion.chunk","model":"test","choices":[{"index":0,"delta":{"content":%q}}]}`+"\n\n", content)
+		}
+		_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer ts.Close()
+	o := NewOpenAI("test", "key", ts.URL)
+
+	resp, err := o.Handle(context.Background(), &llm.Request{
+		Stop:     []string{"\nUser:"},
+		Messages: []schema.Message{schema.UserMessage("你好", "")},
+	})
+	if err != nil {
+		t.Fatalf("handle: %v", err)
+	}
+	if resp.Content != "好的。" {
+		t.Fatalf("content = %q, want %q", resp.Content, "好的。")
+	}
+	var reply string
+	for {
+		text, err := o.Recv()
+		if err != nil {
+			break
+		}
+		reply += text
+	}
+	if reply != "好的。" {
+		t.Fatalf("streamed reply = %q, want %q", reply, "好的。")
+	}
+}
+
+func TestRecvEndMarkerAsContent(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, content := range []string{"--end--", "好的"} {
+			_, _ = fmt.Fprintf(w, `data: {"id":"chatcmpl-test","object":"chat.completion.chunk","model":"test","choices":[{"index":0,"delta":{"content":%q}}]}`+"\n\n", content)
+		}
+		_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer ts.Close()
+	o := NewOpenAI("test", "key", ts.URL)
+
+	if _, err := o.Handle(context.Background(), &llm.Request{Messages: []schema.Message{schema.UserMessage("你好", "")}}); err != nil {
+		t.Fatalf("handle: %v", err)
+	}
+	// 模型输出的任意文本都作为回复内容，仅在回复结束时返回 io.EOF
+	var got []string
+	for {
+		text, err := o.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("recv: %v", err)
+		}
+		got = append(got, text)
+	}
+	if len(got) != 2 || got[0] != "--end--" || got[1] != "好的" {
+		t.Fatalf("replies = %q, want [--end-- 好的]", got)
+	}
+}
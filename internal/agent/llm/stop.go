@@ -0,0 +1,87 @@
+package llm
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// StopScanner 在客户端逐片扫描流式回复中的停止序列，服务商不支持或未严格遵守 stop 参数时同样能够提前结束
+// 可能构成停止序列前缀的末尾内容会暂不输出，直至确认其不属于停止序列
+type StopScanner struct {
+	stops   []string
+	maxLen  int
+	text    strings.Builder
+	emitted int // 已输出的字节数
+}
+
+// NewStopScanner 创建停止序列扫描器，stops 为空时返回 nil，nil 扫描器原样输出全部内容
+func NewStopScanner(stops []string) *StopScanner {
+	s := &StopScanner{}
+	for _, stop := range stops {
+		if stop == "" {
+			continue
+		}
+		s.stops = append(s.stops, stop)
+		s.maxLen = max(s.maxLen, len(stop))
+	}
+	if len(s.stops) == 0 {
+		return nil
+	}
+	return s
+}
+
+// Write 写入一个分片
+// @return out 可以输出的内容
+// @return stopped 是否遇到了停止序列，遇到后应结束流式响应，out 为停止序列之前尚未输出的内容
+func (s *StopScanner) Write(delta string) (out string, stopped bool) {
+	if s == nil {
+		return delta, false
+	}
+	s.text.WriteString(delta)
+	text := s.text.String()
+
+	// 停止序列可能跨越多个分片，从可能的最早位置开始查找
+	from := max(s.emitted-s.maxLen+1, 0)
+	if idx := s.index(text[from:]); idx >= 0 {
+		end := from + idx
+		out = text[min(s.emitted, end):end]
+		s.emitted = end
+		return out, true
+	}
+
+	// 保留可能构成停止序列前缀的末尾内容，并保证不截断多字节字符
+	safe := max(len(text)-s.maxLen+1, s.emitted)
+	for safe > s.emitted && safe < len(text) && !utf8.RuneStart(text[safe]) {
+		safe--
+	}
+	out = text[s.emitted:safe]
+	s.emitted = safe
+	return out, false
+}
+
+// Flush 流式响应正常结束时输出保留的末尾内容
+func (s *StopScanner) Flush() string {
+	if s == nil {
+		return ""
+	}
+	text := s.text.String()
+	out := text[s.emitted:]
+	s.emitted = len(text)
+	return out
+}
+
+// Content 停止序列之前的全部内容
+func (s *StopScanner) Content() string {
+	return s.text.String()[:s.emitted]
+}
+
+// index 最早出现的停止序列的位置，未出现时返回 -1
+func (s *StopScanner) index(text string) int {
+	first := -1
+	for _, stop := range s.stops {
+		if idx := strings.Index(text, stop); idx >= 0 && (first < 0 || idx < first) {
+			first = idx
+		}
+	}
+	return first
+}
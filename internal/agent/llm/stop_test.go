@@ -0,0 +1,54 @@
+package llm
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestStopScanner(t *testing.T) {
+	tests := []struct {
+		name        string
+		stops       []string
+		chunks      []string
+		want        string // 输出的全部内容
+		wantStopped bool
+	}{
+		{"no stops", nil, []string{"你好", "\nUser:"}, "你好\nUser:", false},
+		{"not found", []string{"\nUser:"}, []string{"你好", "，世界"}, "你好，世界", false},
+		{"in one chunk", []string{"\nUser:"}, []string{"好的。\nUser: 你好"}, "好的。", true},
+		{"split across chunks", []string{"\nUser:"}, []string{"好的。\nUs", "er: 你好"}, "好的。", true},
+		{"split over three chunks", []string{"\nUser:"}, []string{"好的。\n", "Us", "er:"}, "好的。", true},
+		{"earliest stop", []string{"###", "\nUser:"}, []string{"好的\nUser: 你好###"}, "好的", true},
+		{"prefix then not a stop", []string{"\nUser:"}, []string{"好的\nUs", "ing"}, "好的\nUsing", false},
+		{"multibyte stop", []string{"用户："}, []string{"好的用", "户：你好"}, "好的", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewStopScanner(tt.stops)
+			var out strings.Builder
+			stopped := false
+			for _, chunk := range tt.chunks {
+				var text string
+				text, stopped = s.Write(chunk)
+				// 暂不输出可能构成停止序列前缀的内容时，不截断多字节字符
+				if !utf8.ValidString(text) {
+					t.Fatalf("write %q output %q is not valid utf-8", chunk, text)
+				}
+				out.WriteString(text)
+				if stopped {
+					break
+				}
+			}
+			if !stopped {
+				out.WriteString(s.Flush())
+			}
+			if out.String() != tt.want || stopped != tt.wantStopped {
+				t.Fatalf("output = %q, stopped = %v, want %q, %v", out.String(), stopped, tt.want, tt.wantStopped)
+			}
+			if s != nil && s.Content() != tt.want {
+				t.Fatalf("content = %q, want %q", s.Content(), tt.want)
+			}
+		})
+	}
+}
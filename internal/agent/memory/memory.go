@@ -1,6 +1,11 @@
 package memory
 
-import "crow/internal/agent/schema"
+import (
+	"strings"
+
+	"crow/internal/agent/schema"
+	"crow/pkg/log"
+)
 
 type Memory interface {
 	// FormatMessages 格式化消息
@@ -14,11 +19,15 @@ type Memory interface {
 	GetRecentMessages(n int) []schema.Message
 	// Clear 清空消息
 	Clear()
+	// SetStepPrompt 设置智能体每一步注入的提示信息，FormatMessages 时只保留其中最近的一条，避免重复的提示信息不断累积
+	SetStepPrompt(prompt string)
 }
 
 type DefaultMemory struct {
+	log         *log.Logger // 为 nil 时不记录日志
 	messages    []schema.Message
 	maxMessages int
+	stepPrompt  string
 }
 
 func NewDefaultMemory(maxMessages int) *DefaultMemory {
@@ -37,12 +46,19 @@ func (m *DefaultMemory) FormatMessages() {
 	if len(m.messages) == 0 {
 		return
 	}
-	switch m.messages[len(m.messages)-1].Role {
+	defer m.dedupStepPrompts()
+	// 以下修复仅处理末尾的常见情况，最后仍不合法时丢弃损坏的部分，保证请求模型的消息序列始终合法
+	defer m.truncateInvalid()
+	switch last := m.messages[len(m.messages)-1]; last.Role {
 	case schema.RoleAssistant:
-		// 如果最后一条消息是 assistant 消息，且内容为空或包含工具调用，则不应该保留，否则调用模型会失败，影响模型上下文判断
-		if m.messages[len(m.messages)-1].Content == "" || len(m.messages[len(m.messages)-1].ToolCalls) > 0 {
-			// 移除最后一条 assistant 消息
+		switch {
+		case last.Content == "":
+			// 内容为空的 assistant 消息没有保留的价值，且会导致调用模型失败，直接移除
 			m.messages = m.messages[:len(m.messages)-1]
+		case len(last.ToolCalls) > 0:
+			// 同时包含内容与工具调用时，内容是模型对下一步的说明，保留该消息以延续上下文，
+			// 工具调用则均未执行，补充中断的 tool 消息使消息序列合法
+			m.appendInterruptedTools(last, nil)
 		}
 	case schema.RoleTool:
 		// 如果最后一条消息是 tool 消息，说明请求可能存在部分工具未被成功调用的情况，
@@ -60,15 +76,85 @@ func (m *DefaultMemory) FormatMessages() {
 			}
 		}
 		if len(assistantMessage.ToolCalls) != len(toolMessages) {
-			// 补充未被调用的 tool 信息
-			for _, toolCall := range assistantMessage.ToolCalls {
-				if _, ok := toolMessages[toolCall.ID]; !ok {
-					toolMsg := schema.ToolMessage("error: tool execution was interrupted", toolCall.Function.Name, toolCall.ID, "")
-					m.messages = append(m.messages, toolMsg)
-				}
+			m.appendInterruptedTools(assistantMessage, toolMessages)
+		}
+	}
+}
+
+// appendInterruptedTools 为 assistant 消息中未被调用的工具补充中断的 tool 消息
+// @param answered 已有 tool 消息的工具调用ID
+func (m *DefaultMemory) appendInterruptedTools(assistant schema.Message, answered map[string]struct{}) {
+	for _, toolCall := range assistant.ToolCalls {
+		if _, ok := answered[toolCall.ID]; !ok {
+			toolMsg := schema.ToolMessage("error: tool execution was interrupted", toolCall.Function.Name, toolCall.ID, "")
+			m.messages = append(m.messages, toolMsg)
+		}
+	}
+}
+
+// truncateInvalid 消息序列不合法时，从损坏处所在对话轮次的 user 消息起丢弃后续的全部消息
+// 找不到该 user 消息时仅保留 system 消息
+func (m *DefaultMemory) truncateInvalid() {
+	bad := firstInvalid(m.messages)
+	if bad < 0 {
+		return
+	}
+	cut := -1
+	for i := bad; i >= 0; i-- {
+		if m.messages[i].Role == schema.RoleUser && !m.isStepPrompt(m.messages[i]) {
+			cut = i
+			break
+		}
+	}
+	dropped := len(m.messages)
+	if cut >= 0 {
+		m.messages = m.messages[:cut]
+	} else {
+		messages := m.messages[:0]
+		for _, v := range m.messages[:bad] {
+			if v.Role == schema.RoleSystem {
+				messages = append(messages, v)
+			}
+		}
+		m.messages = messages
+	}
+	dropped -= len(m.messages)
+	if m.log != nil {
+		m.log.Warnf("memory is corrupted at message %d, drop %d messages", bad, dropped)
+	}
+}
+
+// firstInvalid 第一条破坏消息序列合法性的消息下标，合法时返回 -1
+// 合法的序列中，tool 消息必须跟在包含对应工具调用的 assistant 消息之后，且该 assistant 消息的每个工具调用在其他消息之前都有对应的 tool 消息
+func firstInvalid(messages []schema.Message) int {
+	var (
+		pending map[string]struct{} // 当前 assistant 消息尚未得到结果的工具调用
+		start   int                 // 当前 assistant 消息的下标
+	)
+	for i, v := range messages {
+		if v.Role == schema.RoleTool {
+			if _, ok := pending[v.ToolCallID]; !ok {
+				return i
+			}
+			delete(pending, v.ToolCallID)
+			continue
+		}
+		if len(pending) > 0 {
+			return start
+		}
+		pending = nil
+		if v.Role == schema.RoleAssistant && len(v.ToolCalls) > 0 {
+			start = i
+			pending = make(map[string]struct{}, len(v.ToolCalls))
+			for _, toolCall := range v.ToolCalls {
+				pending[toolCall.ID] = struct{}{}
 			}
 		}
 	}
+	if len(pending) > 0 {
+		return start
+	}
+	return -1
 }
 
 func (m *DefaultMemory) AddMessage(messages ...schema.Message) {
@@ -112,3 +198,42 @@ func (m *DefaultMemory) GetRecentMessages(n int) []schema.Message {
 func (m *DefaultMemory) Clear() {
 	m.messages = make([]schema.Message, 0, m.maxMessages)
 }
+
+func (m *DefaultMemory) SetStepPrompt(prompt string) {
+	m.stepPrompt = prompt
+}
+
+// SetLogger 设置日志，用于记录修复消息序列时丢弃的消息
+func (m *DefaultMemory) SetLogger(log *log.Logger) {
+	m.log = log
+}
+
+// dedupStepPrompts 移除历史中累积的提示信息，仅保留最近的一条
+// 提示信息被智能体加上前缀（如陷入重复时的提醒）后同样视为提示信息
+func (m *DefaultMemory) dedupStepPrompts() {
+	if m.stepPrompt == "" {
+		return
+	}
+	last := -1
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.isStepPrompt(m.messages[i]) {
+			last = i
+			break
+		}
+	}
+	if last < 0 {
+		return
+	}
+	messages := m.messages[:0]
+	for i, v := range m.messages {
+		if i < last && m.isStepPrompt(v) {
+			continue
+		}
+		messages = append(messages, v)
+	}
+	m.messages = messages
+}
+
+func (m *DefaultMemory) isStepPrompt(message schema.Message) bool {
+	return m.stepPrompt != "" && message.Role == schema.RoleUser && strings.HasSuffix(message.Content, m.stepPrompt)
+}
@@ -0,0 +1,79 @@
+package memory
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"crow/internal/agent/schema"
+)
+
+func toolCall(id, name string) schema.ToolCall {
+	return schema.ToolCall{ID: id, Type: "function", Function: schema.ToolCallFunction{Name: name, Arguments: "{}"}}
+}
+
+// brief 以 role:content 及 tool 消息的工具调用ID简要表示消息序列，便于比较
+func brief(messages []schema.Message) []string {
+	var out []string
+	for _, v := range messages {
+		s := fmt.Sprintf("%s:%s", v.Role, v.Content)
+		if v.Role == schema.RoleTool {
+			s += ":" + v.ToolCallID
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func TestFormatMessagesTrailingAssistant(t *testing.T) {
+	const interrupted = "error: tool execution was interrupted"
+	tests := []struct {
+		name string
+		last []schema.Message
+		want []string
+	}{
+		{
+			name: "empty content",
+			last: []schema.Message{schema.AssistantMessage("", "")},
+			want: []string{"user:查天气"},
+		},
+		{
+			name: "content only",
+			last: []schema.Message{schema.AssistantMessage("今天晴", "")},
+			want: []string{"user:查天气", "assistant:今天晴"},
+		},
+		{
+			name: "tool calls only",
+			last: []schema.Message{schema.FromToolCalls([]schema.ToolCall{toolCall("call_1", "weather")}, "", "")},
+			want: []string{"user:查天气"},
+		},
+		{
+			// 内容是模型对下一步的说明，保留该消息，并为未执行的工具调用补充中断的 tool 消息
+			name: "content with tool calls",
+			last: []schema.Message{schema.FromToolCalls([]schema.ToolCall{toolCall("call_1", "weather"), toolCall("call_2", "time")}, "我先查一下天气", "")},
+			want: []string{"user:查天气", "assistant:我先查一下天气", "tool:" + interrupted + ":call_1", "tool:" + interrupted + ":call_2"},
+		},
+		{
+			name: "partial tool results",
+			last: []schema.Message{
+				schema.FromToolCalls([]schema.ToolCall{toolCall("call_1", "weather"), toolCall("call_2", "time")}, "我先查一下天气", ""),
+				schema.ToolMessage("晴", "weather", "call_1", ""),
+			},
+			want: []string{"user:查天气", "assistant:我先查一下天气", "tool:晴:call_1", "tool:" + interrupted + ":call_2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewDefaultMemory(20)
+			m.AddMessage(schema.UserMessage("查天气", ""))
+			m.AddMessage(tt.last...)
+			m.FormatMessages()
+			if got := brief(m.GetAllMessages()); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("messages = %q, want %q", got, tt.want)
+			}
+			if bad := firstInvalid(m.GetAllMessages()); bad >= 0 {
+				t.Fatalf("message %d is invalid after format", bad)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"crow/internal/agent/schema"
+	"crow/pkg/log"
+	"crow/pkg/redis"
+)
+
+const (
+	// DefaultRedisTTL 未配置时会话记忆在 Redis 中的保留时间
+	DefaultRedisTTL = 30 * time.Minute
+	// redisTimeout 单次读写 Redis 的超时时间
+	redisTimeout = time.Second
+)
+
+// RedisClient 持久化会话记忆所需的 Redis 命令，*redis.Client 已实现该接口，也可适配其他客户端
+type RedisClient interface {
+	// Get 获取字符串值，键不存在时返回 redis.ErrNil
+	Get(ctx context.Context, key string) (string, error)
+	// Set 设置字符串值，ttl 大于0时同时设置过期时间
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+	// Del 删除键
+	Del(ctx context.Context, keys ...string) error
+}
+
+// RedisMemory 持久化到 Redis 的会话记忆，使多实例部署时客户端重连到任意实例都能恢复上下文
+// 消息同时保存在进程内，读取时不访问 Redis，每次变更后将全部消息写回 Redis 并刷新过期时间
+type RedisMemory struct {
+	*DefaultMemory
+	log    *log.Logger
+	client RedisClient
+	key    string
+	ttl    time.Duration
+}
+
+// NewRedisMemory 创建持久化到 Redis 的会话记忆，已存在的记忆会被加载，用于会话恢复
+// @param sessionKey 会话记忆在 Redis 中的键
+// @param maxMessages 最大消息数，同 NewDefaultMemory
+// @param ttl 记忆的保留时间，0则使用默认值
+func NewRedisMemory(log *log.Logger, client RedisClient, sessionKey string, maxMessages int, ttl time.Duration) *RedisMemory {
+	if ttl <= 0 {
+		ttl = DefaultRedisTTL
+	}
+	m := &RedisMemory{
+		DefaultMemory: NewDefaultMemory(maxMessages),
+		log:           log,
+		client:        client,
+		key:           sessionKey,
+		ttl:           ttl,
+	}
+	m.DefaultMemory.SetLogger(log)
+	m.load()
+	return m
+}
+
+func (m *RedisMemory) FormatMessages() {
+	m.DefaultMemory.FormatMessages()
+	m.save()
+}
+
+func (m *RedisMemory) AddMessage(messages ...schema.Message) {
+	m.DefaultMemory.AddMessage(messages...)
+	m.save()
+}
+
+func (m *RedisMemory) Clear() {
+	m.DefaultMemory.Clear()
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := m.client.Del(ctx, m.key); err != nil {
+		m.log.Errorf("failed to delete memory %s from redis: %v", m.key, err)
+	}
+}
+
+// load 从 Redis 加载已有的记忆，加载失败时以空记忆开始
+func (m *RedisMemory) load() {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	data, err := m.client.Get(ctx, m.key)
+	if err != nil {
+		if !errors.Is(err, redis.ErrNil) {
+			m.log.Errorf("failed to load memory %s from redis: %v", m.key, err)
+		}
+		return
+	}
+	var messages []schema.Message
+	if err = json.Unmarshal([]byte(data), &messages); err != nil {
+		m.log.Errorf("failed to unmarshal memory %s: %v", m.key, err)
+		return
+	}
+	m.DefaultMemory.AddMessage(messages...)
+	m.log.Infof("memory %s restored from redis, messages: %d", m.key, len(m.messages))
+}
+
+// save 将全部消息写回 Redis，失败时仅记录日志，不影响本次对话
+func (m *RedisMemory) save() {
+	data, err := json.Marshal(m.messages)
+	if err != nil {
+		m.log.Errorf("failed to marshal memory %s: %v", m.key, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err = m.client.Set(ctx, m.key, string(data), m.ttl); err != nil {
+		m.log.Errorf("failed to save memory %s to redis: %v", m.key, err)
+	}
+}
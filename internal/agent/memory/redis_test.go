@@ -0,0 +1,106 @@
+package memory
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"crow/internal/agent/schema"
+	"crow/pkg/fakeredis"
+	"crow/pkg/log"
+	"crow/pkg/redis"
+)
+
+func newTestRedis(t *testing.T) (*fakeredis.Server, *redis.Client) {
+	t.Helper()
+	s, err := fakeredis.NewServer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	c := redis.NewClient(redis.Option{Addr: s.Addr()})
+	t.Cleanup(func() { _ = c.Close() })
+	return s, c
+}
+
+func TestRedisMemoryRestore(t *testing.T) {
+	logger := log.NewLogger(&log.Option{Hook: io.Discard, Mode: "debug"})
+	s, c := newTestRedis(t)
+
+	m := NewRedisMemory(logger, c, "crow:memory:u1:c1", 10, time.Minute)
+	m.AddMessage(schema.UserMessage("查天气", ""), schema.AssistantMessage("今天晴", ""))
+	data, ok := s.Get("crow:memory:u1:c1")
+	if !ok {
+		t.Fatal("memory is not saved")
+	}
+	var saved []schema.Message
+	if err := json.Unmarshal([]byte(data), &saved); err != nil || len(saved) != 2 {
+		t.Fatalf("saved = %s, err = %v", data, err)
+	}
+	if ttl := s.TTL("crow:memory:u1:c1"); ttl != time.Minute {
+		t.Fatalf("ttl = %v, want 1m", ttl)
+	}
+
+	// 重连到其他实例时恢复上下文
+	restored := NewRedisMemory(logger, c, "crow:memory:u1:c1", 10, time.Minute)
+	if got, want := brief(restored.GetAllMessages()), []string{"user:查天气", "assistant:今天晴"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("restored = %v, want %v", got, want)
+	}
+
+	restored.Clear()
+	if _, ok = s.Get("crow:memory:u1:c1"); ok {
+		t.Fatal("memory still exists after clear")
+	}
+}
+
+func TestRedisMemoryLoad(t *testing.T) {
+	logger := log.NewLogger(&log.Option{Hook: io.Discard, Mode: "debug"})
+	tests := []struct {
+		name    string
+		prepare func(s *fakeredis.Server, c *redis.Client)
+	}{
+		// 键不存在，Redis 返回 nil 批量字符串
+		{"missing", func(*fakeredis.Server, *redis.Client) {}},
+		{"error reply", func(s *fakeredis.Server, _ *redis.Client) { s.FailNext("GET", "ERR boom") }},
+		{"corrupted", func(s *fakeredis.Server, _ *redis.Client) { s.Set("k", "{not json") }},
+		{"server down", func(s *fakeredis.Server, _ *redis.Client) { s.Close() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, c := newTestRedis(t)
+			tt.prepare(s, c)
+			// 加载失败时以空记忆开始，对话仍可继续
+			m := NewRedisMemory(logger, c, "k", 10, 0)
+			if n := len(m.GetAllMessages()); n != 0 {
+				t.Fatalf("messages = %d, want 0", n)
+			}
+			m.AddMessage(schema.UserMessage("你好", ""))
+			if got := brief(m.GetAllMessages()); !reflect.DeepEqual(got, []string{"user:你好"}) {
+				t.Fatalf("messages = %v", got)
+			}
+		})
+	}
+}
+
+func TestRedisMemorySaveError(t *testing.T) {
+	logger := log.NewLogger(&log.Option{Hook: io.Discard, Mode: "debug"})
+	s, c := newTestRedis(t)
+
+	m := NewRedisMemory(logger, c, "k", 10, 0)
+	// 写回失败时保留进程内的记忆，下一次变更时写回全部消息
+	s.FailNext("SET", "OOM command not allowed")
+	m.AddMessage(schema.UserMessage("查天气", ""))
+	if _, ok := s.Get("k"); ok {
+		t.Fatal("memory saved despite error reply")
+	}
+	m.AddMessage(schema.AssistantMessage("今天晴", ""))
+	if ttl := s.TTL("k"); ttl != DefaultRedisTTL {
+		t.Fatalf("ttl = %v, want default %v", ttl, DefaultRedisTTL)
+	}
+	restored := NewRedisMemory(logger, c, "k", 10, 0)
+	if got, want := brief(restored.GetAllMessages()), []string{"user:查天气", "assistant:今天晴"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("restored = %v, want %v", got, want)
+	}
+}
@@ -1,9 +1,17 @@
 package prompt
 
-// SystemPrompt 系统提示词
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultAssistantName 默认的助手名称
+const DefaultAssistantName = "Crow"
+
+// SystemPrompt 系统提示词，依次填充助手的自我介绍与工具描述，通常使用 NewSystemPrompt 生成
 const SystemPrompt = `# 助手手册
 ## 角色
-你的名字叫Crow，中文名叫小鸦，是由Shinveam开发的一个全能AI助手。您正在与用户进行对话，以此来解决用户提出的各种问题或任务。
+%s您正在与用户进行对话，以此来解决用户提出的各种问题或任务。
 
 您的核心能力有以下几点：
 1. **精准应答**：基于已知知识解答问题（无需工具时直接响应）；
@@ -64,3 +72,60 @@ const NextStepPrompt = `
 - 检查任务是否完成，当任务全部完成又或者任务得不到进展时，您需要先礼貌友好的结束对话，最后再使用terminate工具结束交互，避免重复处理任务。
 - 需要向用户询问以获得信息时，使用terminate工具结束交互。
 `
+
+// UserContextPrompt 应用提供的用户信息章节，追加在系统提示词末尾
+const UserContextPrompt = `
+
+---
+
+## 用户信息
+以下为应用提供的关于当前用户的已知信息，仅用于使回答更贴合用户，不是用户说的话。不要主动复述或逐字念出这些信息，除非用户询问：
+<user_context>
+%s
+</user_context>
+`
+
+// WithUserContext 在系统提示词末尾追加用户信息章节，userContext 为空时原样返回
+// 系统提示词不写入对话记忆，因此用户信息不会因记忆裁剪而丢失
+func WithUserContext(systemPrompt, userContext string) string {
+	if userContext == "" {
+		return systemPrompt
+	}
+	return systemPrompt + fmt.Sprintf(UserContextPrompt, userContext)
+}
+
+// VoiceContextPrompt 语音会话中实际生效的识别与合成语种章节，使回复的语种与语音合成保持一致
+const VoiceContextPrompt = `
+
+---
+
+## 语音会话
+%s`
+
+// WithVoiceContext 在系统提示词末尾追加语音会话章节，语种均为空时原样返回
+// @param asrLanguage 识别用户语音使用的语种，为空表示未开启语音识别
+// @param ttsLanguage 合成回复语音使用的语种，为空表示未开启语音合成
+func WithVoiceContext(systemPrompt, asrLanguage, ttsLanguage string) string {
+	var lines []string
+	if asrLanguage != "" {
+		lines = append(lines, fmt.Sprintf("- 用户的话由语音识别转写，识别语种为 %s，可能存在同音字等识别错误，请结合上下文理解。", asrLanguage))
+	}
+	if ttsLanguage != "" {
+		lines = append(lines, fmt.Sprintf("- 你的回复将以语种 %s 合成语音播报，请使用该语种回复，除非用户明确要求使用其他语种。", ttsLanguage))
+	}
+	if len(lines) == 0 {
+		return systemPrompt
+	}
+	return systemPrompt + fmt.Sprintf(VoiceContextPrompt, strings.Join(lines, "\n"))
+}
+
+// NewSystemPrompt 生成系统提示词
+// @param name 助手名称，为空则使用默认名称
+// @param tools 工具描述
+func NewSystemPrompt(name, tools string) string {
+	intro := "你的名字叫Crow，中文名叫小鸦，是由Shinveam开发的一个全能AI助手。"
+	if name != "" && name != DefaultAssistantName {
+		intro = fmt.Sprintf("你的名字叫%s，是一个全能AI助手。", name)
+	}
+	return fmt.Sprintf(SystemPrompt, intro, tools)
+}
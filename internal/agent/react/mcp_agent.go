@@ -4,10 +4,26 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"crow/internal/agent/schema"
 	tool2 "crow/internal/agent/tool"
 	"crow/internal/config"
+	"crow/pkg/metrics"
+)
+
+// unknownToolLabel 模型请求了不存在的工具时使用的指标标签，避免任意的工具名称导致指标数量无限增长
+const unknownToolLabel = "unknown"
+
+var (
+	toolCallCounter = metrics.NewCounterVec("crow_tool_calls_total",
+		"Number of tool calls by result, status is success, error or canceled.", "tool", "status")
+	toolCallSeconds = metrics.NewHistogramVec("crow_tool_call_duration_seconds",
+		"Latency of tool calls, including failed ones.", nil, "tool")
 )
 
 type MCPAgent struct {
@@ -15,9 +31,83 @@ type MCPAgent struct {
 	mcpClient        *tool2.MCPClient
 	tools            map[string]tool2.Caller
 	specialToolNames []string
+	maxOutputBytes   int            // 工具输出的最大字节数
+	outputLimits     map[string]int // 按工具名称单独设置的最大字节数
+	schemaCacheTTL   time.Duration  // 工具定义缓存的有效期
+	instructions     string         // 各 MCP 服务器工具的使用说明
+	shared           bool           // 使用所有会话共用的 MCP 连接，会话结束时不断开
+}
+
+var (
+	sharedLock sync.Mutex
+	// sharedAgent 开启 tools.shared_connections 时所有会话共用的 MCP 连接及工具，首个会话创建，进程退出前通过 CloseSharedMCP 断开
+	sharedAgent *MCPAgent
+)
+
+// NewMCPAgent 创建 MCPAgent，tools 为会话配置中的工具集配置
+// 开启 tools.shared_connections 时复用所有会话共用的连接，此时忽略 headers
+func NewMCPAgent(ctx context.Context, headers map[string]string, tools config.ToolsConfig) (*MCPAgent, error) {
+	if tools.SharedConnections {
+		return newSharedMCPAgent(ctx, tools)
+	}
+	agent := newMCPAgent(tools)
+	err := agent.initializeMCPClient(ctx, "mcp", "1.0.0", headers)
+	if err != nil {
+		agent.Cleanup()
+		return nil, err
+	}
+	agent.filterTools(tools)
+	return agent, nil
+}
+
+// newSharedMCPAgent 创建使用共用连接的 agent，工具集按会话的配置单独裁剪，互不影响
+// 工具调用本身无状态，且 MCP 客户端支持并发请求，因此多个会话可同时通过同一连接执行工具
+func newSharedMCPAgent(ctx context.Context, tools config.ToolsConfig) (*MCPAgent, error) {
+	sharedLock.Lock()
+	defer sharedLock.Unlock()
+	if sharedAgent == nil {
+		shared := newMCPAgent(tools)
+		if err := shared.initializeMCPClient(ctx, "mcp", "1.0.0", nil); err != nil {
+			shared.Cleanup()
+			return nil, err
+		}
+		shared.shared = true
+		sharedAgent = shared
+	}
+
+	agent := newMCPAgent(tools)
+	agent.mcpConfig = sharedAgent.mcpConfig
+	agent.mcpClient = sharedAgent.mcpClient
+	agent.instructions = sharedAgent.instructions
+	agent.shared = true
+	for k, v := range sharedAgent.tools {
+		agent.tools[k] = v
+	}
+	agent.filterTools(tools)
+	return agent, nil
 }
 
-func NewMCPAgent(ctx context.Context, headers map[string]string) (*MCPAgent, error) {
+// CloseSharedMCP 断开所有会话共用的 MCP 连接，在服务退出时调用
+func CloseSharedMCP() {
+	sharedLock.Lock()
+	defer sharedLock.Unlock()
+	if sharedAgent == nil {
+		return
+	}
+	if err := sharedAgent.mcpClient.Close(); err != nil {
+		fmt.Printf("errors disconnecting from mcp servers: %v\n", err)
+	}
+	sharedAgent = nil
+}
+
+// NewBuiltinAgent 创建仅包含内置工具的 agent，不连接任何 MCP 服务器，用于 MCP 不可用时降级
+func NewBuiltinAgent(tools config.ToolsConfig) *MCPAgent {
+	agent := newMCPAgent(tools)
+	agent.filterTools(tools)
+	return agent
+}
+
+func newMCPAgent(tools config.ToolsConfig) *MCPAgent {
 	terminateTool := tool2.NewTerminate()
 	curTimeTool := tool2.NewCurrentTime()
 	agent := &MCPAgent{
@@ -26,16 +116,46 @@ func NewMCPAgent(ctx context.Context, headers map[string]string) (*MCPAgent, err
 			curTimeTool.GetName():   curTimeTool,
 		},
 		specialToolNames: []string{terminateTool.GetName()},
+		maxOutputBytes:   tools.MaxOutputBytes,
+		outputLimits:     tools.OutputLimits,
+		schemaCacheTTL:   tools.SchemaCacheTTL,
+		mcpConfig:        &config.McpConfig{},
 	}
-	err := agent.initializeMCPClient(ctx, "mcp", "1.0.0", headers)
-	if err != nil {
-		return nil, err
+	if agent.maxOutputBytes == 0 {
+		agent.maxOutputBytes = tool2.DefaultMaxOutputBytes
+	}
+	return agent
+}
+
+// filterTools 按配置裁剪提供给大模型的工具集
+// terminate 用于结束 ReAct 循环，只要还有其他工具可用就会保留，仅在禁用全部工具时一并移除
+func (m *MCPAgent) filterTools(cfg config.ToolsConfig) {
+	if slices.Contains(cfg.Disabled, "*") {
+		m.tools = map[string]tool2.Caller{}
+		m.specialToolNames = nil
+		return
+	}
+	for name := range m.tools {
+		if slices.Contains(m.specialToolNames, name) {
+			continue
+		}
+		if (len(cfg.Enabled) > 0 && !slices.Contains(cfg.Enabled, name)) || slices.Contains(cfg.Disabled, name) {
+			delete(m.tools, name)
+		}
+	}
+	if len(m.tools) == len(m.specialToolNames) {
+		// 只剩下特殊工具时，同样视为纯对话模式
+		m.tools = map[string]tool2.Caller{}
+		m.specialToolNames = nil
 	}
-	return agent, nil
 }
 
 func (m *MCPAgent) initializeMCPClient(ctx context.Context, serverName, version string, headers map[string]string) error {
-	m.mcpConfig = config.NewMCPServerConfig()
+	mcpConfig, err := config.LoadMCPServerConfig()
+	if err != nil {
+		return err
+	}
+	m.mcpConfig = mcpConfig
 	// 连接到mcp server
 	m.mcpClient = tool2.NewMCPClient(serverName, version, headers)
 	if err := m.connectMCPServer(ctx); err != nil {
@@ -50,30 +170,42 @@ func (m *MCPAgent) initializeMCPClient(ctx context.Context, serverName, version
 }
 
 func (m *MCPAgent) connectMCPServer(ctx context.Context) error {
-	for k, v := range m.mcpConfig.McpServers {
+	var instructions []string
+	// 按名称顺序连接，使系统提示信息中的使用说明顺序稳定
+	for _, k := range slices.Sorted(maps.Keys(m.mcpConfig.McpServers)) {
+		v := m.mcpConfig.McpServers[k]
 		if v.Disabled {
 			continue
 		}
-		switch v.Type {
-		case "stdio":
-			if err := m.mcpClient.ConnectStdio(ctx, k, v.Command, v.Args...); err != nil {
-				return err
-			}
-		case "sse":
-			if err := m.mcpClient.ConnectSSE(ctx, k, v.URL); err != nil {
-				return err
-			}
-		case "streamableHttp":
-			if err := m.mcpClient.ConnectStreamableHTTP(ctx, k, v.URL); err != nil {
-				return err
-			}
-		default:
-			return fmt.Errorf("unknown server type: %s", v.Type)
+		spec := tool2.ServerSpec{
+			Type:            v.Type,
+			Command:         v.Command,
+			Args:            v.Args,
+			URL:             v.URL,
+			CallTimeout:     time.Duration(v.CallTimeoutMs) * time.Millisecond,
+			Retries:         v.Retries,
+			IdempotentTools: v.IdempotentTools,
+		}
+		if err := m.mcpClient.ConnectCached(ctx, k, spec, m.schemaCacheTTL); err != nil {
+			return err
+		}
+		text := strings.TrimSpace(v.Instructions)
+		if text == "" && !v.IgnoreInstructions {
+			text = m.mcpClient.Instructions(k)
+		}
+		if text != "" {
+			instructions = append(instructions, fmt.Sprintf("## %s\n%s", k, text))
 		}
 	}
+	m.instructions = strings.Join(instructions, "\n\n")
 	return nil
 }
 
+// Instructions 各 MCP 服务器工具的使用说明，由 ReActAgent 追加到系统提示信息中
+func (m *MCPAgent) Instructions() string {
+	return m.instructions
+}
+
 func (m *MCPAgent) GetTools() []schema.Tool {
 	tools := make([]schema.Tool, 0, len(m.tools))
 	for _, v := range m.tools {
@@ -83,6 +215,10 @@ func (m *MCPAgent) GetTools() []schema.Tool {
 }
 
 func (m *MCPAgent) GetToolChoice() schema.ToolChoice {
+	// 没有可用工具时为纯对话模式
+	if len(m.tools) == 0 {
+		return schema.ToolChoiceNone
+	}
 	return schema.ToolChoiceAuto
 }
 
@@ -100,27 +236,60 @@ func (m *MCPAgent) ExecuteTool(ctx context.Context, toolCall schema.ToolCall) (s
 
 	theTool, ok := m.tools[toolCall.Function.Name]
 	if !ok {
+		toolCallCounter.Inc(unknownToolLabel, "error")
 		return schema.AgentStateERROR, fmt.Sprintf("Error: Unknown tool %s", toolCall.Function.Name)
 	}
 
 	var arguments map[string]any
 	if toolCall.Function.Arguments != "" {
 		if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &arguments); err != nil {
+			toolCallCounter.Inc(toolCall.Function.Name, "error")
 			return schema.AgentStateERROR, fmt.Sprintf("failed to parse arguments: %v", err)
 		}
 	}
-	result, err := theTool.Execute(ctx, arguments)
+	start := time.Now()
+	result, err := tool2.Execute(ctx, theTool, arguments)
+	toolCallSeconds.Observe(time.Since(start).Seconds(), toolCall.Function.Name)
 	if err != nil {
+		// 对话被打断导致的失败不计入工具的错误
+		if ctx.Err() != nil {
+			toolCallCounter.Inc(toolCall.Function.Name, "canceled")
+		} else {
+			toolCallCounter.Inc(toolCall.Function.Name, "error")
+		}
 		return schema.AgentStateERROR, fmt.Sprintf("Error: %s", err.Error())
 	}
-	return state, result
+	toolCallCounter.Inc(toolCall.Function.Name, "success")
+	// 在写入记忆前限制输出大小，避免超大的输出在并发会话中占用大量内存
+	content, _ := tool2.LimitOutput(result.Content(), m.outputLimit(toolCall.Function.Name))
+	return state, content
+}
+
+// outputLimit 工具输出的最大字节数，优先使用按工具名称单独设置的值
+func (m *MCPAgent) outputLimit(name string) int {
+	if limit, ok := m.outputLimits[name]; ok {
+		return limit
+	}
+	return m.maxOutputBytes
 }
 
+// PreloadMCPTools 预先连接全部 MCP 服务器并缓存工具定义，可在服务启动时调用，避免首个会话承担连接与获取工具的延迟
+func PreloadMCPTools(ctx context.Context, tools config.ToolsConfig) error {
+	agent, err := NewMCPAgent(ctx, nil, tools)
+	if err != nil {
+		return err
+	}
+	agent.Cleanup()
+	return nil
+}
+
+// Cleanup 断开所有 MCP 服务器的连接，连接在会话的各轮对话之间保持，仅在会话结束时调用
+// 共用的连接由 CloseSharedMCP 断开
 func (m *MCPAgent) Cleanup() {
-	for k := range m.mcpConfig.McpServers {
-		if err := m.mcpClient.Disconnect(k); err != nil {
-			fmt.Printf("errors disconnecting from server %s: %v\n", k, err)
-			continue
-		}
+	if m.mcpClient == nil || m.shared {
+		return
+	}
+	if err := m.mcpClient.Close(); err != nil {
+		fmt.Printf("errors disconnecting from mcp servers: %v\n", err)
 	}
 }
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"crow/internal/agent/memory"
+	"crow/internal/agent/schema"
 )
 
 type Option func(agent *ReActAgent)
@@ -26,6 +27,52 @@ func WithNextStepPrompt(nextStepPrompt string) Option {
 	}
 }
 
+func WithExamples(examples ...schema.Message) Option {
+	return func(agent *ReActAgent) {
+		agent.examples = examples
+	}
+}
+
+func WithTerminateConfirm(question string, timeout time.Duration) Option {
+	return func(agent *ReActAgent) {
+		agent.terminateConfirm = question
+		agent.confirmTimeout = timeout
+	}
+}
+
+func WithToolFailureFallback(steps int, reply string) Option {
+	return func(agent *ReActAgent) {
+		agent.toolFailureSteps = steps
+		agent.toolFailureReply = reply
+	}
+}
+
+func WithSessionContext(fn func() string) Option {
+	return func(agent *ReActAgent) {
+		agent.sessionContext = fn
+	}
+}
+
+func WithFinalReply(finalReply string) Option {
+	return func(agent *ReActAgent) {
+		agent.finalReply = finalReply
+	}
+}
+
+func WithInjectDateTime(tz string) Option {
+	return func(agent *ReActAgent) {
+		agent.dateTimeLoc = time.Local
+		if tz == "" {
+			return
+		}
+		if loc, err := time.LoadLocation(tz); err == nil {
+			agent.dateTimeLoc = loc
+		} else if agent.log != nil {
+			agent.log.Warnf("invalid timezone %q, use local timezone: %v", tz, err)
+		}
+	}
+}
+
 func WithMaxSteps(maxSteps int) Option {
 	return func(agent *ReActAgent) {
 		if maxSteps > 0 {
@@ -50,6 +97,43 @@ func WithPeerAskTimeout(timeout time.Duration) Option {
 	}
 }
 
+func WithMaxRunDuration(d time.Duration) Option {
+	return func(agent *ReActAgent) {
+		agent.maxRunDuration = d
+	}
+}
+
+func WithSkipFirstStepPrompt(skip bool) Option {
+	return func(agent *ReActAgent) {
+		agent.skipFirstStepPrompt = skip
+	}
+}
+
+func WithStreamIdleTimeout(timeout time.Duration) Option {
+	return func(agent *ReActAgent) {
+		agent.streamIdleTimeout = timeout
+	}
+}
+
+func WithStopSequences(stops ...string) Option {
+	return func(agent *ReActAgent) {
+		agent.stopSequences = stops
+	}
+}
+
+func WithReasoning(effort string, maxTokens int) Option {
+	return func(agent *ReActAgent) {
+		agent.reasoningEffort = effort
+		agent.maxReasoningTokens = maxTokens
+	}
+}
+
+func WithStreamReasoning(enable bool) Option {
+	return func(agent *ReActAgent) {
+		agent.streamReasoning = enable
+	}
+}
+
 func WithDuplicateThreshold(duplicateThreshold int) Option {
 	return func(agent *ReActAgent) {
 		if duplicateThreshold > 0 {
@@ -68,8 +152,28 @@ func WithMemoryMaxMessages(maxMessages int) Option {
 	}
 }
 
+func WithMemory(m memory.Memory) Option {
+	return func(agent *ReActAgent) {
+		if m != nil {
+			agent.memory = m
+		}
+	}
+}
+
+func WithContentSanitizer(enable bool) Option {
+	return func(agent *ReActAgent) {
+		agent.sanitizeContent = enable
+	}
+}
+
 func WithSupportImages(supportImages bool) Option {
 	return func(agent *ReActAgent) {
 		agent.supportImages = supportImages
 	}
 }
+
+func WithRouter(router Router) Option {
+	return func(agent *ReActAgent) {
+		agent.router = router
+	}
+}
@@ -2,9 +2,11 @@ package react
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -14,9 +16,31 @@ import (
 	"crow/internal/agent/llm"
 	"crow/internal/agent/memory"
 	"crow/internal/agent/schema"
+	tool2 "crow/internal/agent/tool"
 	"crow/pkg/log"
 )
 
+// defaultFinalReply 仅调用工具且未输出任何回复就成功结束时的默认答复
+const defaultFinalReply = "好的，已经为您处理完成了。"
+
+// stallNotice 模型流式响应停滞被中止时，追加在已输出内容之后的提示
+const stallNotice = "抱歉，网络有些不稳定，我先回答到这里。"
+
+// runTimeoutNotice 单次运行超过最长时间被中止时，追加在已输出内容之后的提示
+const runTimeoutNotice = "抱歉，这个问题处理得有些久，我先回答到这里。"
+
+// defaultToolFailureReply 连续多个步骤调用的工具全部失败时的默认答复
+const defaultToolFailureReply = "抱歉，我暂时无法获取相关信息，请稍后再试。"
+
+// defaultConfirmTimeout 默认等待用户回复确认问题的时间
+const defaultConfirmTimeout = 30 * time.Second
+
+// defaultStreamIdleTimeout 默认的流式响应停滞时间
+const defaultStreamIdleTimeout = 20 * time.Second
+
+// terminateToolName 结束交互的特殊工具，不作为工具调用事件通知监听者
+var terminateToolName = tool2.NewTerminate().GetName()
+
 type ReAct interface {
 	// GetTools 获取工具列表
 	GetTools() []schema.Tool
@@ -25,10 +49,16 @@ type ReAct interface {
 	// ExecuteTool 执行工具
 	// 注意特殊工具的使用，例如：当使用terminate工具时，需要将AgentState置为AgentStateFINISHED并返回
 	ExecuteTool(context.Context, schema.ToolCall) (schema.AgentState, string)
-	// Cleanup 清理资源
+	// Cleanup 清理资源，会话结束时调用一次，而非每轮对话结束时调用，避免每轮对话重新连接 MCP 服务器
 	Cleanup()
 }
 
+// InstructionProvider 可选的 ReAct 扩展，提供需要追加到系统提示信息中的工具使用说明
+type InstructionProvider interface {
+	// Instructions 工具的使用说明，为空则不追加
+	Instructions() string
+}
+
 type ReActAgent struct {
 	log      *log.Logger
 	listener agent.Listener
@@ -38,32 +68,79 @@ type ReActAgent struct {
 	// Prompts
 	systemPrompt   string // 系统提示信息
 	nextStepPrompt string // 下一步的提示信息
+	finalReply     string // 仅调用工具且未输出任何回复就成功结束时的答复
+	// skipFirstStepPrompt 每轮的第一步不追加下一步骤提示，闲聊时模型直接回复用户，执行过工具后的步骤仍会追加
+	skipFirstStepPrompt bool
+	// terminateConfirm 结束前的确认问题，不为空时模型成功结束交互后先询问用户，用户确认或超时未回复才真正结束
+	terminateConfirm string
+	confirmTimeout   time.Duration  // 等待用户回复确认问题的最长时间，默认30s
+	dateTimeLoc      *time.Location // 不为 nil 时，每次运行都会在系统提示信息前注入该时区的当前时间
+	runPrompt        string         // 本次运行实际使用的系统提示信息
+	// sessionContext 每次运行时获取会话当前的状态，追加在系统提示信息末尾，用于会话中途可能变化的信息，如语音合成的语种
+	sessionContext func() string
+	// examples 少样本示例，每次询问模型时置于历史消息之前，不写入记忆，因此不会被淘汰也不占用记忆的消息数
+	examples []schema.Message
 	// Dependencies
 	reAct     ReAct             // ReAct 操作对象
 	llm       llm.LLM           // LLM实例
+	router    Router            // 按步骤选择大模型，为 nil 则始终使用 llm
+	stepLLM   llm.LLM           // 本步骤询问的大模型
 	memory    memory.Memory     // Agent的记忆存储
 	toolCalls []schema.ToolCall // 需要被调用的工具
+	// lastContent 本轮模型最近一次非空的回复内容
+	lastContent string
+	// confirming 上一轮以确认问题结束，正在等待用户回复
+	confirming bool
+	// confirmRound 本轮是对确认问题的回复
+	confirmRound bool
+	// confirmSeq 确认问题的序号，用于忽略已失效的超时回调
+	confirmSeq int64
+	// roundTools 本轮执行过的普通工具数
+	roundTools int
+	// failedSteps 本轮连续调用的工具全部失败的步骤数
+	failedSteps int
+	// toolFailureSteps 连续多少个步骤调用的工具全部失败后，不再询问模型，直接以 toolFailureReply 答复，0为不开启
+	toolFailureSteps int
+	toolFailureReply string
 	// Execution control
 	supportImages      bool              // 是否支持图像
+	sanitizeContent    bool              // 是否过滤回复内容中误输出的工具调用JSON及标记，默认开启
 	maxSteps           int               // 最大执行步骤，默认为20
 	currentStep        int               // 当前执行步骤
 	maxObserve         int               // 最大观测数目
 	peerAskTimeout     time.Duration     // 每次询问模型的超时时间
+	maxRunDuration     time.Duration     // 每次运行的最长时间，超过则中止模型请求及工具调用，0为不限制
+	runDeadline        time.Time         // 本次运行的截止时间，零值为不限制
+	streamIdleTimeout  time.Duration     // 模型流式响应中相邻分片的最长间隔，超过则中止本次询问，默认20s，负数为不限制
+	stopSequences      []string          // 停止序列，模型回复中出现时提前结束生成
+	reasoningEffort    string            // 推理强度，为空则使用服务商默认值
+	maxReasoningTokens int               // 推理过程最多消耗的token数，0为不限制
+	streamReasoning    bool              // 是否向监听者单独回调推理模型的思考过程
 	duplicateThreshold int               // 重复阈值，默认为2
 	state              schema.AgentState // Agent的状态
 
+	// nextToolChoice、nextToolName 指定的下一轮对话的工具选择方式，由 nextLock 保护，开始运行时转为本轮的设置
+	nextLock       sync.Mutex
+	nextToolChoice schema.ToolChoice
+	nextToolName   string
+	// turnToolChoice、turnToolName 本轮第一步的工具选择方式，为空则使用默认方式
+	turnToolChoice schema.ToolChoice
+	turnToolName   string
+
 	lock      sync.Mutex
 	interrupt int32 // 是否被打断，0：未打断，1：已打断
+	replied   int32 // 本轮是否已向监听者输出过回复，0：否，1：是
 	connectId string
 }
 
 func NewReActAgent(agentName string, log *log.Logger, llm llm.LLM, reAct ReAct, opts ...Option) *ReActAgent {
 	react := &ReActAgent{
-		name:  agentName,
-		state: schema.AgentStateIDLE,
-		llm:   llm,
-		reAct: reAct,
-		log:   log,
+		name:            agentName,
+		state:           schema.AgentStateIDLE,
+		llm:             llm,
+		reAct:           reAct,
+		log:             log,
+		sanitizeContent: true,
 	}
 	for _, fn := range opts {
 		fn(react)
@@ -74,9 +151,22 @@ func NewReActAgent(agentName string, log *log.Logger, llm llm.LLM, reAct ReAct,
 	if react.memory == nil {
 		react.memory = memory.NewDefaultMemory(20)
 	}
+	react.memory.SetStepPrompt(react.nextStepPrompt)
 	if react.duplicateThreshold <= 0 {
 		react.duplicateThreshold = 2
 	}
+	if react.finalReply == "" {
+		react.finalReply = defaultFinalReply
+	}
+	if react.toolFailureReply == "" {
+		react.toolFailureReply = defaultToolFailureReply
+	}
+	if react.confirmTimeout <= 0 {
+		react.confirmTimeout = defaultConfirmTimeout
+	}
+	if react.streamIdleTimeout == 0 {
+		react.streamIdleTimeout = defaultStreamIdleTimeout
+	}
 	return react
 }
 
@@ -98,6 +188,22 @@ func (r *ReActAgent) Run(ctx context.Context, userPrompt string) error {
 
 	r.currentStep = 0
 	r.state = schema.AgentStateRUNNING
+	r.lastContent = ""
+	r.roundTools = 0
+	r.failedSteps = 0
+	r.confirmRound = r.confirming
+	r.confirming = false
+	r.confirmSeq++
+	r.nextLock.Lock()
+	r.turnToolChoice, r.turnToolName = r.nextToolChoice, r.nextToolName
+	r.nextToolChoice, r.nextToolName = "", ""
+	r.nextLock.Unlock()
+	atomic.StoreInt32(&r.replied, 0)
+	r.runPrompt = r.buildSystemPrompt()
+	r.runDeadline = time.Time{}
+	if r.maxRunDuration > 0 {
+		r.runDeadline = time.Now().Add(r.maxRunDuration)
+	}
 	defer func() {
 		// 如果不是被打断的，说明是正常结束的，则需要不乏一个结束标识
 		if atomic.LoadInt32(&r.interrupt) == 0 {
@@ -106,7 +212,6 @@ func (r *ReActAgent) Run(ctx context.Context, userPrompt string) error {
 		}
 		r.state = schema.AgentStateIDLE
 		atomic.StoreInt32(&r.interrupt, 0)
-		r.reAct.Cleanup()
 	}()
 
 	r.memory.FormatMessages()
@@ -126,7 +231,9 @@ func (r *ReActAgent) Run(ctx context.Context, userPrompt string) error {
 		results = append(results, fmt.Sprintf("step %d: %s", r.currentStep, stepResult))
 	}
 
-	if r.currentStep >= r.maxSteps {
+	if r.runExpired() {
+		results = append(results, fmt.Sprintf("terminated: Reached max run duration (%v)", r.maxRunDuration))
+	} else if r.currentStep >= r.maxSteps {
 		results = append(results, fmt.Sprintf("terminated: Reached max steps (%d)", r.maxSteps))
 	}
 
@@ -141,7 +248,16 @@ func (r *ReActAgent) Reset() error {
 	return nil
 }
 
+// Close 释放工具占用的资源，如 MCP 服务器的连接
+func (r *ReActAgent) Close() error {
+	r.reAct.Cleanup()
+	return nil
+}
+
 func (r *ReActAgent) step(ctx context.Context) (string, error) {
+	r.stepLLM = r.routeLLM()
+	// 每步使用新的回复通道，之前提前停止接收的步骤或轮次残留的回复不会被本步读到
+	_ = r.stepLLM.Reset()
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
@@ -150,46 +266,114 @@ func (r *ReActAgent) step(ctx context.Context) (string, error) {
 	}()
 
 	shouldAct, err := r.think(ctx)
+	wg.Wait()
+	if errors.Is(err, llm.ErrStreamStalled) {
+		r.log.Warnf("abort stalled llm stream: %v", err)
+		r.replyNotice(ctx, stallNotice)
+		r.state = schema.AgentStateFINISHED
+		return "llm stream stalled", nil
+	}
+	if err != nil && r.runExpired() {
+		r.log.Warnf("abort llm request, run exceeded %v: %v", r.maxRunDuration, err)
+		r.replyNotice(ctx, runTimeoutNotice)
+		r.state = schema.AgentStateFINISHED
+		return "run timeout", nil
+	}
 	if err != nil {
-		wg.Wait()
 		return "", fmt.Errorf("errors during thinking: %v", err)
 	}
-	wg.Wait()
 
 	if !shouldAct {
 		r.state = schema.AgentStateFINISHED
 		return "thinking complete - no action needed", nil
 	}
-	return r.act(ctx)
+	result, err := r.act(ctx)
+	if r.runExpired() && r.state != schema.AgentStateFINISHED {
+		// 工具调用已超时中止，不再询问模型，以提示结束本轮
+		r.log.Warnf("stop after tool calls, run exceeded %v", r.maxRunDuration)
+		r.memory.AddMessage(schema.AssistantMessage(runTimeoutNotice, ""))
+		r.replyNotice(ctx, runTimeoutNotice)
+		r.state = schema.AgentStateFINISHED
+		return "run timeout", nil
+	}
+	return result, err
+}
+
+// runContext 本次运行设置了截止时间时，为模型请求及工具调用附加截止时间
+func (r *ReActAgent) runContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.runDeadline.IsZero() {
+		return ctx, func() {}
+	}
+	return context.WithDeadline(ctx, r.runDeadline)
+}
+
+// runExpired 本次运行是否已超过最长时间
+func (r *ReActAgent) runExpired() bool {
+	return !r.runDeadline.IsZero() && !time.Now().Before(r.runDeadline)
 }
 
 func (r *ReActAgent) think(ctx context.Context) (bool, error) {
-	if r.nextStepPrompt != "" {
+	if r.needStepPrompt() && !r.lastIsNextStepPrompt() {
 		r.memory.AddMessage(schema.UserMessage(r.nextStepPrompt, ""))
 	}
 
-	message, err := r.llm.Handle(ctx, &llm.Request{
-		Timeout:         r.peerAskTimeout,
-		ToolChoice:      r.reAct.GetToolChoice(),
-		Tools:           r.reAct.GetTools(),
-		SystemMessage:   schema.SystemMessage(r.systemPrompt),
-		Messages:        r.memory.GetAllMessages(),
-		IsSupportImages: r.supportImages,
+	toolChoice, toolName := r.toolChoice()
+	runCtx, cancel := r.runContext(ctx)
+	defer cancel()
+	message, err := r.stepLLM.Handle(runCtx, &llm.Request{
+		Timeout:            r.peerAskTimeout,
+		IdleTimeout:        max(r.streamIdleTimeout, 0),
+		Stop:               r.stopSequences,
+		ReasoningEffort:    r.reasoningEffort,
+		MaxReasoningTokens: r.maxReasoningTokens,
+		StreamReasoning:    r.streamReasoning,
+		ToolChoice:         toolChoice,
+		ToolName:           toolName,
+		Tools:              r.reAct.GetTools(),
+		SystemMessage:      schema.SystemMessage(r.runPrompt),
+		Messages:           r.requestMessages(),
+		IsSupportImages:    r.supportImages,
 	})
 	if err != nil {
+		var notice string
+		switch {
+		case errors.Is(err, llm.ErrStreamStalled):
+			notice = stallNotice
+		case r.runExpired():
+			notice = runTimeoutNotice
+		}
+		if notice != "" {
+			// 已输出的部分回复与提示一起写入记忆，使下一轮对话的上下文保持完整
+			var partial string
+			if message != nil {
+				partial = message.Content
+				if r.sanitizeContent {
+					partial, _ = sanitizeContent(partial)
+				}
+			}
+			r.memory.AddMessage(schema.AssistantMessage(partial+notice, ""))
+		}
 		return false, fmt.Errorf("llm handle error: %w", err)
 	}
 	if message == nil {
 		return false, errors.New("no response received")
 	}
+	if r.sanitizeContent {
+		message.Content, _ = sanitizeContent(message.Content)
+	}
+	if message.Content != "" {
+		r.lastContent = message.Content
+	}
 
-	if r.reAct.GetToolChoice() == schema.ToolChoiceNone {
+	if toolChoice == schema.ToolChoiceNone {
 		if len(message.ToolCalls) > 0 {
-			return false, fmt.Errorf("%s tried to use tools when they weren't available", r.name)
+			// 部分模型即使未提供工具也会偶尔返回工具调用，忽略这些调用并继续使用回复内容，避免中止整轮对话
+			r.log.Warnf("%s tried to use tools when they weren't available, ignore %d tool calls", r.name, len(message.ToolCalls))
+			r.toolCalls = nil
 		}
+		// 没有可用工具时，模型回复即为最终答复，无需继续执行
 		if message.Content != "" {
 			r.memory.AddMessage(schema.AssistantMessage(message.Content, ""))
-			return true, nil
 		}
 		return false, nil
 	}
@@ -205,11 +389,11 @@ func (r *ReActAgent) think(ctx context.Context) (bool, error) {
 	}
 	r.memory.AddMessage(assistantMsg)
 
-	if r.reAct.GetToolChoice() == schema.ToolChoiceRequired && len(r.toolCalls) == 0 {
+	if toolChoice == schema.ToolChoiceRequired && len(r.toolCalls) == 0 {
 		return true, nil // Will be handled in act()
 	}
 	// For 'auto' mode, continue with content if no commands but content exists
-	if r.reAct.GetToolChoice() == schema.ToolChoiceAuto && len(r.toolCalls) == 0 {
+	if toolChoice == schema.ToolChoiceAuto && len(r.toolCalls) == 0 {
 		if message.Content != "" {
 			return true, nil
 		}
@@ -221,7 +405,7 @@ func (r *ReActAgent) think(ctx context.Context) (bool, error) {
 // act 执行函数调用操作，例如 function calling、MCPAgent 等
 func (r *ReActAgent) act(ctx context.Context) (string, error) {
 	if len(r.toolCalls) == 0 {
-		if r.reAct.GetToolChoice() == schema.ToolChoiceRequired {
+		if toolChoice, _ := r.toolChoice(); toolChoice == schema.ToolChoiceRequired {
 			return "", errors.New("tool calls required but none provided")
 		}
 		if len(r.memory.GetAllMessages()) != 0 && r.memory.GetRecentMessages(1)[0].Content != "" {
@@ -230,15 +414,32 @@ func (r *ReActAgent) act(ctx context.Context) (string, error) {
 		return "No content or commands to execute", nil
 	}
 
-	var results []string
+	var (
+		results []string
+		failed  int
+	)
 	for _, toolCall := range r.toolCalls {
-		state, result := r.reAct.ExecuteTool(ctx, toolCall)
+		call := agent.ToolCall{ID: toolCall.ID, Name: toolCall.Function.Name, Arguments: toolCall.Function.Arguments}
+		notify := call.Name != terminateToolName
+		if notify {
+			agent.NotifyToolCall(ctx, r.listener, call)
+		}
+		runCtx, cancel := r.runContext(ctx)
+		state, result := r.reAct.ExecuteTool(runCtx, toolCall)
+		cancel()
+		if notify {
+			call.Finished, call.Failed, call.Result = true, state == schema.AgentStateERROR, result
+			agent.NotifyToolCall(ctx, r.listener, call)
+		}
 
 		if r.maxObserve > 0 && r.maxObserve < len(result) {
 			result = result[:r.maxObserve]
 		}
 
 		r.log.Debugf("tool %s executed with result: %s", toolCall.Function.Name, result)
+		if state == schema.AgentStateERROR {
+			failed++
+		}
 
 		// Add tool response to memory
 		r.memory.AddMessage(schema.ToolMessage(result, toolCall.Function.Name, toolCall.ID, ""))
@@ -246,13 +447,165 @@ func (r *ReActAgent) act(ctx context.Context) (string, error) {
 
 		if state == schema.AgentStateFINISHED {
 			r.state = state
+			if r.confirmTerminate(ctx, toolCall) {
+				return "", nil
+			}
 			r.log.Info("all tools are executed !")
+			r.replyOnSilentFinish(ctx, toolCall)
 			return "", nil
 		}
+		r.roundTools++
+	}
+
+	// 工具全部失败时，如MCP服务器均不可用，模型通常会反复重试直至达到最大步骤，此时直接告知用户，避免浪费步骤与token
+	if failed == len(r.toolCalls) {
+		r.failedSteps++
+	} else {
+		r.failedSteps = 0
+	}
+	if r.toolFailureSteps > 0 && r.failedSteps >= r.toolFailureSteps {
+		r.log.Warnf("all tool calls failed in %d consecutive steps, stop with fallback reply", r.failedSteps)
+		r.memory.AddMessage(schema.AssistantMessage(r.toolFailureReply, ""))
+		r.replyNotice(ctx, r.toolFailureReply)
+		r.state = schema.AgentStateFINISHED
+		return "all tools failed", nil
 	}
 	return strings.Join(results, "\n\n"), nil
 }
 
+// weekdays 星期的中文名称，下标与 time.Weekday 对应
+var weekdays = [...]string{"星期日", "星期一", "星期二", "星期三", "星期四", "星期五", "星期六"}
+
+// buildSystemPrompt 生成本次运行的系统提示信息，开启时间注入时在开头加入当前时间，使模型无需调用工具即可知道“现在”
+// 工具提供了使用说明时追加在末尾，使模型按各服务的要求正确使用工具
+func (r *ReActAgent) buildSystemPrompt() string {
+	prompt := r.systemPrompt
+	if p, ok := r.reAct.(InstructionProvider); ok {
+		if instructions := p.Instructions(); instructions != "" {
+			prompt += "\n\n# 工具使用说明\n以下为各工具服务提供的使用说明，调用对应服务的工具时请遵循：\n\n" + instructions
+		}
+	}
+	if r.sessionContext != nil {
+		prompt += r.sessionContext()
+	}
+	if r.dateTimeLoc == nil {
+		return prompt
+	}
+	now := time.Now().In(r.dateTimeLoc)
+	return fmt.Sprintf("当前时间：%s %s（时区：%s）\n\n%s",
+		now.Format("2006-01-02 15:04:05"), weekdays[now.Weekday()], r.dateTimeLoc, prompt)
+}
+
+// replyOnSilentFinish 本轮仅调用了工具、未向用户输出任何回复就成功结束时，补充一条答复，避免用户得不到任何反馈
+// 优先使用模型本轮最近一次的回复内容，否则使用默认答复
+func (r *ReActAgent) replyOnSilentFinish(ctx context.Context, toolCall schema.ToolCall) {
+	if atomic.LoadInt32(&r.replied) == 1 || atomic.LoadInt32(&r.interrupt) == 1 {
+		return
+	}
+	if terminateStatus(toolCall) != "success" {
+		return
+	}
+
+	reply := r.lastContent
+	if reply == "" {
+		reply = r.finalReply
+	}
+	r.log.Infof("agent finished without any reply, send final reply: %s", reply)
+	r.memory.AddMessage(schema.AssistantMessage(reply, ""))
+	if finish := r.listener.OnAgentResult(ctx, reply, agent.StateProcessing); finish {
+		atomic.StoreInt32(&r.interrupt, 1)
+	}
+}
+
+// confirmTerminate 开启结束前确认时，模型成功结束交互后先询问用户是否还有其他需要，而不是直接结束
+// 用户回复后本轮未执行其他工具便再次结束，视为用户确认，此时才真正结束并通知监听者；超时未回复同样真正结束
+// @return 是否已向用户询问，询问后本轮结束
+func (r *ReActAgent) confirmTerminate(ctx context.Context, toolCall schema.ToolCall) bool {
+	if r.terminateConfirm == "" || terminateStatus(toolCall) != "success" {
+		return false
+	}
+	if r.confirmRound && r.roundTools == 0 {
+		r.log.Info("user confirmed, terminate the interaction")
+		agent.NotifyTerminate(ctx, r.listener, false)
+		return false
+	}
+	if atomic.LoadInt32(&r.interrupt) == 1 {
+		return true
+	}
+
+	r.log.Infof("confirm before terminate: %s", r.terminateConfirm)
+	r.confirming = true
+	atomic.StoreInt32(&r.replied, 1)
+	r.memory.AddMessage(schema.AssistantMessage(r.terminateConfirm, ""))
+	if finish := r.listener.OnAgentResult(ctx, r.terminateConfirm, agent.StateProcessing); finish {
+		atomic.StoreInt32(&r.interrupt, 1)
+	}
+
+	seq := r.confirmSeq
+	time.AfterFunc(r.confirmTimeout, func() {
+		r.lock.Lock()
+		expired := r.confirming && r.confirmSeq == seq
+		if expired {
+			r.confirming = false
+		}
+		r.lock.Unlock()
+		if expired {
+			r.log.Infof("no reply to confirmation in %v, terminate the interaction", r.confirmTimeout)
+			agent.NotifyTerminate(context.Background(), r.listener, true)
+		}
+	})
+	return true
+}
+
+// terminateStatus terminate 工具调用的完成状态
+func terminateStatus(toolCall schema.ToolCall) string {
+	var arguments struct {
+		Status string `json:"status"`
+	}
+	_ = json.Unmarshal([]byte(toolCall.Function.Arguments), &arguments)
+	return arguments.Status
+}
+
+// requestMessages 本次询问模型的上下文，少样本示例在前，记忆中的历史消息在后
+func (r *ReActAgent) requestMessages() []schema.Message {
+	if len(r.examples) == 0 {
+		return r.memory.GetAllMessages()
+	}
+	history := r.memory.GetAllMessages()
+	messages := make([]schema.Message, 0, len(r.examples)+len(history))
+	messages = append(messages, r.examples...)
+	return append(messages, history...)
+}
+
+// replyNotice 模型流式响应停滞、运行超时或工具全部失败被中止后，在已输出的内容之后追加提示，随后结束本轮对话
+func (r *ReActAgent) replyNotice(ctx context.Context, notice string) {
+	if atomic.LoadInt32(&r.interrupt) == 1 {
+		return
+	}
+	atomic.StoreInt32(&r.replied, 1)
+	if finish := r.listener.OnAgentResult(ctx, notice, agent.StateProcessing); finish {
+		atomic.StoreInt32(&r.interrupt, 1)
+	}
+}
+
+// flushSanitizer 流式结束时输出过滤器暂存的剩余内容，并记录被过滤的内容
+func (r *ReActAgent) flushSanitizer(ctx context.Context, s *sanitizer) {
+	if s == nil {
+		return
+	}
+	reply := s.Flush()
+	if removed := s.Removed(); len(removed) > 0 {
+		r.log.Warnf("removed tool call artifacts from llm content: %q", removed)
+	}
+	if reply == "" || atomic.LoadInt32(&r.interrupt) == 1 {
+		return
+	}
+	atomic.StoreInt32(&r.replied, 1)
+	if finish := r.listener.OnAgentResult(ctx, reply, agent.StateProcessing); finish {
+		atomic.StoreInt32(&r.interrupt, 1)
+	}
+}
+
 // isStuck 通过检查重复消息来判断是否陷入停滞状态
 func (r *ReActAgent) isStuck() bool {
 	if len(r.memory.GetAllMessages()) < r.duplicateThreshold {
@@ -274,22 +627,103 @@ func (r *ReActAgent) isStuck() bool {
 
 func (r *ReActAgent) handleStuckState() {
 	stuckPrompt := "观察到重复响应，请考虑新的策略，避免重复已经尝试过的无效路径。"
+	// 已提醒过则不再重复添加
+	if strings.HasPrefix(r.nextStepPrompt, stuckPrompt) {
+		return
+	}
 	r.nextStepPrompt = fmt.Sprintf("%s\n%s", stuckPrompt, r.nextStepPrompt)
 }
 
+// SetNextToolChoice 指定下一轮对话第一步的工具选择方式，强制调用的工具执行后，后续步骤恢复默认方式，避免反复调用
+func (r *ReActAgent) SetNextToolChoice(choice string) error {
+	var (
+		toolChoice = schema.ToolChoice(choice)
+		toolName   string
+	)
+	switch toolChoice {
+	case "", schema.ToolChoiceNone, schema.ToolChoiceAuto, schema.ToolChoiceRequired:
+	default:
+		if !slices.ContainsFunc(r.reAct.GetTools(), func(t schema.Tool) bool { return t.Function.Name == choice }) {
+			return fmt.Errorf("unknown tool: %s", choice)
+		}
+		toolChoice, toolName = schema.ToolChoiceRequired, choice
+	}
+	r.nextLock.Lock()
+	r.nextToolChoice, r.nextToolName = toolChoice, toolName
+	r.nextLock.Unlock()
+	return nil
+}
+
+// toolChoice 本步的工具选择方式及强制调用的工具名称
+// 指定的方式仅对本轮第一步生效，没有可用工具时始终为 none
+func (r *ReActAgent) toolChoice() (schema.ToolChoice, string) {
+	toolChoice := r.reAct.GetToolChoice()
+	if toolChoice == schema.ToolChoiceNone || r.turnToolChoice == "" || r.currentStep > 1 {
+		return toolChoice, ""
+	}
+	return r.turnToolChoice, r.turnToolName
+}
+
+// needStepPrompt 本步是否需要追加下一步骤提示
+// 没有可用工具时提示中关于工具的要求没有意义，追加反而干扰回复
+func (r *ReActAgent) needStepPrompt() bool {
+	if toolChoice, _ := r.toolChoice(); r.nextStepPrompt == "" || toolChoice == schema.ToolChoiceNone {
+		return false
+	}
+	return !r.skipFirstStepPrompt || r.currentStep > 1
+}
+
+// lastIsNextStepPrompt 记忆中最后一条消息是否已是相同的提示信息，如上一步未得到模型的回复
+func (r *ReActAgent) lastIsNextStepPrompt() bool {
+	recent := r.memory.GetRecentMessages(1)
+	return len(recent) == 1 && recent[0].Role == schema.RoleUser && recent[0].Content == r.nextStepPrompt
+}
+
 func (r *ReActAgent) recvLLMMessages(ctx context.Context) {
+	var s *sanitizer
+	if r.sanitizeContent {
+		s = &sanitizer{}
+	}
+	receiver, _ := r.stepLLM.(llm.DeltaReceiver)
+	if !r.streamReasoning {
+		receiver = nil
+	}
 	for {
-		reply, err := r.llm.Recv()
+		var (
+			reply string
+			err   error
+		)
+		if receiver != nil {
+			var delta llm.Delta
+			if delta, err = receiver.RecvDelta(); err == nil && delta.Reasoning != "" {
+				// 思考过程单独回调，不作为回复输出
+				agent.NotifyReasoning(ctx, r.listener, delta.Reasoning)
+				continue
+			}
+			reply = delta.Content
+		} else {
+			reply, err = r.stepLLM.Recv()
+		}
 		if err != nil {
 			if errors.Is(err, io.EOF) {
+				r.flushSanitizer(ctx, s)
 				return
 			}
 			r.log.Errorf("recv llm message error: %v", err)
+			_ = r.stepLLM.Reset()
 			return
 		}
+		if s != nil {
+			reply = s.Write(reply)
+		}
 
+		if reply != "" {
+			atomic.StoreInt32(&r.replied, 1)
+		}
 		if finish := r.listener.OnAgentResult(ctx, reply, agent.StateProcessing); finish {
 			atomic.StoreInt32(&r.interrupt, 1)
+			// 不再接收本步的回复，重置使仍在输出的模型请求不再阻塞于已满的回复通道
+			_ = r.stepLLM.Reset()
 			return
 		}
 	}
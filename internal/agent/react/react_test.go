@@ -0,0 +1,440 @@
+package react
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"crow/internal/agent"
+	"crow/internal/agent/llm"
+	"crow/internal/agent/llm/openai"
+	"crow/internal/agent/schema"
+	"crow/pkg/log"
+)
+
+// chatServer 模拟 OpenAI 兼容的流式接口，第 n 次请求以 replies[n] 的各分片作为回复内容，超出时重复最后一次的回复
+func chatServer(t *testing.T, replies ...[]string) *httptest.Server {
+	t.Helper()
+	var (
+		lock sync.Mutex
+		n    int
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		chunks := replies[min(n, len(replies)-1)]
+		n++
+		lock.Unlock()
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, content := range chunks {
+			chunk := map[string]any{
+				"id":      "chatcmpl-test",
+				"object":  "chat.completion.chunk",
+				"model":   "test",
+				"choices": []map[string]any{{"index": 0, "delta": map[string]any{"content": content}}},
+			}
+			data, _ := json.Marshal(chunk)
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+		}
+		_, _ = io.WriteString(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// turn scriptLLM 的一次回复
+type turn struct {
+	content   string
+	toolCalls []schema.ToolCall
+	delay     time.Duration // 回复前的等待时间，期间请求被取消则返回 ctx 的错误
+	err       error         // 输出回复内容后返回的错误
+}
+
+// chunk 回复分片，end 表示本次请求的回复结束
+type chunk struct {
+	content string
+	end     bool
+}
+
+// scriptLLM 按顺序回复预设内容的大模型，超出时重复最后一次的回复
+type scriptLLM struct {
+	lock    sync.Mutex
+	turns   []turn
+	n       int
+	replies chan chunk
+}
+
+func newScriptLLM(turns ...turn) *scriptLLM {
+	return &scriptLLM{turns: turns, replies: make(chan chunk, 16)}
+}
+
+func (l *scriptLLM) Name() string { return "script" }
+
+func (l *scriptLLM) Handle(ctx context.Context, _ *llm.Request) (*llm.Response, error) {
+	l.lock.Lock()
+	t := l.turns[min(l.n, len(l.turns)-1)]
+	l.n++
+	replies := l.replies
+	l.lock.Unlock()
+
+	if t.delay > 0 {
+		select {
+		case <-time.After(t.delay):
+		case <-ctx.Done():
+			replies <- chunk{end: true}
+			return &llm.Response{}, ctx.Err()
+		}
+	}
+	if t.content != "" {
+		replies <- chunk{content: t.content}
+	}
+	replies <- chunk{end: true}
+	return &llm.Response{Content: t.content, ToolCalls: t.toolCalls}, t.err
+}
+
+func (l *scriptLLM) Recv() (string, error) {
+	l.lock.Lock()
+	replies := l.replies
+	l.lock.Unlock()
+	c := <-replies
+	if c.end {
+		return "", io.EOF
+	}
+	return c.content, nil
+}
+
+func (l *scriptLLM) Reset() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.replies = make(chan chunk, 16)
+	return nil
+}
+
+// requests 已处理的请求数
+func (l *scriptLLM) requests() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.n
+}
+
+func toolCall(id, name, arguments string) schema.ToolCall {
+	return schema.ToolCall{ID: id, Type: "function", Function: schema.ToolCallFunction{Name: name, Arguments: arguments}}
+}
+
+// fakeListener 记录每次回调的回复文本，finish 返回 true 时不再监听本轮的回复
+type fakeListener struct {
+	lock   sync.Mutex
+	texts  []string
+	finish func(text string) bool
+}
+
+func (l *fakeListener) OnAgentResult(_ context.Context, text string, state agent.State) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if state == agent.StateProcessing {
+		l.texts = append(l.texts, text)
+	}
+	return l.finish != nil && l.finish(text)
+}
+
+func (l *fakeListener) reply() string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return strings.Join(l.texts, "")
+}
+
+func (l *fakeListener) reset() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.texts = nil
+}
+
+// fakeReAct 测试用的工具集，execute 为 nil 时任何工具均执行失败
+type fakeReAct struct {
+	tools    []schema.Tool
+	execute  func(call schema.ToolCall) (schema.AgentState, string)
+	cleanups int32 // Cleanup 的调用次数
+}
+
+func (f *fakeReAct) GetTools() []schema.Tool { return f.tools }
+func (f *fakeReAct) GetToolChoice() schema.ToolChoice {
+	// 与 MCPAgent 相同，没有可用工具时为纯对话模式
+	if len(f.tools) == 0 {
+		return schema.ToolChoiceNone
+	}
+	return schema.ToolChoiceAuto
+}
+func (f *fakeReAct) Cleanup() { atomic.AddInt32(&f.cleanups, 1) }
+func (f *fakeReAct) ExecuteTool(_ context.Context, call schema.ToolCall) (schema.AgentState, string) {
+	if f.execute == nil {
+		return schema.AgentStateERROR, "Error: tool failed"
+	}
+	return f.execute(call)
+}
+
+func newTestLogger() *log.Logger {
+	return log.NewLogger(&log.Option{Hook: io.Discard, Mode: "debug"})
+}
+
+// runWithin 在限定时间内完成一轮对话
+func runWithin(t *testing.T, a *ReActAgent, prompt string) {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() { done <- a.Run(context.Background(), prompt) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("run: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not return")
+	}
+}
+
+func TestFinalReplyAfterToolOnlyTurn(t *testing.T) {
+	tools := []schema.Tool{
+		{Type: "function", Function: schema.ToolFunction{Name: "light_on"}},
+		{Type: "function", Function: schema.ToolFunction{Name: "terminate"}},
+	}
+	execute := func(call schema.ToolCall) (schema.AgentState, string) {
+		if call.Function.Name == "terminate" {
+			return schema.AgentStateFINISHED, "The interaction has been completed"
+		}
+		return schema.AgentStateRUNNING, "ok"
+	}
+	tests := []struct {
+		name   string
+		first  string // 第一步调用工具时的回复内容
+		status string // terminate 的完成状态
+		opts   []Option
+		want   string
+	}{
+		{"default reply", "", "success", nil, defaultFinalReply},
+		{"custom reply", "", "success", []Option{WithFinalReply("已开灯")}, "已开灯"},
+		{"already replied", "好的，马上开灯", "success", nil, "好的，马上开灯"},
+		{"terminate failed", "", "failure", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newScriptLLM(
+				turn{content: tt.first, toolCalls: []schema.ToolCall{toolCall("call_1", "light_on", "{}")}},
+				turn{toolCalls: []schema.ToolCall{toolCall("call_2", "terminate", `{"status":"`+tt.status+`"}`)}},
+			)
+			listener := &fakeListener{}
+			a := NewReActAgent("test", newTestLogger(), l, &fakeReAct{tools: tools, execute: execute}, tt.opts...)
+			a.SetListener(listener)
+
+			// 仅调用工具且未输出任何回复就成功结束时，补充一条答复，已有回复或未成功结束时不补充
+			runWithin(t, a, "开灯")
+			if got := listener.reply(); got != tt.want {
+				t.Fatalf("reply = %q, want %q", got, tt.want)
+			}
+			if n := l.requests(); n != 2 {
+				t.Fatalf("llm requests = %d, want 2", n)
+			}
+			if tt.first == "" && tt.want != "" {
+				// 补充的答复同时写入记忆
+				if last := a.memory.GetRecentMessages(1)[0]; last.Role != schema.RoleAssistant || last.Content != tt.want {
+					t.Fatalf("last message = %+v, want final reply", last)
+				}
+			}
+		})
+	}
+}
+
+func TestStopReceivingReleasesLLMRequest(t *testing.T) {
+	first := make([]string, 50)
+	for i := range first {
+		first[i] = "old "
+	}
+	ts := chatServer(t, first, []string{"new"})
+	listener := &fakeListener{finish: func(text string) bool { return text == "old " }}
+	a := NewReActAgent("test", newTestLogger(), openai.NewOpenAI("test", "key", ts.URL), &fakeReAct{})
+	a.SetListener(listener)
+
+	// 监听者在首个分片后不再接收，之后的分片超出回复通道的缓冲，模型请求不能因此阻塞
+	runWithin(t, a, "first")
+
+	// 下一轮只能读到本轮的回复，不能读到上一轮残留的分片
+	listener.reset()
+	listener.finish = nil
+	runWithin(t, a, "second")
+	if got := listener.reply(); got != "new" {
+		t.Fatalf("second round reply = %q, want %q", got, "new")
+	}
+}
+
+func TestIgnoreToolCallsWithoutTools(t *testing.T) {
+	// 没有提供工具时，模型仍返回了工具调用
+	l := newScriptLLM(turn{content: "今天晴", toolCalls: []schema.ToolCall{toolCall("call_1", "weather", "{}")}})
+	listener := &fakeListener{}
+	a := NewReActAgent("test", newTestLogger(), l, &fakeReAct{})
+	a.SetListener(listener)
+
+	// 忽略工具调用，以回复内容作为最终答复，而非中止整轮对话
+	runWithin(t, a, "今天天气怎么样")
+	if got := listener.reply(); got != "今天晴" {
+		t.Fatalf("reply = %q, want %q", got, "今天晴")
+	}
+	if n := l.requests(); n != 1 {
+		t.Fatalf("llm requests = %d, want 1", n)
+	}
+	last := a.memory.GetRecentMessages(1)[0]
+	if last.Role != schema.RoleAssistant || last.Content != "今天晴" || len(last.ToolCalls) != 0 {
+		t.Fatalf("last message = %+v, want assistant reply without tool calls", last)
+	}
+}
+
+func TestToolFailureFallback(t *testing.T) {
+	tests := []struct {
+		name      string
+		steps     int
+		wantCalls int    // 询问模型的次数
+		wantReply string // 为空表示不以兜底答复结束
+	}{
+		{"enabled", 2, 2, defaultToolFailureReply},
+		{"disabled", 0, 5, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 模型每一步都调用工具，而工具始终执行失败，如 MCP 服务器均不可用
+			l := newScriptLLM(turn{toolCalls: []schema.ToolCall{toolCall("call_1", "weather", "{}")}})
+			listener := &fakeListener{}
+			tools := []schema.Tool{{Type: "function", Function: schema.ToolFunction{Name: "weather"}}}
+			a := NewReActAgent("test", newTestLogger(), l, &fakeReAct{tools: tools},
+				WithMaxSteps(5), WithToolFailureFallback(tt.steps, ""))
+			a.SetListener(listener)
+
+			runWithin(t, a, "今天天气怎么样")
+			if n := l.requests(); n != tt.wantCalls {
+				t.Fatalf("llm requests = %d, want %d", n, tt.wantCalls)
+			}
+			if got := listener.reply(); got != tt.wantReply {
+				t.Fatalf("reply = %q, want %q", got, tt.wantReply)
+			}
+			if tt.wantReply != "" {
+				if last := a.memory.GetRecentMessages(1)[0]; last.Role != schema.RoleAssistant || last.Content != tt.wantReply {
+					t.Fatalf("last message = %+v, want fallback reply", last)
+				}
+			}
+		})
+	}
+}
+
+func TestToolFailureFallbackResetsOnSuccess(t *testing.T) {
+	// 工具交替失败与成功，连续失败的步骤数不会达到阈值
+	var calls int
+	execute := func(schema.ToolCall) (schema.AgentState, string) {
+		calls++
+		if calls%2 == 1 {
+			return schema.AgentStateERROR, "Error: tool failed"
+		}
+		return schema.AgentStateRUNNING, "晴"
+	}
+	l := newScriptLLM(turn{toolCalls: []schema.ToolCall{toolCall("call_1", "weather", "{}")}})
+	tools := []schema.Tool{{Type: "function", Function: schema.ToolFunction{Name: "weather"}}}
+	a := NewReActAgent("test", newTestLogger(), l, &fakeReAct{tools: tools, execute: execute},
+		WithMaxSteps(5), WithToolFailureFallback(2, ""))
+	listener := &fakeListener{}
+	a.SetListener(listener)
+
+	runWithin(t, a, "今天天气怎么样")
+	if n := l.requests(); n != 5 {
+		t.Fatalf("llm requests = %d, want 5", n)
+	}
+	if got := listener.reply(); got != "" {
+		t.Fatalf("reply = %q, want no fallback reply", got)
+	}
+}
+
+func TestMaxRunDuration(t *testing.T) {
+	weather := []schema.ToolCall{toolCall("call_1", "weather", "{}")}
+	tests := []struct {
+		name     string
+		turn     turn
+		slowTool bool // 工具执行超过最长时间
+	}{
+		{"slow llm", turn{content: "今天晴", delay: 10 * time.Second}, false},
+		{"slow tool", turn{toolCalls: weather}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reAct := &fakeReAct{tools: []schema.Tool{{Type: "function", Function: schema.ToolFunction{Name: "weather"}}}}
+			if tt.slowTool {
+				reAct.execute = func(schema.ToolCall) (schema.AgentState, string) {
+					time.Sleep(200 * time.Millisecond)
+					return schema.AgentStateERROR, "Error: context deadline exceeded"
+				}
+			}
+			l := newScriptLLM(tt.turn)
+			listener := &fakeListener{}
+			a := NewReActAgent("test", newTestLogger(), l, reAct, WithMaxRunDuration(100*time.Millisecond))
+			a.SetListener(listener)
+
+			// 超过最长时间后中止模型请求或不再询问模型，以提示结束本轮，而非等待请求超时
+			start := time.Now()
+			runWithin(t, a, "今天天气怎么样")
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Fatalf("run took %v, want it aborted soon after max run duration", elapsed)
+			}
+			if got := listener.reply(); got != runTimeoutNotice {
+				t.Fatalf("reply = %q, want %q", got, runTimeoutNotice)
+			}
+			if n := l.requests(); n != 1 {
+				t.Fatalf("llm requests = %d, want 1", n)
+			}
+			if last := a.memory.GetRecentMessages(1)[0]; last.Role != schema.RoleAssistant || last.Content != runTimeoutNotice {
+				t.Fatalf("last message = %+v, want run timeout notice", last)
+			}
+		})
+	}
+}
+
+func TestStreamStalled(t *testing.T) {
+	l := newScriptLLM(turn{content: "今天", err: fmt.Errorf("%w: no chunk received", llm.ErrStreamStalled)})
+	listener := &fakeListener{}
+	a := NewReActAgent("test", newTestLogger(), l, &fakeReAct{})
+	a.SetListener(listener)
+
+	// 模型流式响应停滞时，在已输出的内容之后追加提示并结束本轮，而非报错
+	runWithin(t, a, "今天天气怎么样")
+	want := "今天" + stallNotice
+	if got := listener.reply(); got != want {
+		t.Fatalf("reply = %q, want %q", got, want)
+	}
+	if last := a.memory.GetRecentMessages(1)[0]; last.Role != schema.RoleAssistant || last.Content != want {
+		t.Fatalf("last message = %+v, want partial reply with stall notice", last)
+	}
+}
+
+func TestToolsKeptAcrossTurns(t *testing.T) {
+	reAct := &fakeReAct{}
+	a := NewReActAgent("test", newTestLogger(), newScriptLLM(turn{content: "你好"}), reAct)
+	a.SetListener(&fakeListener{})
+
+	// 每轮对话结束时不释放工具占用的资源，各轮对话复用 MCP 服务器的连接
+	runWithin(t, a, "first")
+	runWithin(t, a, "second")
+	if n := atomic.LoadInt32(&reAct.cleanups); n != 0 {
+		t.Fatalf("cleanups after two turns = %d, want 0", n)
+	}
+
+	// 会话结束时通过 agent.Closer 释放一次
+	var closer agent.Closer = a
+	if err := closer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&reAct.cleanups); n != 1 {
+		t.Fatalf("cleanups after close = %d, want 1", n)
+	}
+}
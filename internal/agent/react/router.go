@@ -0,0 +1,41 @@
+package react
+
+import (
+	"crow/internal/agent/llm"
+	"crow/internal/agent/schema"
+)
+
+// Route 询问模型前本步骤的信息，供 Router 选择大模型
+type Route struct {
+	Step       int               // 本轮的第几步，从1开始
+	RoundTools int               // 本轮已执行的普通工具数
+	ToolChoice schema.ToolChoice // 本步骤的工具选择方式
+}
+
+// Router 按步骤选择询问的大模型，如用低成本的模型决定是否调用工具，用效果更好的模型组织最终答复
+// 返回 nil 时使用创建 agent 时指定的大模型
+type Router func(route Route) llm.LLM
+
+// ToolStepRouter 尚未执行过工具且可以调用工具的步骤使用 toolLLM，即决定是否及如何调用工具的步骤，
+// 执行工具后根据结果组织答复的步骤使用默认的大模型
+// 注意无需调用工具的闲聊同样由 toolLLM 直接答复
+func ToolStepRouter(toolLLM llm.LLM) Router {
+	return func(route Route) llm.LLM {
+		if route.RoundTools == 0 && route.ToolChoice != schema.ToolChoiceNone {
+			return toolLLM
+		}
+		return nil
+	}
+}
+
+// routeLLM 本步骤询问的大模型
+func (r *ReActAgent) routeLLM() llm.LLM {
+	if r.router == nil {
+		return r.llm
+	}
+	toolChoice, _ := r.toolChoice()
+	if model := r.router(Route{Step: r.currentStep, RoundTools: r.roundTools, ToolChoice: toolChoice}); model != nil {
+		return model
+	}
+	return r.llm
+}
@@ -0,0 +1,293 @@
+package react
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// maxMarkupLen 疑似工具调用的内容最多暂存的字节数，超过仍未闭合则作为普通内容输出，避免长时间阻塞回复
+const maxMarkupLen = 4096
+
+// matchState 疑似工具调用的内容的判定结果
+type matchState int
+
+const (
+	matchNone    matchState = iota // 不是工具调用，作为普通内容输出
+	matchPartial                   // 尚未结束，等待后续分片
+	matchFull                      // 完整的工具调用，需要过滤
+)
+
+// markupBlock 模型误输出到回复内容中的工具调用或特殊标记
+type markupBlock struct {
+	start string
+	end   string
+	// match 判断标记之间的内容，closed 为 false 时 body 尚不完整，仅判断是否可能构成工具调用
+	match func(body, end string, closed bool) bool
+}
+
+var markupBlocks = []markupBlock{
+	{start: "<tool_call>", end: "</tool_call>", match: matchJSONBody},
+	{start: "<tool>", end: "</tool>", match: matchJSONBody},
+	{start: "<tools>", end: "</tools>", match: matchJSONBody},
+	{start: "<function=", end: "</function>", match: matchFunctionBody},
+	{start: "<|", end: "|>", match: matchSpecialToken},
+}
+
+// jsonCallStart 以工具调用字段开头的JSON对象，如 {"name": "xxx", "arguments": {...}}
+var jsonCallStart = regexp.MustCompile(`\{\s*"(name|function|tool_calls?|arguments)"\s*:`)
+
+// sanitizer 过滤流式回复内容中的工具调用JSON及标记，避免被下发给客户端或被TTS朗读
+// 仅过滤格式完整的工具调用，如 <tool_call>{...}</tool_call>、{"name": ..., "arguments": ...}、<|tool_call_begin|>，
+// “使用<tool>键”之类的正常内容原样输出；标记可能被拆分在多个分片中，因此疑似标记的内容会暂存，直至确认或流式结束
+type sanitizer struct {
+	pending string   // 暂存的待确认内容
+	removed []string // 已过滤的内容，用于日志
+}
+
+// Write 写入一个回复分片，返回可以立即输出的内容
+func (s *sanitizer) Write(chunk string) string {
+	s.pending += chunk
+	return s.scan(false)
+}
+
+// Flush 流式结束，返回剩余可输出的内容，未闭合的标记作为普通内容输出
+func (s *sanitizer) Flush() string {
+	return s.scan(true)
+}
+
+// Removed 返回并清空已过滤的内容
+func (s *sanitizer) Removed() []string {
+	removed := s.removed
+	s.removed = nil
+	return removed
+}
+
+// scan 过滤暂存内容中的工具调用，返回可以输出的内容，final 为 true 时不再等待后续分片
+func (s *sanitizer) scan(final bool) string {
+	var out strings.Builder
+	from := 0
+	for {
+		start, block := s.nextCandidate(from)
+		if start < 0 {
+			break
+		}
+		end, state := s.match(start, block)
+		if state == matchPartial && (final || len(s.pending)-start > maxMarkupLen) {
+			state = matchNone
+		}
+		switch state {
+		case matchNone:
+			from = start + 1
+		case matchPartial:
+			out.WriteString(s.pending[:start])
+			s.pending = s.pending[start:]
+			return out.String()
+		case matchFull:
+			out.WriteString(s.pending[:start])
+			s.removed = append(s.removed, s.pending[start:end])
+			s.pending = s.pending[end:]
+			from = 0
+		}
+	}
+
+	// 末尾可能是标记的开头，暂不输出
+	hold := 0
+	if !final {
+		hold = s.holdBack()
+	}
+	out.WriteString(s.pending[:len(s.pending)-hold])
+	s.pending = s.pending[len(s.pending)-hold:]
+	return out.String()
+}
+
+// nextCandidate 查找 from 之后最早出现的疑似工具调用，block 为 nil 表示JSON对象，未找到时 start 为 -1
+func (s *sanitizer) nextCandidate(from int) (start int, block *markupBlock) {
+	start = -1
+	text := s.pending[from:]
+	for i := range markupBlocks {
+		if idx := strings.Index(text, markupBlocks[i].start); idx >= 0 && (start < 0 || idx < start) {
+			start, block = idx, &markupBlocks[i]
+		}
+	}
+	if loc := jsonCallStart.FindStringIndex(text); loc != nil && (start < 0 || loc[0] < start) {
+		start, block = loc[0], nil
+	}
+	if start < 0 {
+		return -1, nil
+	}
+	return from + start, block
+}
+
+// match 判定 start 处的疑似工具调用，matchFull 时 end 为其结束位置
+func (s *sanitizer) match(start int, block *markupBlock) (end int, state matchState) {
+	if block == nil {
+		end = matchBrace(s.pending, start)
+		switch {
+		case end < 0:
+			return 0, matchPartial
+		case isToolCallJSON(s.pending[start:end]):
+			return end, matchFull
+		}
+		return 0, matchNone
+	}
+
+	body := s.pending[start+len(block.start):]
+	idx := strings.Index(body, block.end)
+	if idx < 0 {
+		if block.match(body, block.end, false) {
+			return 0, matchPartial
+		}
+		return 0, matchNone
+	}
+	if block.match(body[:idx], block.end, true) {
+		return start + len(block.start) + idx + len(block.end), matchFull
+	}
+	return 0, matchNone
+}
+
+// matchJSONBody 标记之间为JSON对象，如 <tool_call>{"name": "xxx", "arguments": {...}}</tool_call>
+func matchJSONBody(body, end string, closed bool) bool {
+	trimmed := strings.TrimSpace(body)
+	if closed {
+		return strings.HasPrefix(trimmed, "{") && json.Valid([]byte(trimmed))
+	}
+	if trimmed == "" {
+		return true
+	}
+	if trimmed[0] != '{' {
+		return false
+	}
+	// JSON对象已结束时，其后只能是结束标记的开头
+	if i := matchBrace(trimmed, 0); i >= 0 {
+		return json.Valid([]byte(trimmed[:i])) && strings.HasPrefix(end, strings.TrimSpace(trimmed[i:]))
+	}
+	return true
+}
+
+// matchFunctionBody 函数名后为JSON参数，如 <function=get_weather>{"city": "北京"}</function>
+func matchFunctionBody(body, end string, closed bool) bool {
+	name, args, ok := strings.Cut(body, ">")
+	if !ok {
+		return !closed && (name == "" || isIdentifier(name))
+	}
+	return isIdentifier(name) && matchJSONBody(args, end, closed)
+}
+
+// matchSpecialToken 模型的特殊标记，如 <|tool_call_begin|>、<|im_end|>
+func matchSpecialToken(body, _ string, closed bool) bool {
+	const maxTokenLen = 32
+	if len(body) > maxTokenLen {
+		return false
+	}
+	if !closed {
+		// 结束标记的 | 可能已到达
+		if body = strings.TrimSuffix(body, "|"); body == "" {
+			return true
+		}
+	}
+	return isIdentifier(body)
+}
+
+// isIdentifier 是否仅由字母、数字、下划线、连字符或点组成
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// isToolCallJSON 是否为工具调用的JSON，如 {"name": "xxx", "arguments": {...}}、{"function": {...}}、{"tool_calls": [...]}
+func isToolCallJSON(text string) bool {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(text), &obj); err != nil {
+		return false
+	}
+	if name, ok := obj["name"]; ok && isJSONString(name) {
+		_, hasArgs := obj["arguments"]
+		_, hasParams := obj["parameters"]
+		return hasArgs || hasParams
+	}
+	if function, ok := obj["function"]; ok {
+		var f map[string]json.RawMessage
+		if json.Unmarshal(function, &f) == nil && isJSONString(f["name"]) {
+			return true
+		}
+	}
+	for _, key := range []string{"tool_calls", "tool_call"} {
+		if v, ok := obj[key]; ok && len(v) > 0 && (v[0] == '[' || v[0] == '{') {
+			return true
+		}
+	}
+	return false
+}
+
+func isJSONString(raw json.RawMessage) bool {
+	var s string
+	return len(raw) > 0 && json.Unmarshal(raw, &s) == nil
+}
+
+// holdBack 返回末尾需要暂存的字节数，即末尾疑似为标记开头的部分
+func (s *sanitizer) holdBack() int {
+	if idx := strings.LastIndexByte(s.pending, '<'); idx >= 0 {
+		suffix := s.pending[idx:]
+		for _, block := range markupBlocks {
+			if strings.HasPrefix(block.start, suffix) {
+				return len(suffix)
+			}
+		}
+	}
+	// JSON字段前可能有空白，只要足够短且未闭合就暂存
+	if idx := strings.LastIndexByte(s.pending, '{'); idx >= 0 {
+		suffix := s.pending[idx:]
+		if len(suffix) <= 24 && !strings.Contains(suffix, "}") {
+			return len(suffix)
+		}
+	}
+	return 0
+}
+
+// matchBrace 返回从 start 处的 { 开始的JSON对象的结束位置，未闭合返回 -1
+func matchBrace(text string, start int) int {
+	depth := 0
+	inString := false
+	escaped := false
+	for i := start; i < len(text); i++ {
+		c := text[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+// sanitizeContent 过滤完整回复内容中的工具调用JSON及标记
+func sanitizeContent(content string) (string, []string) {
+	var s sanitizer
+	text := s.Write(content) + s.Flush()
+	return text, s.Removed()
+}
@@ -0,0 +1,92 @@
+package react
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSanitizer(t *testing.T) {
+	call := `{"name": "get_weather", "arguments": {"city": "北京"}}`
+	tests := []struct {
+		name        string
+		chunks      []string
+		want        string
+		wantRemoved []string
+	}{
+		{"plain", []string{"今天", "天气不错"}, "今天天气不错", nil},
+		{"tool call block", []string{"好的<tool_call>" + call + "</tool_call>马上查"}, "好的马上查", []string{"<tool_call>" + call + "</tool_call>"}},
+		{"bare json", []string{"好的" + call + "。"}, "好的。", []string{call}},
+		{"function object", []string{`{"function": {"name": "f", "arguments": "{}"}}`}, "", []string{`{"function": {"name": "f", "arguments": "{}"}}`}},
+		{"tool calls", []string{`{"tool_calls": [{"function": {"name": "f"}}]}好的`}, "好的", []string{`{"tool_calls": [{"function": {"name": "f"}}]}`}},
+		{"function tag", []string{`<function=get_weather>{"city": "北京"}</function>好的`}, "好的", []string{`<function=get_weather>{"city": "北京"}</function>`}},
+		{"special token", []string{"好的<|im_end|>"}, "好的", []string{"<|im_end|>"}},
+
+		// 被拆分在多个分片中
+		{"split block", []string{"好的<tool", "_call>{\"name\": \"get_weather\", ", "\"arguments\": {}}</tool_", "call>马上查"}, "好的马上查", []string{`<tool_call>{"name": "get_weather", "arguments": {}}</tool_call>`}},
+		{"split json", []string{"好的{", `"name": "f",`, ` "arguments": {}`, "}。"}, "好的。", []string{`{"name": "f", "arguments": {}}`}},
+		{"split special token", []string{"好的<", "|im_", "end|", ">再见"}, "好的再见", []string{"<|im_end|>"}},
+
+		// 正常内容不应被过滤
+		{"benign tool tag", []string{"Use the <tool> key to open it"}, "Use the <tool> key to open it", nil},
+		{"benign split tool tag", []string{"Use the <to", "ol> key", " to open it"}, "Use the <tool> key to open it", nil},
+		{"benign special", []string{"a <| b |> c"}, "a <| b |> c", nil},
+		{"benign json", []string{`返回 {"name": "张三", "age": 18} 即可`}, `返回 {"name": "张三", "age": 18} 即可`, nil},
+		{"benign block text", []string{"<tool>锤子</tool>"}, "<tool>锤子</tool>", nil},
+
+		// 未闭合的标记在流式结束时作为普通内容输出
+		{"unclosed block", []string{"好的<tool_call>{\"name\": \"f\"", "，后面的内容"}, "好的<tool_call>{\"name\": \"f\"，后面的内容", nil},
+		{"unclosed json", []string{`好的{"name": "f", "arguments": {`, "后面的内容"}, `好的{"name": "f", "arguments": {后面的内容`, nil},
+		{"unclosed special", []string{"好的<|", "im_end"}, "好的<|im_end", nil},
+		{"unclosed function", []string{"好的<function=f>"}, "好的<function=f>", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s sanitizer
+			var out strings.Builder
+			for _, chunk := range tt.chunks {
+				out.WriteString(s.Write(chunk))
+			}
+			out.WriteString(s.Flush())
+			if out.String() != tt.want {
+				t.Fatalf("output = %q, want %q", out.String(), tt.want)
+			}
+			if removed := s.Removed(); !reflect.DeepEqual(removed, tt.wantRemoved) {
+				t.Fatalf("removed = %q, want %q", removed, tt.wantRemoved)
+			}
+		})
+	}
+}
+
+func TestSanitizerStreamsBenignText(t *testing.T) {
+	tests := []struct {
+		name  string
+		chunk string
+		want  string // 写入后立即输出的内容
+	}{
+		{"benign tool tag", "Use the <tool> key", "Use the <tool> key"},
+		{"benign special", "a <| b", "a <| b"},
+		{"possible tag start", "好的<tool", "好的"},
+		{"possible json", `好的{"name": "f"`, "好的"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s sanitizer
+			if got := s.Write(tt.chunk); got != tt.want {
+				t.Fatalf("write = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizerMaxMarkupLen(t *testing.T) {
+	// 未闭合的内容超过上限后不再暂存，避免阻塞后续回复
+	var s sanitizer
+	long := `<tool_call>{"text": "` + strings.Repeat("a", maxMarkupLen) + `"`
+	if got := s.Write(long); got != long {
+		t.Fatalf("write = %d bytes, want %d", len(got), len(long))
+	}
+	if got := s.Write("。"); got != "。" {
+		t.Fatalf("write after limit = %q", got)
+	}
+}
@@ -0,0 +1,58 @@
+package tool
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultMaxOutputBytes 未配置时工具输出的最大字节数
+const DefaultMaxOutputBytes = 64 * 1024
+
+// blobSampleBytes 判断是否为二进制或base64数据时检查的前缀长度
+const blobSampleBytes = 4096
+
+// LimitOutput 限制工具输出的大小，避免超大的输出（如网页全文、base64图片）写入记忆后占用大量内存及上下文
+// 超出上限的文本会按字符边界截断并追加提示，二进制或base64数据对模型没有意义，直接丢弃
+// @param maxBytes 最大字节数，0或负数为不限制
+// @return string 限制后的输出
+// @return bool 是否超出上限
+func LimitOutput(content string, maxBytes int) (string, bool) {
+	if maxBytes <= 0 || len(content) <= maxBytes {
+		return content, false
+	}
+	if isBlob(content) {
+		return fmt.Sprintf("[工具输出为%d字节的二进制数据，已丢弃]", len(content)), true
+	}
+
+	cut := maxBytes
+	for cut > 0 && !utf8.RuneStart(content[cut]) {
+		cut--
+	}
+	return content[:cut] + fmt.Sprintf("\n[输出过长，已截断，原始长度%d字节]", len(content)), true
+}
+
+// isBlob 是否为二进制数据、base64编码的数据或 data URL
+func isBlob(content string) bool {
+	sample := content
+	if len(sample) > blobSampleBytes {
+		sample = sample[:blobSampleBytes]
+	}
+	if strings.HasPrefix(sample, "data:") && strings.Contains(sample, ";base64,") {
+		return true
+	}
+	// 截断处可能落在多字节字符中间，去掉末尾不完整的字符后再校验
+	for i := 0; i < utf8.UTFMax-1 && len(sample) > 0 && !utf8.ValidString(sample); i++ {
+		sample = sample[:len(sample)-1]
+	}
+	if !utf8.ValidString(sample) {
+		return true
+	}
+	for i := 0; i < len(sample); i++ {
+		c := sample[i]
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '+' || c == '/' || c == '=' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
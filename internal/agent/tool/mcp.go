@@ -4,22 +4,66 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/mark3labs/mcp-go/client"
 	"github.com/mark3labs/mcp-go/client/transport"
 	"github.com/mark3labs/mcp-go/mcp"
 
 	"crow/internal/agent/schema"
+	"crow/internal/auth"
 )
 
+// DefaultCallTimeout 未配置时单次调用 MCP 工具的超时时间
+const DefaultCallTimeout = 30 * time.Second
+
+// ErrCallTimeout 调用 MCP 工具超时，服务器可能已挂起
+var ErrCallTimeout = errors.New("mcp tool call timed out")
+
 // MCPClientTool MCP 客户端可调用的工具
 type MCPClientTool struct {
 	client *client.Client
 	tool   schema.Tool
+	// connect 不为空时，每次调用前通过它获取连接，连接尚未建立或已断开时会重新连接
+	connect func(ctx context.Context) (*client.Client, error)
+	// evict 调用出现传输层错误时回调，丢弃该连接，使下次调用重新连接
+	evict func(c *client.Client)
+
+	callTimeout  time.Duration // 单次调用的超时时间，负数为不限制
+	retries      int           // 超时或连接出错时的重试次数，仅应对幂等的工具开启
+	retryBackoff time.Duration // 重试前的等待时间
+}
+
+// MCPToolOption MCP 工具调用的可选设置
+type MCPToolOption func(*MCPClientTool)
+
+// WithCallTimeout 单次调用的超时时间，0为使用默认值，负数为不限制
+func WithCallTimeout(timeout time.Duration) MCPToolOption {
+	return func(t *MCPClientTool) {
+		if timeout != 0 {
+			t.callTimeout = timeout
+		}
+	}
+}
+
+// WithRetry 超时或连接出错时重试，重复调用可能导致重复执行，仅应对幂等的工具（如查询类）开启
+// @param retries 重试次数
+// @param backoff 重试前的等待时间
+func WithRetry(retries int, backoff time.Duration) MCPToolOption {
+	return func(t *MCPClientTool) {
+		t.retries = max(retries, 0)
+		t.retryBackoff = backoff
+	}
 }
 
-func NewMCPClientTool(client *client.Client, tool schema.Tool) *MCPClientTool {
-	return &MCPClientTool{client: client, tool: tool}
+func NewMCPClientTool(client *client.Client, tool schema.Tool, opts ...MCPToolOption) *MCPClientTool {
+	t := &MCPClientTool{client: client, tool: tool, callTimeout: DefaultCallTimeout}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
 }
 
 func (m *MCPClientTool) GetName() string {
@@ -31,6 +75,15 @@ func (m *MCPClientTool) GetTool() schema.Tool {
 }
 
 func (m *MCPClientTool) Execute(ctx context.Context, arguments map[string]any) (string, error) {
+	result, err := m.ExecuteStructured(ctx, arguments)
+	if err != nil {
+		return "", err
+	}
+	return result.Text, nil
+}
+
+// ExecuteStructured 执行工具，文本内容合并为 Text，嵌入的资源作为 Data，服务端返回的 _meta 作为 Metadata
+func (m *MCPClientTool) ExecuteStructured(ctx context.Context, arguments map[string]any) (Result, error) {
 	toolRequest := mcp.CallToolRequest{
 		Request: mcp.Request{
 			Method: "tools/call",
@@ -38,14 +91,85 @@ func (m *MCPClientTool) Execute(ctx context.Context, arguments map[string]any) (
 	}
 	toolRequest.Params.Name = m.tool.Function.Name
 	toolRequest.Params.Arguments = arguments
-	result, err := m.client.CallTool(ctx, toolRequest)
-	if err != nil {
-		return "", fmt.Errorf("call tool failed: %v", err)
+	// 将用户身份传给 MCP 服务器，以便代表用户执行的工具限定操作范围
+	if id, ok := auth.FromContext(ctx); ok {
+		toolRequest.Params.Meta = &mcp.Meta{AdditionalFields: map[string]any{auth.IdentityMetaKey: id}}
+	}
+	var (
+		result *mcp.CallToolResult
+		err    error
+	)
+	for attempt := 0; ; attempt++ {
+		if result, err = m.call(ctx, toolRequest); err == nil {
+			break
+		}
+		// 对话被打断或重试次数用完时不再重试
+		if ctx.Err() != nil || attempt >= m.retries {
+			return Result{}, err
+		}
+		select {
+		case <-ctx.Done():
+			return Result{}, err
+		case <-time.After(m.retryBackoff):
+		}
+	}
+
+	var (
+		texts     []string
+		resources []any
+	)
+	for _, content := range result.Content {
+		switch c := content.(type) {
+		case mcp.TextContent:
+			texts = append(texts, c.Text)
+		case mcp.EmbeddedResource:
+			resources = append(resources, c.Resource)
+		}
+	}
+	text := strings.Join(texts, "\n")
+	if result.IsError {
+		return Result{}, fmt.Errorf("tool returned an error: %s", text)
+	}
+
+	toolResult := Result{Text: text, Metadata: result.Meta}
+	if len(resources) > 0 {
+		toolResult.Data = resources
+	}
+	return toolResult, nil
+}
+
+// call 在超时时间内调用一次工具，超时返回 ErrCallTimeout，便于模型据此换用其他方式回答
+func (m *MCPClientTool) call(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	callCtx := ctx
+	if m.callTimeout > 0 {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithTimeout(ctx, m.callTimeout)
+		defer cancel()
+	}
+	mcpClient := m.client
+	if m.connect != nil {
+		var err error
+		if mcpClient, err = m.connect(callCtx); err != nil {
+			return nil, err
+		}
 	}
-	if len(result.Content) == 0 {
-		return "", nil
+	result, err := mcpClient.CallTool(callCtx, request)
+	if err != nil {
+		// 连接已断开或服务器挂起，对话被打断则不影响连接
+		if m.evict != nil && ctx.Err() == nil && isTransportError(err) {
+			m.evict(mcpClient)
+		}
+		if ctx.Err() == nil && errors.Is(callCtx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w after %v", ErrCallTimeout, m.callTimeout)
+		}
+		return nil, fmt.Errorf("call tool failed: %v", err)
 	}
-	return result.Content[0].(mcp.TextContent).Text, nil
+	return result, nil
+}
+
+// isTransportError 是否为传输层的错误，服务器返回的 JSON-RPC 错误不属于此类
+func isTransportError(err error) bool {
+	return strings.HasPrefix(err.Error(), "transport error")
 }
 
 // MCPClient 连接到多个 MCP 服务器并通过 Model Context Protocol 管理可用工具的工具集合。
@@ -55,18 +179,30 @@ type MCPClient struct {
 	version    string
 	headers    map[string]string
 	// 连接管理
+	lock          sync.Mutex
+	specs         map[string]ServerSpec     // k: serverId, v: 连接方式，用于按需连接
 	sessions      map[string]*client.Client // k: serverId, v: MCP connect client
 	session2Tools map[string][]string       // k: serverId, v: list of tool's name
+	instructions  map[string]string         // k: serverId, v: 服务器在初始化时提供的使用说明
+	closed        bool                      // 已调用 Close，不再按需连接
+	// ctx 连接的生命周期，SSE 的事件流与启动时的 context 绑定，因此不能使用单次调用的 context，Close 时结束
+	ctx    context.Context
+	cancel context.CancelFunc
 	// 获取到的MCP Server的必要数据
 	Tools map[string]Caller // k: tool's name, v: MCPClientTool
 }
 
 func NewMCPClient(serverName, version string, headers map[string]string) *MCPClient {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &MCPClient{
-		serverName: serverName,
-		version:    version,
-		headers:    headers,
-		sessions:   make(map[string]*client.Client),
+		serverName:   serverName,
+		version:      version,
+		headers:      headers,
+		specs:        make(map[string]ServerSpec),
+		sessions:     make(map[string]*client.Client),
+		instructions: make(map[string]string),
+		ctx:          ctx,
+		cancel:       cancel,
 	}
 }
 
@@ -138,7 +274,8 @@ func (m *MCPClient) initialize(ctx context.Context, serverId string) error {
 	if !ok {
 		return fmt.Errorf("serverId %s is not exists", serverId)
 	}
-	if err := mcpClient.Start(ctx); err != nil {
+	// 启动传输层使用连接的生命周期，初始化及获取工具仍受调用方的 context 约束
+	if err := mcpClient.Start(m.ctx); err != nil {
 		return fmt.Errorf("mcp client start failed: %v", err)
 	}
 
@@ -155,6 +292,7 @@ func (m *MCPClient) initialize(ctx context.Context, serverId string) error {
 	if err != nil {
 		return fmt.Errorf("initialize mcp client failed: %v", err)
 	}
+	m.instructions[serverId] = strings.TrimSpace(initResult.Instructions)
 
 	if initResult.Capabilities.Tools != nil {
 		if err = m.getTools(ctx, serverId); err != nil {
@@ -179,13 +317,7 @@ func (m *MCPClient) getTools(ctx context.Context, serverId string) error {
 		return err
 	}
 
-	if m.Tools == nil {
-		m.Tools = make(map[string]Caller, len(toolList.Tools))
-	}
-	if m.session2Tools == nil {
-		m.session2Tools = make(map[string][]string)
-	}
-
+	tools := make([]schema.Tool, 0, len(toolList.Tools))
 	for _, t := range toolList.Tools {
 		tool := schema.Tool{
 			Type: "function",
@@ -199,12 +331,17 @@ func (m *MCPClient) getTools(ctx context.Context, serverId string) error {
 				},
 			},
 		}
-		m.Tools[t.Name] = NewMCPClientTool(mcpClient, tool)
-		m.session2Tools[serverId] = append(m.session2Tools[serverId], t.Name)
+		tools = append(tools, tool)
 	}
+	m.addTools(serverId, tools)
 	return nil
 }
 
+// Instructions 服务器在初始化时提供的使用说明，未提供时为空
+func (m *MCPClient) Instructions(serverId string) string {
+	return m.instructions[serverId]
+}
+
 func (m *MCPClient) Disconnect(serverId string) error {
 	if serverId == "" {
 		return errors.New("server id is required")
@@ -220,3 +357,19 @@ func (m *MCPClient) Disconnect(serverId string) error {
 	}
 	return nil
 }
+
+// Close 断开所有服务器的连接，之后调用工具将返回错误，不再重新连接，避免会话结束时仍在执行的工具泄漏连接
+func (m *MCPClient) Close() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.closed = true
+	defer m.cancel()
+	var errs []error
+	for serverId, c := range m.sessions {
+		if err := c.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to disconnect server %s: %v", serverId, err))
+		}
+		delete(m.sessions, serverId)
+	}
+	return errors.Join(errs...)
+}
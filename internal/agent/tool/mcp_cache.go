@@ -0,0 +1,166 @@
+package tool
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/client"
+
+	"crow/internal/agent/schema"
+)
+
+// DefaultSchemaCacheTTL 工具定义缓存的默认有效期
+const DefaultSchemaCacheTTL = 10 * time.Minute
+
+// ServerSpec MCP 服务器的连接方式
+type ServerSpec struct {
+	Type    string // stdio、sse 或 streamableHttp
+	Command string // stdio 需要
+	Args    []string
+	URL     string // sse、streamableHttp 需要
+
+	// 以下为调用工具的设置，不影响连接
+	CallTimeout     time.Duration // 单次调用的超时时间，0为使用默认值，负数为不限制
+	Retries         int           // 幂等工具超时或连接出错时的重试次数
+	IdempotentTools []string      // 可以安全重试的工具名称，* 表示全部工具
+}
+
+// toolOptions 服务器中指定工具的调用设置
+func (s ServerSpec) toolOptions(name string) []MCPToolOption {
+	opts := []MCPToolOption{WithCallTimeout(s.CallTimeout)}
+	if s.Retries > 0 && (slices.Contains(s.IdempotentTools, "*") || slices.Contains(s.IdempotentTools, name)) {
+		opts = append(opts, WithRetry(s.Retries, time.Second))
+	}
+	return opts
+}
+
+type schemaCacheEntry struct {
+	tools        []schema.Tool
+	instructions string
+	expireAt     time.Time
+}
+
+var (
+	schemaCacheLock sync.Mutex
+	// schemaCache 进程级的工具定义缓存，k: 服务器标识、连接方式及请求头，连接方式变更后自然失效
+	schemaCache = map[string]schemaCacheEntry{}
+)
+
+// ConnectCached 连接 MCP 服务器，工具定义优先使用进程级缓存
+// 缓存命中时不建立连接，仅登记工具，首次调用工具时才建立连接；未命中时立即连接并缓存工具定义
+// @param ttl 缓存的有效期，0则使用默认值，负数为不缓存
+func (m *MCPClient) ConnectCached(ctx context.Context, serverId string, spec ServerSpec, ttl time.Duration) error {
+	m.specs[serverId] = spec
+	if ttl == 0 {
+		ttl = DefaultSchemaCacheTTL
+	}
+	key := m.schemaCacheKey(serverId, spec)
+	if ttl > 0 {
+		schemaCacheLock.Lock()
+		entry, ok := schemaCache[key]
+		schemaCacheLock.Unlock()
+		if ok && time.Now().Before(entry.expireAt) {
+			m.addTools(serverId, entry.tools)
+			m.instructions[serverId] = entry.instructions
+			return nil
+		}
+	}
+
+	if err := m.connect(ctx, serverId, spec); err != nil {
+		return err
+	}
+	if ttl > 0 {
+		tools := make([]schema.Tool, 0, len(m.session2Tools[serverId]))
+		for _, name := range m.session2Tools[serverId] {
+			tools = append(tools, m.Tools[name].GetTool())
+		}
+		schemaCacheLock.Lock()
+		schemaCache[key] = schemaCacheEntry{tools: tools, instructions: m.instructions[serverId], expireAt: time.Now().Add(ttl)}
+		schemaCacheLock.Unlock()
+	}
+	return nil
+}
+
+func (m *MCPClient) connect(ctx context.Context, serverId string, spec ServerSpec) error {
+	switch spec.Type {
+	case "stdio":
+		return m.ConnectStdio(ctx, serverId, spec.Command, spec.Args...)
+	case "sse":
+		return m.ConnectSSE(ctx, serverId, spec.URL)
+	case "streamableHttp":
+		return m.ConnectStreamableHTTP(ctx, serverId, spec.URL)
+	}
+	return fmt.Errorf("unknown server type: %s", spec.Type)
+}
+
+// session 获取服务器的连接，尚未连接或已断开时按登记的连接方式重新连接
+func (m *MCPClient) session(ctx context.Context, serverId string) (*client.Client, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if c, ok := m.sessions[serverId]; ok {
+		return c, nil
+	}
+	if m.closed {
+		return nil, fmt.Errorf("mcp client is closed")
+	}
+	spec, ok := m.specs[serverId]
+	if !ok {
+		return nil, fmt.Errorf("serverId %s is not exists", serverId)
+	}
+	if err := m.connect(ctx, serverId, spec); err != nil {
+		if c, ok := m.sessions[serverId]; ok {
+			_ = c.Close()
+			delete(m.sessions, serverId)
+		}
+		return nil, fmt.Errorf("failed to connect server %s: %v", serverId, err)
+	}
+	return m.sessions[serverId], nil
+}
+
+// evict 丢弃已失效的连接，下次调用时重新连接；连接已被替换时不做处理
+func (m *MCPClient) evict(serverId string, c *client.Client) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.sessions[serverId] != c {
+		return
+	}
+	delete(m.sessions, serverId)
+	_ = c.Close()
+}
+
+// addTools 登记服务器的工具，调用时通过 session 获取连接
+func (m *MCPClient) addTools(serverId string, tools []schema.Tool) {
+	if m.Tools == nil {
+		m.Tools = make(map[string]Caller, len(tools))
+	}
+	if m.session2Tools == nil {
+		m.session2Tools = make(map[string][]string)
+	}
+	m.session2Tools[serverId] = m.session2Tools[serverId][:0]
+	spec := m.specs[serverId]
+	for _, t := range tools {
+		caller := NewMCPClientTool(nil, t, spec.toolOptions(t.Function.Name)...)
+		caller.connect = func(ctx context.Context) (*client.Client, error) {
+			return m.session(ctx, serverId)
+		}
+		caller.evict = func(c *client.Client) {
+			m.evict(serverId, c)
+		}
+		m.Tools[t.Function.Name] = caller
+		m.session2Tools[serverId] = append(m.session2Tools[serverId], t.Function.Name)
+	}
+}
+
+func (m *MCPClient) schemaCacheKey(serverId string, spec ServerSpec) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\xff%s\xff%s\xff%q\xff%s", serverId, spec.Type, spec.Command, spec.Args, spec.URL)
+	for _, k := range slices.Sorted(maps.Keys(m.headers)) {
+		fmt.Fprintf(&b, "\xff%s=%s", k, m.headers[k])
+	}
+	return b.String()
+}
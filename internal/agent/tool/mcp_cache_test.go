@@ -0,0 +1,161 @@
+package tool
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func newEchoServer(t *testing.T) (*httptest.Server, *atomic.Bool) {
+	t.Helper()
+	s := server.NewMCPServer("echo", "1.0.0")
+	s.AddTool(mcp.NewTool("echo", mcp.WithString("text")), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText(req.GetString("text", "")), nil
+	})
+	// down 为 true 时拒绝所有请求，模拟服务器断开
+	down := &atomic.Bool{}
+	var sse *server.SSEServer
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		sse.ServeHTTP(w, r)
+	}))
+	sse = server.NewSSEServer(s, server.WithBaseURL(ts.URL))
+	t.Cleanup(ts.Close)
+	return ts, down
+}
+
+func callEcho(t *testing.T, m *MCPClient, timeout time.Duration) (string, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return m.Tools["echo"].Execute(ctx, map[string]any{"text": "hi"})
+}
+
+func TestConnectCached(t *testing.T) {
+	var inits atomic.Int32
+	hooks := &server.Hooks{}
+	hooks.AddAfterInitialize(func(context.Context, any, *mcp.InitializeRequest, *mcp.InitializeResult) {
+		inits.Add(1)
+	})
+	s := server.NewMCPServer("echo", "1.0.0", server.WithHooks(hooks))
+	s.AddTool(mcp.NewTool("echo", mcp.WithString("text")), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText(req.GetString("text", "")), nil
+	})
+	ts := httptest.NewServer(server.NewStreamableHTTPServer(s))
+	defer ts.Close()
+
+	spec := ServerSpec{Type: "streamableHttp", URL: ts.URL}
+	call := func(c Caller) (string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return c.Execute(ctx, map[string]any{"text": "hi"})
+	}
+
+	// 首次连接时握手并缓存工具定义
+	first := NewMCPClient("test", "1.0.0", nil)
+	if err := first.ConnectCached(context.Background(), "echo", spec, time.Minute); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer first.Disconnect("echo")
+	if n := inits.Load(); n != 1 {
+		t.Fatalf("initializations = %d, want 1", n)
+	}
+
+	// 缓存命中时仅登记工具，首次调用时才建立连接
+	second := NewMCPClient("test", "1.0.0", nil)
+	if err := second.ConnectCached(context.Background(), "echo", spec, time.Minute); err != nil {
+		t.Fatalf("connect cached: %v", err)
+	}
+	defer second.Disconnect("echo")
+	echo, ok := second.Tools["echo"]
+	if !ok {
+		t.Fatal("cached tool is not registered")
+	}
+	if n := inits.Load(); n != 1 {
+		t.Fatalf("initializations after cache hit = %d, want 1", n)
+	}
+	if text, err := call(echo); err != nil || text != "hi" {
+		t.Fatalf("call: text = %q, err = %v", text, err)
+	}
+	if n := inits.Load(); n != 2 {
+		t.Fatalf("initializations after call = %d, want 2", n)
+	}
+
+	// 断开后，已获取的工具再次调用时会重新连接
+	if err := second.Disconnect("echo"); err != nil {
+		t.Fatalf("disconnect: %v", err)
+	}
+	if text, err := call(echo); err != nil || text != "hi" {
+		t.Fatalf("call after disconnect: text = %q, err = %v", text, err)
+	}
+	if n := inits.Load(); n != 3 {
+		t.Fatalf("initializations after reconnect = %d, want 3", n)
+	}
+}
+
+func TestConnectCachedLazySessionOutlivesCall(t *testing.T) {
+	ts, _ := newEchoServer(t)
+	spec := ServerSpec{Type: "sse", URL: ts.URL + "/sse"}
+
+	// 首个客户端建立连接并缓存工具定义
+	warm := NewMCPClient("test", "1.0.0", nil)
+	if err := warm.ConnectCached(context.Background(), "echo", spec, time.Minute); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	_ = warm.Close()
+
+	// 缓存命中，首次调用时才以调用的 context 按需连接
+	m := NewMCPClient("test", "1.0.0", nil)
+	defer m.Close()
+	if err := m.ConnectCached(context.Background(), "echo", spec, time.Minute); err != nil {
+		t.Fatalf("connect cached: %v", err)
+	}
+	if len(m.sessions) != 0 {
+		t.Fatalf("expected lazy connection, got %d sessions", len(m.sessions))
+	}
+	for i := 0; i < 3; i++ {
+		text, err := callEcho(t, m, 5*time.Second)
+		if err != nil || text != "hi" {
+			t.Fatalf("call %d: text = %q, err = %v", i, text, err)
+		}
+	}
+}
+
+func TestSessionEvictedAfterTransportError(t *testing.T) {
+	ts, down := newEchoServer(t)
+	m := NewMCPClient("test", "1.0.0", nil)
+	defer m.Close()
+	if err := m.ConnectCached(context.Background(), "echo", ServerSpec{Type: "sse", URL: ts.URL + "/sse"}, -1); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	first := m.sessions["echo"]
+
+	down.Store(true)
+	if _, err := callEcho(t, m, 5*time.Second); err == nil {
+		t.Fatal("expected an error while the server is down")
+	}
+	m.lock.Lock()
+	_, ok := m.sessions["echo"]
+	m.lock.Unlock()
+	if ok {
+		t.Fatal("broken session should be evicted")
+	}
+
+	down.Store(false)
+	text, err := callEcho(t, m, 5*time.Second)
+	if err != nil || text != "hi" {
+		t.Fatalf("call after recovery: text = %q, err = %v", text, err)
+	}
+	if m.sessions["echo"] == first {
+		t.Fatal("expected a new session after reconnecting")
+	}
+}
@@ -0,0 +1,57 @@
+package tool
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func TestCloseRefusesReconnect(t *testing.T) {
+	var inits atomic.Int32
+	hooks := &server.Hooks{}
+	hooks.AddAfterInitialize(func(context.Context, any, *mcp.InitializeRequest, *mcp.InitializeResult) {
+		inits.Add(1)
+	})
+	s := server.NewMCPServer("echo", "1.0.0", server.WithHooks(hooks))
+	s.AddTool(mcp.NewTool("echo", mcp.WithString("text")), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText(req.GetString("text", "")), nil
+	})
+	ts := httptest.NewServer(server.NewStreamableHTTPServer(s))
+	defer ts.Close()
+
+	m := NewMCPClient("test", "1.0.0", nil)
+	if err := m.ConnectCached(context.Background(), "echo", ServerSpec{Type: "streamableHttp", URL: ts.URL}, -1); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	call := func() (string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return m.Tools["echo"].Execute(ctx, map[string]any{"text": "hi"})
+	}
+
+	// 多次调用复用同一连接
+	for i := 0; i < 3; i++ {
+		if text, err := call(); err != nil || text != "hi" {
+			t.Fatalf("call %d: text = %q, err = %v", i, text, err)
+		}
+	}
+	if n := inits.Load(); n != 1 {
+		t.Fatalf("initializations = %d, want 1", n)
+	}
+
+	// 关闭后调用失败，且不再重新连接
+	if err := m.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, err := call(); err == nil {
+		t.Fatal("call after close succeeded, want error")
+	}
+	if n := inits.Load(); n != 1 {
+		t.Fatalf("initializations after close = %d, want 1", n)
+	}
+}
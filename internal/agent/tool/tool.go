@@ -2,6 +2,7 @@ package tool
 
 import (
 	"context"
+	"encoding/json"
 
 	"crow/internal/agent/schema"
 )
@@ -16,3 +17,44 @@ type Caller interface {
 	// @return string: 执行的结果
 	Execute(ctx context.Context, arguments map[string]any) (string, error)
 }
+
+// Result 工具的结构化执行结果
+type Result struct {
+	Text     string         // 文本结果
+	Data     any            // 可选的结构化数据，以JSON的形式提供给模型，避免模型从文本中重新解析
+	Metadata map[string]any // 可选的元数据，如引用来源
+}
+
+// StructuredCaller 可选的工具接口，实现该接口的工具会优先以结构化的方式执行，简单的工具只需实现 Caller
+type StructuredCaller interface {
+	Caller
+	// ExecuteStructured 执行工具并返回结构化的结果
+	// @param arguments: 需要执行的参数
+	ExecuteStructured(ctx context.Context, arguments map[string]any) (Result, error)
+}
+
+// Execute 执行工具，工具实现了 StructuredCaller 时使用结构化的执行方式
+func Execute(ctx context.Context, caller Caller, arguments map[string]any) (Result, error) {
+	if c, ok := caller.(StructuredCaller); ok {
+		return c.ExecuteStructured(ctx, arguments)
+	}
+	text, err := caller.Execute(ctx, arguments)
+	return Result{Text: text}, err
+}
+
+// Content 转换为提供给模型的工具消息内容，仅有文本时直接使用文本，否则序列化为JSON
+func (r Result) Content() string {
+	if r.Data == nil && len(r.Metadata) == 0 {
+		return r.Text
+	}
+	content := struct {
+		Text     string         `json:"text,omitempty"`
+		Data     any            `json:"data,omitempty"`
+		Metadata map[string]any `json:"metadata,omitempty"`
+	}{r.Text, r.Data, r.Metadata}
+	data, err := json.Marshal(content)
+	if err != nil {
+		return r.Text
+	}
+	return string(data)
+}
@@ -2,10 +2,26 @@ package asr
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"crow/internal/config"
+	"crow/pkg/lifecycle"
 )
 
+const (
+	// DefaultMaxRetries 未配置时建立连接的最大尝试次数
+	DefaultMaxRetries = 2
+	// DefaultIdleTimeout 未配置时的空闲超时，开始监听后超过该时长仍无识别文本，空结果才计为静音
+	DefaultIdleTimeout = 30 * time.Second
+	// DefaultMaxIdleTimeout 未配置时客户端可设置的空闲超时上限，避免客户端长时间占用服务商的连接
+	DefaultMaxIdleTimeout = 2 * time.Minute
+)
+
+// ErrUnavailable 服务暂时不可用，即多次尝试后仍无法与服务商建立连接，或连接数已满等待超时
+// Provider 返回的错误可通过 errors.Is 判断，以便告知客户端而不只是记录日志
+var ErrUnavailable = errors.New("asr service unavailable")
+
 // State asr识别状态
 type State int
 
@@ -27,6 +43,39 @@ type Listener interface {
 	OnAsrResult(ctx context.Context, result string, state State) bool
 }
 
+// Detail 语音识别结果的附加信息，仅在服务商提供时填充，未提供的字段为零值
+type Detail struct {
+	Result     string  // 识别结果
+	State      State   // 识别状态
+	Language   string  // 识别出的语种
+	Confidence float64 // 识别置信度[0-1]，可作为音频质量的粗略参考，0表示未知
+	BeginTime  int64   // 语句在音频流中的开始时间，单位毫秒
+	EndTime    int64   // 语句在音频流中的结束时间，单位毫秒，语句未结束时为0
+	Words      []Word  // 逐词的识别结果
+}
+
+// Word 逐词的识别结果，时间均为词在音频流中的位置，单位毫秒
+type Word struct {
+	Text        string
+	Punctuation string // 词后的标点
+	BeginTime   int64
+	EndTime     int64 // 词尚未确定结束时为0
+}
+
+// DetailListener 可选的语音识别事件监听者，用于获取识别结果的附加信息
+// Listener 同时实现该接口时，Provider 会在每次 OnAsrResult 之前回调 OnAsrResultDetail
+type DetailListener interface {
+	// OnAsrResultDetail 语音识别结果附加信息回调
+	OnAsrResultDetail(ctx context.Context, detail Detail)
+}
+
+// NotifyDetail 若监听者实现了 DetailListener，则回调识别结果附加信息
+func NotifyDetail(ctx context.Context, listener Listener, detail Detail) {
+	if l, ok := listener.(DetailListener); ok {
+		l.OnAsrResultDetail(ctx, detail)
+	}
+}
+
 type Config struct {
 	config.AsrConfig
 	// 以下为可选参数
@@ -36,9 +85,15 @@ type Config struct {
 	Format     string // 音频格式
 	EnablePunc bool   // 是否启用标点符号
 	VadEos     int    // 语音活动检测时长后端点(vad_eos)，0为关闭，单位毫秒
+	// IdleTimeout 开始监听后超过该时长仍无识别文本，空结果计为静音，连续静音后结束识别
+	IdleTimeout time.Duration
 }
 
 type Provider interface {
+	// Lifecycle 连接的生命周期，Start 可用于预先建连，Stop 同 Reset
+	lifecycle.Lifecycle
+	// Name 服务商的稳定标识，如 paraformer、doubao，用于日志与监控
+	Name() string
 	// SetConfig 设置 Provider 的配置
 	// @param cfg: 客户端需求的配置
 	// @return *Config: 实际请求的配置
@@ -50,6 +105,10 @@ type Provider interface {
 	SendAudio(ctx context.Context, data []byte) error
 	// GetSilenceCount 获取当前的静音次数
 	GetSilenceCount() int
+	// Finalize 通知服务端音频已发送完毕，立即结束本次识别，最终结果以 StateCompleted 回调
+	Finalize() error
+	// Abort 中止当前语句的识别，丢弃该语句后续的识别结果，但保留连接，后续音频可继续识别
+	Abort() error
 	// Reset 重置 Provider
 	Reset() error
 }
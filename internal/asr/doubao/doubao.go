@@ -1,51 +1,71 @@
 package doubao
 
 import (
-	"bytes"
-	"compress/gzip"
 	"context"
-	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 
 	"crow/internal/asr"
+	"crow/pkg/connlimit"
+	"crow/pkg/lifecycle"
 	"crow/pkg/log"
+	"crow/pkg/netproxy"
+	"crow/pkg/volcproto"
 )
 
 // 豆包大模型流式语音识别 API 文档
 // https://www.volcengine.com/docs/6561/1354869
 
 const (
-	wsURL       = "wss://openspeech.bytedance.com/api/v3/sauc/bigmodel_async"
-	idleTimeout = 30 * time.Second
+	wsURL = "wss://openspeech.bytedance.com/api/v3/sauc/bigmodel_async"
+
+	resourceIDDuration   = "volc.bigasr.sauc.duration"   // 小时版
+	resourceIDConcurrent = "volc.bigasr.sauc.concurrent" // 并发版
+
+	heartbeatInterval = 3 * time.Second // 超过该时长未发送音频，则发送一次静音帧保活
+	heartbeatFrameMs  = 100             // 每个静音帧的时长，单位毫秒
 )
 
 type Doubao struct {
-	cfg *asr.Config
+	cfg atomic.Pointer[asr.Config] // 当前生效的配置，会话中可被整体替换，通过 config 读取
 	log *log.Logger
 
 	conn     *websocket.Conn
+	release  func() // 释放占用的连接名额
 	listener asr.Listener
 
-	lock sync.Mutex
+	lock      sync.Mutex
+	writeLock sync.Mutex // 音频发送与心跳保活可能并发写入连接
 
-	isRunning bool
+	state     lifecycle.State // 连接的生命周期状态
 	reqID     string
 	connectID string
 	taskID    string
 
 	sendDataCnt     int
 	startListenTime time.Time
-	silenceCount    int
+	silenceCount    int32
+
+	connectCost     time.Duration // 建立连接的耗时
+	firstSendTime   int64         // 本次会话首次发送音频的时间，UnixNano
+	firstResultCost int64         // 首次发送音频到收到首个非空识别结果的耗时，单位纳秒
+	sendBytes       int64         // 本次会话发送的音频字节数
+
+	speaking   int32 // 当前是否有未结束的语句，0：否，1：是
+	discarding int32 // 是否丢弃当前语句的识别结果，0：否，1：是
+
+	utteranceStart int64 // 当前语句开始识别出文本的时间，UnixNano，0表示当前没有语句
+
+	lastSendTime int64 // 最近一次发送真实音频的时间，UnixNano，不包括心跳静音帧
 }
 
 func NewDoubao(log *log.Logger) *Doubao {
@@ -55,6 +75,10 @@ func NewDoubao(log *log.Logger) *Doubao {
 	}
 }
 
+func (d *Doubao) Name() string {
+	return "doubao"
+}
+
 func (d *Doubao) SetConfig(cfg *asr.Config) *asr.Config {
 	if cfg.Language == "" || cfg.Language == "zh" {
 		cfg.Language = "zh-CN"
@@ -66,8 +90,32 @@ func (d *Doubao) SetConfig(cfg *asr.Config) *asr.Config {
 	if cfg.VadEos < 200 {
 		cfg.VadEos = 800
 	}
-	d.cfg = cfg
-	return d.cfg
+	if cfg.MaxUtteranceMs < 0 {
+		cfg.MaxUtteranceMs = 0
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = asr.DefaultIdleTimeout
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = wsURL
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = asr.DefaultMaxRetries
+	}
+	// 资源ID需与账号开通的计费版本一致，支持简写 duration、concurrent
+	switch cfg.ResourceID {
+	case "", "duration":
+		cfg.ResourceID = resourceIDDuration
+	case "concurrent":
+		cfg.ResourceID = resourceIDConcurrent
+	}
+	d.cfg.Store(cfg)
+	return cfg
+}
+
+// config 当前生效的配置，SetConfig 整体替换配置，因此可与各协程并发读取
+func (d *Doubao) config() *asr.Config {
+	return d.cfg.Load()
 }
 
 func (d *Doubao) SetListener(listener asr.Listener) {
@@ -75,187 +123,166 @@ func (d *Doubao) SetListener(listener asr.Listener) {
 }
 
 func (d *Doubao) SendAudio(ctx context.Context, data []byte) error {
-	// 使用锁检查状态
-	d.lock.Lock()
-	isRunning := d.isRunning
-	d.lock.Unlock()
-
-	if !isRunning {
-		if err := d.initConnection(ctx); err != nil {
-			return err
-		}
+	if err := d.Start(ctx); err != nil {
+		return err
 	}
 
 	// 检查是否有实际数据需要发送
-	if len(data) > 0 && d.isRunning {
+	if len(data) > 0 && d.state.Running() {
 		// 直接发送音频数据
 		err := d.sendAudioData(data, false)
 		if err != nil {
 			return err
 		}
+		atomic.StoreInt64(&d.lastSendTime, time.Now().UnixNano())
 		d.sendDataCnt++
-		if d.sendDataCnt%20 == 0 {
-			d.log.Debugf("send audio data cnt: %d", d.sendDataCnt)
-		}
+		atomic.AddInt64(&d.sendBytes, int64(len(data)))
+		atomic.CompareAndSwapInt64(&d.firstSendTime, 0, time.Now().UnixNano())
 	}
 	return nil
 }
 
-// Protocol constants
-const (
-	clientFullRequest  = 0x1
-	clientAudioRequest = 0x2
-)
-
-// Sequence types
-const (
-	noSequence  = 0x0
-	negSequence = 0x2
-)
-
-// Serialization methods
-const (
-	noSerialization = 0x0
-	jsonFormat      = 0x1
-	gzipCompression = 0x1
-)
-
-// generateHeader 生成协议头
-func (d *Doubao) generateHeader(messageType uint8, flags uint8, serializationMethod uint8) []byte {
-	header := make([]byte, 4)
-	header[0] = (1 << 4) | 1                                 // 协议版本(4位) + 头大小(4位)
-	header[1] = (messageType << 4) | flags                   // 消息类型(4位) + 消息标志(4位)
-	header[2] = (serializationMethod << 4) | gzipCompression // 序列化方法(4位) + 压缩方法(4位)
-	header[3] = 0                                            // 保留字段
-	return header
-}
-
 // constructRequest 构造请求数据
 func (d *Doubao) constructRequest() map[string]any {
+	cfg := d.config()
 	return map[string]any{
 		"user": map[string]any{
 			"uid": d.connectID,
 		},
 		"audio": map[string]any{
-			"format":   d.cfg.Format,     // pcm(pcm_s16le)/wav(pcm_s16le)
-			"codec":    "raw",            // 默认raw音频格式，opus/raw
-			"rate":     d.cfg.SampleRate, // 目前只有16000
+			"format":   cfg.Format,     // pcm(pcm_s16le)/wav(pcm_s16le)
+			"codec":    "raw",          // 默认raw音频格式，opus/raw
+			"rate":     cfg.SampleRate, // 目前只有16000
 			"bits":     16,
 			"channel":  1,
-			"language": d.cfg.Language, // Added language as per doc example
+			"language": cfg.Language, // Added language as per doc example
 		},
 		"request": map[string]any{
 			"model_name":           "bigmodel", // 目前只有bigmodel
 			"enable_itn":           true,
-			"enable_punc":          d.cfg.EnablePunc,
+			"enable_punc":          cfg.EnablePunc,
 			"enable_ddc":           false,
-			"show_utterances":      true,         // 输出语音停顿、分句、分词信息，默认false
-			"result_type":          "single",     // 默认为"full"，全量返回，设置为"single"则为增量结果返回，即不返回之前分句的结果
-			"vad_segment_duration": 3000,         // 单位ms，默认为3000。当静音时间超过该值时，会将文本分为两个句子。不决定判停，所以不会修改definite出现的位置。在end_window_size配置后，该参数失效。
-			"end_window_size":      d.cfg.VadEos, // 单位ms，默认为800，最小200。静音时长超过该值，会直接判停，输出definite。配置该值，不使用语义分句，根据静音时长来分句。用于实时性要求较高场景，可以提前获得definite句子
-			"force_to_speech_time": 1000,         // 单位ms，默认为10000，最小1。音频时长超过该值之后，才会判停，根据静音时长输出definite，需配合end_window_size使用。用于解决短音频+实时性要求较高场景，不配置该参数，只使用end_window_size时，前10s不会判停。推荐设置1000，可能会影响识别准确率。
+			"show_utterances":      true,       // 输出语音停顿、分句、分词信息，默认false
+			"result_type":          "single",   // 默认为"full"，全量返回，设置为"single"则为增量结果返回，即不返回之前分句的结果
+			"vad_segment_duration": 3000,       // 单位ms，默认为3000。当静音时间超过该值时，会将文本分为两个句子。不决定判停，所以不会修改definite出现的位置。在end_window_size配置后，该参数失效。
+			"end_window_size":      cfg.VadEos, // 单位ms，默认为800，最小200。静音时长超过该值，会直接判停，输出definite。配置该值，不使用语义分句，根据静音时长来分句。用于实时性要求较高场景，可以提前获得definite句子
+			"force_to_speech_time": 1000,       // 单位ms，默认为10000，最小1。音频时长超过该值之后，才会判停，根据静音时长输出definite，需配合end_window_size使用。用于解决短音频+实时性要求较高场景，不配置该参数，只使用end_window_size时，前10s不会判停。推荐设置1000，可能会影响识别准确率。
 		},
 	}
 }
 
 type serverResponse struct {
 	Result struct {
-		Text       string `json:"text"`
-		Utterances []struct {
-			Text     string `json:"text"`
-			Definite bool   `json:"definite"`
-		} `json:"utterances"`
+		Text       string      `json:"text"`
+		Language   string      `json:"language,omitempty"`   // 识别出的语种，服务端返回时才有
+		Confidence float64     `json:"confidence,omitempty"` // 识别置信度，服务端返回时才有
+		Utterances []utterance `json:"utterances"`
 	} `json:"result"`
 }
 
+// utterance 分句信息，时间均为在音频流中的位置，单位毫秒
+type utterance struct {
+	Text      string `json:"text"`
+	Definite  bool   `json:"definite"`
+	StartTime int64  `json:"start_time"`
+	EndTime   int64  `json:"end_time"`
+	Words     []struct {
+		Text      string `json:"text"`
+		StartTime int64  `json:"start_time"`
+		EndTime   int64  `json:"end_time"`
+	} `json:"words"`
+}
+
 type synResp struct {
 	Code       int32
 	ErrMsg     string
-	Text       string // 识别文本
-	IsLast     bool   // 是否已结束
-	IsDefinite bool   // 是否明确是分句
-}
-
-func (d *Doubao) gzipCompress(input []byte) []byte {
-	var b bytes.Buffer
-	w := gzip.NewWriter(&b)
-	_, _ = w.Write(input)
-	_ = w.Close()
-	return b.Bytes()
-}
-
-func (d *Doubao) gzipDecompress(input []byte) []byte {
-	b := bytes.NewBuffer(input)
-	r, _ := gzip.NewReader(b)
-	out, _ := io.ReadAll(r)
-	_ = r.Close()
-	return out
+	Text       string  // 识别文本
+	IsLast     bool    // 是否已结束
+	IsDefinite bool    // 是否明确是分句
+	Language   string  // 识别出的语种
+	Confidence float64 // 识别置信度
+	BeginTime  int64   // 语句开始时间，单位毫秒
+	EndTime    int64   // 语句结束时间，单位毫秒，语句未结束时为0
+	Words      []asr.Word
 }
 
 // parseResponse 解析响应数据
 func (d *Doubao) parseResponse(data []byte) (synResp, error) {
-	headerSize := data[0] & 0x0f
-	messageType := data[1] >> 4
-	messageTypeSpecificFlags := data[1] & 0x0f
-	messageCompression := data[2] & 0x0f
-	messageSerializationMethod := data[2] >> 4
-
-	payload := data[headerSize*4:]
-
 	var resp synResp
+	msg, err := volcproto.Parse(data)
+	if err != nil {
+		return resp, err
+	}
 
-	switch messageType {
-	case 0x9: // full server response
-		if messageTypeSpecificFlags != 0 {
-			sequenceNumber := int32(binary.BigEndian.Uint32(payload[0:4]))
-			payload = payload[8:]
-			if sequenceNumber < 0 {
-				resp.IsLast = true
-			}
-		} else {
-			payload = payload[4:]
-		}
-	case 0xf: // 服务端处理错误时下发的消息类型
-		resp.Code = int32(binary.BigEndian.Uint32(payload[:4]))
-		payload = payload[8:]
-		if messageCompression == 1 {
-			payload = d.gzipDecompress(payload)
+	switch msg.MsgType {
+	case volcproto.MsgTypeFullServerResponse:
+		resp.IsLast = msg.IsLast()
+	case volcproto.MsgTypeError: // 服务端处理错误时下发的消息类型
+		resp.Code = int32(msg.ErrorCode)
+		payload, err := msg.DecodedPayload()
+		if err != nil {
+			payload = msg.Payload
 		}
 		resp.ErrMsg = string(payload)
 		return resp, nil
 	}
 
-	if len(payload) != 0 {
-		if messageCompression == gzipCompression {
-			payload = d.gzipDecompress(payload)
+	if len(msg.Payload) != 0 {
+		payload, err := msg.DecodedPayload()
+		if err != nil {
+			return resp, err
 		}
 
-		if messageSerializationMethod == 0x1 { // json格式
+		if msg.Serialization == volcproto.SerializationJSON {
 			var jsonData serverResponse
 			if err := json.Unmarshal(payload, &jsonData); err != nil {
 				return resp, fmt.Errorf("failed to parse the JSON response: %v", err)
 			}
 			resp.Text = jsonData.Result.Text
+			resp.Language = jsonData.Result.Language
+			resp.Confidence = jsonData.Result.Confidence
 			if len(jsonData.Result.Utterances) != 0 {
 				resp.IsDefinite = jsonData.Result.Utterances[0].Definite
+				setUtterances(&resp, jsonData.Result.Utterances)
 			}
-		} else if messageSerializationMethod != noSerialization { // 无序列化
+		} else if msg.Serialization != volcproto.SerializationRaw { // 无序列化
 			resp.Text = string(payload)
 		}
 	}
 	return resp, nil
 }
 
+// setUtterances 填充语句的起止时间与逐词的识别结果，服务端以负数表示尚未确定的时间
+func setUtterances(resp *synResp, utterances []utterance) {
+	resp.BeginTime = max(utterances[0].StartTime, 0)
+	if last := utterances[len(utterances)-1]; last.Definite {
+		resp.EndTime = max(last.EndTime, 0)
+	}
+	for _, u := range utterances {
+		for _, w := range u.Words {
+			resp.Words = append(resp.Words, asr.Word{
+				Text:      w.Text,
+				BeginTime: max(w.StartTime, 0),
+				EndTime:   max(w.EndTime, 0),
+			})
+		}
+	}
+}
+
 func (d *Doubao) initConnection(ctx context.Context) error {
+	cfg := d.config()
 	d.log.Info("start asr")
 	d.startListenTime = time.Now()
 
 	d.lock.Lock()
 	defer d.lock.Unlock()
 
-	if d.isRunning {
+	if d.state.Running() {
 		return nil
 	}
+	d.state.Start()
+	// 建立失败时回到 StatusStopped，建立成功时已流转为 StatusRunning，不受影响
+	defer d.state.Transition(lifecycle.StatusStarting, lifecycle.StatusStopped)
 
 	// 确保旧连接已关闭
 	if d.conn != nil {
@@ -264,40 +291,46 @@ func (d *Doubao) initConnection(ctx context.Context) error {
 
 	// 建立WebSocket连接
 	dialer := websocket.Dialer{
+		Proxy:            netproxy.Func(cfg.Proxy),
 		HandshakeTimeout: 10 * time.Second, // 设置握手超时
 	}
 	header := make(http.Header)
-	header.Add("X-Api-App-Key", d.cfg.AppID)
-	header.Add("X-Api-Access-Key", d.cfg.AccessToken)
-	header.Add("X-Api-Resource-Id", "volc.bigasr.sauc.duration") // 小时版：volc.bigasr.sauc.duration，并发版：volc.bigasr.sauc.concurrent
+	header.Add("X-Api-App-Key", cfg.AppID)
+	header.Add("X-Api-Access-Key", cfg.AccessToken)
+	header.Add("X-Api-Resource-Id", cfg.ResourceID)
 	header.Add("X-Api-Connect-Id", d.connectID)
 
 	// 重试机制
+	// 占用连接名额，避免超出服务商的并发配额，建立连接失败时释放
+	release, err := connlimit.Get("asr", d.Name(), cfg.MaxConcurrency, cfg.ConcurrencyTimeout).Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("%w, %v", asr.ErrUnavailable, err)
+	}
+	defer func() {
+		if d.state.Running() {
+			d.release = release
+		} else {
+			release()
+		}
+	}()
+
 	var (
 		conn *websocket.Conn
 		resp *http.Response
-		err  error
 	)
-	maxRetries := 2 // 最大重试次数
-	for i := 0; i < maxRetries; i++ {
-		conn, resp, err = dialer.DialContext(ctx, wsURL, header)
-		if err == nil {
-			break
-		}
-
-		if i+1 < maxRetries {
-			backoffTime := time.Duration(500*(i+1)) * time.Millisecond
-			d.log.Warnf("failed to connect to the websocket, try %d/%d: %v, will try again %v", i+1, maxRetries+1, err, backoffTime)
-			time.Sleep(backoffTime)
-		}
-	}
+	err = lifecycle.Retry(ctx, cfg.MaxRetries, func() error {
+		conn, resp, err = dialer.DialContext(ctx, cfg.Endpoint, header)
+		return err
+	}, func(attempt int, err error, backoff time.Duration) {
+		d.log.Warnf("failed to connect to the websocket, try %d/%d: %v, will try again %v", attempt, cfg.MaxRetries, err, backoff)
+	})
 
 	if err != nil {
 		statusCode := 0
 		if resp != nil {
 			statusCode = resp.StatusCode
 		}
-		return fmt.Errorf("falied to connect(status_code:%d): %v", statusCode, err)
+		return fmt.Errorf("%w, falied to connect(status_code:%d): %v", asr.ErrUnavailable, statusCode, err)
 	}
 
 	// 发送初始请求
@@ -307,14 +340,16 @@ func (d *Doubao) initConnection(ctx context.Context) error {
 		return fmt.Errorf("error unmarshaling task-started event message: %v", err)
 	}
 
-	compressedRequest := d.gzipCompress(requestBytes)
-	headers := d.generateHeader(clientFullRequest, noSequence, jsonFormat)
-
 	// 构造完整请求
-	size := make([]byte, 4)
-	binary.BigEndian.PutUint32(size, uint32(len(compressedRequest)))
-	fullRequest := append(headers, size...)
-	fullRequest = append(fullRequest, compressedRequest...)
+	msg := volcproto.NewMessage(volcproto.MsgTypeFullClientRequest, volcproto.MsgTypeFlagNoSeq)
+	msg.Compression = volcproto.CompressionGzip
+	if msg.Payload, err = volcproto.GzipCompress(requestBytes); err != nil {
+		return err
+	}
+	fullRequest, err := msg.Marshal()
+	if err != nil {
+		return err
+	}
 
 	// 发送请求
 	if err = conn.WriteMessage(websocket.BinaryMessage, fullRequest); err != nil {
@@ -338,15 +373,65 @@ func (d *Doubao) initConnection(ctx context.Context) error {
 	}
 
 	d.conn = conn
-	d.isRunning = true
+	d.state.Transition(lifecycle.StatusStarting, lifecycle.StatusRunning)
 	d.reqID = uuid.New().String()
 
-	d.log.Debugf("init asr succeed, connect_id: %s, req_id: %s", d.connectID, d.reqID)
+	d.connectCost = time.Since(d.startListenTime)
+	d.sendDataCnt = 0
+	atomic.StoreInt64(&d.firstSendTime, 0)
+	atomic.StoreInt64(&d.firstResultCost, 0)
+	atomic.StoreInt64(&d.sendBytes, 0)
+	d.log.WithFields(log.Fields{
+		"connect_id": d.connectID,
+		"req_id":     d.reqID,
+		"connect_ms": d.connectCost.Milliseconds(),
+	}).Debug("init asr succeed")
+
+	atomic.StoreInt64(&d.lastSendTime, time.Now().UnixNano())
 
 	go d.readMessage(ctx)
+	if cfg.Heartbeat {
+		go d.keepalive(conn, heartbeatInterval)
+	}
 	return nil
 }
 
+// keepalive 在用户停顿期间定时发送静音帧，避免连接因长时间无音频被服务端断开
+// 静音帧只写入启动时的连接，连接被替换或关闭后即退出，不会写入新建立的连接
+func (d *Doubao) keepalive(conn *websocket.Conn, interval time.Duration) {
+	ticker := time.NewTicker(interval / 3)
+	defer ticker.Stop()
+
+	// 16bit 单声道 pcm 静音帧
+	frame, err := d.audioMessage(make([]byte, d.config().SampleRate/1000*heartbeatFrameMs*2), false)
+	if err != nil {
+		d.log.Warnf("build heartbeat frame failed: %v", err)
+		return
+	}
+	var lastBeat time.Time
+	for range ticker.C {
+		d.lock.Lock()
+		isCurrent := d.state.Running() && d.conn == conn
+		d.lock.Unlock()
+		if !isCurrent {
+			return
+		}
+
+		lastAudio := time.Unix(0, atomic.LoadInt64(&d.lastSendTime))
+		if time.Since(lastAudio) < interval || time.Since(lastBeat) < interval {
+			continue
+		}
+		d.writeLock.Lock()
+		err = conn.WriteMessage(websocket.BinaryMessage, frame)
+		d.writeLock.Unlock()
+		if err != nil {
+			d.log.Warnf("send heartbeat frame failed: %v", err)
+			return
+		}
+		lastBeat = time.Now()
+	}
+}
+
 func (d *Doubao) readMessage(ctx context.Context) {
 	d.log.Info("doubao read message started")
 
@@ -354,8 +439,9 @@ func (d *Doubao) readMessage(ctx context.Context) {
 		if err := recover(); err != nil {
 			d.log.Errorf("asr read goroutine panic: %v", err)
 		}
+		d.logStats()
 		d.lock.Lock()
-		d.isRunning = false
+		d.state.Stop()
 		if d.conn != nil {
 			d.closeConnection()
 		}
@@ -366,7 +452,7 @@ func (d *Doubao) readMessage(ctx context.Context) {
 	for {
 		// 检查连接状态，避免在连接关闭后继续读取
 		d.lock.Lock()
-		if !d.isRunning || d.conn == nil {
+		if !d.state.Running() || d.conn == nil {
 			d.lock.Unlock()
 			d.log.Info("recognition has ended or the connection has been closed, exiting the read loop")
 			return
@@ -393,11 +479,12 @@ func (d *Doubao) readMessage(ctx context.Context) {
 			return
 		}
 
-		// 处理正常响应
-		if result.Text == "" && !d.startListenTime.IsZero() && time.Since(d.startListenTime) > idleTimeout {
-			d.silenceCount++
-		} else if result.Text != "" {
-			d.silenceCount = 0 // 重置静音计数
+		// 处理正常响应，静音只取决于识别结果，心跳静音帧对应的空结果同样计入，用户停顿期间仍能判定静音
+		if result.Text != "" {
+			atomic.StoreInt32(&d.silenceCount, 0) // 重置静音计数
+			d.markFirstResult()
+		} else if !d.startListenTime.IsZero() && time.Since(d.startListenTime) > d.config().IdleTimeout {
+			atomic.AddInt32(&d.silenceCount, 1)
 		}
 
 		state := asr.StateProcessing
@@ -408,6 +495,27 @@ func (d *Doubao) readMessage(ctx context.Context) {
 			state = asr.StateCompleted
 		}
 
+		forced := d.reachMaxUtterance(result.Text, state)
+		if forced {
+			state = asr.StateSentenceEnd
+		}
+		if d.discard(&result.Text, state) {
+			continue
+		}
+		if forced {
+			// 已强制结束的语句，丢弃服务端后续返回的该句结果
+			atomic.StoreInt32(&d.discarding, 1)
+		}
+
+		asr.NotifyDetail(ctx, d.listener, asr.Detail{
+			Result:     result.Text,
+			State:      state,
+			Language:   result.Language,
+			Confidence: result.Confidence,
+			BeginTime:  result.BeginTime,
+			EndTime:    result.EndTime,
+			Words:      result.Words,
+		})
 		if finished := d.listener.OnAsrResult(ctx, result.Text, state); finished {
 			return
 		}
@@ -425,41 +533,49 @@ func (d *Doubao) sendAudioData(data []byte, isLast bool) error {
 		}
 	}()
 
+	audioMessage, err := d.audioMessage(data, isLast)
+	if err != nil {
+		return err
+	}
+
+	d.writeLock.Lock()
+	defer d.writeLock.Unlock()
 	if d.conn == nil {
 		return errors.New("connection not initialized")
 	}
-
-	var compressBuffer bytes.Buffer
-	gzipWriter := gzip.NewWriter(&compressBuffer)
-	if _, err := gzipWriter.Write(data); err != nil {
-		return fmt.Errorf("compress audio data failed: %v", err)
+	if err := d.conn.WriteMessage(websocket.BinaryMessage, audioMessage); err != nil {
+		return fmt.Errorf("send audio data failed: %v", err)
 	}
-	_ = gzipWriter.Close()
+	return nil
+}
 
-	compressedAudio := compressBuffer.Bytes()
-	flags := uint8(0)
+// audioMessage 构造仅包含音频的请求帧
+func (d *Doubao) audioMessage(data []byte, isLast bool) ([]byte, error) {
+	flag := volcproto.MsgTypeFlagNoSeq
 	if isLast {
-		flags = negSequence
+		flag = volcproto.MsgTypeFlagLastNoSeq
 	}
+	msg := volcproto.NewMessage(volcproto.MsgTypeAudioOnlyClient, flag)
+	msg.Serialization = volcproto.SerializationRaw
+	msg.Payload = data
 
-	header := d.generateHeader(clientAudioRequest, flags, noSerialization)
-	size := make([]byte, 4)
-	binary.BigEndian.PutUint32(size, uint32(len(compressedAudio)))
-
-	audioMessage := append(header, size...)
-	audioMessage = append(audioMessage, compressedAudio...)
-
-	if err := d.conn.WriteMessage(websocket.BinaryMessage, audioMessage); err != nil {
-		return fmt.Errorf("send audio data failed: %v", err)
+	// 音频数据默认gzip压缩，pcm小帧的压缩收益有限，可通过配置关闭以节省CPU
+	if !d.config().DisableGzip {
+		audio, err := volcproto.GzipCompress(data)
+		if err != nil {
+			return nil, fmt.Errorf("compress audio data failed: %v", err)
+		}
+		msg.Payload = audio
+		msg.Compression = volcproto.CompressionGzip
 	}
-	return nil
+	return msg.Marshal()
 }
 
 func (d *Doubao) setErrorAndClose(err error) {
 	d.lock.Lock()
 	defer d.lock.Unlock()
 
-	d.isRunning = false
+	d.state.Stop()
 
 	if strings.Contains(err.Error(), "use of closed network connection") {
 		d.log.Debugf("setErrorAndClose: %v, sendDataCnt=%d", err, d.sendDataCnt)
@@ -479,14 +595,129 @@ func (d *Doubao) closeConnection() {
 		}
 	}()
 
+	if d.release != nil {
+		d.release()
+		d.release = nil
+	}
 	if d.conn != nil {
 		_ = d.conn.Close()
 		d.conn = nil
 	}
 }
 
+func (d *Doubao) Finalize() error {
+	d.lock.Lock()
+	isRunning := d.state.Running()
+	d.lock.Unlock()
+	if !isRunning {
+		return nil
+	}
+	// 发送带结束标记的最后一包，服务端返回的最后一个结果即为最终结果
+	if err := d.sendAudioData(nil, true); err != nil {
+		return fmt.Errorf("failed to send last audio packet: %v", err)
+	}
+	d.log.Info("doubao finalize")
+	return nil
+}
+
+// discard 判断当前结果是否属于已中止的语句，是则丢弃
+// 识别结束的结果仍需回调，以便监听者感知识别结束，但其中已中止语句的文本会被清空
+func (d *Doubao) discard(text *string, state asr.State) bool {
+	if state == asr.StateProcessing {
+		if *text != "" {
+			atomic.StoreInt32(&d.speaking, 1)
+		}
+	} else {
+		atomic.StoreInt32(&d.speaking, 0)
+	}
+
+	if atomic.LoadInt32(&d.discarding) == 0 {
+		return false
+	}
+	if state == asr.StateProcessing {
+		return true
+	}
+	atomic.StoreInt32(&d.discarding, 0)
+	if state == asr.StateSentenceEnd {
+		return true
+	}
+	*text = ""
+	return false
+}
+
+// reachMaxUtterance 判断当前语句是否超过最大时长，超过则需强制结束该语句
+func (d *Doubao) reachMaxUtterance(text string, state asr.State) bool {
+	cfg := d.config()
+	if state != asr.StateProcessing {
+		atomic.StoreInt64(&d.utteranceStart, 0)
+		return false
+	}
+	if cfg.MaxUtteranceMs <= 0 || text == "" || atomic.LoadInt32(&d.discarding) == 1 {
+		return false
+	}
+	start := atomic.LoadInt64(&d.utteranceStart)
+	if start == 0 {
+		atomic.StoreInt64(&d.utteranceStart, time.Now().UnixNano())
+		return false
+	}
+	if time.Since(time.Unix(0, start)) < time.Duration(cfg.MaxUtteranceMs)*time.Millisecond {
+		return false
+	}
+	atomic.StoreInt64(&d.utteranceStart, 0)
+	d.log.Warnf("utterance exceeds %dms, force to end the sentence", cfg.MaxUtteranceMs)
+	return true
+}
+
+// markFirstResult 记录首个非空识别结果的延迟
+func (d *Doubao) markFirstResult() {
+	start := atomic.LoadInt64(&d.firstSendTime)
+	if start == 0 || atomic.LoadInt64(&d.firstResultCost) != 0 {
+		return
+	}
+	atomic.StoreInt64(&d.firstResultCost, time.Now().UnixNano()-start)
+}
+
+// logStats 以结构化字段输出本次会话的耗时与数据量统计
+func (d *Doubao) logStats() {
+	d.log.WithFields(log.Fields{
+		"provider":        d.Name(),
+		"connect_id":      d.connectID,
+		"req_id":          d.reqID,
+		"connect_ms":      d.connectCost.Milliseconds(),
+		"first_result_ms": time.Duration(atomic.LoadInt64(&d.firstResultCost)).Milliseconds(),
+		"send_bytes":      atomic.LoadInt64(&d.sendBytes),
+		"send_cnt":        d.sendDataCnt,
+	}).Info("asr session stats")
+}
+
+func (d *Doubao) Abort() error {
+	// 只有存在未结束的语句时才需要丢弃，避免误丢弃下一句的识别结果
+	if atomic.LoadInt32(&d.speaking) == 1 {
+		atomic.StoreInt32(&d.discarding, 1)
+		d.log.Info("doubao abort current sentence")
+	}
+	return nil
+}
+
 func (d *Doubao) GetSilenceCount() int {
-	return d.silenceCount
+	return int(atomic.LoadInt32(&d.silenceCount))
+}
+
+// Start 建立连接，已建立时直接返回，可用于预先建连以降低首次识别的延迟
+func (d *Doubao) Start(ctx context.Context) error {
+	if d.state.Running() {
+		return nil
+	}
+	return d.initConnection(ctx)
+}
+
+// Stop 关闭连接并重置状态，同 Reset
+func (d *Doubao) Stop() error {
+	return d.Reset()
+}
+
+func (d *Doubao) Status() lifecycle.Status {
+	return d.state.Status()
 }
 
 func (d *Doubao) Reset() error {
@@ -494,12 +725,16 @@ func (d *Doubao) Reset() error {
 	d.lock.Lock()
 	defer d.lock.Unlock()
 
-	d.isRunning = false
+	d.state.Transition(lifecycle.StatusRunning, lifecycle.StatusStopping)
 	d.closeConnection()
+	d.state.Stop()
 
-	d.silenceCount = 0
+	atomic.StoreInt32(&d.silenceCount, 0)
 	d.sendDataCnt = 0
 	d.taskID = ""
+	atomic.StoreInt32(&d.speaking, 0)
+	atomic.StoreInt32(&d.discarding, 0)
+	atomic.StoreInt64(&d.utteranceStart, 0)
 
 	d.log.Info("doubao reset")
 	return nil
@@ -0,0 +1,421 @@
+package doubao
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"crow/internal/asr"
+	"crow/internal/config"
+	"crow/pkg/fakews"
+	"crow/pkg/lifecycle"
+	"crow/pkg/log"
+	"crow/pkg/volcproto"
+)
+
+// result 一次识别结果回调
+type result struct {
+	text  string
+	state asr.State
+}
+
+// fakeListener 记录识别结果回调，识别结束时关闭 done
+type fakeListener struct {
+	lock    sync.Mutex
+	results []result
+	done    chan struct{}
+}
+
+func newFakeListener() *fakeListener {
+	return &fakeListener{done: make(chan struct{})}
+}
+
+func (l *fakeListener) OnAsrResult(_ context.Context, text string, state asr.State) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.results = append(l.results, result{text, state})
+	if state == asr.StateCompleted {
+		close(l.done)
+		return true
+	}
+	return false
+}
+
+func (l *fakeListener) get() []result {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return append([]result(nil), l.results...)
+}
+
+// response 服务端下发的识别结果帧，sequence 为负数表示最后一包
+func response(sequence int32, text string, definite bool) fakews.Frame {
+	return fakews.DoubaoResponse(0x9, sequence, map[string]any{
+		"result": map[string]any{
+			"text":       text,
+			"utterances": []map[string]any{{"text": text, "definite": definite}},
+		},
+	})
+}
+
+// timedResponse 携带分句及逐词时间的识别结果，尚未确定的时间为负数
+func timedResponse() fakews.Frame {
+	return fakews.DoubaoResponse(0x9, 2, map[string]any{
+		"result": map[string]any{
+			"text": "你好",
+			"utterances": []map[string]any{{
+				"text": "你好", "definite": true, "start_time": 100, "end_time": 700,
+				"words": []map[string]any{
+					{"text": "你", "start_time": 100, "end_time": 400},
+					{"text": "好", "start_time": 400, "end_time": -1},
+				},
+			}},
+		},
+	})
+}
+
+func newTestDoubao(t *testing.T, endpoint string, listener asr.Listener) *Doubao {
+	t.Helper()
+	return newTestDoubaoWithConfig(t, config.AsrConfig{Endpoint: endpoint}, listener)
+}
+
+func newTestDoubaoWithConfig(t testing.TB, cfg config.AsrConfig, listener asr.Listener) *Doubao {
+	t.Helper()
+	cfg.AppID, cfg.AccessToken = "app", "token"
+	d := NewDoubao(log.NewLogger(&log.Option{Hook: io.Discard, Mode: "debug"}))
+	d.SetConfig(&asr.Config{AsrConfig: cfg})
+	d.SetListener(listener)
+	t.Cleanup(func() { _ = d.Reset() })
+	return d
+}
+
+// waitStopped 等待读取循环退出后连接关闭
+func waitStopped(t *testing.T, d *Doubao) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for d.Status() != lifecycle.StatusStopped {
+		if time.Now().After(deadline) {
+			t.Fatal("still running, want stopped")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestStart(t *testing.T) {
+	server := fakews.NewServer(fakews.Step{Wait: 1, Frames: []fakews.Frame{response(1, "", false)}})
+	defer server.Close()
+	d := newTestDoubao(t, server.URL(), newFakeListener())
+
+	if err := d.Start(t.Context()); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if got := d.Status(); got != lifecycle.StatusRunning {
+		t.Fatalf("status = %v after start, want running", got)
+	}
+	header := server.Header()
+	if header.Get("X-Api-App-Key") != "app" || header.Get("X-Api-Access-Key") != "token" || header.Get("X-Api-Resource-Id") != "volc.bigasr.sauc.duration" {
+		t.Fatalf("unexpected handshake header: %v", header)
+	}
+
+	// 首个请求为gzip压缩的完整请求，携带音频参数
+	received := server.Received()
+	if len(received) != 1 {
+		t.Fatalf("received %d messages, want 1", len(received))
+	}
+	msg, err := volcproto.Parse(received[0])
+	if err != nil {
+		t.Fatalf("parse first request: %v", err)
+	}
+	if msg.MsgType != volcproto.MsgTypeFullClientRequest || msg.Compression != volcproto.CompressionGzip {
+		t.Fatalf("first request = %s", msg)
+	}
+	payload, err := msg.DecodedPayload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var request struct {
+		Audio struct {
+			Format string `json:"format"`
+			Rate   int    `json:"rate"`
+		} `json:"audio"`
+	}
+	if err := json.Unmarshal(payload, &request); err != nil {
+		t.Fatal(err)
+	}
+	if request.Audio.Format != "pcm" || request.Audio.Rate != 16000 {
+		t.Fatalf("audio params = %+v", request.Audio)
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	server := fakews.NewServer(fakews.Step{Wait: 1, Frames: []fakews.Frame{response(1, "", false)}})
+	defer server.Close()
+	d := newTestDoubao(t, server.URL(), newFakeListener())
+
+	if err := d.Start(t.Context()); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	// 已建立连接时再次 Start 不会重新建连
+	if err := d.Start(t.Context()); err != nil {
+		t.Fatalf("start again: %v", err)
+	}
+	if n := len(server.Received()); n != 1 {
+		t.Fatalf("received %d messages, want 1", n)
+	}
+	if err := d.Stop(); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	if got := d.Status(); got != lifecycle.StatusStopped {
+		t.Fatalf("status = %v after stop, want stopped", got)
+	}
+}
+
+func TestStartFailed(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame fakews.Frame
+	}{
+		{"error frame", fakews.DoubaoError(45000001, "invalid params")},
+		{"truncated frame", fakews.Truncate(response(1, "", false), 6)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := fakews.NewServer(fakews.Step{Wait: 1, Frames: []fakews.Frame{tt.frame}})
+			defer server.Close()
+			d := newTestDoubao(t, server.URL(), newFakeListener())
+
+			if err := d.Start(t.Context()); err == nil {
+				t.Fatal("start succeeded, want error")
+			}
+			if got := d.Status(); got != lifecycle.StatusStopped {
+				t.Fatalf("status = %v after failed start, want stopped", got)
+			}
+		})
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		frame   fakews.Frame
+		want    synResp
+		wantErr bool
+	}{
+		{"processing", response(1, "你好", false), synResp{Text: "你好"}, false},
+		{"definite", response(1, "你好。", true), synResp{Text: "你好。", IsDefinite: true}, false},
+		{"last", response(-1, "", true), synResp{IsLast: true, IsDefinite: true}, false},
+		{"error frame", fakews.DoubaoError(45000001, "invalid params"), synResp{Code: 45000001, ErrMsg: "invalid params"}, false},
+		{"word timings", timedResponse(), synResp{Text: "你好", IsDefinite: true, BeginTime: 100, EndTime: 700,
+			Words: []asr.Word{{Text: "你", BeginTime: 100, EndTime: 400}, {Text: "好", BeginTime: 400}}}, false},
+		{"truncated header", fakews.Truncate(response(1, "你好", false), 3), synResp{}, true},
+		{"truncated sequence", fakews.Truncate(response(1, "你好", false), 6), synResp{}, true},
+		{"truncated payload", fakews.Truncate(response(1, "你好", false), 20), synResp{}, true},
+		{"truncated error frame", fakews.Truncate(fakews.DoubaoError(45000001, "invalid params"), 10), synResp{}, true},
+	}
+	d := NewDoubao(log.NewLogger(&log.Option{Hook: io.Discard}))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := d.parseResponse(tt.frame.Data)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parse = %+v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("parse = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	server := fakews.NewServer(fakews.Step{Wait: 1, Frames: []fakews.Frame{
+		response(1, "", false),
+		response(2, "今天", false),
+		response(3, "今天天气", true),
+		response(-4, "", true),
+	}})
+	defer server.Close()
+	listener := newFakeListener()
+	d := newTestDoubao(t, server.URL(), listener)
+
+	if err := d.Start(t.Context()); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	select {
+	case <-listener.done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("recognition not completed, results: %v", listener.get())
+	}
+	want := []result{{"今天", asr.StateProcessing}, {"今天天气", asr.StateSentenceEnd}, {"", asr.StateCompleted}}
+	if got := listener.get(); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Fatalf("results = %v, want %v", got, want)
+	}
+}
+
+func TestReadMessageStopsOnBadFrame(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame fakews.Frame
+		close bool
+	}{
+		{"error frame", fakews.DoubaoError(55000000, "server busy"), false},
+		{"truncated frame", fakews.Truncate(response(2, "今天", false), 10), false},
+		{"connection closed", response(2, "今天", false), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := fakews.NewServer(fakews.Step{Wait: 1, Frames: []fakews.Frame{response(1, "", false), tt.frame}, Close: tt.close})
+			defer server.Close()
+			d := newTestDoubao(t, server.URL(), newFakeListener())
+
+			if err := d.Start(t.Context()); err != nil {
+				t.Fatalf("start: %v", err)
+			}
+			// 读取循环退出后关闭连接，下一次发送音频时重新建连
+			waitStopped(t, d)
+		})
+	}
+}
+
+// pcmFrame 100ms 的 16k 16bit 单声道正弦波，模拟客户端上行的一帧音频
+func pcmFrame() []byte {
+	frame := make([]byte, 3200)
+	for i := 0; i < len(frame)/2; i++ {
+		sample := int16(8000 * math.Sin(2*math.Pi*440*float64(i)/16000))
+		frame[2*i], frame[2*i+1] = byte(sample), byte(sample>>8)
+	}
+	return frame
+}
+
+func TestSendAudioCompression(t *testing.T) {
+	frame := pcmFrame()
+	for _, tt := range []struct {
+		name        string
+		disableGzip bool
+	}{
+		{"gzip", false},
+		{"raw", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			server := fakews.NewServer(fakews.Step{Wait: 1, Frames: []fakews.Frame{response(1, "", false)}})
+			defer server.Close()
+			d := newTestDoubaoWithConfig(t, config.AsrConfig{Endpoint: server.URL(), DisableGzip: tt.disableGzip}, newFakeListener())
+
+			if err := d.SendAudio(t.Context(), frame); err != nil {
+				t.Fatalf("send audio: %v", err)
+			}
+			deadline := time.Now().Add(5 * time.Second)
+			for len(server.Received()) < 2 {
+				if time.Now().After(deadline) {
+					t.Fatal("audio frame not received")
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+
+			// 头部的压缩方式与负载一致，关闭压缩时直接发送原始音频
+			msg, err := volcproto.Parse(server.Received()[1])
+			if err != nil {
+				t.Fatalf("parse audio request: %v", err)
+			}
+			if msg.MsgType != volcproto.MsgTypeAudioOnlyClient {
+				t.Fatalf("message type = %s, want audio request", msg.MsgType)
+			}
+			want := volcproto.CompressionGzip
+			if tt.disableGzip {
+				want = volcproto.CompressionNone
+			}
+			if msg.Compression != want {
+				t.Fatalf("compression = %d, want %d", msg.Compression, want)
+			}
+			if payload, err := msg.DecodedPayload(); err != nil || !bytes.Equal(payload, frame) {
+				t.Fatalf("payload %d bytes (%v), want the audio frame", len(payload), err)
+			}
+		})
+	}
+}
+
+func BenchmarkSendAudio(b *testing.B) {
+	frame := pcmFrame()
+	for _, bm := range []struct {
+		name        string
+		disableGzip bool
+	}{
+		{"gzip", false},
+		{"raw", true},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			server := fakews.NewDiscardServer(fakews.Step{Wait: 1, Frames: []fakews.Frame{response(1, "", false)}})
+			defer server.Close()
+			d := newTestDoubaoWithConfig(b, config.AsrConfig{Endpoint: server.URL(), DisableGzip: bm.disableGzip}, newFakeListener())
+			if err := d.Start(context.Background()); err != nil {
+				b.Fatalf("start: %v", err)
+			}
+
+			b.SetBytes(int64(len(frame)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := d.SendAudio(context.Background(), frame); err != nil {
+					b.Fatalf("send audio: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestKeepalive(t *testing.T) {
+	server := fakews.NewServer(
+		fakews.Step{Wait: 1, Frames: []fakews.Frame{response(1, "", false)}},
+		fakews.Step{Wait: 1, Frames: []fakews.Frame{response(2, "", false)}},
+		fakews.Step{Wait: 1, Frames: []fakews.Frame{response(3, "", false)}},
+	)
+	defer server.Close()
+	d := newTestDoubao(t, server.URL(), newFakeListener())
+	cfg := *d.config()
+	cfg.IdleTimeout = time.Millisecond
+	d.SetConfig(&cfg)
+
+	if err := d.Start(t.Context()); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	d.lock.Lock()
+	conn := d.conn
+	d.lock.Unlock()
+	go d.keepalive(conn, 30*time.Millisecond)
+
+	// 用户停顿期间发送静音帧，其空结果同样计为静音
+	deadline := time.Now().Add(5 * time.Second)
+	for d.GetSilenceCount() < 2 || len(server.Received()) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("silence count = %d after %d messages, want 2 heartbeats counted as silence", d.GetSilenceCount(), len(server.Received()))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	received := server.Received()
+	for _, data := range received[1:3] {
+		msg, err := volcproto.Parse(data)
+		if err != nil {
+			t.Fatalf("parse heartbeat: %v", err)
+		}
+		payload, err := msg.DecodedPayload()
+		if err != nil || msg.MsgType != volcproto.MsgTypeAudioOnlyClient || len(payload) == 0 || bytes.Count(payload, []byte{0}) != len(payload) {
+			t.Fatalf("heartbeat = %s with %d bytes (%v), want silent audio", msg.MsgType, len(payload), err)
+		}
+	}
+
+	// 心跳不计为真实音频
+	if since := time.Since(time.Unix(0, atomic.LoadInt64(&d.lastSendTime))); since < 60*time.Millisecond {
+		t.Fatalf("last audio %v ago, heartbeats must not update it", since)
+	}
+}
@@ -0,0 +1,45 @@
+package asr
+
+import "bytes"
+
+// DetectFormat 根据音频数据开头的特征字节识别常见的封装格式
+// 仅识别有明确特征的格式，裸的 pcm 数据或无法识别时返回空字符串
+func DetectFormat(data []byte) string {
+	switch {
+	case len(data) >= 12 && bytes.Equal(data[:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WAVE")):
+		return "wav"
+	case bytes.HasPrefix(data, []byte("ID3")):
+		return "mp3"
+	case bytes.HasPrefix(data, []byte("OggS")):
+		return "ogg"
+	case bytes.HasPrefix(data, []byte("fLaC")):
+		return "flac"
+	case bytes.HasPrefix(data, []byte("#!AMR")):
+		return "amr"
+	case bytes.HasPrefix(data, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		return "webm"
+	case len(data) >= 4 && data[0] == 0xFF && data[1]&0xF6 == 0xF0 && data[2]>>2&0x0F != 0x0F:
+		// ADTS 头：12位同步字，layer 为0，采样率索引有效
+		return "aac"
+	case len(data) >= 4 && data[0] == 0xFF && data[1]&0xE0 == 0xE0 && data[1]&0x06 != 0 &&
+		data[2]>>4 != 0x0F && data[2]>>4 != 0 && data[2]&0x0C != 0x0C:
+		// 无ID3标签的 mp3 帧头：11位同步字，layer、码率及采样率索引有效
+		return "mp3"
+	}
+	return ""
+}
+
+// FormatMatches 识别出的格式是否与协商的格式一致，未识别出格式时视为一致
+// pcm 与 wav 的采样数据相同，互相视为一致；opus 通常以 ogg 封装
+func FormatMatches(format, detected string) bool {
+	if detected == "" || format == detected {
+		return true
+	}
+	switch format {
+	case "pcm":
+		return detected == "wav"
+	case "opus", "speex":
+		return detected == "ogg"
+	}
+	return false
+}
@@ -8,33 +8,35 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 
 	"crow/internal/asr"
+	"crow/pkg/connlimit"
+	"crow/pkg/lifecycle"
 	"crow/pkg/log"
+	"crow/pkg/netproxy"
 )
 
 // 阿里 Paraformer 实时语音识别 WebSocket API 文档
 // https://help.aliyun.com/zh/model-studio/websocket-for-paraformer-real-time-service
 
-const (
-	wsURL       = "wss://dashscope.aliyuncs.com/api-ws/v1/inference/" // WebSocket服务器地址
-	idleTimeout = 30 * time.Second                                    // 没有新的文本数据则结束识别
-)
+const wsURL = "wss://dashscope.aliyuncs.com/api-ws/v1/inference/" // WebSocket服务器地址
 
 type Paraformer struct {
-	cfg *asr.Config
+	cfg atomic.Pointer[asr.Config] // 当前生效的配置，会话中可被整体替换，通过 config 读取
 	log *log.Logger
 
 	conn     *websocket.Conn
+	release  func() // 释放占用的连接名额
 	listener asr.Listener
 
 	lock sync.Mutex
 
-	isRunning bool
+	state     lifecycle.State // 连接的生命周期状态
 	reqID     string
 	connectID string
 	taskID    string
@@ -42,6 +44,19 @@ type Paraformer struct {
 	sendDataCnt     int
 	startListenTime time.Time
 	silenceCount    int
+
+	connectCost     time.Duration // 建立连接的耗时
+	firstSendTime   int64         // 本次会话首次发送音频的时间，UnixNano
+	firstResultCost int64         // 首次发送音频到收到首个非空识别结果的耗时，单位纳秒
+	sendBytes       int64         // 本次会话发送的音频字节数
+
+	speaking   int32 // 当前是否有未结束的语句，0：否，1：是
+	discarding int32 // 是否丢弃当前语句的识别结果，0：否，1：是
+	finishing  int32 // 是否已通知服务端结束识别，0：否，1：是
+
+	finalText string // 结束识别期间已分句的文本，在任务结束时作为最终结果回调
+
+	utteranceStart int64 // 当前语句开始识别出文本的时间，UnixNano，0表示当前没有语句
 }
 
 func NewParaformer(log *log.Logger) *Paraformer {
@@ -51,6 +66,10 @@ func NewParaformer(log *log.Logger) *Paraformer {
 	}
 }
 
+func (p *Paraformer) Name() string {
+	return "paraformer"
+}
+
 func (p *Paraformer) SetConfig(cfg *asr.Config) *asr.Config {
 	if cfg.Language == "" {
 		cfg.Language = "zh"
@@ -64,8 +83,25 @@ func (p *Paraformer) SetConfig(cfg *asr.Config) *asr.Config {
 	if cfg.VadEos < 200 || cfg.VadEos > 6000 {
 		cfg.VadEos = 800
 	}
-	p.cfg = cfg
-	return p.cfg
+	if cfg.MaxUtteranceMs < 0 {
+		cfg.MaxUtteranceMs = 0
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = asr.DefaultIdleTimeout
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = wsURL
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = asr.DefaultMaxRetries
+	}
+	p.cfg.Store(cfg)
+	return cfg
+}
+
+// config 当前生效的配置，SetConfig 整体替换配置，因此可与各协程并发读取
+func (p *Paraformer) config() *asr.Config {
+	return p.cfg.Load()
 }
 
 func (p *Paraformer) SetListener(listener asr.Listener) {
@@ -73,28 +109,20 @@ func (p *Paraformer) SetListener(listener asr.Listener) {
 }
 
 func (p *Paraformer) SendAudio(ctx context.Context, data []byte) error {
-	// 使用锁检查状态
-	p.lock.Lock()
-	isRunning := p.isRunning
-	p.lock.Unlock()
-
-	if !isRunning {
-		if err := p.initConnection(ctx); err != nil {
-			return err
-		}
+	if err := p.Start(ctx); err != nil {
+		return err
 	}
 
 	// 检查是否有实际数据需要发送
-	if len(data) > 0 && p.isRunning {
+	if len(data) > 0 && p.state.Running() {
 		// 直接发送音频数据
 		err := p.sendAudioData(data, false)
 		if err != nil {
 			return err
 		}
 		p.sendDataCnt++
-		if p.sendDataCnt%20 == 0 {
-			p.log.Debugf("send audio data cnt: %d", p.sendDataCnt)
-		}
+		atomic.AddInt64(&p.sendBytes, int64(len(data)))
+		atomic.CompareAndSwapInt64(&p.firstSendTime, 0, time.Now().UnixNano())
 	}
 	return nil
 }
@@ -111,10 +139,12 @@ type Header struct {
 
 type Output struct {
 	Sentence struct {
-		SentenceEnd bool   `json:"sentence_end"`
-		BeginTime   int64  `json:"begin_time"`
-		EndTime     *int64 `json:"end_time"`
-		Text        string `json:"text"`
+		SentenceEnd bool    `json:"sentence_end"`
+		BeginTime   int64   `json:"begin_time"`
+		EndTime     *int64  `json:"end_time"`
+		Text        string  `json:"text"`
+		Language    string  `json:"language,omitempty"`   // 识别出的语种，服务端返回时才有
+		Confidence  float64 `json:"confidence,omitempty"` // 识别置信度，服务端返回时才有
 		Words       []struct {
 			BeginTime   int64  `json:"begin_time"`
 			EndTime     *int64 `json:"end_time"`
@@ -163,15 +193,19 @@ type Event struct {
 }
 
 func (p *Paraformer) initConnection(ctx context.Context) error {
+	cfg := p.config()
 	p.log.Info("start asr")
 	p.startListenTime = time.Now()
 
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
-	if p.isRunning {
+	if p.state.Running() {
 		return nil
 	}
+	p.state.Start()
+	// 建立失败时回到 StatusStopped，建立成功时已流转为 StatusRunning，不受影响
+	defer p.state.Transition(lifecycle.StatusStarting, lifecycle.StatusStopped)
 
 	// 确保旧连接已关闭
 	if p.conn != nil {
@@ -180,34 +214,40 @@ func (p *Paraformer) initConnection(ctx context.Context) error {
 
 	header := make(http.Header)
 	header.Add("X-DashScope-DataInspection", "enable")
-	header.Add("Authorization", fmt.Sprintf("bearer %s", p.cfg.ApiKey))
+	header.Add("Authorization", fmt.Sprintf("bearer %s", cfg.ApiKey))
+
+	// 占用连接名额，避免超出服务商的并发配额，建立连接失败时释放
+	release, err := connlimit.Get("asr", p.Name(), cfg.MaxConcurrency, cfg.ConcurrencyTimeout).Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("%w, %v", asr.ErrUnavailable, err)
+	}
+	defer func() {
+		if p.state.Running() {
+			p.release = release
+		} else {
+			release()
+		}
+	}()
 
 	var (
 		conn *websocket.Conn
 		resp *http.Response
-		err  error
 	)
-	maxRetries := 2 // 最大重试次数
-	for i := 0; i < maxRetries; i++ {
-		dialer := websocket.DefaultDialer
-		conn, resp, err = dialer.DialContext(ctx, wsURL, header)
-		if err == nil {
-			break
-		}
-
-		if i+1 < maxRetries {
-			backoffTime := time.Duration(500*(i+1)) * time.Millisecond
-			p.log.Warnf("failed to connect to the websocket, try %d/%d: %v, will try again %v", i+1, maxRetries+1, err, backoffTime)
-			time.Sleep(backoffTime)
-		}
-	}
+	dialer := *websocket.DefaultDialer
+	dialer.Proxy = netproxy.Func(cfg.Proxy)
+	err = lifecycle.Retry(ctx, cfg.MaxRetries, func() error {
+		conn, resp, err = dialer.DialContext(ctx, cfg.Endpoint, header)
+		return err
+	}, func(attempt int, err error, backoff time.Duration) {
+		p.log.Warnf("failed to connect to the websocket, try %d/%d: %v, will try again %v", attempt, cfg.MaxRetries, err, backoff)
+	})
 
 	if err != nil {
 		statusCode := 0
 		if resp != nil {
 			statusCode = resp.StatusCode
 		}
-		return fmt.Errorf("falied to connect(status_code:%d): %v", statusCode, err)
+		return fmt.Errorf("%w, falied to connect(status_code:%d): %v", asr.ErrUnavailable, statusCode, err)
 	}
 
 	// 发送run-task指令
@@ -230,10 +270,19 @@ func (p *Paraformer) initConnection(ctx context.Context) error {
 	}
 
 	p.conn = conn
-	p.isRunning = true
+	p.state.Transition(lifecycle.StatusStarting, lifecycle.StatusRunning)
 	p.reqID = fmt.Sprintf("%d", time.Now().UnixNano())
 
-	p.log.Debugf("init asr succeed, connect_id: %s, req_id: %s", p.connectID, p.reqID)
+	p.connectCost = time.Since(p.startListenTime)
+	p.sendDataCnt = 0
+	atomic.StoreInt64(&p.firstSendTime, 0)
+	atomic.StoreInt64(&p.firstResultCost, 0)
+	atomic.StoreInt64(&p.sendBytes, 0)
+	p.log.WithFields(log.Fields{
+		"connect_id": p.connectID,
+		"req_id":     p.reqID,
+		"connect_ms": p.connectCost.Milliseconds(),
+	}).Debug("init asr succeed")
 
 	go p.readMessage(ctx)
 	return nil
@@ -246,8 +295,9 @@ func (p *Paraformer) readMessage(ctx context.Context) {
 		if err := recover(); err != nil {
 			p.log.Errorf("asr read goroutine panic: %v", err)
 		}
+		p.logStats()
 		p.lock.Lock()
-		p.isRunning = false
+		p.state.Stop()
 		if p.conn != nil {
 			p.closeConnection()
 		}
@@ -258,7 +308,7 @@ func (p *Paraformer) readMessage(ctx context.Context) {
 	for {
 		// 检查连接状态，避免在连接关闭后继续读取
 		p.lock.Lock()
-		if !p.isRunning || p.conn == nil {
+		if !p.state.Running() || p.conn == nil {
 			p.lock.Unlock()
 			p.log.Info("recognition has ended or the connection has been closed, exiting the read loop")
 			return
@@ -318,6 +368,7 @@ func (p *Paraformer) sendRunTaskCmd(conn *websocket.Conn) (string, error) {
 
 // 生成run-task指令
 func (p *Paraformer) generateRunTaskCmd() (string, string, error) {
+	cfg := p.config()
 	taskID := uuid.New().String()
 	runTaskCmd := Event{
 		Header: Header{
@@ -331,11 +382,11 @@ func (p *Paraformer) generateRunTaskCmd() (string, string, error) {
 			Function:  "recognition",
 			Model:     "paraformer-realtime-v2",
 			Parameters: Params{
-				Format:                       p.cfg.Format,
-				SampleRate:                   p.cfg.SampleRate,
-				LanguageHints:                []string{p.cfg.Language},
-				MaxSentenceSilence:           p.cfg.VadEos,
-				PunctuationPredictionEnabled: p.cfg.EnablePunc,
+				Format:                       cfg.Format,
+				SampleRate:                   cfg.SampleRate,
+				LanguageHints:                []string{cfg.Language},
+				MaxSentenceSilence:           cfg.VadEos,
+				PunctuationPredictionEnabled: cfg.EnablePunc,
 				Heartbeat:                    true,
 			},
 			Input: Input{},
@@ -376,20 +427,53 @@ func (p *Paraformer) handleEvent(ctx context.Context, event Event) bool {
 	switch event.Header.Event {
 	case "result-generated":
 		text := event.Payload.Output.Sentence.Text
-		if text == "" && !p.startListenTime.IsZero() && time.Since(p.startListenTime) > idleTimeout {
+		if text == "" && !p.startListenTime.IsZero() && time.Since(p.startListenTime) > p.config().IdleTimeout {
 			p.silenceCount++
 		} else if text != "" {
 			p.silenceCount = 0 // 重置静音计数
+			p.markFirstResult()
 		}
 		state := asr.StateProcessing
 		if event.Payload.Output.Sentence.SentenceEnd {
 			state = asr.StateSentenceEnd
 		}
+		words := toWords(event)
+		forced := p.reachMaxUtterance(text, state) || p.reachTrailingSilence(text, state, words)
+		if forced {
+			state = asr.StateSentenceEnd
+		}
+		if p.discard(text, state) {
+			return false
+		}
+		if state == asr.StateSentenceEnd && atomic.LoadInt32(&p.finishing) == 1 {
+			// 结束识别期间的分句结果合并到最终结果中，在任务结束时一并回调
+			p.finalText += text
+			return false
+		}
+		if forced {
+			// 已强制结束的语句，丢弃服务端后续返回的该句结果
+			atomic.StoreInt32(&p.discarding, 1)
+		}
+		sentence := event.Payload.Output.Sentence
+		detail := asr.Detail{
+			Result:     text,
+			State:      state,
+			Language:   sentence.Language,
+			Confidence: sentence.Confidence,
+			BeginTime:  sentence.BeginTime,
+			Words:      words,
+		}
+		if sentence.EndTime != nil {
+			detail.EndTime = *sentence.EndTime
+		}
+		asr.NotifyDetail(ctx, p.listener, detail)
 		if finished := p.listener.OnAsrResult(ctx, text, state); finished {
 			return true
 		}
 	case "task-finished":
-		p.listener.OnAsrResult(ctx, "", asr.StateCompleted)
+		text := p.finalText
+		p.finalText = ""
+		p.listener.OnAsrResult(ctx, text, asr.StateCompleted)
 		return true
 	case "task-failed":
 		if event.Header.ErrorMessage != "" {
@@ -405,11 +489,141 @@ func (p *Paraformer) handleEvent(ctx context.Context, event Event) bool {
 	return false
 }
 
+// discard 判断当前结果是否属于已中止的语句，是则丢弃
+func (p *Paraformer) discard(text string, state asr.State) bool {
+	if state == asr.StateProcessing {
+		if text != "" {
+			atomic.StoreInt32(&p.speaking, 1)
+		}
+	} else {
+		atomic.StoreInt32(&p.speaking, 0)
+	}
+
+	if atomic.LoadInt32(&p.discarding) == 0 {
+		return false
+	}
+	if state == asr.StateSentenceEnd {
+		atomic.StoreInt32(&p.discarding, 0)
+	}
+	return true
+}
+
+// reachMaxUtterance 判断当前语句是否超过最大时长，超过则需强制结束该语句
+func (p *Paraformer) reachMaxUtterance(text string, state asr.State) bool {
+	cfg := p.config()
+	if state != asr.StateProcessing {
+		atomic.StoreInt64(&p.utteranceStart, 0)
+		return false
+	}
+	if cfg.MaxUtteranceMs <= 0 || text == "" || atomic.LoadInt32(&p.discarding) == 1 {
+		return false
+	}
+	start := atomic.LoadInt64(&p.utteranceStart)
+	if start == 0 {
+		atomic.StoreInt64(&p.utteranceStart, time.Now().UnixNano())
+		return false
+	}
+	if time.Since(time.Unix(0, start)) < time.Duration(cfg.MaxUtteranceMs)*time.Millisecond {
+		return false
+	}
+	atomic.StoreInt64(&p.utteranceStart, 0)
+	p.log.Warnf("utterance exceeds %dms, force to end the sentence", cfg.MaxUtteranceMs)
+	return true
+}
+
+// reachTrailingSilence 判断当前语句末尾的静音是否已达到 vad 时长，达到则无需等待服务端断句，立即结束该语句
+// 静音时长为已发送音频的时长减去最后一个词的结束时间，仅 pcm 音频可由字节数换算时长
+func (p *Paraformer) reachTrailingSilence(text string, state asr.State, words []asr.Word) bool {
+	cfg := p.config()
+	if state != asr.StateProcessing || text == "" || len(words) == 0 || atomic.LoadInt32(&p.discarding) == 1 {
+		return false
+	}
+	lastEnd := words[len(words)-1].EndTime
+	if lastEnd <= 0 || cfg.Format != "pcm" || cfg.SampleRate <= 0 {
+		return false
+	}
+	sentMs := atomic.LoadInt64(&p.sendBytes) * 1000 / int64(cfg.SampleRate*2)
+	if silence := sentMs - lastEnd; silence < int64(cfg.VadEos) {
+		return false
+	}
+	atomic.StoreInt64(&p.utteranceStart, 0)
+	p.log.Infof("trailing silence after %dms exceeds %dms, end the sentence", lastEnd, cfg.VadEos)
+	return true
+}
+
+// toWords 转换逐词的识别结果
+func toWords(event Event) []asr.Word {
+	src := event.Payload.Output.Sentence.Words
+	if len(src) == 0 {
+		return nil
+	}
+	words := make([]asr.Word, 0, len(src))
+	for _, w := range src {
+		word := asr.Word{
+			Text:        w.Text,
+			Punctuation: w.Punctuation,
+			BeginTime:   w.BeginTime,
+		}
+		if w.EndTime != nil {
+			word.EndTime = *w.EndTime
+		}
+		words = append(words, word)
+	}
+	return words
+}
+
+// markFirstResult 记录首个非空识别结果的延迟
+func (p *Paraformer) markFirstResult() {
+	start := atomic.LoadInt64(&p.firstSendTime)
+	if start == 0 || atomic.LoadInt64(&p.firstResultCost) != 0 {
+		return
+	}
+	atomic.StoreInt64(&p.firstResultCost, time.Now().UnixNano()-start)
+}
+
+// logStats 以结构化字段输出本次会话的耗时与数据量统计
+func (p *Paraformer) logStats() {
+	p.log.WithFields(log.Fields{
+		"provider":        p.Name(),
+		"connect_id":      p.connectID,
+		"req_id":          p.reqID,
+		"connect_ms":      p.connectCost.Milliseconds(),
+		"first_result_ms": time.Duration(atomic.LoadInt64(&p.firstResultCost)).Milliseconds(),
+		"send_bytes":      atomic.LoadInt64(&p.sendBytes),
+		"send_cnt":        p.sendDataCnt,
+	}).Info("asr session stats")
+}
+
+func (p *Paraformer) Finalize() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if !p.state.Running() || p.conn == nil || !atomic.CompareAndSwapInt32(&p.finishing, 0, 1) {
+		return nil
+	}
+	// 发送finish-task指令，服务端识别完剩余音频后返回task-finished事件
+	if err := p.sendFinishTaskCmd(); err != nil {
+		atomic.StoreInt32(&p.finishing, 0)
+		return fmt.Errorf("send finish task cmd error: %v", err)
+	}
+	p.log.Info("paraformer finalize")
+	return nil
+}
+
+func (p *Paraformer) Abort() error {
+	// 只有存在未结束的语句时才需要丢弃，避免误丢弃下一句的识别结果
+	if atomic.LoadInt32(&p.speaking) == 1 {
+		atomic.StoreInt32(&p.discarding, 1)
+		p.log.Info("paraformer abort current sentence")
+	}
+	return nil
+}
+
 func (p *Paraformer) setErrorAndClose(err error) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
-	p.isRunning = false
+	p.state.Stop()
 
 	if strings.Contains(err.Error(), "use of closed network connection") {
 		p.log.Debugf("setErrorAndClose: %v, sendDataCnt=%d", err, p.sendDataCnt)
@@ -428,6 +642,10 @@ func (p *Paraformer) closeConnection() {
 			p.log.Errorf("asr close error: %v", err)
 		}
 	}()
+	if p.release != nil {
+		p.release()
+		p.release = nil
+	}
 	// 发送finish-task指令
 	if err := p.sendFinishTaskCmd(); err != nil {
 		p.log.Errorf("send finish task cmd error: %v", err)
@@ -438,17 +656,40 @@ func (p *Paraformer) closeConnection() {
 	}
 }
 
+// Start 建立连接，已建立时直接返回，可用于预先建连以降低首次识别的延迟
+func (p *Paraformer) Start(ctx context.Context) error {
+	if p.state.Running() {
+		return nil
+	}
+	return p.initConnection(ctx)
+}
+
+// Stop 关闭连接并重置状态，同 Reset
+func (p *Paraformer) Stop() error {
+	return p.Reset()
+}
+
+func (p *Paraformer) Status() lifecycle.Status {
+	return p.state.Status()
+}
+
 func (p *Paraformer) Reset() error {
 	// 使用锁保护状态变更
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
-	p.isRunning = false
+	p.state.Transition(lifecycle.StatusRunning, lifecycle.StatusStopping)
 	p.closeConnection()
+	p.state.Stop()
 
 	p.silenceCount = 0
 	p.sendDataCnt = 0
 	p.taskID = ""
+	atomic.StoreInt32(&p.speaking, 0)
+	atomic.StoreInt32(&p.discarding, 0)
+	atomic.StoreInt32(&p.finishing, 0)
+	atomic.StoreInt64(&p.utteranceStart, 0)
+	p.finalText = ""
 
 	p.log.Info("paraformer reset")
 	return nil
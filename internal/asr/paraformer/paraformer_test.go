@@ -0,0 +1,251 @@
+package paraformer
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"crow/internal/asr"
+	"crow/internal/config"
+	"crow/pkg/fakews"
+	"crow/pkg/lifecycle"
+	"crow/pkg/log"
+)
+
+// result 一次识别结果回调
+type result struct {
+	text  string
+	state asr.State
+}
+
+// fakeListener 记录识别结果回调，识别结束时关闭 done
+type fakeListener struct {
+	lock    sync.Mutex
+	results []result
+	done    chan struct{}
+}
+
+func newFakeListener() *fakeListener {
+	return &fakeListener{done: make(chan struct{})}
+}
+
+func (l *fakeListener) OnAsrResult(_ context.Context, text string, state asr.State) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.results = append(l.results, result{text, state})
+	if state == asr.StateCompleted {
+		close(l.done)
+		return true
+	}
+	return false
+}
+
+func (l *fakeListener) get() []result {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return append([]result(nil), l.results...)
+}
+
+// wait 等待识别结束
+func (l *fakeListener) wait(t *testing.T) []result {
+	t.Helper()
+	select {
+	case <-l.done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("recognition not completed, results: %v", l.get())
+	}
+	return l.get()
+}
+
+// sentence 服务端下发的 result-generated 事件
+func sentence(text string, end bool) fakews.Frame {
+	return fakews.DashScopeEvent("result-generated", "task", map[string]any{
+		"sentence": map[string]any{"text": text, "sentence_end": end, "begin_time": 100},
+	})
+}
+
+func newTestParaformer(t testing.TB, endpoint string, listener asr.Listener) *Paraformer {
+	t.Helper()
+	p := NewParaformer(log.NewLogger(&log.Option{Hook: io.Discard, Mode: "debug"}))
+	p.SetConfig(&asr.Config{AsrConfig: config.AsrConfig{ApiKey: "key", Endpoint: endpoint}})
+	p.SetListener(listener)
+	t.Cleanup(func() { _ = p.Reset() })
+	return p
+}
+
+// waitStopped 等待读取循环退出后连接关闭
+func waitStopped(t *testing.T, p *Paraformer) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for p.Status() != lifecycle.StatusStopped {
+		if time.Now().After(deadline) {
+			t.Fatal("still running, want stopped")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestStart(t *testing.T) {
+	server := fakews.NewServer(fakews.Step{Wait: 1, Frames: []fakews.Frame{fakews.DashScopeEvent("task-started", "task", nil)}})
+	defer server.Close()
+	p := newTestParaformer(t, server.URL(), newFakeListener())
+
+	if err := p.Start(t.Context()); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if got := p.Status(); got != lifecycle.StatusRunning {
+		t.Fatalf("status = %v after start, want running", got)
+	}
+	if got := server.Header().Get("Authorization"); got != "bearer key" {
+		t.Fatalf("authorization = %q", got)
+	}
+
+	received := server.Received()
+	if len(received) != 1 {
+		t.Fatalf("received %d messages, want 1", len(received))
+	}
+	var cmd Event
+	if err := json.Unmarshal(received[0], &cmd); err != nil {
+		t.Fatal(err)
+	}
+	if cmd.Header.Action != "run-task" || cmd.Header.TaskID != p.taskID || cmd.Payload.Parameters.SampleRate != 16000 {
+		t.Fatalf("run-task = %+v", cmd)
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	server := fakews.NewServer(fakews.Step{Wait: 1, Frames: []fakews.Frame{fakews.DashScopeEvent("task-started", "task", nil)}})
+	defer server.Close()
+	p := newTestParaformer(t, server.URL(), newFakeListener())
+
+	if err := p.Start(t.Context()); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	// 已建立连接时再次 Start 不会重新建连
+	if err := p.Start(t.Context()); err != nil {
+		t.Fatalf("start again: %v", err)
+	}
+	if n := len(server.Received()); n != 1 {
+		t.Fatalf("received %d messages, want 1", n)
+	}
+	if err := p.Stop(); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	if got := p.Status(); got != lifecycle.StatusStopped {
+		t.Fatalf("status = %v after stop, want stopped", got)
+	}
+}
+
+func TestStartFailed(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame fakews.Frame
+	}{
+		{"task failed", fakews.DashScopeTaskFailed("task", "InvalidParameter", "invalid sample rate")},
+		{"invalid json", fakews.Truncate(fakews.DashScopeEvent("task-started", "task", nil), 10)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := fakews.NewServer(fakews.Step{Wait: 1, Frames: []fakews.Frame{tt.frame}})
+			defer server.Close()
+			p := newTestParaformer(t, server.URL(), newFakeListener())
+
+			if err := p.Start(t.Context()); err == nil {
+				t.Fatal("start succeeded, want error")
+			}
+			if got := p.Status(); got != lifecycle.StatusStopped {
+				t.Fatalf("status = %v after failed start, want stopped", got)
+			}
+		})
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	server := fakews.NewServer(fakews.Step{Wait: 1, Frames: []fakews.Frame{
+		fakews.DashScopeEvent("task-started", "task", nil),
+		sentence("今天", false),
+		{MessageType: websocket.TextMessage, Data: []byte(`not json`)}, // 无法解析的事件被跳过
+		sentence("今天天气", true),
+		fakews.DashScopeEvent("task-finished", "task", nil),
+	}})
+	defer server.Close()
+	listener := newFakeListener()
+	p := newTestParaformer(t, server.URL(), listener)
+
+	if err := p.Start(t.Context()); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	got := listener.wait(t)
+	want := []result{{"今天", asr.StateProcessing}, {"今天天气", asr.StateSentenceEnd}, {"", asr.StateCompleted}}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Fatalf("results = %v, want %v", got, want)
+	}
+}
+
+func TestReadMessageTaskFailed(t *testing.T) {
+	server := fakews.NewServer(fakews.Step{Wait: 1, Frames: []fakews.Frame{
+		fakews.DashScopeEvent("task-started", "task", nil),
+		sentence("今天", false),
+		fakews.DashScopeTaskFailed("task", "InternalError", "internal error"),
+	}})
+	defer server.Close()
+	listener := newFakeListener()
+	p := newTestParaformer(t, server.URL(), listener)
+
+	if err := p.Start(t.Context()); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	// 任务失败时以空的最终结果结束识别，并关闭连接
+	got := listener.wait(t)
+	if last := got[len(got)-1]; last != (result{"", asr.StateCompleted}) {
+		t.Fatalf("last result = %v, want empty completed", last)
+	}
+	waitStopped(t, p)
+}
+
+func TestReadMessageConnectionClosed(t *testing.T) {
+	server := fakews.NewServer(fakews.Step{Wait: 1, Frames: []fakews.Frame{fakews.DashScopeEvent("task-started", "task", nil)}, Close: true})
+	defer server.Close()
+	p := newTestParaformer(t, server.URL(), newFakeListener())
+
+	if err := p.Start(t.Context()); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	// 服务端断开后读取循环退出，下一次发送音频时重新建连
+	waitStopped(t, p)
+}
+
+// pcmFrame 100ms 的 16k 16bit 单声道正弦波，模拟客户端上行的一帧音频
+func pcmFrame() []byte {
+	frame := make([]byte, 3200)
+	for i := 0; i < len(frame)/2; i++ {
+		sample := int16(8000 * math.Sin(2*math.Pi*440*float64(i)/16000))
+		frame[2*i], frame[2*i+1] = byte(sample), byte(sample>>8)
+	}
+	return frame
+}
+
+func BenchmarkSendAudio(b *testing.B) {
+	frame := pcmFrame()
+	server := fakews.NewDiscardServer(fakews.Step{Wait: 1, Frames: []fakews.Frame{fakews.DashScopeEvent("task-started", "task", nil)}})
+	defer server.Close()
+	p := newTestParaformer(b, server.URL(), newFakeListener())
+	if err := p.Start(context.Background()); err != nil {
+		b.Fatalf("start: %v", err)
+	}
+
+	b.SetBytes(int64(len(frame)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := p.SendAudio(context.Background(), frame); err != nil {
+			b.Fatalf("send audio: %v", err)
+		}
+	}
+}
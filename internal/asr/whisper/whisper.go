@@ -0,0 +1,563 @@
+package whisper
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"crow/internal/asr"
+	"crow/pkg/connlimit"
+	"crow/pkg/lifecycle"
+	"crow/pkg/log"
+	"crow/pkg/netproxy"
+)
+
+// OpenAI 语音转写 API 文档，兼容该接口的自部署服务（如 faster-whisper-server）同样适用
+// https://platform.openai.com/docs/api-reference/audio/createTranscription
+
+const (
+	baseURL      = "https://api.openai.com/v1"
+	defaultModel = "whisper-1"
+
+	frameMs     = 20  // 端点检测的分析帧时长，单位毫秒
+	speechLevel = 400 // 帧内采样的平均幅度超过该值视为有人声，16位 pcm
+	preRollMs   = 300 // 检测到人声前保留的音频时长，避免截掉首字，单位毫秒
+	minVoicedMs = 200 // 一句话中人声的最短时长，不足时视为噪声而不请求转写，避免模型对噪声臆造文本，单位毫秒
+
+	defaultMaxUtteranceMs = 60000   // 未配置单句最大时长时，单次转写的最大音频时长，单位毫秒
+	maxResponseBytes      = 1 << 20 // 单次转写响应的最大字节数，避免异常响应占用过多内存
+	chunkQueueSize        = 16      // 待转写语句的队列长度
+)
+
+var (
+	httpClientsLock sync.Mutex
+	// httpClients 按代理配置复用的 HTTP 客户端，使各会话共用连接池，k: 代理地址
+	httpClients = map[string]*http.Client{}
+)
+
+func httpClient(proxy string) *http.Client {
+	httpClientsLock.Lock()
+	defer httpClientsLock.Unlock()
+	if client, ok := httpClients[proxy]; ok {
+		return client
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = netproxy.Func(proxy)
+	client := &http.Client{Transport: transport}
+	httpClients[proxy] = client
+	return client
+}
+
+// chunk 一段待转写的音频，即一句话
+type chunk struct {
+	audio     []byte
+	speech    bool      // 是否检测到人声，无人声时无需请求转写
+	state     asr.State // 转写后回调的识别状态
+	beginTime int64     // 在本次识别音频中的开始时间，单位毫秒
+}
+
+// Whisper 非流式的语音识别，缓存音频并在本地做端点检测，每句话结束后整句请求转写
+// 仅支持 pcm 音频，转写前封装为 wav；识别过程中没有中间结果，因此不会以 StateProcessing 回调文本
+type Whisper struct {
+	cfg atomic.Pointer[asr.Config] // 当前生效的配置，会话中可被整体替换，通过 config 读取
+	log *log.Logger
+
+	listener asr.Listener
+
+	lock sync.Mutex
+
+	state     lifecycle.State // 转写协程的生命周期状态
+	reqID     string
+	connectID string
+	chunks    chan chunk         // 待转写的语句，由转写协程按顺序处理
+	cancel    context.CancelFunc // 结束转写协程并取消进行中的请求
+
+	buf       []byte    // 当前语句已缓存的音频
+	remain    []byte    // 不足一帧的音频，与下次的数据拼接
+	recvBytes int64     // 本次识别已接收的音频字节数
+	speaking  bool      // 当前语句是否已检测到人声
+	voicedMs  int       // 当前语句中人声的时长
+	silenceMs int       // 当前语句末尾连续静音的时长
+	idleSince time.Time // 空闲计时的起点，检测到人声或计入一次静音时重新计时
+
+	silenceCount int32
+	finishing    int32 // 是否已结束识别，0：否，1：是
+
+	sendDataCnt     int
+	firstSendTime   int64 // 本次会话首次发送音频的时间，UnixNano
+	firstResultCost int64 // 首次发送音频到收到首个非空识别结果的耗时，单位纳秒
+	sendBytes       int64 // 本次会话发送的音频字节数
+	requestCnt      int64 // 本次会话请求转写的次数
+}
+
+func NewWhisper(log *log.Logger) *Whisper {
+	return &Whisper{
+		connectID: fmt.Sprintf("%d", time.Now().UnixNano()),
+		log:       log,
+	}
+}
+
+func (w *Whisper) Name() string {
+	return "whisper"
+}
+
+func (w *Whisper) SetConfig(cfg *asr.Config) *asr.Config {
+	// whisper 仅接受 ISO-639-1 语种代码，如 zh-CN 取 zh
+	if cfg.Language == "" {
+		cfg.Language = "zh"
+	}
+	cfg.Language, _, _ = strings.Cut(cfg.Language, "-")
+	if cfg.SampleRate <= 0 {
+		cfg.SampleRate = 16000
+	}
+	cfg.Format = "pcm"    // 端点检测需解析采样数据，仅支持 pcm
+	cfg.EnablePunc = true // 转写结果总是带有标点
+	if cfg.VadEos < 200 {
+		cfg.VadEos = 800
+	}
+	if cfg.MaxUtteranceMs < 0 {
+		cfg.MaxUtteranceMs = 0
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = asr.DefaultIdleTimeout
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = baseURL
+	}
+	if cfg.Model == "" {
+		cfg.Model = defaultModel
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = asr.DefaultMaxRetries
+	}
+	w.cfg.Store(cfg)
+	return cfg
+}
+
+// config 当前生效的配置，SetConfig 整体替换配置，因此可与各协程并发读取
+func (w *Whisper) config() *asr.Config {
+	return w.cfg.Load()
+}
+
+func (w *Whisper) SetListener(listener asr.Listener) {
+	w.listener = listener
+}
+
+func (w *Whisper) SendAudio(ctx context.Context, data []byte) error {
+	if err := w.Start(ctx); err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if !w.state.Running() || atomic.LoadInt32(&w.finishing) == 1 {
+		return nil
+	}
+	w.sendDataCnt++
+	atomic.AddInt64(&w.sendBytes, int64(len(data)))
+	atomic.CompareAndSwapInt64(&w.firstSendTime, 0, time.Now().UnixNano())
+
+	frameBytes := w.bytesOf(frameMs)
+	w.remain = append(w.remain, data...)
+	for len(w.remain) >= frameBytes {
+		w.detect(w.remain[:frameBytes])
+		w.remain = w.remain[frameBytes:]
+	}
+	w.remain = bytes.Clone(w.remain)
+	return nil
+}
+
+// detect 对一帧音频做端点检测，末尾静音达到 vad 时长或语句超过最大时长时结束该句
+// 未检测到人声时只保留少量音频，长时间无人声时计入一次静音
+func (w *Whisper) detect(frame []byte) {
+	cfg := w.config()
+	w.buf = append(w.buf, frame...)
+	w.recvBytes += int64(len(frame))
+	if level(frame) >= speechLevel {
+		w.speaking = true
+		w.voicedMs += frameMs
+		w.silenceMs = 0
+		w.idleSince = time.Now()
+	} else {
+		w.silenceMs += frameMs
+	}
+
+	if !w.speaking {
+		if keep := w.bytesOf(preRollMs); len(w.buf) > keep {
+			w.buf = append(w.buf[:0], w.buf[len(w.buf)-keep:]...)
+		}
+		if time.Since(w.idleSince) > cfg.IdleTimeout {
+			atomic.AddInt32(&w.silenceCount, 1)
+			w.idleSince = time.Now()
+			// 以空结果告知监听者，由监听者根据静音次数决定是否结束识别
+			w.push(chunk{state: asr.StateProcessing})
+		}
+		return
+	}
+
+	maxUtteranceMs := cfg.MaxUtteranceMs
+	if maxUtteranceMs <= 0 {
+		maxUtteranceMs = defaultMaxUtteranceMs
+	}
+	if len(w.buf) >= w.bytesOf(maxUtteranceMs) {
+		w.log.Warnf("utterance exceeds %dms, force to end the sentence", maxUtteranceMs)
+		w.flush(asr.StateSentenceEnd)
+	} else if w.silenceMs >= cfg.VadEos {
+		w.flush(asr.StateSentenceEnd)
+	}
+}
+
+// flush 将当前语句的音频加入转写队列，并开始缓存下一句
+func (w *Whisper) flush(state asr.State) {
+	w.push(chunk{
+		audio:     w.buf,
+		speech:    w.voicedMs >= minVoicedMs,
+		state:     state,
+		beginTime: w.msOf(w.recvBytes - int64(len(w.buf))),
+	})
+	w.resetUtterance()
+}
+
+// resetUtterance 开始缓存新的语句
+func (w *Whisper) resetUtterance() {
+	w.buf = nil
+	w.speaking = false
+	w.voicedMs = 0
+	w.silenceMs = 0
+}
+
+// push 加入转写队列，持有锁时不能等待转写协程，因此队列已满或转写协程已结束时丢弃
+func (w *Whisper) push(c chunk) {
+	select {
+	case w.chunks <- c:
+	default:
+		w.log.Warnf("whisper transcription queue is full, drop %dms audio", w.msOf(int64(len(c.audio))))
+	}
+}
+
+// level 帧内采样的平均幅度，用于粗略判断是否有人声
+func level(frame []byte) int {
+	n := len(frame) / 2
+	if n == 0 {
+		return 0
+	}
+	var sum int
+	for i := 0; i < n; i++ {
+		v := int(int16(binary.LittleEndian.Uint16(frame[2*i:])))
+		if v < 0 {
+			v = -v
+		}
+		sum += v
+	}
+	return sum / n
+}
+
+// bytesOf 时长对应的 pcm 字节数，16位单声道
+func (w *Whisper) bytesOf(ms int) int {
+	return w.config().SampleRate * 2 * ms / 1000
+}
+
+// msOf pcm 字节数对应的时长，单位毫秒
+func (w *Whisper) msOf(n int64) int64 {
+	return n * 1000 / int64(w.config().SampleRate*2)
+}
+
+// transcribeLoop 按顺序转写队列中的语句并回调监听者，收到 StateCompleted 的语句或被重置时退出
+func (w *Whisper) transcribeLoop(ctx context.Context, chunks chan chunk) {
+	w.log.Info("whisper transcribe loop started")
+
+	defer func() {
+		if err := recover(); err != nil {
+			w.log.Errorf("asr transcribe goroutine panic: %v", err)
+		}
+		w.logStats()
+		w.lock.Lock()
+		// 期间已被重置并开始了新的识别时，不影响新的识别
+		if w.chunks == chunks {
+			w.stop()
+		}
+		w.lock.Unlock()
+		w.log.Info("whisper transcribe loop stopped")
+	}()
+
+	for {
+		var c chunk
+		select {
+		case <-ctx.Done():
+			return
+		case c = <-chunks:
+		}
+
+		var text string
+		if c.speech {
+			var err error
+			text, err = w.transcribe(ctx, c.audio)
+			if ctx.Err() != nil {
+				// 已被重置，丢弃结果
+				return
+			}
+			if err != nil {
+				w.log.Errorf("failed to transcribe: %v", err)
+			}
+		}
+		if text != "" {
+			atomic.StoreInt32(&w.silenceCount, 0) // 重置静音计数
+			w.markFirstResult()
+		}
+
+		detail := asr.Detail{
+			Result:    text,
+			State:     c.state,
+			Language:  w.config().Language,
+			BeginTime: c.beginTime,
+		}
+		if c.state != asr.StateProcessing {
+			detail.EndTime = c.beginTime + w.msOf(int64(len(c.audio)))
+		}
+		asr.NotifyDetail(ctx, w.listener, detail)
+		if finished := w.listener.OnAsrResult(ctx, text, c.state); finished || c.state == asr.StateCompleted {
+			return
+		}
+	}
+}
+
+// transcribe 将一句话的音频封装为 wav 并请求转写
+// 服务端过载或网关错误时重试，均失败时返回错误
+func (w *Whisper) transcribe(ctx context.Context, audio []byte) (string, error) {
+	cfg := w.config()
+	// 占用连接名额，避免超出服务商的并发配额
+	release, err := connlimit.Get("asr", w.Name(), cfg.MaxConcurrency, cfg.ConcurrencyTimeout).Acquire(ctx)
+	if err != nil {
+		return "", fmt.Errorf("%w, %v", asr.ErrUnavailable, err)
+	}
+	defer release()
+
+	body, contentType, err := w.newForm(audio)
+	if err != nil {
+		return "", fmt.Errorf("failed to build the request: %v", err)
+	}
+	atomic.AddInt64(&w.requestCnt, 1)
+
+	var resp *http.Response
+	client := httpClient(cfg.Proxy)
+	url := strings.TrimSuffix(cfg.Endpoint, "/") + "/audio/transcriptions"
+	err = lifecycle.Retry(ctx, cfg.MaxRetries, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", contentType)
+		if cfg.ApiKey != "" {
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", cfg.ApiKey))
+		}
+		resp, err = client.Do(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode >= http.StatusInternalServerError {
+			_ = resp.Body.Close()
+			return fmt.Errorf("status code %d", resp.StatusCode)
+		}
+		return nil
+	}, func(attempt int, err error, backoff time.Duration) {
+		w.log.Warnf("failed to request the transcription, try %d/%d: %v, will try again %v", attempt, cfg.MaxRetries, err, backoff)
+	})
+	if err != nil {
+		return "", fmt.Errorf("%w, failed to request: %v", asr.ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return "", fmt.Errorf("failed to read the response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("ASR server side error: status code = %d, body: %s", resp.StatusCode, data)
+	}
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return "", fmt.Errorf("failed to parse the JSON response: %v", err)
+	}
+	return strings.TrimSpace(result.Text), nil
+}
+
+// newForm 构造转写请求的 multipart 表单
+func (w *Whisper) newForm(audio []byte) ([]byte, string, error) {
+	cfg := w.config()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fields := map[string]string{
+		"model":           cfg.Model,
+		"language":        cfg.Language,
+		"response_format": "json",
+	}
+	for k, v := range fields {
+		if err := mw.WriteField(k, v); err != nil {
+			return nil, "", err
+		}
+	}
+	fw, err := mw.CreateFormFile("file", "audio.wav")
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err = fw.Write(wavHeader(len(audio), cfg.SampleRate)); err != nil {
+		return nil, "", err
+	}
+	if _, err = fw.Write(audio); err != nil {
+		return nil, "", err
+	}
+	if err = mw.Close(); err != nil {
+		return nil, "", err
+	}
+	return body.Bytes(), mw.FormDataContentType(), nil
+}
+
+// wavHeader 16位单声道 pcm 的 wav 文件头
+func wavHeader(dataLen, sampleRate int) []byte {
+	header := make([]byte, 44)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(36+dataLen))
+	copy(header[8:], "WAVE")
+	copy(header[12:], "fmt ")
+	binary.LittleEndian.PutUint32(header[16:], 16)                   // fmt 块大小
+	binary.LittleEndian.PutUint16(header[20:], 1)                    // pcm
+	binary.LittleEndian.PutUint16(header[22:], 1)                    // 声道数
+	binary.LittleEndian.PutUint32(header[24:], uint32(sampleRate))   // 采样率
+	binary.LittleEndian.PutUint32(header[28:], uint32(sampleRate*2)) // 每秒字节数
+	binary.LittleEndian.PutUint16(header[32:], 2)                    // 每个采样的字节数
+	binary.LittleEndian.PutUint16(header[34:], 16)                   // 采样位数
+	copy(header[36:], "data")
+	binary.LittleEndian.PutUint32(header[40:], uint32(dataLen))
+	return header
+}
+
+// markFirstResult 记录首个非空识别结果的延迟
+func (w *Whisper) markFirstResult() {
+	start := atomic.LoadInt64(&w.firstSendTime)
+	if start == 0 || atomic.LoadInt64(&w.firstResultCost) != 0 {
+		return
+	}
+	atomic.StoreInt64(&w.firstResultCost, time.Now().UnixNano()-start)
+}
+
+// logStats 以结构化字段输出本次会话的耗时与数据量统计
+func (w *Whisper) logStats() {
+	w.log.WithFields(log.Fields{
+		"provider":        w.Name(),
+		"connect_id":      w.connectID,
+		"req_id":          w.reqID,
+		"first_result_ms": time.Duration(atomic.LoadInt64(&w.firstResultCost)).Milliseconds(),
+		"send_bytes":      atomic.LoadInt64(&w.sendBytes),
+		"send_cnt":        w.sendDataCnt,
+		"request_cnt":     atomic.LoadInt64(&w.requestCnt),
+	}).Info("asr session stats")
+}
+
+// Finalize 将剩余的音频作为最后一句转写，转写完成后以 StateCompleted 回调
+func (w *Whisper) Finalize() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if !w.state.Running() || !atomic.CompareAndSwapInt32(&w.finishing, 0, 1) {
+		return nil
+	}
+	w.flush(asr.StateCompleted)
+	w.log.Info("whisper finalize")
+	return nil
+}
+
+// Abort 丢弃当前语句已缓存的音频，已结束并在转写中的语句不受影响
+func (w *Whisper) Abort() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.speaking {
+		w.log.Info("whisper abort current sentence")
+	}
+	w.resetUtterance()
+	return nil
+}
+
+// Start 启动转写协程，已启动时直接返回；转写为无状态的 HTTP 请求，无需预先建连
+func (w *Whisper) Start(ctx context.Context) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.config() == nil {
+		return errors.New("whisper is not configured")
+	}
+	if !w.state.Start() {
+		return nil
+	}
+	ctx, w.cancel = context.WithCancel(ctx)
+	w.chunks = make(chan chunk, chunkQueueSize)
+	w.reqID = fmt.Sprintf("%d", time.Now().UnixNano())
+	w.idleSince = time.Now()
+	w.sendDataCnt = 0
+	atomic.StoreInt64(&w.firstSendTime, 0)
+	atomic.StoreInt64(&w.firstResultCost, 0)
+	atomic.StoreInt64(&w.sendBytes, 0)
+	atomic.StoreInt64(&w.requestCnt, 0)
+	w.state.Transition(lifecycle.StatusStarting, lifecycle.StatusRunning)
+	w.log.Info("start asr")
+
+	go w.transcribeLoop(ctx, w.chunks)
+	return nil
+}
+
+// Stop 结束转写并重置状态，同 Reset
+func (w *Whisper) Stop() error {
+	return w.Reset()
+}
+
+func (w *Whisper) Status() lifecycle.Status {
+	return w.state.Status()
+}
+
+// Reset 结束转写协程并丢弃未转写的音频，进行中的转写请求将被取消
+func (w *Whisper) Reset() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.state.Transition(lifecycle.StatusRunning, lifecycle.StatusStopping)
+	w.stop()
+
+	atomic.StoreInt32(&w.silenceCount, 0)
+	atomic.StoreInt32(&w.finishing, 0)
+	w.resetUtterance()
+	w.remain = nil
+	w.recvBytes = 0
+
+	w.log.Info("whisper reset")
+	return nil
+}
+
+// stop 结束转写协程，需持有锁
+func (w *Whisper) stop() {
+	if w.cancel != nil {
+		w.cancel()
+		w.cancel = nil
+	}
+	w.chunks = nil
+	w.state.Stop()
+}
+
+func (w *Whisper) GetSilenceCount() int {
+	return int(atomic.LoadInt32(&w.silenceCount))
+}
@@ -0,0 +1,287 @@
+package whisper
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"crow/internal/asr"
+	"crow/internal/config"
+	"crow/pkg/log"
+)
+
+// result 一次识别结果回调
+type result struct {
+	text  string
+	state asr.State
+}
+
+// fakeListener 记录识别结果回调，识别结束时关闭 done
+type fakeListener struct {
+	lock    sync.Mutex
+	results []result
+	done    chan struct{}
+}
+
+func newFakeListener() *fakeListener {
+	return &fakeListener{done: make(chan struct{})}
+}
+
+func (l *fakeListener) OnAsrResult(_ context.Context, text string, state asr.State) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.results = append(l.results, result{text, state})
+	if state == asr.StateCompleted {
+		close(l.done)
+		return true
+	}
+	return false
+}
+
+func (l *fakeListener) get() []result {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return append([]result(nil), l.results...)
+}
+
+// waitFor 等待收到 n 个识别结果
+func (l *fakeListener) waitFor(t *testing.T, n int) []result {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if results := l.get(); len(results) >= n {
+			return results
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d results, got %v", n, l.get())
+	return nil
+}
+
+// pcm 16kHz 16位单声道的音频，amplitude 为0时为静音
+func pcm(ms int, amplitude int16) []byte {
+	data := make([]byte, 16000*2*ms/1000)
+	for i := 0; i < len(data); i += 2 {
+		v := amplitude
+		if i/2%2 == 1 {
+			v = -v
+		}
+		binary.LittleEndian.PutUint16(data[i:], uint16(v))
+	}
+	return data
+}
+
+// fakeServer 转写服务，前 failures 次请求返回 503，之后返回 text
+type fakeServer struct {
+	*httptest.Server
+	failures int32
+	requests int32
+
+	lock  sync.Mutex
+	forms []map[string]string // 每次请求的表单字段
+	audio [][]byte            // 每次请求上传的音频，不含 wav 文件头
+	auth  string
+}
+
+func newFakeServer(t *testing.T, text string, failures int32) *fakeServer {
+	s := &fakeServer{failures: failures}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/audio/transcriptions" {
+			http.NotFound(w, r)
+			return
+		}
+		if atomic.AddInt32(&s.requests, 1) <= s.failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("read audio file: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		wav, _ := io.ReadAll(file)
+		if len(wav) < 44 || string(wav[:4]) != "RIFF" || int(binary.LittleEndian.Uint32(wav[40:])) != len(wav)-44 {
+			t.Errorf("invalid wav file of %d bytes", len(wav))
+		}
+		s.lock.Lock()
+		s.forms = append(s.forms, map[string]string{
+			"model":           r.FormValue("model"),
+			"language":        r.FormValue("language"),
+			"response_format": r.FormValue("response_format"),
+		})
+		s.audio = append(s.audio, wav[44:])
+		s.auth = r.Header.Get("Authorization")
+		s.lock.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"text":" `+text+` "}`)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newTestWhisper(t *testing.T, cfg config.AsrConfig, listener asr.Listener) *Whisper {
+	t.Helper()
+	w := NewWhisper(log.NewLogger(&log.Option{Hook: io.Discard, Mode: "debug"}))
+	w.SetConfig(&asr.Config{AsrConfig: cfg, Language: "zh-CN", IdleTimeout: time.Minute})
+	w.SetListener(listener)
+	t.Cleanup(func() { _ = w.Reset() })
+	return w
+}
+
+func TestTranscribe(t *testing.T) {
+	server := newFakeServer(t, "你好。", 0)
+	listener := newFakeListener()
+	w := newTestWhisper(t, config.AsrConfig{Endpoint: server.URL, ApiKey: "key"}, listener)
+
+	// 一句话之后的静音达到 vad 时长时结束该句并转写
+	speech := pcm(400, 1000)
+	for _, data := range [][]byte{pcm(100, 0), speech, pcm(900, 0)} {
+		if err := w.SendAudio(t.Context(), data); err != nil {
+			t.Fatalf("send audio: %v", err)
+		}
+	}
+	if got := listener.waitFor(t, 1); !reflect.DeepEqual(got, []result{{"你好。", asr.StateSentenceEnd}}) {
+		t.Fatalf("results = %v", got)
+	}
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	if want := map[string]string{"model": "whisper-1", "language": "zh", "response_format": "json"}; !reflect.DeepEqual(server.forms[0], want) {
+		t.Fatalf("form = %v, want %v", server.forms[0], want)
+	}
+	if server.auth != "Bearer key" {
+		t.Fatalf("authorization = %q", server.auth)
+	}
+	// 上传的音频包含人声前保留的静音及人声之后的静音
+	if n := len(server.audio[0]); n < len(speech) || n > len(speech)+len(pcm(100+900, 0)) {
+		t.Fatalf("uploaded %d bytes of audio, want at least the %d bytes of speech", n, len(speech))
+	}
+}
+
+func TestFinalize(t *testing.T) {
+	tests := []struct {
+		name     string
+		audio    []byte
+		want     []result
+		requests int32
+	}{
+		{"speech", pcm(400, 1000), []result{{"你好。", asr.StateCompleted}}, 1},
+		// 没有人声时无需请求转写
+		{"silence", pcm(400, 0), []result{{"", asr.StateCompleted}}, 0},
+		{"too short", pcm(100, 1000), []result{{"", asr.StateCompleted}}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t, "你好。", 0)
+			listener := newFakeListener()
+			w := newTestWhisper(t, config.AsrConfig{Endpoint: server.URL}, listener)
+
+			if err := w.SendAudio(t.Context(), tt.audio); err != nil {
+				t.Fatalf("send audio: %v", err)
+			}
+			if err := w.Finalize(); err != nil {
+				t.Fatalf("finalize: %v", err)
+			}
+			select {
+			case <-listener.done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("no StateCompleted result after finalize")
+			}
+			if got := listener.get(); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("results = %v, want %v", got, tt.want)
+			}
+			if n := atomic.LoadInt32(&server.requests); n != tt.requests {
+				t.Fatalf("requests = %d, want %d", n, tt.requests)
+			}
+		})
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	server := newFakeServer(t, "你好。", 0)
+	listener := newFakeListener()
+	w := newTestWhisper(t, config.AsrConfig{Endpoint: server.URL}, listener)
+	w.SetConfig(&asr.Config{AsrConfig: config.AsrConfig{Endpoint: server.URL}, IdleTimeout: 50 * time.Millisecond})
+
+	// 超过空闲超时仍无人声时，以空结果计入一次静音
+	for i := 0; i < 2; i++ {
+		if err := w.SendAudio(t.Context(), pcm(20, 0)); err != nil {
+			t.Fatalf("send audio: %v", err)
+		}
+		time.Sleep(60 * time.Millisecond)
+		if err := w.SendAudio(t.Context(), pcm(20, 0)); err != nil {
+			t.Fatalf("send audio: %v", err)
+		}
+	}
+	if got := listener.waitFor(t, 2); !reflect.DeepEqual(got, []result{{"", asr.StateProcessing}, {"", asr.StateProcessing}}) {
+		t.Fatalf("results = %v", got)
+	}
+	if got := w.GetSilenceCount(); got != 2 {
+		t.Fatalf("silence count = %d, want 2", got)
+	}
+	if n := atomic.LoadInt32(&server.requests); n != 0 {
+		t.Fatalf("requests = %d, want 0", n)
+	}
+
+	// 识别出文本后静音计数清零
+	if err := w.SendAudio(t.Context(), pcm(400, 1000)); err != nil {
+		t.Fatalf("send audio: %v", err)
+	}
+	if err := w.Finalize(); err != nil {
+		t.Fatalf("finalize: %v", err)
+	}
+	select {
+	case <-listener.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no StateCompleted result after finalize")
+	}
+	if got := w.GetSilenceCount(); got != 0 {
+		t.Fatalf("silence count = %d after a result, want 0", got)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int32
+		want     string
+		requests int32
+	}{
+		{"recovered", 1, "你好。", 2},
+		// 均失败时以空结果结束该句，不影响后续识别
+		{"exhausted", 2, "", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t, "你好。", tt.failures)
+			listener := newFakeListener()
+			w := newTestWhisper(t, config.AsrConfig{Endpoint: server.URL, MaxRetries: 2}, listener)
+
+			if err := w.SendAudio(t.Context(), pcm(400, 1000)); err != nil {
+				t.Fatalf("send audio: %v", err)
+			}
+			if err := w.Finalize(); err != nil {
+				t.Fatalf("finalize: %v", err)
+			}
+			select {
+			case <-listener.done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("no result")
+			}
+			if got := listener.get(); !reflect.DeepEqual(got, []result{{tt.want, asr.StateCompleted}}) {
+				t.Fatalf("results = %v", got)
+			}
+			if n := atomic.LoadInt32(&server.requests); n != tt.requests {
+				t.Fatalf("requests = %d, want %d", n, tt.requests)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+// Package auth 会话的认证与调用方身份
+//
+// 建立连接时由 Authenticator 认证调用方，得到的 Identity 通过 context 一路传递至工具的执行，
+// 代表用户执行操作的工具（如日历、邮件）应通过 FromContext 获取调用方身份并据此限定操作范围：
+//   - 未配置认证时 context 中没有身份，此类工具应拒绝执行，而不是以某个默认用户的身份执行；
+//   - 身份仅由服务端认证得到，不能来自客户端消息或模型生成的参数；
+//   - MCP 工具调用时，身份会放在请求的 _meta 中以 IdentityMetaKey 为键传给 MCP 服务器。
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// IdentityMetaKey 调用 MCP 工具时，调用方身份在请求 _meta 中的键
+const IdentityMetaKey = "crow/identity"
+
+// ErrUnauthorized 认证失败
+var ErrUnauthorized = errors.New("unauthorized")
+
+// Identity 已认证的调用方身份
+type Identity struct {
+	Subject string         `json:"subject"`          // 调用方的唯一标识，如用户ID
+	Claims  map[string]any `json:"claims,omitempty"` // 认证方提供的其他声明，如租户、权限范围
+}
+
+// Authenticator 认证建立连接的请求，可按需替换为对接 JWT、OAuth 等的实现
+type Authenticator interface {
+	// Authenticate 认证请求，失败时返回 ErrUnauthorized 或包装它的错误
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// StaticTokens 基于静态令牌的认证，k: 令牌，v: 令牌对应的调用方标识
+type StaticTokens map[string]string
+
+func (s StaticTokens) Authenticate(r *http.Request) (*Identity, error) {
+	token := BearerToken(r)
+	if token == "" {
+		return nil, ErrUnauthorized
+	}
+	// 逐一以常量时间比较，避免通过响应耗时推测令牌
+	var (
+		subject string
+		ok      bool
+	)
+	for t, sub := range s {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			subject, ok = sub, true
+		}
+	}
+	if !ok {
+		return nil, ErrUnauthorized
+	}
+	return &Identity{Subject: subject}, nil
+}
+
+// BearerToken 获取请求携带的令牌，优先使用 Authorization: Bearer 请求头，
+// 浏览器的 websocket 无法设置请求头，因此也可以通过 token 查询参数携带，访问日志中会将其脱敏
+func BearerToken(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); h != "" {
+		if scheme, token, ok := strings.Cut(h, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+	return r.URL.Query().Get("token")
+}
+
+// identityKey 调用方身份在 context 中的键，未导出以避免与其他包的键冲突
+type identityKey struct{}
+
+// NewContext 返回携带调用方身份的 context，id 为 nil 时原样返回
+func NewContext(ctx context.Context, id *Identity) context.Context {
+	if id == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// FromContext 获取 context 携带的调用方身份，未认证时返回 false
+func FromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+	return id, ok
+}
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStaticTokens(t *testing.T) {
+	tokens := StaticTokens{"alice-token": "alice", "bob-token": "bob"}
+	tests := []struct {
+		name    string
+		target  string
+		header  string
+		subject string // 为空表示认证失败
+	}{
+		{"header", "/crow/v1", "Bearer alice-token", "alice"},
+		{"query", "/crow/v1?token=bob-token", "", "bob"},
+		{"header first", "/crow/v1?token=bob-token", "Bearer alice-token", "alice"},
+		{"missing", "/crow/v1", "", ""},
+		{"unknown", "/crow/v1", "Bearer carol-token", ""},
+		{"prefix", "/crow/v1", "Bearer alice", ""},
+		{"other scheme", "/crow/v1?token=bob-token", "Basic alice-token", "bob"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.target, nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			id, err := tokens.Authenticate(r)
+			if tt.subject == "" {
+				if !errors.Is(err, ErrUnauthorized) {
+					t.Fatalf("err = %v, want ErrUnauthorized", err)
+				}
+				return
+			}
+			if err != nil || id.Subject != tt.subject {
+				t.Fatalf("identity = %v, err = %v, want subject %q", id, err, tt.subject)
+			}
+		})
+	}
+}
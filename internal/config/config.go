@@ -3,38 +3,248 @@ package config
 import (
 	"fmt"
 	"log"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"sync"
 	"time"
+	_ "time/tzdata" // 内置时区数据，容器中未安装时区数据时也能加载 server.timezone
 
 	"github.com/fsnotify/fsnotify"
 	"gopkg.in/yaml.v3"
+
+	"crow/pkg/netproxy"
 )
 
 type Config struct {
 	Server struct {
-		Mode string `yaml:"mode"`
-		IP   string `yaml:"ip"`
-		Port string `yaml:"port"`
+		Mode        string        `yaml:"mode"`
+		IP          string        `yaml:"ip"`
+		Port        string        `yaml:"port"`
+		IdleTimeout time.Duration `yaml:"idle_timeout"` // 会话空闲超时时间，期间无任何收发活动则关闭连接，默认60s
+		Warmup      bool          `yaml:"warmup"`       // 是否在hello后预先建立ASR/TTS连接，会提前占用服务商的连接数
+		// Timezone 服务使用的时区，如 Asia/Shanghai，current_time 工具及注入提示词的当前时间等均以此为准，不受宿主机 TZ 影响，为空则使用宿主机时区；修改后需重启生效
+		Timezone string `yaml:"timezone"`
+		// AudioFormatCheck 校验客户端音频与hello中协商的格式是否一致，off：不校验，warn：不一致时告知客户端但仍进行识别（默认），reject：不一致时告知客户端并丢弃本句的音频
+		AudioFormatCheck string `yaml:"audio_format_check"`
+		// AsrFallback 语音识别服务不可用时的降级方式，off：仅告知服务不可用（默认），notify：同时建议客户端改用文字输入，仍继续尝试识别，auto：本次会话切换为文字输入，不再识别语音
+		AsrFallback string `yaml:"asr_fallback"`
+		// WsCompression 是否与支持的客户端协商 permessage-deflate 压缩，仅压缩较大的文本消息，以CPU换取带宽，适合按流量计费的移动端
+		WsCompression bool `yaml:"ws_compression"`
+		// WsCompressionLevel 压缩级别，-2：仅霍夫曼编码（默认），对base64音频等匹配较少的文本效果最好且开销低，1（最快）至9（压缩率最高）：完整的deflate压缩
+		WsCompressionLevel int `yaml:"ws_compression_level"`
+		// MaxChatRounds 每个会话最多的对话轮次，最后一轮回复后告知用户并关闭会话，可用于免费额度等场景，0为不限制，客户端只能在此基础上调低
+		MaxChatRounds int `yaml:"max_chat_rounds"`
+		// MaxChatRoundsReply 对话轮次用完时告知用户的回复，为空则使用默认回复
+		MaxChatRoundsReply string `yaml:"max_chat_rounds_reply"`
+		// AgentErrorReply 对话出错且本轮尚未回复任何内容时告知用户的回复，k: 语音合成的语种，如 zh、en，
+		// 未配置当前语种时使用内置的回复，避免用户只等到沉默
+		AgentErrorReply map[string]string `yaml:"agent_error_reply"`
+		// MaxReplyChars 每轮回复最多的字数，超出时在句子边界截断后再合成语音，以控制TTS成本，0为不限制，客户端只能在此基础上调低
+		MaxReplyChars int `yaml:"max_reply_chars"`
+		// MaxReplyCharsSuffix 回复被截断时追加的续说提示，k: 语音合成的语种，如 zh、en，未配置当前语种时使用内置的提示
+		MaxReplyCharsSuffix map[string]string `yaml:"max_reply_chars_suffix"`
+		// TtsChunkMs 将TTS音频重新切分为该时长的分片后下发，使播放更平稳，仅对pcm格式生效，单位毫秒，0为不切分
+		TtsChunkMs int `yaml:"tts_chunk_ms"`
+		// TtsChunkBytes 将TTS音频重新切分为该字节数的分片后下发，用于mp3等压缩格式，pcm格式同时配置了 TtsChunkMs 时以时长为准，0为不切分
+		TtsChunkBytes int `yaml:"tts_chunk_bytes"`
+		// ClientAudioQueueSize 每个会话排队等待发送给ASR的客户端音频帧数，默认100
+		ClientAudioQueueSize int `yaml:"client_audio_queue_size"`
+		// ClientTextQueueSize 每个会话排队等待处理的客户端文本消息数，默认100
+		ClientTextQueueSize int `yaml:"client_text_queue_size"`
+		// ClientQueuePolicy 客户端消息队列已满时的处理方式，block：等待队列腾出空间，期间暂停读取客户端消息（默认），drop_oldest：丢弃最早的一条消息
+		ClientQueuePolicy string `yaml:"client_queue_policy"`
+		// AuthTokens 建立连接时认证客户端的静态令牌，k: 令牌，v: 令牌对应的用户标识，会传递给工具以限定操作范围；
+		// 客户端通过 Authorization: Bearer 请求头或 token 查询参数携带令牌，为空则不认证
+		AuthTokens map[string]string `yaml:"auth_tokens"`
+		// AdminToken 管理接口（/admin）的令牌，通过 Authorization: Bearer 请求头携带，为空则不开放管理接口
+		AdminToken string `yaml:"admin_token"`
+		// InterruptCooldown 语音打断的冷却时间，距上一次打断不足该时间时，新的识别结果不再打断对话，
+		// 避免嘈杂环境下连续的识别结果反复中止对话，用户持续说话时冷却结束后仍会打断，0为不限制
+		InterruptCooldown time.Duration `yaml:"interrupt_cooldown"`
+		// CloseDrainTimeout 正常结束会话（如用户说再见、对话轮次用完）前，等待最后的回复合成并下发的最长时间，避免告别语被截断，默认10s
+		CloseDrainTimeout time.Duration `yaml:"close_drain_timeout"`
+		// RecordDir 录制会话的目录，每个会话的收发消息按 <会话ID>.jsonl 写入，文本消息中的敏感字段脱敏后写入，用于复现问题，为空则不录制
+		// 录制包含用户的音频与对话内容，仅应在排查问题时临时开启
+		RecordDir string `yaml:"record_dir"`
 	} `yaml:"server"`
+	Log struct {
+		Encoding string `yaml:"encoding"` // 日志输出格式，console 或 json，为空则服务端默认json，命令行默认console
+	} `yaml:"log"`
+	AssistantName  string               `yaml:"assistant_name"` // 助手名称，用于提示词中的自我介绍及日志，为空则使用默认名称Crow
 	SelectedModule map[string]string    `yaml:"selected_module"`
+	AllowedModule  map[string][]string  `yaml:"allowed_module"` // 客户端可在hello中自行选择的模块，未配置则只能使用selected_module
 	Asr            map[string]AsrConfig `yaml:"asr"`
 	LLM            map[string]LLMConfig `yaml:"llm"`
 	Tts            map[string]TtsConfig `yaml:"tts"`
+	Tools          ToolsConfig          `yaml:"tools"`
+	WakeWord       WakeWordConfig       `yaml:"wake_word"`
+	Endpoint       EndpointConfig       `yaml:"endpoint"`
+	Language       LanguageConfig       `yaml:"language"`
+	Memory         MemoryConfig         `yaml:"memory"`
+	Proxy          ProxyConfig          `yaml:"proxy"`
+	Agent          AgentConfig          `yaml:"agent"`
 	CMDExit        []string             `yaml:"cmd_exit"`
+	CMDRepeat      []string             `yaml:"cmd_repeat"` // 重复上一次回复的指令，命中时不询问模型，直接重新下发并合成上一次的回复
+}
+
+// ToolsConfig 提供给大模型的工具集，包括内置工具与MCP工具
+type ToolsConfig struct {
+	// Enabled 仅启用列出的工具，为空则启用全部工具
+	Enabled []string `yaml:"enabled"`
+	// Disabled 禁用列出的工具，优先级高于 Enabled，"*" 表示禁用全部工具，即纯对话模式
+	Disabled []string `yaml:"disabled"`
+	// MaxOutputBytes 工具输出的最大字节数，超出则截断后再写入记忆，二进制或base64数据直接丢弃，0为默认值64KB，负数为不限制
+	MaxOutputBytes int `yaml:"max_output_bytes"`
+	// OutputLimits 按工具名称单独设置的最大字节数，优先级高于 MaxOutputBytes，0或负数为不限制
+	OutputLimits map[string]int `yaml:"output_limits"`
+	// SchemaCacheTTL MCP工具定义在进程内的缓存时间，缓存有效时新会话无需连接MCP服务器即可获得工具，首次调用工具时才建立连接，默认10m，负数为不缓存
+	SchemaCacheTTL time.Duration `yaml:"schema_cache_ttl"`
+	// RedactKeys 下发 tool_call 事件时需要脱敏的参数名，参数名包含任意一项（忽略大小写）即替换为***，为空则使用内置的 password、token、secret 等
+	RedactKeys []string `yaml:"redact_keys"`
+	// SharedConnections 所有会话共用同一组 MCP 服务器连接，避免每个会话各自建立连接（stdio 服务器即各自启动进程），
+	// 工具集在服务启动后首个会话创建时确定，此后 mcp_server_settings.json 的变更需重启服务才能生效
+	SharedConnections bool `yaml:"shared_connections"`
+}
+
+// WakeWordConfig 唤醒词配置，开启后检测到唤醒词才会识别用户的语音，直至本轮说话结束，适用于常开的智能音箱类设备
+type WakeWordConfig struct {
+	Enabled bool     `yaml:"enabled"` // 是否默认开启，客户端可在hello中覆盖
+	Words   []string `yaml:"words"`   // 唤醒词，命中任意一个即唤醒，忽略标点与大小写
+}
+
+// EndpointConfig 端点检测配置，即判断用户何时说完一句话
+type EndpointConfig struct {
+	// Mode 端点检测策略，cloud：交由服务商的云端VAD判定（默认），stable：识别结果保持不变一段时间即判定说完，云端VAD仍然生效，先到者结束本句
+	Mode string `yaml:"mode"`
+	// StableTimeout stable 策略下识别结果保持不变的时长，默认800ms
+	StableTimeout time.Duration `yaml:"stable_timeout"`
+	// SentenceEndTimeout stable 策略下识别结果以句末标点结尾时保持不变的时长，默认300ms
+	SentenceEndTimeout time.Duration `yaml:"sentence_end_timeout"`
+}
+
+// LanguageConfig 根据ASR识别出的语种切换TTS的语种与发音人，需ASR服务商返回语种
+type LanguageConfig struct {
+	// Mode 切换方式，off：不切换（默认），follow：跟随用户的语种切换，lock：切换到首个稳定识别出的语种后，整个会话不再切换
+	Mode string `yaml:"mode"`
+	// SwitchAfter 连续识别为同一语种的语句数达到该值才切换，避免短句或噪声误识别导致发音人来回切换，默认2
+	SwitchAfter int `yaml:"switch_after"`
+	// Speakers 各语种使用的发音人，k: 语种，如 zh、en，未配置的语种仅切换语种、不切换发音人
+	Speakers map[string]string `yaml:"speakers"`
+}
+
+// ProxyConfig 访问各服务商时使用的代理，均为空时使用 HTTP_PROXY、HTTPS_PROXY、NO_PROXY 环境变量
+// 各服务商可通过自身的 proxy 配置单独指定代理地址，或配置为 direct 不使用代理
+type ProxyConfig struct {
+	HTTPURL string `yaml:"http_url"` // 代理地址，支持 http、https 及 socks5，如 http://10.0.0.1:3128，为空则使用环境变量
+	NoProxy string `yaml:"no_proxy"` // 不经过代理的主机，逗号分隔，格式同 NO_PROXY 环境变量，为空则使用环境变量
+}
+
+// MemoryConfig 会话记忆配置
+type MemoryConfig struct {
+	// Backend 存储方式，memory：进程内（默认），redis：持久化到Redis，多实例部署时客户端重连到任意实例均可恢复上下文
+	Backend     string        `yaml:"backend"`
+	MaxMessages int           `yaml:"max_messages"` // 最大消息数，默认20
+	TTL         time.Duration `yaml:"ttl"`          // redis 记忆的保留时间，默认30m
+	Redis       struct {
+		Addr     string `yaml:"addr"`
+		Password string `yaml:"password"`
+		DB       int    `yaml:"db"`
+	} `yaml:"redis"`
+}
+
+// AgentConfig 智能体配置
+type AgentConfig struct {
+	// Examples 少样本示例，会话开始时提供给模型，用于统一回复的语气与格式，不会因记忆的消息数上限而被淘汰
+	Examples []ExampleConfig `yaml:"examples"`
+	// TerminateConfirm 结束前的确认问题，如“请问还有什么可以帮您？”，不为空时模型成功结束交互后先询问用户，
+	// 用户确认没有其他需要或超时未回复后才真正结束会话，适用于引导式的流程，为空则不开启
+	TerminateConfirm string `yaml:"terminate_confirm"`
+	// TerminateConfirmTimeout 等待用户回复确认问题的最长时间，默认30s
+	TerminateConfirmTimeout time.Duration `yaml:"terminate_confirm_timeout"`
+	// SkipFirstStepPrompt 每轮对话的第一步不追加下一步骤提示，闲聊更自然且节省token，调用过工具后的步骤仍会追加
+	SkipFirstStepPrompt bool `yaml:"skip_first_step_prompt"`
+	// InjectDateTime 每轮对话在系统提示词前加入当前日期时间，时区为 server.timezone，时间相关的问题无需再调用 current_time 工具，
+	// 但系统提示词每轮都会变化，无法命中服务商的提示词缓存
+	InjectDateTime bool `yaml:"inject_date_time"`
+	// DisableContentSanitizer 不过滤回复内容中模型误输出的工具调用JSON及标记，模型不会误输出时可关闭以免误伤正常内容
+	DisableContentSanitizer bool `yaml:"disable_content_sanitizer"`
+	// MaxRunDuration 每轮对话的最长处理时间，超过则中止模型请求及工具调用，以已输出的内容作为答复，0为不限制
+	MaxRunDuration time.Duration `yaml:"max_run_duration"`
+	// MaxUserContextChars 客户端在hello中携带的用户信息的最大字数，超出则拒绝建立会话，默认2000
+	MaxUserContextChars int `yaml:"max_user_context_chars"`
+	// ToolLLM 决定是否及如何调用工具的步骤使用的大模型，对应 llm 下的名称，执行工具后组织答复的步骤仍使用会话的大模型，
+	// 可用低成本的模型降低工具调用的开销，无需调用工具的闲聊同样由该模型答复，为空则所有步骤使用会话的大模型
+	ToolLLM string `yaml:"tool_llm"`
+	// ToolFailureSteps 连续多少个步骤调用的工具全部失败（如MCP服务器均不可用）后不再询问模型，直接以 ToolFailureReply 答复，
+	// 避免模型反复重试直至达到最大步骤，0为不开启
+	ToolFailureSteps int `yaml:"tool_failure_steps"`
+	// ToolFailureReply 工具全部失败时的答复，为空则使用默认答复
+	ToolFailureReply string `yaml:"tool_failure_reply"`
+}
+
+// ExampleConfig 一组少样本示例，即一问一答
+type ExampleConfig struct {
+	User      string `yaml:"user"`
+	Assistant string `yaml:"assistant"`
 }
 
 type AsrConfig struct {
 	ApiKey      string `yaml:"api_key"`      // paraformer 需要
 	AppID       string `yaml:"app_id"`       // doubao 需要
 	AccessToken string `yaml:"access_token"` // doubao 需要
+	ResourceID  string `yaml:"resource_id"`  // doubao 可选，计费版本对应的资源ID，duration（小时版，默认）或 concurrent（并发版），也可填写完整的资源ID
+	Heartbeat   bool   `yaml:"heartbeat"`    // doubao 可选，用户长时间停顿时发送静音帧保活，避免连接被服务端断开
+	DisableGzip bool   `yaml:"disable_gzip"` // doubao 可选，上传音频时不进行gzip压缩，高并发场景下可节省CPU
+	// MaxUtteranceMs 单句语音的最大时长，超过后强制结束该句识别并开始对话，0为不限制，单位毫秒
+	MaxUtteranceMs int `yaml:"max_utterance_ms"`
+	// MaxIdleTimeoutMs 客户端可设置的空闲超时（asr_params.idle_timeout_ms）的上限，默认120000，单位毫秒
+	MaxIdleTimeoutMs int `yaml:"max_idle_timeout_ms"`
+	// Endpoint 服务商的 WebSocket 地址，为空则使用默认地址，可指向私有化部署或本地的回放服务
+	// whisper 为 OpenAI 兼容接口的 base URL，如 https://api.openai.com/v1，可指向自部署的 faster-whisper 服务
+	Endpoint string `yaml:"endpoint"`
+	// Model 转写使用的模型，whisper 可选，默认 whisper-1
+	Model string `yaml:"model"`
+	// MaxConcurrency 所有会话同时建立的最大连接数，应低于服务商的并发配额，0为不限制
+	MaxConcurrency int `yaml:"max_concurrency"`
+	// ConcurrencyTimeout 连接数已满时的最长等待时间，超时则本次识别失败，默认3s
+	ConcurrencyTimeout time.Duration `yaml:"concurrency_timeout"`
+	// MaxRetries 建立连接的最大尝试次数，失败后以递增的间隔重试，均失败时告知客户端服务暂时不可用，默认2次
+	MaxRetries int `yaml:"max_retries"`
+	// Proxy 单独为该服务商指定的代理地址，direct 为不使用代理，为空则使用全局的 proxy 配置
+	Proxy string `yaml:"proxy"`
+	// DefaultLanguage 客户端未指定语种时使用的语种，为空则使用服务商的默认值
+	DefaultLanguage string `yaml:"default_language"`
+	// DefaultAccent 客户端未指定方言时使用的方言，为空则使用服务商的默认值
+	DefaultAccent string `yaml:"default_accent"`
 }
 
 type LLMConfig struct {
 	Model   string `yaml:"model"`
 	APIKey  string `yaml:"api_key"`
 	BaseURL string `yaml:"base_url"`
+	// StreamIdleTimeout 流式响应中相邻两个分片的最长间隔（含首个分片），超时则中止生成并以已输出的内容加提示结束本轮对话，默认20s，负数为不限制
+	StreamIdleTimeout time.Duration `yaml:"stream_idle_timeout"`
+	// Stop 停止序列，回复中出现任意一个时立即结束生成，如模型开始输出不需要的段落时的分隔符，最多4个会传递给服务商，其余仅在本地检查
+	Stop []string `yaml:"stop"`
+	// ReasoningEffort 推理模型的推理强度：low、medium、high，为空则使用服务商默认值，客户端只能在此基础上调低
+	ReasoningEffort string `yaml:"reasoning_effort"`
+	// MaxReasoningTokens 推理过程最多消耗的token数（服务商需支持 thinking_budget 参数），0为不限制
+	MaxReasoningTokens int `yaml:"max_reasoning_tokens"`
+	// MaxCompletionTokens 单次回复最多生成的token数（含推理过程），0为不传递该参数，使用服务商的默认值
+	MaxCompletionTokens int `yaml:"max_completion_tokens"`
+	// Headers 每次请求附加的请求头，如网关要求的租户标识、路由键，与内置的请求头同名时覆盖内置值
+	Headers map[string]string `yaml:"headers"`
+	// TLS 连接模型服务的TLS设置，用于使用内部CA证书的私有化网关
+	TLS struct {
+		// InsecureSkipVerify 跳过证书校验，存在中间人攻击的风险，需显式开启，仅用于测试环境
+		InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+		// CAFile 额外信任的CA证书文件（PEM格式），在系统证书的基础上追加
+		CAFile string `yaml:"ca_file"`
+	} `yaml:"tls"`
+	// Proxy 单独为该模型服务指定的代理地址，direct 为不使用代理，为空则使用全局的 proxy 配置
+	Proxy string `yaml:"proxy"`
 }
 
 type TtsConfig struct {
@@ -43,6 +253,30 @@ type TtsConfig struct {
 	Token      string `yaml:"token"`       // doubao 需要
 	Cluster    string `yaml:"cluster"`     // doubao 需要
 	ResourceID string `yaml:"resource_id"` // doubao 需要
+	// MaxResumeRetries cosy-voice 可选，合成过程中连接中断时的最大续传次数，续传时仅重新合成尚未合成完毕的句子，0为不续传
+	MaxResumeRetries int `yaml:"max_resume_retries"`
+	// Endpoint 服务商的 WebSocket 地址，为空则使用默认地址，可指向私有化部署或本地的回放服务
+	Endpoint string `yaml:"endpoint"`
+	// MaxConcurrency 所有会话同时建立的最大连接数，应低于服务商的并发配额，0为不限制
+	MaxConcurrency int `yaml:"max_concurrency"`
+	// ConcurrencyTimeout 连接数已满时的最长等待时间，超时则本次合成失败，默认3s
+	ConcurrencyTimeout time.Duration `yaml:"concurrency_timeout"`
+	// MaxRetries 建立连接的最大尝试次数，失败后以递增的间隔重试，均失败时告知客户端服务暂时不可用，默认2次
+	MaxRetries int `yaml:"max_retries"`
+	// PersistentSession cosy-voice、doubao_stream 可选，每轮合成结束后保留连接，下一轮直接在该连接上开始新的任务，
+	// 省去每轮重新建连的耗时，连接空闲超过 SessionIdleTimeout 或会话结束时才关闭
+	PersistentSession bool `yaml:"persistent_session"`
+	// SessionIdleTimeout 开启 PersistentSession 时，连接在两轮合成之间的最长空闲时间，默认60s
+	SessionIdleTimeout time.Duration `yaml:"session_idle_timeout"`
+	// Proxy 单独为该服务商指定的代理地址，direct 为不使用代理，为空则使用全局的 proxy 配置
+	Proxy string `yaml:"proxy"`
+	// DefaultSpeaker 客户端未指定发音人时使用的发音人，为空则使用服务商的默认值
+	DefaultSpeaker string `yaml:"default_speaker"`
+	// DefaultLanguage 客户端未指定语种时使用的语种，为空则使用服务商的默认值
+	DefaultLanguage string `yaml:"default_language"`
+	// Operation doubao 可选，submit 为流式合成（默认），query 为非流式合成，每句话通过 HTTP 一次性返回完整音频，
+	// 适用于问候语等较短的固定话术，延迟更低
+	Operation string `yaml:"operation"`
 }
 
 var (
@@ -64,7 +298,17 @@ func NewConfig() *Config {
 		}
 
 		config = newConfig(filePath)
+		applyTimezone(config.Server.Timezone)
 	})
+	return Snapshot()
+}
+
+// Snapshot 获取当前配置的快照
+// 配置文件变更后会重新加载为新的 Config 对象，不会修改已有对象，因此快照在其生命周期内始终一致，调用方也不能修改快照；
+// 会话在建立时获取快照并在整个生命周期内使用，配置重载仅对之后新建立的会话生效
+func Snapshot() *Config {
+	cfgLock.RLock()
+	defer cfgLock.RUnlock()
 	return config
 }
 
@@ -136,6 +380,17 @@ func loadConfig(filename string) error {
 	if err = yaml.Unmarshal(file, &cfg); err != nil {
 		return fmt.Errorf("解析系统配置失败: %w", err)
 	}
+	if cfg.Server.Timezone != "" {
+		if _, err = time.LoadLocation(cfg.Server.Timezone); err != nil {
+			return fmt.Errorf("时区配置错误: %w", err)
+		}
+	}
+	if err = validateProxy(&cfg); err != nil {
+		return fmt.Errorf("代理配置错误: %w", err)
+	}
+	if err = netproxy.SetDefault(cfg.Proxy.HTTPURL, cfg.Proxy.NoProxy); err != nil {
+		return fmt.Errorf("代理配置错误: %w", err)
+	}
 
 	cfgLock.Lock()
 	defer cfgLock.Unlock()
@@ -143,6 +398,36 @@ func loadConfig(filename string) error {
 	return nil
 }
 
+// validateProxy 校验各服务商单独指定的代理地址
+func validateProxy(cfg *Config) error {
+	for name, c := range cfg.Asr {
+		if err := netproxy.Validate(c.Proxy); err != nil {
+			return fmt.Errorf("asr %s: %w", name, err)
+		}
+	}
+	for name, c := range cfg.LLM {
+		if err := netproxy.Validate(c.Proxy); err != nil {
+			return fmt.Errorf("llm %s: %w", name, err)
+		}
+	}
+	for name, c := range cfg.Tts {
+		if err := netproxy.Validate(c.Proxy); err != nil {
+			return fmt.Errorf("tts %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// applyTimezone 将配置的时区设置为进程的本地时区，仅在首次加载配置时调用，避免运行期间修改 time.Local
+func applyTimezone(tz string) {
+	if tz == "" {
+		return
+	}
+	// 加载配置时已校验
+	loc, _ := time.LoadLocation(tz)
+	time.Local = loc
+}
+
 func printConfig() {
 	cfgLock.RLock()
 	defer cfgLock.RUnlock()
@@ -151,16 +436,99 @@ func printConfig() {
 	fmt.Printf("• 服务器模式: %s\n", config.Server.Mode)
 	fmt.Printf("• 服务器IP: %s\n", config.Server.IP)
 	fmt.Printf("• 服务器端口: %s\n", config.Server.Port)
+	fmt.Printf("• 会话空闲超时: %v\n", config.Server.IdleTimeout)
+	fmt.Printf("• 预建立连接: %v\n", config.Server.Warmup)
+	if config.Server.Timezone != "" {
+		fmt.Printf("• 时区: %s\n", config.Server.Timezone)
+	}
+	if config.Server.WsCompression {
+		fmt.Printf("• WebSocket压缩: 开启，级别: %d\n", config.Server.WsCompressionLevel)
+	}
+	if config.Server.MaxChatRounds > 0 {
+		fmt.Printf("• 会话最大对话轮次: %d\n", config.Server.MaxChatRounds)
+	}
+	if config.Server.MaxReplyChars > 0 {
+		fmt.Printf("• 每轮回复最多字数: %d\n", config.Server.MaxReplyChars)
+	}
+	if config.Server.TtsChunkMs > 0 || config.Server.TtsChunkBytes > 0 {
+		fmt.Printf("• TTS音频分片: %dms，%d字节\n", config.Server.TtsChunkMs, config.Server.TtsChunkBytes)
+	}
+	if config.Server.ClientAudioQueueSize > 0 || config.Server.ClientTextQueueSize > 0 || config.Server.ClientQueuePolicy != "" {
+		fmt.Printf("• 客户端消息队列: 音频%d，文本%d，已满时: %s\n", config.Server.ClientAudioQueueSize, config.Server.ClientTextQueueSize, config.Server.ClientQueuePolicy)
+	}
+	if len(config.Server.AuthTokens) > 0 {
+		fmt.Printf("• 连接认证: 静态令牌，共%d个\n", len(config.Server.AuthTokens))
+	}
+	if config.Server.AdminToken != "" {
+		fmt.Println("• 管理接口: 开启")
+	}
+	if config.Server.InterruptCooldown > 0 {
+		fmt.Printf("• 语音打断冷却: %v\n", config.Server.InterruptCooldown)
+	}
+	if config.Server.CloseDrainTimeout > 0 {
+		fmt.Printf("• 关闭前等待回复播报完毕: %v\n", config.Server.CloseDrainTimeout)
+	}
+	if config.Server.RecordDir != "" {
+		fmt.Printf("• 会话录制: %s\n", config.Server.RecordDir)
+	}
+	if config.Server.AsrFallback != "" {
+		fmt.Printf("• 语音识别降级: %s\n", config.Server.AsrFallback)
+	}
+	if config.Server.AudioFormatCheck != "" {
+		fmt.Printf("• 音频格式校验: %s\n", config.Server.AudioFormatCheck)
+	}
+	if config.Proxy.HTTPURL != "" || config.Proxy.NoProxy != "" {
+		fmt.Printf("• 代理: %s，不经过代理: %s\n", config.Proxy.HTTPURL, config.Proxy.NoProxy)
+	}
+	if config.AssistantName != "" {
+		fmt.Printf("• 助手名称: %s\n", config.AssistantName)
+	}
+	if config.Log.Encoding != "" {
+		fmt.Printf("• 日志格式: %s\n", config.Log.Encoding)
+	}
 	fmt.Println("• 已选择的模块:")
 	for module, provider := range config.SelectedModule {
 		fmt.Printf("  - %s: %s\n", module, provider)
 	}
+	if len(config.AllowedModule) > 0 {
+		fmt.Println("• 允许客户端选择的模块:")
+		for module, providers := range config.AllowedModule {
+			fmt.Printf("  - %s: %v\n", module, providers)
+		}
+	}
 	fmt.Println("• ASR配置:")
 	for name, cfg := range config.Asr {
 		fmt.Printf("  - %s:\n", name)
 		fmt.Printf("    api_key: %s\n", cfg.ApiKey)
 		fmt.Printf("    app_id: %s\n", cfg.AppID)
 		fmt.Printf("    access_token: %s\n", cfg.AccessToken)
+		if cfg.ResourceID != "" {
+			fmt.Printf("    resource_id: %s\n", cfg.ResourceID)
+		}
+		fmt.Printf("    heartbeat: %v\n", cfg.Heartbeat)
+		fmt.Printf("    disable_gzip: %v\n", cfg.DisableGzip)
+		fmt.Printf("    max_utterance_ms: %d\n", cfg.MaxUtteranceMs)
+		if cfg.MaxIdleTimeoutMs > 0 {
+			fmt.Printf("    max_idle_timeout_ms: %d\n", cfg.MaxIdleTimeoutMs)
+		}
+		if cfg.Endpoint != "" {
+			fmt.Printf("    endpoint: %s\n", cfg.Endpoint)
+		}
+		if cfg.Model != "" {
+			fmt.Printf("    model: %s\n", cfg.Model)
+		}
+		if cfg.MaxConcurrency > 0 {
+			fmt.Printf("    max_concurrency: %d\n", cfg.MaxConcurrency)
+		}
+		if cfg.MaxRetries > 0 {
+			fmt.Printf("    max_retries: %d\n", cfg.MaxRetries)
+		}
+		if cfg.Proxy != "" {
+			fmt.Printf("    proxy: %s\n", cfg.Proxy)
+		}
+		if cfg.DefaultLanguage != "" || cfg.DefaultAccent != "" {
+			fmt.Printf("    default_language: %s, default_accent: %s\n", cfg.DefaultLanguage, cfg.DefaultAccent)
+		}
 	}
 	fmt.Println("• LLM配置:")
 	for name, cfg := range config.LLM {
@@ -168,6 +536,99 @@ func printConfig() {
 		fmt.Printf("    model: %s\n", cfg.Model)
 		fmt.Printf("    api_key: %s\n", cfg.APIKey)
 		fmt.Printf("    base_url: %s\n", cfg.BaseURL)
+		if cfg.StreamIdleTimeout != 0 {
+			fmt.Printf("    stream_idle_timeout: %v\n", cfg.StreamIdleTimeout)
+		}
+		if len(cfg.Stop) > 0 {
+			fmt.Printf("    stop: %q\n", cfg.Stop)
+		}
+		if cfg.ReasoningEffort != "" {
+			fmt.Printf("    reasoning_effort: %s\n", cfg.ReasoningEffort)
+		}
+		if cfg.MaxReasoningTokens > 0 {
+			fmt.Printf("    max_reasoning_tokens: %d\n", cfg.MaxReasoningTokens)
+		}
+		if cfg.MaxCompletionTokens > 0 {
+			fmt.Printf("    max_completion_tokens: %d\n", cfg.MaxCompletionTokens)
+		}
+		if len(cfg.Headers) > 0 {
+			// 请求头的值可能是凭证，只输出名称
+			fmt.Printf("    headers: %v\n", slices.Sorted(maps.Keys(cfg.Headers)))
+		}
+		if cfg.TLS.InsecureSkipVerify || cfg.TLS.CAFile != "" {
+			fmt.Printf("    tls: insecure_skip_verify: %v, ca_file: %s\n", cfg.TLS.InsecureSkipVerify, cfg.TLS.CAFile)
+		}
+		if cfg.Proxy != "" {
+			fmt.Printf("    proxy: %s\n", cfg.Proxy)
+		}
+	}
+	if len(config.Agent.Examples) > 0 {
+		fmt.Printf("• 少样本示例: %d组\n", len(config.Agent.Examples))
+	}
+	if config.Agent.TerminateConfirm != "" {
+		fmt.Printf("• 结束前确认: %s，超时: %v\n", config.Agent.TerminateConfirm, config.Agent.TerminateConfirmTimeout)
+	}
+	if config.Agent.SkipFirstStepPrompt {
+		fmt.Println("• 首步不追加下一步骤提示: true")
+	}
+	if config.Agent.DisableContentSanitizer {
+		fmt.Println("• 不过滤回复中的工具调用标记: true")
+	}
+	if config.Agent.MaxUserContextChars > 0 {
+		fmt.Printf("• 用户信息最大字数: %d\n", config.Agent.MaxUserContextChars)
+	}
+	if config.Agent.ToolLLM != "" {
+		fmt.Printf("• 工具调用大模型: %s\n", config.Agent.ToolLLM)
+	}
+	if config.Agent.ToolFailureSteps > 0 {
+		fmt.Printf("• 工具全部失败后直接答复的连续步骤数: %d\n", config.Agent.ToolFailureSteps)
+	}
+	if config.Agent.MaxRunDuration > 0 {
+		fmt.Printf("• 每轮对话最长处理时间: %v\n", config.Agent.MaxRunDuration)
+	}
+	if len(config.Tools.Enabled) > 0 || len(config.Tools.Disabled) > 0 || config.Tools.MaxOutputBytes != 0 || len(config.Tools.OutputLimits) > 0 ||
+		config.Tools.SchemaCacheTTL != 0 || len(config.Tools.RedactKeys) > 0 || config.Tools.SharedConnections {
+		fmt.Println("• 工具配置:")
+		fmt.Printf("  - enabled: %v\n", config.Tools.Enabled)
+		fmt.Printf("  - disabled: %v\n", config.Tools.Disabled)
+		fmt.Printf("  - max_output_bytes: %d\n", config.Tools.MaxOutputBytes)
+		if len(config.Tools.OutputLimits) > 0 {
+			fmt.Printf("  - output_limits: %v\n", config.Tools.OutputLimits)
+		}
+		if config.Tools.SchemaCacheTTL != 0 {
+			fmt.Printf("  - schema_cache_ttl: %v\n", config.Tools.SchemaCacheTTL)
+		}
+		if len(config.Tools.RedactKeys) > 0 {
+			fmt.Printf("  - redact_keys: %v\n", config.Tools.RedactKeys)
+		}
+		if config.Tools.SharedConnections {
+			fmt.Println("  - shared_connections: true")
+		}
+	}
+	if config.Language.Mode != "" && config.Language.Mode != "off" {
+		fmt.Printf("• 语种切换: %s，连续%d句后切换，发音人: %v\n", config.Language.Mode, config.Language.SwitchAfter, config.Language.Speakers)
+	}
+	if config.WakeWord.Enabled || len(config.WakeWord.Words) > 0 {
+		fmt.Println("• 唤醒词配置:")
+		fmt.Printf("  - enabled: %v\n", config.WakeWord.Enabled)
+		fmt.Printf("  - words: %v\n", config.WakeWord.Words)
+	}
+	if config.Endpoint.Mode != "" {
+		fmt.Println("• 端点检测配置:")
+		fmt.Printf("  - mode: %s\n", config.Endpoint.Mode)
+		if config.Endpoint.Mode == "stable" {
+			fmt.Printf("  - stable_timeout: %v\n", config.Endpoint.StableTimeout)
+			fmt.Printf("  - sentence_end_timeout: %v\n", config.Endpoint.SentenceEndTimeout)
+		}
+	}
+	if config.Memory.Backend != "" {
+		fmt.Println("• 会话记忆配置:")
+		fmt.Printf("  - backend: %s\n", config.Memory.Backend)
+		fmt.Printf("  - max_messages: %d\n", config.Memory.MaxMessages)
+		if config.Memory.Backend == "redis" {
+			fmt.Printf("  - ttl: %v\n", config.Memory.TTL)
+			fmt.Printf("  - redis: %s/%d\n", config.Memory.Redis.Addr, config.Memory.Redis.DB)
+		}
 	}
 	fmt.Println("• TTS配置:")
 	for name, cfg := range config.Tts {
@@ -176,5 +637,29 @@ func printConfig() {
 		fmt.Printf("    app_id: %s\n", cfg.AppID)
 		fmt.Printf("    token: %s\n", cfg.Token)
 		fmt.Printf("    cluster: %s\n", cfg.Cluster)
+		if cfg.MaxResumeRetries > 0 {
+			fmt.Printf("    max_resume_retries: %d\n", cfg.MaxResumeRetries)
+		}
+		if cfg.Endpoint != "" {
+			fmt.Printf("    endpoint: %s\n", cfg.Endpoint)
+		}
+		if cfg.MaxConcurrency > 0 {
+			fmt.Printf("    max_concurrency: %d\n", cfg.MaxConcurrency)
+		}
+		if cfg.MaxRetries > 0 {
+			fmt.Printf("    max_retries: %d\n", cfg.MaxRetries)
+		}
+		if cfg.Proxy != "" {
+			fmt.Printf("    proxy: %s\n", cfg.Proxy)
+		}
+		if cfg.PersistentSession {
+			fmt.Printf("    persistent_session: true, session_idle_timeout: %v\n", cfg.SessionIdleTimeout)
+		}
+		if cfg.DefaultSpeaker != "" || cfg.DefaultLanguage != "" {
+			fmt.Printf("    default_speaker: %s, default_language: %s\n", cfg.DefaultSpeaker, cfg.DefaultLanguage)
+		}
+		if cfg.Operation != "" {
+			fmt.Printf("    operation: %s\n", cfg.Operation)
+		}
 	}
 }
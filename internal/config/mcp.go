@@ -18,6 +18,16 @@ type McpServerConfig struct {
 	Args     []string `json:"args"`
 	URL      string   `json:"url,omitempty"`
 	Disabled bool     `json:"disabled,omitempty"`
+	// Instructions 服务器工具的使用说明，追加到系统提示信息中，为空则使用服务器在初始化时提供的说明
+	Instructions string `json:"instructions,omitempty"`
+	// IgnoreInstructions 不使用服务器在初始化时提供的说明，仅在 Instructions 为空时生效
+	IgnoreInstructions bool `json:"ignore_instructions,omitempty"`
+	// CallTimeoutMs 单次调用工具的超时时间，单位毫秒，0为使用默认值30s，负数为不限制
+	CallTimeoutMs int `json:"call_timeout_ms,omitempty"`
+	// Retries 工具调用超时或连接出错时的重试次数，仅对 IdempotentTools 中的工具生效
+	Retries int `json:"retries,omitempty"`
+	// IdempotentTools 可以安全重试的工具名称，如查询类工具，* 表示全部工具
+	IdempotentTools []string `json:"idempotent_tools,omitempty"`
 }
 
 type McpConfig struct {
@@ -25,36 +35,53 @@ type McpConfig struct {
 }
 
 var (
-	mcpConfig  *McpConfig
-	mcpCfgLock sync.RWMutex
-	mcpOnce    sync.Once
+	mcpConfig    *McpConfig
+	mcpConfigErr error // mcpConfigErr 首次加载配置的错误，加载失败后不再重试
+	mcpCfgLock   sync.RWMutex
+	mcpOnce      sync.Once
 )
 
 func NewMCPServerConfig() *McpConfig {
+	cfg, err := LoadMCPServerConfig()
+	if err != nil {
+		panic(err)
+	}
+	return cfg
+}
+
+// LoadMCPServerConfig 加载 MCP 服务器配置，与 NewMCPServerConfig 相同，但配置文件缺失或格式错误时返回错误而不是退出进程
+func LoadMCPServerConfig() (*McpConfig, error) {
 	mcpOnce.Do(func() {
 		// 获取mcp server配置
 		pwd, err := os.Getwd()
 		if err != nil {
-			panic(err)
+			mcpConfigErr = fmt.Errorf("failed to get working directory: %v", err)
+			return
 		}
 		filePath := filepath.Join(pwd, "config", "mcp_server_setting.json")
 		if _, err = os.Stat(filePath); os.IsNotExist(err) {
-			panic(fmt.Sprintf("config file not found: %s", filePath))
+			mcpConfigErr = fmt.Errorf("config file not found: %s", filePath)
+			return
 		}
 
-		mcpConfig = newMCPServerConfig(filePath)
+		mcpConfigErr = newMCPServerConfig(filePath)
 	})
-	return mcpConfig
+	if mcpConfigErr != nil {
+		return nil, mcpConfigErr
+	}
+	mcpCfgLock.RLock()
+	defer mcpCfgLock.RUnlock()
+	return mcpConfig, nil
 }
 
-func newMCPServerConfig(configFilePath string) *McpConfig {
+func newMCPServerConfig(configFilePath string) error {
 	// 初始加载配置
 	if err := loadMCPConfig(configFilePath); err != nil {
-		log.Fatalf("初始化配置失败: %v", err)
+		return fmt.Errorf("failed to load mcp config: %v", err)
 	}
 	printMCPConfig()
 	go watchMcpConfig(configFilePath)
-	return mcpConfig
+	return nil
 }
 
 func watchMcpConfig(filePath string) {
@@ -137,6 +164,9 @@ func printMCPConfig() {
 		if server.URL != "" {
 			fmt.Printf("  URL: %s\n", server.URL)
 		}
+		if server.CallTimeoutMs != 0 || server.Retries > 0 {
+			fmt.Printf("  调用超时: %dms，重试: %d次 %v\n", server.CallTimeoutMs, server.Retries, server.IdempotentTools)
+		}
 		fmt.Printf("  Disabled: %v\n", server.Disabled)
 	}
 }
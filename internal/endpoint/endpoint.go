@@ -0,0 +1,37 @@
+// Package endpoint 端点检测，即判断用户何时说完一句话，可在服务商的云端VAD之外根据识别结果在本地提前结束识别
+package endpoint
+
+import (
+	"time"
+
+	"crow/internal/asr"
+)
+
+// Endpointer 端点检测策略，不同的使用环境（电话、自助终端、按键说话等）可选用不同的策略
+type Endpointer interface {
+	// Name 策略的稳定标识，用于日志与监控
+	Name() string
+	// Observe 输入一次中间识别结果，返回此后多久没有新的识别结果即判定用户已说完，小于0表示不在本地判定，交由云端VAD决定
+	Observe(result Result) time.Duration
+	// Reset 重置检测状态，准备下一句，在一句识别结束时调用
+	Reset()
+}
+
+// Result 中间识别结果
+type Result struct {
+	Text  string
+	Words []asr.Word // 逐词的识别结果，仅在服务商提供时有值
+}
+
+// CloudVAD 完全交由服务商的云端VAD判定端点，即 vad_eos 等识别参数，为默认策略
+type CloudVAD struct{}
+
+func (CloudVAD) Name() string {
+	return "cloud"
+}
+
+func (CloudVAD) Observe(Result) time.Duration {
+	return -1
+}
+
+func (CloudVAD) Reset() {}
@@ -0,0 +1,62 @@
+package endpoint
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	DefaultStableTimeout      = 800 * time.Millisecond
+	DefaultSentenceEndTimeout = 300 * time.Millisecond
+)
+
+// sentenceEnds 表示一句话已结束的标点
+const sentenceEnds = "。？！.?!"
+
+// Stable 识别结果保持不变一段时间即判定用户已说完，无需等待较长的云端VAD后端点，
+// 识别结果以句末标点结尾时等待更短的时间，适合希望尽快响应的场景
+// 云端VAD仍然生效，先到者结束本句
+type Stable struct {
+	timeout            time.Duration
+	sentenceEndTimeout time.Duration
+}
+
+// NewStable 创建基于识别结果稳定性的端点检测策略，时长小于等于0时使用默认值
+// @param timeout: 识别结果保持不变的时长
+// @param sentenceEndTimeout: 识别结果以句末标点结尾时保持不变的时长
+func NewStable(timeout, sentenceEndTimeout time.Duration) *Stable {
+	if timeout <= 0 {
+		timeout = DefaultStableTimeout
+	}
+	if sentenceEndTimeout <= 0 {
+		sentenceEndTimeout = DefaultSentenceEndTimeout
+	}
+	return &Stable{timeout: timeout, sentenceEndTimeout: min(sentenceEndTimeout, timeout)}
+}
+
+func (s *Stable) Name() string {
+	return "stable"
+}
+
+func (s *Stable) Observe(result Result) time.Duration {
+	text := strings.TrimSpace(result.Text)
+	if text == "" {
+		return -1
+	}
+	if endsSentence(text, result) {
+		return s.sentenceEndTimeout
+	}
+	return s.timeout
+}
+
+func (s *Stable) Reset() {}
+
+// endsSentence 识别结果是否以句末标点结尾，优先使用逐词结果中最后一个已确定结束的词的标点
+func endsSentence(text string, result Result) bool {
+	if n := len(result.Words); n > 0 && result.Words[n-1].EndTime > 0 {
+		return result.Words[n-1].Punctuation != "" && strings.ContainsAny(result.Words[n-1].Punctuation, sentenceEnds)
+	}
+	r, _ := utf8.DecodeLastRuneInString(text)
+	return strings.ContainsRune(sentenceEnds, r)
+}
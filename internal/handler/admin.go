@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"crow/internal/auth"
+)
+
+// SessionInfo 活跃会话的概要信息，供管理接口查看
+type SessionInfo struct {
+	SessionID   string    `json:"session_id"`
+	Subject     string    `json:"subject,omitempty"` // 认证得到的用户标识，未配置认证时为空
+	StartTime   time.Time `json:"start_time"`
+	Duration    string    `json:"duration"`    // 会话已持续的时长
+	ChatRounds  int       `json:"chat_rounds"` // 已开始的对话轮次
+	AsrProvider string    `json:"asr_provider,omitempty"`
+	TtsProvider string    `json:"tts_provider,omitempty"`
+	LLMModel    string    `json:"llm_model,omitempty"`
+	AsrLanguage string    `json:"asr_language,omitempty"` // 语音识别实际使用的语种
+	TtsLanguage string    `json:"tts_language,omitempty"` // 语音合成实际使用的语种，按识别出的语种切换后随之更新
+	TtsSpeaker  string    `json:"tts_speaker,omitempty"`  // 语音合成实际使用的发音人
+}
+
+// updateInfo 更新会话的概要信息，会话的字段仅在各自的协程中读写，因此须在变更时同步一份供管理接口读取
+func (h *Handler) updateInfo(update func(info *SessionInfo)) {
+	h.infoLock.Lock()
+	defer h.infoLock.Unlock()
+	update(&h.info)
+}
+
+// setIdentity 设置建立连接时认证得到的用户身份
+func (h *Handler) setIdentity(id *auth.Identity) {
+	h.identity = id
+	if id != nil {
+		h.info.Subject = id.Subject
+	}
+}
+
+// Info 获取会话的概要信息，并发安全
+func (h *Handler) Info() SessionInfo {
+	h.infoLock.Lock()
+	info := h.info
+	h.infoLock.Unlock()
+	info.Duration = time.Since(info.StartTime).Truncate(time.Second).String()
+	return info
+}
+
+// ListSessions 列出所有活跃会话，按建立时间排序
+func (w *WebsocketServer) ListSessions(ctx *gin.Context) {
+	var sessions []SessionInfo
+	w.sessions.Range(func(key, value any) bool {
+		sessions = append(sessions, value.(*Handler).Info())
+		return true
+	})
+	slices.SortFunc(sessions, func(a, b SessionInfo) int {
+		return a.StartTime.Compare(b.StartTime)
+	})
+	ctx.JSON(http.StatusOK, gin.H{"total": len(sessions), "sessions": sessions})
+}
+
+// CloseSession 强制断开指定的会话，下发goodbye后以 CloseCodeKicked 关闭连接
+func (w *WebsocketServer) CloseSession(ctx *gin.Context) {
+	id := ctx.Param("id")
+	value, ok := w.sessions.Load(id)
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+	handler := value.(*Handler)
+	reason := strings.TrimSpace(ctx.Query("reason"))
+	if reason == "" {
+		reason = "closed by admin"
+	}
+	w.log.Infof("session %s closed by admin: %s", id, reason)
+	_ = handler.sendGoodbyeMessage(reason)
+	handler.closeWithReason(CloseCodeKicked, reason)
+	ctx.JSON(http.StatusOK, gin.H{"session_id": id})
+}
@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"slices"
+
+	"crow/internal/model"
+)
+
+// 服务端可能启用的功能，均为客户端可按需使用、旧版本服务端不一定支持的能力
+const (
+	FeatureBinaryAudio        = "binary_audio"        // 以二进制消息上传音频
+	FeatureToolEvents         = "tool_events"         // 下发 tool_call 事件
+	FeatureReasoning          = "reasoning"           // 下发推理模型的思考过程
+	FeatureWakeWord           = "wake_word"           // 唤醒词检测
+	FeatureTtsTimestamp       = "tts_timestamp"       // 下发字词时间戳，仅客户端开启且本次会话的TTS服务商支持时列出
+	FeatureConversationResume = "conversation_resume" // 断线重连时通过 conversation_id 恢复上下文
+	FeatureFullReply          = "full_reply"          // 每轮回复的最后一个 chat 消息携带完整回复
+)
+
+// clientMessageTypes 服务端可处理的客户端文本消息类型，与 handleHelloMessage、handleClientTextMessages 保持一致
+var clientMessageTypes = []string{"hello", "chat", "abort", "audio_end", "tool_choice", "set_config"}
+
+// capabilities 汇总服务端支持的功能，须在协商完各模块的配置后调用
+// @param msg: 已填充协商结果的hello响应
+func (h *Handler) capabilities(msg *model.HelloResponse) model.Capabilities {
+	features := []string{FeatureBinaryAudio, FeatureToolEvents, FeatureReasoning, FeatureWakeWord, FeatureFullReply}
+	if msg.TtsParams.EnableTimestamp {
+		features = append(features, FeatureTtsTimestamp)
+	}
+	if h.cfg.Memory.Backend == "redis" && h.identity != nil {
+		features = append(features, FeatureConversationResume)
+	}
+	return model.Capabilities{
+		MessageTypes: clientMessageTypes,
+		Features:     features,
+		AsrProviders: h.selectableModules("asr", func(name string) bool { _, ok := h.cfg.Asr[name]; return ok }),
+		TtsProviders: h.selectableModules("tts", func(name string) bool { _, ok := h.cfg.Tts[name]; return ok }),
+		LLMModels:    h.selectableModules("llm", func(name string) bool { _, ok := h.cfg.LLM[name]; return ok }),
+	}
+}
+
+// selectableModules 客户端可选择的模块，即默认模块及 allowed_module 中已配置的模块
+func (h *Handler) selectableModules(module string, configured func(name string) bool) []string {
+	var names []string
+	for _, name := range append([]string{h.cfg.SelectedModule[module]}, h.cfg.AllowedModule[module]...) {
+		if name != "" && configured(name) && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -1,44 +1,99 @@
 package handler
 
 import (
+	"compress/flate"
 	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	"crow/pkg/metrics"
 )
 
 var (
 	ErrConnectionClosed = errors.New("websocket connection is closed")
 )
 
+// 连接关闭码，客户端可据此判断断开原因，以决定是否需要重连
+const (
+	CloseCodeNormal         = websocket.CloseNormalClosure     // 1000 正常关闭，如用户主动退出，无需重连
+	CloseCodeBadRequest     = websocket.ClosePolicyViolation   // 1008 请求不合法，如 hello 消息错误，修正后再连接
+	CloseCodeServerError    = websocket.CloseInternalServerErr // 1011 服务端内部错误，可稍后重连
+	CloseCodeServerShutdown = websocket.CloseServiceRestart    // 1012 服务端重启或关闭，可稍后重连
+	CloseCodeIdleTimeout    = 4000                             // 会话空闲超时，需要时再重连
+	CloseCodeUnauthorized   = 4001                             // 认证失败，不应重连
+	CloseCodeKicked         = 4003                             // 被管理员强制断开，不应自动重连
+)
+
+// defaultCompressionLevel 默认的压缩级别，仅霍夫曼编码
+// base64音频几乎没有重复片段，标准库的 deflate 在1-6级时会直接放弃压缩，仅霍夫曼编码即可压缩约25%，且CPU开销远低于7-9级
+const defaultCompressionLevel = flate.HuffmanOnly
+
+// minCompressBytes 开启压缩时，仅压缩不小于该长度的文本消息，过短的消息压缩收益有限；二进制音频已是压缩格式或难以压缩，不进行压缩
+const minCompressBytes = 512
+
+var sentBytesCounter = metrics.NewCounterVec("crow_ws_sent_bytes_total",
+	"Payload bytes sent to clients before compression, compressed is whether permessage-deflate was applied.", "type", "compressed")
+
 type Connection interface {
 	ReadMessage() (messageType int, p []byte, err error)
 	WriteMessage(messageType int, data []byte) error
+	// Close 正常关闭连接，等同于 CloseWithReason(CloseCodeNormal, "connection closed")
 	Close() error
+	// CloseWithReason 携带关闭码和原因关闭连接
+	CloseWithReason(code int, reason string) error
 	IsClosed() bool
 }
 
 type websocketConn struct {
-	conn     *websocket.Conn
-	lock     sync.Mutex
-	isClosed int32 // 连接状态标记: 0:open, 1:closed; 使用原子操作降低开销
+	conn        *websocket.Conn
+	lock        sync.Mutex
+	isClosed    int32         // 连接状态标记: 0:open, 1:closed; 使用原子操作降低开销
+	readTimeout time.Duration // 读取超时时间
+	compress    bool          // 是否已与客户端协商压缩
 }
 
-func newWebsocketConn(w http.ResponseWriter, r *http.Request) (*websocketConn, error) {
+// newWebsocketConn 升级为 websocket 连接
+// @param compression 是否与支持的客户端协商 permessage-deflate 压缩
+// @param compressionLevel 压缩级别，取值同 compress/flate，0或非法值使用默认级别
+func newWebsocketConn(w http.ResponseWriter, r *http.Request, readTimeout time.Duration, compression bool, compressionLevel int) (*websocketConn, error) {
 	upGrader := websocket.Upgrader{
-		ReadBufferSize:  4096,
-		WriteBufferSize: 4096,
-		CheckOrigin:     func(r *http.Request) bool { return true },
+		ReadBufferSize:    4096,
+		WriteBufferSize:   4096,
+		CheckOrigin:       func(r *http.Request) bool { return true },
+		EnableCompression: compression,
 	}
 
 	conn, err := upGrader.Upgrade(w, r, nil)
 	if err != nil {
 		return nil, err
 	}
-	return &websocketConn{conn: conn, isClosed: 0}, nil
+	if readTimeout <= 0 {
+		readTimeout = time.Minute
+	}
+	wsConn := &websocketConn{conn: conn, isClosed: 0, readTimeout: readTimeout}
+	if compression && offersCompression(r) {
+		wsConn.compress = true
+		if compressionLevel == 0 || conn.SetCompressionLevel(compressionLevel) != nil {
+			_ = conn.SetCompressionLevel(defaultCompressionLevel)
+		}
+	}
+	return wsConn, nil
+}
+
+// offersCompression 客户端是否在握手时提供了 permessage-deflate 扩展，提供时服务端会接受
+func offersCompression(r *http.Request) bool {
+	for _, v := range r.Header.Values("Sec-WebSocket-Extensions") {
+		if strings.Contains(v, "permessage-deflate") {
+			return true
+		}
+	}
+	return false
 }
 
 func (w *websocketConn) ReadMessage() (messageType int, p []byte, err error) {
@@ -48,7 +103,7 @@ func (w *websocketConn) ReadMessage() (messageType int, p []byte, err error) {
 	}
 
 	// 设置读取超时
-	_ = w.conn.SetReadDeadline(time.Now().Add(time.Minute))
+	_ = w.conn.SetReadDeadline(time.Now().Add(w.readTimeout))
 
 	messageType, p, err = w.conn.ReadMessage()
 	if err != nil {
@@ -77,6 +132,12 @@ func (w *websocketConn) WriteMessage(messageType int, data []byte) error {
 	// 设置写入超时时间
 	_ = w.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
 
+	compress := w.compress && messageType == websocket.TextMessage && len(data) >= minCompressBytes
+	if w.compress {
+		w.conn.EnableWriteCompression(compress)
+	}
+	sentBytesCounter.Add(float64(len(data)), messageTypeLabel(messageType), strconv.FormatBool(compress))
+
 	err := w.conn.WriteMessage(messageType, data)
 	if err != nil {
 		// 如果读取出错，则标记为连接已关闭
@@ -88,8 +149,16 @@ func (w *websocketConn) WriteMessage(messageType int, data []byte) error {
 }
 
 func (w *websocketConn) Close() error {
+	return w.CloseWithReason(CloseCodeNormal, "connection closed")
+}
+
+func (w *websocketConn) CloseWithReason(code int, reason string) error {
 	// 原子操作避免重复关闭
 	if !atomic.CompareAndSwapInt32(&w.isClosed, 0, 1) {
+		// 读写出错时只标记了关闭状态，底层连接仍需关闭，避免残留僵尸连接
+		w.lock.Lock()
+		defer w.lock.Unlock()
+		_ = w.conn.Close()
 		return nil
 	}
 
@@ -97,7 +166,7 @@ func (w *websocketConn) Close() error {
 	defer w.lock.Unlock()
 
 	// 发送关闭帧
-	closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "connection closed")
+	closeMsg := websocket.FormatCloseMessage(code, reason)
 	_ = w.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
 	_ = w.conn.WriteMessage(websocket.CloseMessage, closeMsg)
 
@@ -107,3 +176,10 @@ func (w *websocketConn) Close() error {
 func (w *websocketConn) IsClosed() bool {
 	return atomic.LoadInt32(&w.isClosed) == 1
 }
+
+func messageTypeLabel(messageType int) string {
+	if messageType == websocket.BinaryMessage {
+		return "binary"
+	}
+	return "text"
+}
@@ -0,0 +1,46 @@
+package handler
+
+import "time"
+
+// defaultCloseDrainTimeout 未配置时正常关闭会话前等待最后的回复合成下发的最长时间
+const defaultCloseDrainTimeout = 10 * time.Second
+
+// markTtsPending 已向TTS提交本轮的文本，合成结束前正常关闭会话需等待
+func (h *Handler) markTtsPending() {
+	h.drainLock.Lock()
+	defer h.drainLock.Unlock()
+	if h.ttsDrain == nil {
+		h.ttsDrain = make(chan struct{})
+	}
+}
+
+// markTtsDrained 本轮的合成已结束并下发，或已被中止
+func (h *Handler) markTtsDrained() {
+	h.drainLock.Lock()
+	defer h.drainLock.Unlock()
+	if h.ttsDrain != nil {
+		close(h.ttsDrain)
+		h.ttsDrain = nil
+	}
+}
+
+// closeGracefully 正常结束会话，如用户说再见或对话轮次用完，等待最后的回复合成并下发后再关闭，避免用户听不完告别语
+// 最多等待 server.close_drain_timeout；中止对话、出错等情况仍直接关闭
+func (h *Handler) closeGracefully() {
+	h.drainLock.Lock()
+	drain := h.ttsDrain
+	h.drainLock.Unlock()
+	if drain != nil {
+		timeout := h.cfg.Server.CloseDrainTimeout
+		if timeout <= 0 {
+			timeout = defaultCloseDrainTimeout
+		}
+		select {
+		case <-drain:
+		case <-h.stopChan:
+		case <-time.After(timeout):
+			h.log.Warnf("tts is not drained in %v, close anyway", timeout)
+		}
+	}
+	h.close()
+}
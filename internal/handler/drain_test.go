@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"crow/internal/config"
+	"crow/internal/tts"
+)
+
+// fakeTts 测试用的TTS服务商，仅实现合成结果回调及调整配置时用到的方法
+type fakeTts struct {
+	tts.Provider
+}
+
+func (fakeTts) Reset() error                          { return nil }
+func (fakeTts) SetConfig(cfg *tts.Config) *tts.Config { return cfg }
+
+func TestCloseGracefully(t *testing.T) {
+	tests := []struct {
+		name    string
+		pending bool          // 关闭时是否有尚未合成完毕的回复
+		drainIn time.Duration // 多久后合成结束，0为不结束
+		minWait time.Duration // 至少等待多久才关闭
+	}{
+		{"nothing pending", false, 0, 0},
+		{"wait for completion", true, 100 * time.Millisecond, 100 * time.Millisecond},
+		{"drain timeout", true, 0, 200 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Server.CloseDrainTimeout = 200 * time.Millisecond
+			h, conn := newTestHandler(t, cfg, nil)
+			h.ttsProvider = fakeTts{}
+			if tt.pending {
+				h.markTtsPending()
+			}
+			if tt.drainIn > 0 {
+				// 最后一段回复合成并下发完毕
+				time.AfterFunc(tt.drainIn, func() { h.OnTtsResult(nil, tts.StateCompleted) })
+			}
+
+			start := time.Now()
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				h.closeGracefully()
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("close did not return")
+			}
+			if elapsed := time.Since(start); elapsed < tt.minWait || elapsed > tt.minWait+time.Second {
+				t.Fatalf("closed after %v, want about %v", elapsed, tt.minWait)
+			}
+			if !conn.IsClosed() {
+				t.Fatal("connection is not closed")
+			}
+		})
+	}
+}
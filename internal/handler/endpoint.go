@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"time"
+
+	"crow/internal/asr"
+	"crow/internal/endpoint"
+)
+
+// newEndpointer 按配置创建端点检测策略，未知的策略使用云端VAD
+func (h *Handler) newEndpointer() endpoint.Endpointer {
+	cfg := h.cfg.Endpoint
+	switch cfg.Mode {
+	case "", "cloud":
+		return endpoint.CloudVAD{}
+	case "stable":
+		return endpoint.NewStable(cfg.StableTimeout, cfg.SentenceEndTimeout)
+	}
+	h.log.Warnf("unknown endpoint mode %q, use cloud", cfg.Mode)
+	return endpoint.CloudVAD{}
+}
+
+// observeEndpoint 将中间识别结果交给端点检测策略，判定用户已说完时经由音频队列结束本次识别，与客户端发送 audio_end 相同
+// 仅在ASR结果回调中调用
+func (h *Handler) observeEndpoint(result string, words []asr.Word) {
+	if h.endpointer == nil {
+		return
+	}
+	h.stopEndpointTimer()
+	wait := h.endpointer.Observe(endpoint.Result{Text: result, Words: words})
+	if wait < 0 {
+		return
+	}
+	name := h.endpointer.Name()
+	h.endpointTimer = time.AfterFunc(wait, func() {
+		h.log.Infof("endpoint detected by %s, no new asr result in %v", name, wait)
+		h.enqueueAudio(nil)
+	})
+}
+
+// resetEndpoint 一句识别结束，停止等待并重置端点检测状态，仅在ASR结果回调中调用
+func (h *Handler) resetEndpoint() {
+	if h.endpointer == nil {
+		return
+	}
+	h.stopEndpointTimer()
+	h.endpointer.Reset()
+}
+
+func (h *Handler) stopEndpointTimer() {
+	if h.endpointTimer != nil {
+		h.endpointTimer.Stop()
+		h.endpointTimer = nil
+	}
+}
@@ -5,24 +5,37 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 
+	"crow/internal/agent"
+	"crow/internal/agent/llm"
 	"crow/internal/asr"
+	"crow/internal/auth"
+	"crow/internal/config"
 	"crow/internal/model"
 	"crow/internal/tts"
+	"crow/internal/wakeword"
 	errcode "crow/pkg/err-code"
 )
 
+// errInvalidHello hello 消息不合法或请求了不允许的配置，以 CloseCodeBadRequest 关闭连接，其余错误视为服务端内部错误
+var errInvalidHello = errors.New("invalid hello message")
+
 func (h *Handler) handleMessage(messageType int, message []byte) error {
 	switch messageType {
 	case websocket.TextMessage:
-		h.clientTextQueue <- string(message)
+		h.enqueueText(string(message))
 		return nil
 	case websocket.BinaryMessage:
-		if h.clientAudioQueue != nil {
-			h.clientAudioQueue <- message
+		// 音频队列中的nil用于标记客户端结束说话，不能下发空音频
+		if h.clientAudioQueue != nil && len(message) > 0 {
+			h.enqueueAudio(message)
 		}
 		return nil
 	default:
@@ -38,11 +51,31 @@ func (h *Handler) handleClientTextMessages(ctx context.Context, content string)
 	}
 	switch data.Type {
 	case "abort":
+		// 客户端主动中止时，同时丢弃ASR当前句的识别结果
+		if h.enableAsr && h.asrProvider != nil {
+			if err := h.asrProvider.Abort(); err != nil {
+				h.log.Warnf("failed to abort asr provider: %v", err)
+			}
+		}
 		return h.handleAbortChat()
+	case "audio_end":
+		// 客户端明确结束说话（如按键说话松开），经由音频队列通知ASR，保证此前收到的音频均已发送
+		if h.enableAsr && h.clientAudioQueue != nil {
+			h.enqueueAudio(nil)
+		}
+		return nil
 	case "chat":
-		// 如果有新的对话文本，则应该打断当前的对话
-		_ = h.handleAbortChat()
+		// 文本不会打断进行中的对话，而是排在其后；需要打断时客户端应先发送 abort，语音则由ASR识别到新的语句时打断
+		if data.ToolChoice != "" {
+			if err := h.setNextToolChoice(data.ToolChoice); err != nil {
+				return err
+			}
+		}
 		return h.handleChatMessage(ctx, data.ChatText)
+	case "tool_choice":
+		return h.setNextToolChoice(data.ToolChoice)
+	case "set_config":
+		return h.handleSetConfig(data)
 	default:
 		return fmt.Errorf("unsupported message type: %s", data.Type)
 	}
@@ -64,17 +97,69 @@ func (h *Handler) handleHelloMessage(ctx context.Context) error {
 	}
 	if messageType != websocket.TextMessage {
 		_ = h.sendErrorMessage(errcode.ErrInvalidDataType.Code(), errcode.ErrInvalidDataType.Msg())
-		return fmt.Errorf("unsupported message type: %d", messageType)
+		return fmt.Errorf("%w, unsupported message type: %d", errInvalidHello, messageType)
 	}
 
 	var data model.ClientTextMessage
 	if err = json.Unmarshal(message, &data); err != nil {
 		_ = h.sendErrorMessage(errcode.ErrInvalidDataType.Code(), errcode.ErrInvalidDataType.Msg())
-		return fmt.Errorf("failed to unmarshal text message: %v", err)
+		return fmt.Errorf("%w, failed to unmarshal text message: %v", errInvalidHello, err)
+	}
+
+	// 客户端可在允许的范围内为本次会话选择模块
+	if err = h.handleModuleSelection(data); err != nil {
+		_ = h.sendErrorMessage(errcode.ErrNotAllowed.Code(), errcode.ErrNotAllowed.Msg())
+		return fmt.Errorf("%w, %v", errInvalidHello, err)
+	}
+	// 所选的服务商不受支持是服务端配置的问题，而非请求不合法
+	if err = h.checkProviders(data); err != nil {
+		_ = h.sendErrorMessage(errcode.ErrInternal.Code(), errcode.ErrInternal.Msg())
+		return err
+	}
+	msg.LLMModel = h.selectedModule["llm"]
+
+	// 用户信息加入系统提示词，每轮对话都会携带，须限制长度
+	h.userContext = strings.TrimSpace(data.UserContext)
+	if n, limit := utf8.RuneCountInString(h.userContext), maxUserContextChars(h.cfg); n > limit {
+		_ = h.sendErrorMessage(errcode.ErrTooLarge.Code(), errcode.ErrTooLarge.Msg())
+		return fmt.Errorf("%w, user context is too long: %d > %d", errInvalidHello, n, limit)
+	}
+
+	// 客户端只能在服务端配置的推理强度之内调低，如交互场景使用 low 以尽快得到首个回复
+	h.reasoningEffort = llm.LimitReasoningEffort(h.cfg.LLM[msg.LLMModel].ReasoningEffort, data.LLMParams.ReasoningEffort)
+	msg.LLMParams.ReasoningEffort = h.reasoningEffort
+
+	// 记忆持久化到Redis时，客户端可携带之前的会话记忆标识以恢复上下文
+	// 会话记忆归属于认证得到的用户，未认证的连接无法证明归属，因此不能恢复
+	if h.cfg.Memory.Backend == "redis" {
+		h.conversationID = h.sessionID
+		if _, err := uuid.Parse(data.ConversationID); err == nil {
+			if h.identity != nil {
+				h.conversationID = data.ConversationID
+			} else {
+				h.log.Warnf("refuse to resume conversation %s without an authenticated identity", data.ConversationID)
+			}
+		}
+		msg.ConversationID = h.conversationID
 	}
 
 	h.enableAsr = data.EnableAsr
 	h.enableTts = data.EnableTts
+	h.toolEvents = data.EnableToolEvents
+	h.reasoning = data.EnableReasoning
+	h.fullReply = data.EnableFullReply
+	// 客户端只能在服务端配置的上限内调低对话轮次
+	h.maxChatRounds = h.cfg.Server.MaxChatRounds
+	if v := data.MaxChatRounds; v > 0 && (h.maxChatRounds <= 0 || v < h.maxChatRounds) {
+		h.maxChatRounds = v
+	}
+	msg.MaxChatRounds = h.maxChatRounds
+	// 客户端只能在服务端配置的上限内调低回复字数
+	h.maxReplyChars = h.cfg.Server.MaxReplyChars
+	if v := data.MaxReplyChars; v > 0 && (h.maxReplyChars <= 0 || v < h.maxReplyChars) {
+		h.maxReplyChars = v
+	}
+	msg.MaxReplyChars = h.maxReplyChars
 
 	if data.EnableAsr {
 		asrCfg := &asr.Config{
@@ -85,24 +170,43 @@ func (h *Handler) handleHelloMessage(ctx context.Context) error {
 			EnablePunc: data.AsrParams.EnablePunc,
 			VadEos:     data.AsrParams.VadEos,
 		}
-		if v, ok := h.cfg.SelectedModule["asr"]; ok {
+		if v, ok := h.selectedModule["asr"]; ok {
 			if cfg, ok := h.cfg.Asr[v]; ok {
-				asrCfg.ApiKey = cfg.ApiKey
-				asrCfg.AppID = cfg.AppID
-				asrCfg.AccessToken = cfg.AccessToken
+				asrCfg.AsrConfig = cfg
 			}
 		}
+		// 客户端未指定时使用服务端配置的默认值，使默认行为由配置统一决定，而非各服务商的内置值
+		if asrCfg.Language == "" {
+			asrCfg.Language = asrCfg.DefaultLanguage
+		}
+		if asrCfg.Accent == "" {
+			asrCfg.Accent = asrCfg.DefaultAccent
+		}
+		// 客户端只能在服务端配置的上限内调整单句最大时长与空闲超时
+		if v := data.AsrParams.MaxUtteranceMs; v > 0 && (asrCfg.MaxUtteranceMs <= 0 || v < asrCfg.MaxUtteranceMs) {
+			asrCfg.MaxUtteranceMs = v
+		}
+		asrCfg.IdleTimeout = clientIdleTimeout(data.AsrParams.IdleTimeoutMs, asrCfg.AsrConfig)
 		asrCfg = h.asrProvider.SetConfig(asrCfg)
+		h.asrCfg = asrCfg
+		h.asrFormat = asrCfg.Format
+
+		msg.AsrProvider = h.selectedModule["asr"]
+		msg.AsrParams = asrParams(asrCfg)
 
-		msg.AsrParams.Language = asrCfg.Language
-		msg.AsrParams.Accent = asrCfg.Accent
-		msg.AsrParams.SampleRate = asrCfg.SampleRate
-		msg.AsrParams.Format = asrCfg.Format
-		msg.AsrParams.EnablePunc = asrCfg.EnablePunc
-		msg.AsrParams.VadEos = asrCfg.VadEos
+		// 开启唤醒词检测时，检测到唤醒词后才会识别用户的语音
+		if words := h.initWakeWord(data, asrCfg); len(words) > 0 {
+			msg.WakeWord.Enable = true
+			msg.WakeWord.Words = words
+		}
+
+		// 替代的服务商（如重放录制的会话）给出的识别结果已包含端点，无需本地判定
+		if h.providers == nil {
+			h.endpointer = h.newEndpointer()
+		}
 
 		// 开启asr后，需要开始监听客户端音频消息
-		h.clientAudioQueue = make(chan []byte, 100)
+		h.clientAudioQueue = make(chan []byte, queueSize(h.cfg.Server.ClientAudioQueueSize))
 		go h.listenClientAudioMessages(ctx)
 	}
 
@@ -116,59 +220,250 @@ func (h *Handler) handleHelloMessage(ctx context.Context) error {
 			SampleRate: data.TtsParams.SampleRate,
 			Format:     data.TtsParams.Format,
 			Language:   data.TtsParams.Language,
+
+			EnableTimestamp: data.TtsParams.EnableTimestamp,
 		}
-		if v, ok := h.cfg.SelectedModule["tts"]; ok {
+		if v, ok := h.selectedModule["tts"]; ok {
 			if cfg, ok := h.cfg.Tts[v]; ok {
-				ttsCfg.ApiKey = cfg.ApiKey
-				ttsCfg.AppID = cfg.AppID
-				ttsCfg.Token = cfg.Token
-				ttsCfg.Cluster = cfg.Cluster
-				ttsCfg.ResourceID = cfg.ResourceID
+				ttsCfg.TtsConfig = cfg
 			}
 		}
+		if ttsCfg.Speaker == "" {
+			ttsCfg.Speaker = ttsCfg.DefaultSpeaker
+		}
+		if ttsCfg.Language == "" {
+			ttsCfg.Language = ttsCfg.DefaultLanguage
+		}
 		ttsCfg = h.ttsProvider.SetConfig(ttsCfg)
+		h.ttsCfg = ttsCfg
+		h.ttsPersistent = ttsCfg.PersistentSession
+		h.ttsChunker = tts.NewChunker(tts.ChunkSize(ttsCfg, h.cfg.Server.TtsChunkMs, h.cfg.Server.TtsChunkBytes))
 
-		msg.TtsParams.Speaker = ttsCfg.Speaker
-		msg.TtsParams.Speed = ttsCfg.Speed
-		msg.TtsParams.Volume = ttsCfg.Volume
-		msg.TtsParams.Pitch = ttsCfg.Pitch
-		msg.TtsParams.SampleRate = ttsCfg.SampleRate
-		msg.TtsParams.Format = ttsCfg.Format
-		msg.TtsParams.Language = ttsCfg.Language
+		msg.TtsProvider = h.selectedModule["tts"]
+		msg.TtsParams = ttsParams(ttsCfg)
 	}
+	msg.Capabilities = h.capabilities(&msg)
+
+	h.updateInfo(func(info *SessionInfo) {
+		info.AsrProvider = msg.AsrProvider
+		info.TtsProvider = msg.TtsProvider
+		info.LLMModel = msg.LLMModel
+		info.AsrLanguage = msg.AsrParams.Language
+		info.TtsLanguage = msg.TtsParams.Language
+		info.TtsSpeaker = msg.TtsParams.Speaker
+	})
 
 	// 开始监听客户端文本消息
-	h.clientTextQueue = make(chan string, 100)
+	h.clientTextQueue = make(chan string, queueSize(h.cfg.Server.ClientTextQueueSize))
 	go h.listenClientTextMessages(ctx)
-	return h.sendHelloMessage(msg)
+	if err = h.sendHelloMessage(msg); err != nil {
+		return err
+	}
+	if err = h.sendSessionStartMessage(msg.Capabilities); err != nil {
+		return err
+	}
+
+	if h.cfg.Server.Warmup {
+		go h.warmup(ctx)
+	}
+	return nil
+}
+
+// defaultMaxUserContextChars 未配置时hello中用户信息的最大字数
+const defaultMaxUserContextChars = 2000
+
+// maxUserContextChars 获取hello中用户信息的最大字数
+func maxUserContextChars(cfg *config.Config) int {
+	if cfg.Agent.MaxUserContextChars <= 0 {
+		return defaultMaxUserContextChars
+	}
+	return cfg.Agent.MaxUserContextChars
+}
+
+// handleModuleSelection 处理客户端在hello中的模块选择
+func (h *Handler) handleModuleSelection(data model.ClientTextMessage) error {
+	asrChanged, err := h.selectModule("asr", data.AsrProvider)
+	if err != nil {
+		return err
+	}
+	ttsChanged, err := h.selectModule("tts", data.TtsProvider)
+	if err != nil {
+		return err
+	}
+	llmChanged, err := h.selectModule("llm", data.LLMModel)
+	if err != nil {
+		return err
+	}
+	if _, ok := h.cfg.LLM[h.selectedModule["llm"]]; llmChanged && !ok {
+		return fmt.Errorf("llm model %q is not configured", h.selectedModule["llm"])
+	}
+	if asrChanged || ttsChanged {
+		h.initProviders()
+	}
+	return nil
+}
+
+// checkProviders 校验客户端需要的ASR、TTS服务是否可用
+func (h *Handler) checkProviders(data model.ClientTextMessage) error {
+	if data.EnableAsr && h.asrProvider == nil {
+		return fmt.Errorf("asr module %q is not supported", h.selectedModule["asr"])
+	}
+	if data.EnableTts && h.ttsProvider == nil {
+		return fmt.Errorf("tts module %q is not supported", h.selectedModule["tts"])
+	}
+	return nil
+}
+
+// initWakeWord 按服务端配置及客户端的设置初始化唤醒词检测器
+// @return 实际使用的唤醒词，为空表示未开启
+func (h *Handler) initWakeWord(data model.ClientTextMessage, asrCfg *asr.Config) []string {
+	enable := h.cfg.WakeWord.Enabled
+	if data.WakeWord.Enable != nil {
+		enable = *data.WakeWord.Enable
+	}
+	words := h.cfg.WakeWord.Words
+	if len(data.WakeWord.Words) > 0 {
+		words = data.WakeWord.Words
+	}
+	// 替代的服务商（如重放录制的会话）给出的识别结果均在唤醒之后，无需检测唤醒词
+	if !enable || h.providers != nil {
+		return nil
+	}
+	if len(words) == 0 {
+		h.log.Warn("wake word is enabled but no words are configured, ignore")
+		return nil
+	}
+
+	// 使用与对话相同的ASR服务商，但需独立的会话
+	provider := h.newAsrProvider(h.selectedModule["asr"])
+	if provider == nil {
+		return nil
+	}
+	detectorCfg := *asrCfg
+	detectorCfg.MaxUtteranceMs = 0
+	provider.SetConfig(&detectorCfg)
+	h.wakeDetector = wakeword.NewAsrDetector(h.log, provider, words)
+	return words
+}
+
+// warmup 预先建立ASR/TTS连接，避免首轮交互承担完整的握手延迟
+func (h *Handler) warmup(ctx context.Context) {
+	if h.enableAsr && h.asrProvider != nil {
+		if err := h.asrProvider.Start(ctx); err != nil {
+			h.log.Warnf("failed to warm up asr provider: %v", err)
+		}
+	}
+	if h.enableTts && h.ttsProvider != nil {
+		if err := h.ttsProvider.Start(ctx); err != nil {
+			h.log.Warnf("failed to warm up tts provider: %v", err)
+		}
+	}
 }
 
 func (h *Handler) handleAbortChat() error {
 	h.log.Infof("client abort chat")
 	atomic.StoreInt32(&h.interrupt, 1)
+	atomic.StoreInt64(&h.lastAbortTime, time.Now().UnixNano())
+	h.chatLock.Lock()
+	if h.chatCancel != nil {
+		h.chatCancel() // 取消正在进行的对话轮次
+	}
+	h.chatLock.Unlock()
 	if h.agentProvider != nil {
 		_ = h.agentProvider.Reset()
 	}
 	if h.ttsProvider != nil {
-		_ = h.ttsProvider.Reset()
+		// 仅中止当前合成，尽可能保留连接，避免下一轮对话重新建连
+		h.ttsChunker.Reset()
+		if err := h.ttsProvider.Abort(); err != nil {
+			h.log.Warnf("failed to abort tts provider: %v", err)
+		}
+		h.markTtsDrained()
 	}
 	return nil
 
 }
 
+// isChatRunning 是否有已开始且尚未结束的对话轮次
+func (h *Handler) isChatRunning() bool {
+	h.chatLock.Lock()
+	defer h.chatLock.Unlock()
+	return h.chatCancel != nil
+}
+
+// handleChatMessage 提交一轮对话
+// 对话轮次严格按到达顺序串行执行，同一时刻只有一轮对话在运行，提交不会中止进行中的对话；
+// 在下一轮对话开始前到达的多条文本，会按到达顺序合并为一轮对话
 func (h *Handler) handleChatMessage(ctx context.Context, text string) error {
 	if text == "" {
-		_ = h.handleAbortChat()
 		return errors.New("empty text message, skip")
 	}
 
-	h.chatRound++
-	h.log.Infof("start new chat round: %d", h.chatRound)
+	h.chatLock.Lock()
+	defer h.chatLock.Unlock()
 
 	if h.isExit(text) {
-		h.closeAfterChat = true           // 存在退出意图则在此次对话后关闭连接
+		// 存在退出意图则在包含该文本的对话轮次结束后关闭连接，进行中的对话照常结束
+		h.pendingExit = true
 		atomic.StoreInt32(&h.stopRecv, 1) // 不再接收客户端消息
-		h.log.Info("user request exit, abort chat")
+		h.log.Info("user request exit, close after chat")
+	}
+	h.pendingChat = append(h.pendingChat, text)
+	if len(h.pendingChat) > 1 {
+		// 已有等待开始的对话轮次，合并到该轮次中
+		h.log.Infof("merge chat text into pending round, pending count: %d", len(h.pendingChat))
+		return nil
+	}
+
+	prevDone := h.chatDone
+	done := make(chan struct{})
+	h.chatDone = done
+	// 开启协程运行agent，避免agent运行时无法打断处理
+	go h.runChatRound(ctx, prevDone, done)
+	return nil
+}
+
+// runChatRound 等待上一轮对话结束后，运行新一轮对话
+func (h *Handler) runChatRound(ctx context.Context, prevDone <-chan struct{}, done chan struct{}) {
+	defer close(done)
+
+	if prevDone != nil {
+		select {
+		case <-prevDone:
+		case <-h.stopChan:
+			return
+		}
+	}
+
+	h.chatLock.Lock()
+	text := strings.Join(h.pendingChat, "\n")
+	h.pendingChat = nil
+	if h.pendingExit {
+		h.pendingExit = false
+		atomic.StoreInt32(&h.closeAfterChat, 1)
+	}
+	// 工具通过 context 获取用户身份，以限定代表用户执行的操作范围
+	roundCtx, cancel := context.WithCancel(auth.NewContext(ctx, h.identity))
+	h.chatCancel = cancel
+	h.chatLock.Unlock()
+
+	defer func() {
+		h.chatLock.Lock()
+		h.chatCancel = nil
+		h.chatLock.Unlock()
+		cancel()
+	}()
+
+	round := int(atomic.AddInt32(&h.chatRound, 1))
+	h.updateInfo(func(info *SessionInfo) { info.ChatRounds = round })
+	h.log.Infof("start new chat round: %d", round)
+	// 已因退出意图等原因需要关闭时，不再提示对话轮次用完
+	lastRound := atomic.LoadInt32(&h.closeAfterChat) == 0 && h.maxChatRounds > 0 && round >= h.maxChatRounds
+	if lastRound {
+		// 最后一轮对话，不再接收客户端消息，回复后告知用户并关闭会话
+		atomic.StoreInt32(&h.closeAfterChat, 1)
+		atomic.StoreInt32(&h.stopRecv, 1)
+		h.log.Infof("reach max chat rounds: %d, close after chat", h.maxChatRounds)
 	}
 
 	// 如果有中断信号，须关闭中断，保证下一轮对话可打断
@@ -176,24 +471,100 @@ func (h *Handler) handleChatMessage(ctx context.Context, text string) error {
 		atomic.StoreInt32(&h.interrupt, 0)
 	}
 
-	// 开启协程运行agent，避免agent运行时无法打断处理
-	go func() {
-		if err := h.agentProvider.Run(ctx, text); err != nil {
-			// 如果无法正常运行agent，且需要在此次对话后关闭连接，则直接关闭连接
-			if h.closeAfterChat {
-				h.close()
-			}
+	h.replyBuf = nil
+	h.messageID.Store(uuid.NewString())
+	h.replyLimiter = newReplyLimiter(h.maxReplyChars, h.localizedReply(h.cfg.Server.MaxReplyCharsSuffix, defaultReplyTruncatedSuffixes))
+	if h.isRepeat(text) && h.lastReply != "" {
+		// 重复上一次的回复，无需询问模型，保证与上一次完全一致
+		h.log.Infof("repeat last reply: %s", h.lastReply)
+		h.OnAgentResult(roundCtx, h.lastReply, agent.StateProcessing)
+		h.OnAgentResult(roundCtx, "", agent.StateCompleted)
+	} else if err := h.agentProvider.Run(roundCtx, text); err != nil {
+		aborted := errors.Is(roundCtx.Err(), context.Canceled)
+		if aborted {
+			h.log.Infof("chat round %d aborted: %v", round, err)
+		} else {
 			h.log.Errorf("agent run error: %v", err)
-			return
+			h.sayAgentError(roundCtx)
+		}
+		// 如果无法正常运行agent，且需要在此次对话后关闭连接，则直接关闭连接，已告知用户出错时等待播报完毕
+		if atomic.LoadInt32(&h.closeAfterChat) == 1 {
+			if lastRound {
+				h.sayRoundsExhausted()
+			}
+			h.closeGracefully()
 		}
+		return
+	}
 
-		// 对话结束后关闭连接
-		if h.closeAfterChat {
-			h.log.Info("close after chat")
-			h.close()
-			return
+	// 对话结束后，等待本轮的回复播报完毕再关闭连接
+	if atomic.LoadInt32(&h.closeAfterChat) == 1 {
+		h.log.Info("close after chat")
+		if lastRound {
+			h.sayRoundsExhausted()
 		}
-	}()
+		h.closeGracefully()
+		return
+	}
+}
+
+// defaultRoundsExhaustedReply 未配置时对话轮次用完的默认回复
+const defaultRoundsExhaustedReply = "本次会话的对话次数已用完，感谢您的使用，再见。"
+
+// sayRoundsExhausted 对话轮次用完时告知用户，随后关闭会话
+func (h *Handler) sayRoundsExhausted() {
+	reply := h.cfg.Server.MaxChatRoundsReply
+	if reply == "" {
+		reply = defaultRoundsExhaustedReply
+	}
+	if err := h.sendChatMessage(reply, true); err != nil {
+		h.log.Errorf("failed to send chat message: %v", err)
+	}
+	_ = h.sendGoodbyeMessage("max chat rounds")
+}
+
+// defaultAgentErrorReplies 未配置时对话出错的默认回复，k: 语音合成的语种
+var defaultAgentErrorReplies = map[string]string{
+	"zh": "抱歉，我这边出了点问题，请稍后再试。",
+	"en": "Sorry, something went wrong on my side. Please try again later.",
+}
 
+// sayAgentError 对话出错且本轮尚未回复任何内容时告知用户，已有回复时保留已下发的内容即可
+// 每轮最多告知一次；TTS服务不可用时仅下发文本，不再尝试合成
+func (h *Handler) sayAgentError(ctx context.Context) {
+	if len(h.replyBuf) > 0 {
+		return
+	}
+	reply := h.localizedReply(h.cfg.Server.AgentErrorReply, defaultAgentErrorReplies)
+	if err := h.sendChatMessage(reply, true); err != nil {
+		h.log.Errorf("failed to send chat message: %v", err)
+		return
+	}
+	if !h.enableTts || h.ttsProvider == nil || atomic.LoadInt32(&h.ttsUnavailable) == 1 {
+		return
+	}
+	h.markTtsPending()
+	if err := h.ttsProvider.ToTTS(ctx, reply); err != nil {
+		h.log.Errorf("failed to convert text to tts: %v", err)
+		h.markTtsDrained()
+		return
+	}
+	if err := h.ttsProvider.ToSessionFinish(); err != nil {
+		h.log.Errorf("failed to finish tts session: %v", err)
+		h.markTtsDrained() // 合成无法正常结束，关闭会话时无需等待
+	}
+}
+
+// setNextToolChoice 指定下一轮对话的工具选择方式，取值无效时告知客户端
+func (h *Handler) setNextToolChoice(choice string) error {
+	setter, ok := h.agentProvider.(agent.ToolChoiceSetter)
+	if !ok {
+		return errors.New("agent does not support tool choice")
+	}
+	if err := setter.SetNextToolChoice(choice); err != nil {
+		_ = h.sendErrorMessage(errcode.ErrInvalidDataType.Code(), errcode.ErrInvalidDataType.Msg())
+		return fmt.Errorf("failed to set tool choice: %v", err)
+	}
+	h.log.Infof("next tool choice: %s", choice)
 	return nil
 }
@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"crow/internal/auth"
+	"crow/internal/config"
+	"crow/internal/tts"
+)
+
+func TestHelloConversationResumeRequiresIdentity(t *testing.T) {
+	previous := uuid.New().String()
+	tests := []struct {
+		name     string
+		identity *auth.Identity
+		resumed  bool
+		key      string
+	}{
+		{name: "anonymous", resumed: false},
+		{name: "authenticated", identity: &auth.Identity{Subject: "alice"}, resumed: true, key: "crow:memory:alice:" + previous},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Memory.Backend = "redis"
+			conn := newFakeConn(map[string]any{"type": "hello", "conversation_id": previous})
+			h := NewHandler(cfg, newTestLogger(), conn)
+			h.identity = tt.identity
+			t.Cleanup(func() { _ = conn.Close() })
+			if err := h.handleHelloMessage(t.Context()); err != nil {
+				t.Fatalf("hello: %v", err)
+			}
+
+			got := conn.waitFor(t, "hello", 1)[0]["conversation_id"]
+			if resumed := got == previous; resumed != tt.resumed {
+				t.Fatalf("conversation_id = %v, resumed = %v, want %v", got, resumed, tt.resumed)
+			}
+			if tt.key != "" && h.memoryKey() != tt.key {
+				t.Fatalf("memory key = %q, want %q", h.memoryKey(), tt.key)
+			}
+		})
+	}
+}
+
+func TestReplyTruncatedSuffixByLanguage(t *testing.T) {
+	tests := []struct {
+		name       string
+		language   string // 语音合成的语种，为空表示未开启TTS
+		configured map[string]string
+		want       string
+	}{
+		{"no tts", "", nil, defaultReplyTruncatedSuffixes["zh"]},
+		{"english", "en-US", nil, defaultReplyTruncatedSuffixes["en"]},
+		{"configured", "en", map[string]string{"en": " Want more?"}, " Want more?"},
+		{"other language configured", "en", map[string]string{"zh": "……还要听吗？"}, defaultReplyTruncatedSuffixes["en"]},
+		{"unsupported language", "fr", nil, defaultReplyTruncatedSuffixes["zh"]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Server.MaxReplyCharsSuffix = tt.configured
+			h := &Handler{cfg: cfg}
+			if tt.language != "" {
+				h.ttsCfg = &tts.Config{Language: tt.language}
+			}
+			limiter := newReplyLimiter(5, h.localizedReply(cfg.Server.MaxReplyCharsSuffix, defaultReplyTruncatedSuffixes))
+			if got, want := limiter.Write("Hello world. Bye."), "Hello"+tt.want; got != want {
+				t.Fatalf("truncated reply = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestMemoryKeyScopedBySubject(t *testing.T) {
+	id := uuid.New().String()
+	alice := &Handler{conversationID: id, identity: &auth.Identity{Subject: "alice"}}
+	bob := &Handler{conversationID: id, identity: &auth.Identity{Subject: "bob"}}
+	if alice.memoryKey() == bob.memoryKey() {
+		t.Fatalf("different users share the memory key %q", alice.memoryKey())
+	}
+}
+
+func TestChatTextQueuedBehindRunningRound(t *testing.T) {
+	h, _ := newTestHandler(t, &config.Config{}, nil)
+	a := newFakeAgent()
+	a.SetListener(h)
+	h.agentProvider = a
+	ctx := t.Context()
+
+	chat := func(text string) {
+		if err := h.handleClientTextMessages(ctx, `{"type":"chat","chat_text":"`+text+`"}`); err != nil {
+			t.Fatalf("chat %q: %v", text, err)
+		}
+	}
+	chat("one")
+	a.waitPrompts(t, 1)
+	// 对话进行中到达的文本排在其后，并按到达顺序合并为下一轮
+	chat("two")
+	chat("three")
+
+	a.release <- struct{}{}
+	prompts := a.waitPrompts(t, 2)
+	a.release <- struct{}{}
+	if want := []string{"one", "two\nthree"}; !slices.Equal(prompts, want) {
+		t.Fatalf("prompts = %q, want %q", prompts, want)
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.aborted != 0 {
+		t.Fatalf("text messages aborted %d running rounds", a.aborted)
+	}
+}
+
+func TestExitIntentClosesAfterItsOwnRound(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.CMDExit = []string{"再见"}
+	h, conn := newTestHandler(t, cfg, nil)
+	a := newFakeAgent()
+	a.SetListener(h)
+	h.agentProvider = a
+	ctx := t.Context()
+
+	if err := h.handleChatMessage(ctx, "one"); err != nil {
+		t.Fatal(err)
+	}
+	a.waitPrompts(t, 1)
+	if err := h.handleChatMessage(ctx, "再见"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 进行中的对话结束后不关闭连接，而是继续回复包含退出意图的一轮
+	a.release <- struct{}{}
+	if prompts := a.waitPrompts(t, 2); prompts[1] != "再见" {
+		t.Fatalf("prompts = %q", prompts)
+	}
+	if conn.IsClosed() {
+		t.Fatal("connection closed before the exit round was answered")
+	}
+	a.release <- struct{}{}
+	deadline := time.Now().Add(5 * time.Second)
+	for !conn.IsClosed() {
+		if time.Now().After(deadline) {
+			t.Fatal("connection not closed after the exit round")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
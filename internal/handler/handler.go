@@ -2,25 +2,39 @@ package handler
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
 
 	"crow/internal/agent"
+	llm2 "crow/internal/agent/llm"
 	"crow/internal/agent/llm/openai"
+	"crow/internal/agent/memory"
 	"crow/internal/agent/prompt"
 	"crow/internal/agent/react"
+	"crow/internal/agent/schema"
 	"crow/internal/asr"
 	doubaoasr "crow/internal/asr/doubao"
 	"crow/internal/asr/paraformer"
+	"crow/internal/asr/whisper"
+	"crow/internal/auth"
 	"crow/internal/config"
+	"crow/internal/endpoint"
 	"crow/internal/tts"
 	cosyvoice "crow/internal/tts/cosy-voice"
 	doubaotts "crow/internal/tts/doubao"
+	"crow/internal/wakeword"
+	errcode "crow/pkg/err-code"
 	"crow/pkg/log"
+	"crow/pkg/redis"
 	"crow/pkg/util"
 )
 
@@ -31,67 +45,163 @@ type Handler struct {
 	conn Connection
 	once sync.Once // 用于确保只执行一次关闭操作
 
-	sessionID string
-	enableAsr bool
-	enableTts bool
+	infoLock sync.Mutex  // infoLock 保护 info
+	info     SessionInfo // info 会话的概要信息，供管理接口读取
+
+	sessionID       string
+	identity        *auth.Identity // identity 建立连接时认证得到的用户身份，未配置认证时为 nil
+	enableAsr       bool
+	enableTts       bool
+	selectedModule  map[string]string // selectedModule 本次会话实际使用的模块，默认为配置中的selected_module
+	conversationID  string            // conversationID 会话记忆标识，仅在记忆持久化到Redis时使用，客户端重连时可携带以恢复上下文
+	reasoningEffort string            // reasoningEffort 本次会话使用的推理强度，为空则使用服务商默认值
+	userContext     string            // userContext 客户端在hello中携带的用户信息，加入系统提示词
 
 	asrProvider   asr.Provider
 	agentProvider agent.Provider
 	ttsProvider   tts.Provider
-
-	chatRound      int   // chatRound 对话轮次
-	closeAfterChat bool  // closeAfterChat 是否对话结束后关闭连接
-	stopRecv       int32 // stopRecv 停止接收客户端消息，0：不停止，1：停止
-	interrupt      int32 // interrupt 中断对话，0：不中断，1：中断
+	wakeDetector  wakeword.Detector   // wakeDetector 唤醒词检测器，为 nil 表示未开启唤醒词检测
+	endpointer    endpoint.Endpointer // endpointer 端点检测策略，为 nil 表示未开启ASR或正在重放会话
+	endpointTimer *time.Timer         // endpointTimer 等待判定用户已说完的定时器，仅在ASR结果回调中使用
+	providers     Providers           // providers 替代按配置创建的服务商，为 nil 表示按配置创建
+
+	asrSegment     int           // asrSegment 已结束的识别句子数，用于生成 asr 响应的 segment_id，仅在ASR回调中使用
+	chatRound      int32         // chatRound 对话轮次，在对话协程中递增，ASR回调中读取
+	replyBuf       []string      // replyBuf 本轮对话已下发的回复片段，仅在对话协程中使用
+	lastReply      string        // lastReply 上一轮完整结束的对话的回复，用于重复上一次回复
+	maxChatRounds  int           // maxChatRounds 本次会话最多的对话轮次，0为不限制
+	maxReplyChars  int           // maxReplyChars 本次会话每轮回复最多的字数，0为不限制
+	replyLimiter   *replyLimiter // replyLimiter 本轮回复的字数限制，仅在对话协程中使用
+	closeAfterChat int32         // closeAfterChat 是否对话结束后关闭连接，0：否，1：是；ASR回调、读取协程与对话协程均会访问
+	stopRecv       int32         // stopRecv 停止接收客户端消息，0：不停止，1：停止
+	interrupt      int32         // interrupt 中断对话，0：不中断，1：中断
+	lastActiveTime int64         // lastActiveTime 最近一次收发活动的时间，UnixNano
+	lastAbortTime  int64         // lastAbortTime 最近一次中断对话的时间，UnixNano，用于语音打断的冷却
+	sessionStarted int32         // sessionStarted 是否已下发 session_start，关闭时据此下发 session_end
+	awake          int32         // awake 是否已唤醒，0：未唤醒，1：已唤醒，仅在开启唤醒词检测时使用
+	asrUnavailable int32         // asrUnavailable ASR服务是否不可用，用于在连续失败时仅告知客户端一次，0：可用，1：不可用
+	asrDegraded    int32         // asrDegraded 是否已因ASR服务不可用降级为文字输入，0：否，1：是，降级后本次会话不再识别语音
+	ttsUnavailable int32         // ttsUnavailable TTS服务是否不可用，用于在连续失败时仅告知客户端一次，0：可用，1：不可用
+	chatSeq        int64         // chatSeq 最近下发的回复分片序号
+	ttsSeq         int64         // ttsSeq 最近下发的音频分片序号
+	messageID      atomic.Value  // messageID 本轮回复的标识，每轮对话开始时生成，chat 与 tts 消息共用
+	asrFormat      string        // asrFormat 与客户端协商的音频格式
+	ttsPersistent  bool          // ttsPersistent 每轮合成结束后是否保留TTS连接，由服务商在保留的连接空闲超时或会话结束时关闭
+	ttsChunker     *tts.Chunker  // ttsChunker 将TTS音频重新切分为固定大小后下发，为 nil 表示原样下发
+	toolEvents     bool          // toolEvents 是否下发 tool_call 事件
+	reasoning      bool          // reasoning 是否下发推理模型的思考过程
+	fullReply      bool          // fullReply 每轮回复的最后一个 chat 消息是否携带本轮的完整回复
+	langCandidate  string        // langCandidate 待切换的语种，仅在ASR结果回调中使用
+	langCount      int           // langCount 连续识别为待切换语种的语句数
+	asrWords       []asr.Word    // asrWords 当前ASR结果的逐词结果，仅在ASR结果回调中使用
+	audioCheck     int           // audioCheck 本句音频格式的校验结果，0：待校验，1：一致，2：不一致，仅在音频处理协程中使用
+
+	// cfgLock 保护ASR、TTS配置，hello 之后 set_config 与ASR回调中的语种切换都可能整体替换配置
+	// 替换时在锁内复制、修改并交给服务商的 SetConfig，互不覆盖对方的修改
+	cfgLock    sync.Mutex
+	asrCfg     *asr.Config // asrCfg 当前实际使用的ASR配置，为 nil 表示未启用ASR
+	ttsCfg     *tts.Config // ttsCfg 当前实际使用的TTS配置，为 nil 表示未启用TTS
+	langLocked bool        // langLocked 语种已锁定，本次会话不再切换
+
+	drainLock sync.Mutex
+	ttsDrain  chan struct{} // ttsDrain 本轮有已提交但尚未合成下发完毕的文本时不为 nil，合成结束或中止时关闭
+
+	chatLock    sync.Mutex
+	pendingChat []string           // pendingChat 等待开始的对话文本，下一轮对话开始时合并处理
+	pendingExit bool               // pendingExit 等待开始的对话文本中存在退出意图，该轮对话结束后关闭连接
+	chatCancel  context.CancelFunc // chatCancel 取消正在进行的对话轮次，为 nil 表示没有正在进行的对话
+	chatDone    chan struct{}      // chatDone 最近一轮对话结束的信号
 
 	stopChan         chan struct{}
 	clientTextQueue  chan string
 	clientAudioQueue chan []byte
 }
 
-func NewHandler(cfg *config.Config, log *log.Logger, conn Connection) *Handler {
+func NewHandler(cfg *config.Config, log *log.Logger, conn Connection, opts ...Option) *Handler {
 	handler := &Handler{
-		cfg:       cfg,
-		log:       log,
-		conn:      conn,
-		sessionID: uuid.New().String(),
-		stopChan:  make(chan struct{}),
+		cfg:            cfg,
+		log:            log,
+		conn:           conn,
+		sessionID:      uuid.New().String(),
+		selectedModule: make(map[string]string, len(cfg.SelectedModule)),
+		stopChan:       make(chan struct{}),
+		lastActiveTime: time.Now().UnixNano(),
 	}
-	switch cfg.SelectedModule["asr"] {
-	case "paraformer":
-		handler.asrProvider = paraformer.NewParaformer(log)
-	case "doubao":
-		handler.asrProvider = doubaoasr.NewDoubao(log)
+	for module, name := range cfg.SelectedModule {
+		handler.selectedModule[module] = name
 	}
-	if handler.asrProvider != nil {
-		handler.asrProvider.SetListener(handler)
+	handler.info = SessionInfo{SessionID: handler.sessionID, StartTime: time.Now()}
+	for _, opt := range opts {
+		opt(handler)
+	}
+	handler.initProviders()
+	handler.startRecording()
+	return handler
+}
+
+// initProviders 根据本次会话选择的模块创建ASR与TTS服务
+func (h *Handler) initProviders() {
+	h.asrProvider = h.newAsrProvider(h.selectedModule["asr"])
+	if h.asrProvider != nil {
+		h.asrProvider.SetListener(h)
 	}
 
-	switch cfg.SelectedModule["tts"] {
+	h.ttsProvider = nil
+	switch h.selectedModule["tts"] {
 	case "cosy_voice":
-		handler.ttsProvider = cosyvoice.NewCosyVoice(log)
+		h.ttsProvider = cosyvoice.NewCosyVoice(h.log)
 	case "doubao":
-		handler.ttsProvider = doubaotts.NewDoubao(log)
+		h.ttsProvider = doubaotts.NewDoubao(h.log)
 	case "doubao_stream":
-		handler.ttsProvider = doubaotts.NewDoubaoStream(log)
+		h.ttsProvider = doubaotts.NewDoubaoStream(h.log)
 	}
-	if handler.ttsProvider != nil {
-		handler.ttsProvider.SetListener(handler)
+	if h.providers != nil {
+		h.ttsProvider = h.providers.Tts()
+	}
+	if h.ttsProvider != nil {
+		h.ttsProvider.SetListener(h)
 	}
-	return handler
+}
+
+// newAsrProvider 按服务商名称创建ASR服务，不支持的服务商返回 nil
+func (h *Handler) newAsrProvider(name string) asr.Provider {
+	if h.providers != nil {
+		return h.providers.Asr()
+	}
+	switch name {
+	case "paraformer":
+		return paraformer.NewParaformer(h.log)
+	case "doubao":
+		return doubaoasr.NewDoubao(h.log)
+	case "whisper":
+		return whisper.NewWhisper(h.log)
+	}
+	return nil
+}
+
+// selectModule 按客户端的选择覆盖本次会话使用的模块，只能选择 allowed_module 中配置的服务商
+// @return bool 是否发生了变更
+func (h *Handler) selectModule(module, name string) (bool, error) {
+	if name == "" || name == h.selectedModule[module] {
+		return false, nil
+	}
+	if !slices.Contains(h.cfg.AllowedModule[module], name) {
+		return false, fmt.Errorf("%s module %q is not allowed", module, name)
+	}
+	h.selectedModule[module] = name
+	return true, nil
 }
 
 func (h *Handler) initAgent(ctx context.Context) error {
 	var llmCfg config.LLMConfig
-	if v, ok := h.cfg.SelectedModule["llm"]; ok {
+	if v, ok := h.selectedModule["llm"]; ok {
 		if _, ok = h.cfg.LLM[v]; ok {
 			llmCfg = h.cfg.LLM[v]
 		}
 	}
-	llm := openai.NewOpenAI(llmCfg.Model, llmCfg.APIKey, llmCfg.BaseURL)
-	mcpReAct, err := react.NewMCPAgent(ctx, nil)
+	llm, mcpReAct, err := h.newLLM(ctx, llmCfg)
 	if err != nil {
-		return fmt.Errorf("failed to create mcp agent: %v", err)
+		return err
 	}
 
 	type toolInfo struct {
@@ -112,32 +222,209 @@ func (h *Handler) initAgent(ctx context.Context) error {
 		toolPrompt += fmt.Sprintf(toolDesc, string(jsonData))
 	}
 
-	h.agentProvider = react.NewReActAgent("crow", h.log, llm, mcpReAct,
-		react.WithSystemPrompt(fmt.Sprintf(prompt.SystemPrompt, toolPrompt)),
+	examples := make([]schema.Message, 0, len(h.cfg.Agent.Examples)*2)
+	for _, example := range h.cfg.Agent.Examples {
+		if example.User == "" || example.Assistant == "" {
+			continue
+		}
+		examples = append(examples, schema.UserMessage(example.User, ""), schema.AssistantMessage(example.Assistant, ""))
+	}
+
+	assistantName := h.cfg.AssistantName
+	if assistantName == "" {
+		assistantName = prompt.DefaultAssistantName
+	}
+	opts := []react.Option{
+		react.WithSystemPrompt(prompt.WithUserContext(prompt.NewSystemPrompt(assistantName, toolPrompt), h.userContext)),
+		react.WithSessionContext(h.voiceContext),
 		react.WithNextStepPrompt(prompt.NextStepPrompt),
+		react.WithSkipFirstStepPrompt(h.cfg.Agent.SkipFirstStepPrompt),
+		react.WithContentSanitizer(!h.cfg.Agent.DisableContentSanitizer),
 		react.WithMaxObserve(500),
-		react.WithMemoryMaxMessages(20))
+		react.WithStreamIdleTimeout(llmCfg.StreamIdleTimeout),
+		react.WithStopSequences(llmCfg.Stop...),
+		react.WithReasoning(h.reasoningEffort, llmCfg.MaxReasoningTokens),
+		react.WithStreamReasoning(h.reasoning),
+		react.WithExamples(examples...),
+		react.WithTerminateConfirm(h.cfg.Agent.TerminateConfirm, h.cfg.Agent.TerminateConfirmTimeout),
+		react.WithMaxRunDuration(h.cfg.Agent.MaxRunDuration),
+		react.WithToolFailureFallback(h.cfg.Agent.ToolFailureSteps, h.cfg.Agent.ToolFailureReply),
+		react.WithRouter(h.toolRouter()),
+		react.WithMemory(h.newMemory()),
+	}
+	if h.cfg.Agent.InjectDateTime {
+		opts = append(opts, react.WithInjectDateTime(h.cfg.Server.Timezone))
+	}
+	h.agentProvider = react.NewReActAgent(assistantName, h.log, llm, mcpReAct, opts...)
 	h.agentProvider.SetListener(h)
+
+	fields := log.Fields{"session_id": h.sessionID, "assistant": assistantName, "llm": llm.Name(), "llm_model": llmCfg.Model}
+	if h.enableAsr && h.asrProvider != nil {
+		fields["asr"] = h.asrProvider.Name()
+	}
+	if h.enableTts && h.ttsProvider != nil {
+		fields["tts"] = h.ttsProvider.Name()
+	}
+	h.log.WithFields(fields).Info("session providers")
 	return nil
 }
 
+// newOpenAI 按配置创建 OpenAI 兼容接口的大模型
+func newOpenAI(llmCfg config.LLMConfig) (*openai.OpenAI, error) {
+	llm := openai.NewOpenAI(llmCfg.Model, llmCfg.APIKey, llmCfg.BaseURL)
+	httpClient, err := llm2.NewHTTPClient(llmCfg.TLS.InsecureSkipVerify, llmCfg.TLS.CAFile, llmCfg.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create llm http client: %v", err)
+	}
+	llm.SetHTTPClient(httpClient)
+	llm.SetMaxCompletionTokens(llmCfg.MaxCompletionTokens)
+	llm.SetHeaders(llmCfg.Headers)
+	return llm, nil
+}
+
+// toolRouter 配置了 agent.tool_llm 时，决定是否调用工具的步骤改用该模型，使用替代的服务商时不区分
+func (h *Handler) toolRouter() react.Router {
+	name := h.cfg.Agent.ToolLLM
+	if name == "" || name == h.selectedModule["llm"] || h.providers != nil {
+		return nil
+	}
+	llmCfg, ok := h.cfg.LLM[name]
+	if !ok {
+		h.log.Warnf("tool llm %q is not configured, ignore", name)
+		return nil
+	}
+	toolLLM, err := newOpenAI(llmCfg)
+	if err != nil {
+		h.log.Warnf("failed to create tool llm %q, ignore: %v", name, err)
+		return nil
+	}
+	return react.ToolStepRouter(toolLLM)
+}
+
+// newLLM 创建大模型及其可用的工具，使用替代的服务商时不提供任何工具
+func (h *Handler) newLLM(ctx context.Context, llmCfg config.LLMConfig) (llm2.LLM, *react.MCPAgent, error) {
+	if h.providers != nil {
+		return h.providers.LLM(), react.NewBuiltinAgent(config.ToolsConfig{Disabled: []string{"*"}}), nil
+	}
+	llm, err := newOpenAI(llmCfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	mcpReAct, err := react.NewMCPAgent(ctx, nil, h.cfg.Tools)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create mcp agent: %v", err)
+	}
+	return llm, mcpReAct, nil
+}
+
+// sharedRedis 共享的Redis客户端及创建时使用的密码，密码变更后改用新的客户端
+type sharedRedis struct {
+	client   *redis.Client
+	password string
+}
+
+var (
+	redisLock    sync.Mutex
+	redisClients = map[string]sharedRedis{} // 按地址及数据库共享的Redis客户端，键中不包含密码
+)
+
+// newMemory 按配置创建会话记忆，持久化到Redis时以会话记忆标识作为键，以便重连后恢复上下文
+func (h *Handler) newMemory() memory.Memory {
+	cfg := h.cfg.Memory
+	if cfg.Backend != "redis" || h.conversationID == "" {
+		m := memory.NewDefaultMemory(cfg.MaxMessages)
+		m.SetLogger(h.log)
+		return m
+	}
+
+	redisLock.Lock()
+	key := fmt.Sprintf("%s/%d", cfg.Redis.Addr, cfg.Redis.DB)
+	shared, ok := redisClients[key]
+	if !ok || shared.password != cfg.Redis.Password {
+		// 重载配置修改密码后，仍在使用旧客户端的会话不受影响，新会话使用新的客户端
+		shared = sharedRedis{
+			client:   redis.NewClient(redis.Option{Addr: cfg.Redis.Addr, Password: cfg.Redis.Password, DB: cfg.Redis.DB}),
+			password: cfg.Redis.Password,
+		}
+		redisClients[key] = shared
+	}
+	redisLock.Unlock()
+	return memory.NewRedisMemory(h.log, shared.client, h.memoryKey(), cfg.MaxMessages, cfg.TTL)
+}
+
+// memoryKey 会话记忆在Redis中的键，包含用户标识，使同一会话记忆标识只能由其所属的用户恢复
+func (h *Handler) memoryKey() string {
+	if h.identity == nil {
+		return "crow:memory:" + h.conversationID
+	}
+	return "crow:memory:" + h.identity.Subject + ":" + h.conversationID
+}
+
 func (h *Handler) Handle(ctx context.Context) {
+	// 无论何种原因退出，都需要关闭连接并释放资源
+	defer h.close()
+
 	// 接收并处理hello消息
 	if err := h.handleHelloMessage(ctx); err != nil {
 		h.log.Errorf("failed to handle hello message: %v", err)
+		if errors.Is(err, errInvalidHello) {
+			h.closeWithReason(CloseCodeBadRequest, "invalid hello message")
+		} else {
+			h.closeWithReason(CloseCodeServerError, "failed to start session")
+		}
 		return
 	}
 
 	// 初始化agent
 	if err := h.initAgent(context.Background()); err != nil {
 		h.log.Errorf("failed to init agent: %v", err)
+		h.closeWithReason(CloseCodeServerError, "failed to init agent")
 		return
 	}
 
+	// 检测会话是否长时间空闲
+	go h.watchIdle()
+
 	// 开始接收客户端消息
 	h.listenClientMessages(ctx)
 }
 
+// idleTimeout 获取会话空闲超时时间
+func idleTimeout(cfg *config.Config) time.Duration {
+	if cfg.Server.IdleTimeout <= 0 {
+		return time.Minute
+	}
+	return cfg.Server.IdleTimeout
+}
+
+// touch 记录会话的收发活动
+func (h *Handler) touch() {
+	atomic.StoreInt64(&h.lastActiveTime, time.Now().UnixNano())
+}
+
+// watchIdle 会话在空闲超时时间内无任何收发活动，则下发goodbye并关闭连接
+func (h *Handler) watchIdle() {
+	timeout := idleTimeout(h.cfg)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.stopChan:
+			return
+		case <-ticker.C:
+			idle := time.Since(time.Unix(0, atomic.LoadInt64(&h.lastActiveTime)))
+			if idle < timeout {
+				continue
+			}
+			h.log.Infof("session idle for %v, close connection", idle)
+			_ = h.sendGoodbyeMessage("idle timeout")
+			h.closeWithReason(CloseCodeIdleTimeout, "idle timeout")
+			return
+		}
+	}
+}
+
 func (h *Handler) listenClientMessages(ctx context.Context) {
 	for {
 		select {
@@ -147,10 +434,18 @@ func (h *Handler) listenClientMessages(ctx context.Context) {
 			return
 		default:
 			messageType, message, err := h.conn.ReadMessage()
+			if errors.Is(err, ErrInputEnded) {
+				// 客户端已结束上传，当前对话结束后关闭会话，没有对话时由空闲检测关闭
+				h.log.Info("client input ended, close after chat")
+				atomic.StoreInt32(&h.closeAfterChat, 1)
+				<-h.stopChan
+				return
+			}
 			if err != nil {
 				h.log.Errorf("failed to read message: %v", err)
 				return
 			}
+			h.touch()
 			if err = h.handleMessage(messageType, message); err != nil {
 				h.log.Errorf("failed to handle message: %v", err)
 			}
@@ -166,16 +461,142 @@ func (h *Handler) listenClientAudioMessages(ctx context.Context) {
 		case <-h.stopChan:
 			return
 		case audio := <-h.clientAudioQueue:
-			if atomic.LoadInt32(&h.stopRecv) == 1 {
+			// 已降级为文字输入时，丢弃客户端的音频
+			if atomic.LoadInt32(&h.stopRecv) == 1 || atomic.LoadInt32(&h.asrDegraded) == 1 {
+				continue
+			}
+			if audio == nil {
+				h.audioCheck = audioCheckPending
+				// 未唤醒时客户端结束说话，无需结束识别
+				if h.wakeDetector != nil && atomic.LoadInt32(&h.awake) == 0 {
+					continue
+				}
+				if err := h.asrProvider.Finalize(); err != nil {
+					h.log.Errorf("failed to finalize asr: %v", err)
+				}
 				continue
 			}
-			if err := h.asrProvider.SendAudio(ctx, audio); err != nil {
+			if !h.checkAudioFormat(audio) {
+				continue
+			}
+			if !h.passWakeGate(ctx, audio) {
+				continue
+			}
+			err := h.asrProvider.SendAudio(ctx, audio)
+			if err != nil {
 				h.log.Errorf("failed to send audio data: %v", err)
 			}
+			if h.checkAvailable(ctx, err, asr.ErrUnavailable, &h.asrUnavailable, errcode.ErrAsrUnavailable) {
+				h.degradeAsr()
+			}
 		}
 	}
 }
 
+const (
+	audioCheckPending = iota
+	audioCheckPassed
+	audioCheckFailed
+)
+
+// checkAudioFormat 校验每句的首帧音频与协商的格式是否一致，客户端结束说话后重新校验
+// 不一致时告知客户端，reject 模式下丢弃本句的全部音频
+// @return 音频是否可以继续处理
+func (h *Handler) checkAudioFormat(audio []byte) bool {
+	mode := h.cfg.Server.AudioFormatCheck
+	if mode == "off" {
+		return true
+	}
+	switch h.audioCheck {
+	case audioCheckPassed:
+		return true
+	case audioCheckFailed:
+		return mode != "reject"
+	}
+
+	detected := asr.DetectFormat(audio)
+	if asr.FormatMatches(h.asrFormat, detected) {
+		h.audioCheck = audioCheckPassed
+		return true
+	}
+	h.audioCheck = audioCheckFailed
+	h.log.Warnf("audio format mismatch, negotiated: %s, detected: %s", h.asrFormat, detected)
+	msg := fmt.Sprintf("%s，协商的格式为%s，实际为%s", errcode.ErrAudioFormat.Msg(), h.asrFormat, detected)
+	if err := h.sendErrorMessage(errcode.ErrAudioFormat.Code(), msg); err != nil {
+		h.log.Errorf("failed to send error message: %v", err)
+	}
+	return mode != "reject"
+}
+
+// checkAvailable 服务商多次尝试后仍无法建立连接时告知客户端服务暂时不可用，连续失败只告知一次，恢复后重新计算
+// @param err 调用服务商的结果
+// @param target 服务不可用的错误类型
+// @param flag 服务不可用的标记
+// @param code 告知客户端的错误码
+// @return 是否刚刚变为不可用，即本次告知了客户端
+func (h *Handler) checkAvailable(ctx context.Context, err, target error, flag *int32, code *errcode.Error) bool {
+	if err == nil {
+		atomic.StoreInt32(flag, 0)
+		return false
+	}
+	// 会话或对话已结束导致的失败无需告知
+	if !errors.Is(err, target) || ctx.Err() != nil {
+		return false
+	}
+	if !atomic.CompareAndSwapInt32(flag, 0, 1) {
+		return false
+	}
+	if err = h.sendErrorMessage(code.Code(), code.Msg()); err != nil {
+		h.log.Errorf("failed to send error message: %v", err)
+	}
+	return true
+}
+
+// degradeAsr 语音识别服务不可用时按配置降级，建议客户端改用文字输入，auto 模式下本次会话不再识别语音
+func (h *Handler) degradeAsr() {
+	mode := h.cfg.Server.AsrFallback
+	if mode != "notify" && mode != "auto" {
+		return
+	}
+	switched := mode == "auto"
+	if switched {
+		atomic.StoreInt32(&h.asrDegraded, 1)
+	}
+	h.log.Warnf("asr unavailable, fallback to text input, switched: %v", switched)
+	if err := h.sendDegradeMessage("asr", "text", switched); err != nil {
+		h.log.Errorf("failed to send degrade message: %v", err)
+	}
+}
+
+// passWakeGate 开启唤醒词检测时，未唤醒的音频仅用于检测唤醒词，不会进入识别与对话
+// @return 音频是否可以送入ASR
+func (h *Handler) passWakeGate(ctx context.Context, audio []byte) bool {
+	if h.wakeDetector == nil || atomic.LoadInt32(&h.awake) == 1 {
+		return true
+	}
+	detected, err := h.wakeDetector.Detect(ctx, audio)
+	if err != nil {
+		h.log.Errorf("failed to detect wake word: %v", err)
+		return false
+	}
+	if detected {
+		atomic.StoreInt32(&h.awake, 1)
+		h.log.Infof("session awakened by %s detector", h.wakeDetector.Name())
+	}
+	// 包含唤醒词的音频不送入ASR，从下一帧开始识别
+	return false
+}
+
+// sleep 本轮说话结束，重新进入等待唤醒的状态
+func (h *Handler) sleep() {
+	if h.wakeDetector == nil || !atomic.CompareAndSwapInt32(&h.awake, 1, 0) {
+		return
+	}
+	if err := h.wakeDetector.Reset(); err != nil {
+		h.log.Errorf("failed to reset wake word detector: %v", err)
+	}
+}
+
 func (h *Handler) listenClientTextMessages(ctx context.Context) {
 	for {
 		select {
@@ -199,7 +620,7 @@ func (h *Handler) OnAsrResult(ctx context.Context, result string, state asr.Stat
 	var isSystemMsg bool
 	if h.asrProvider.GetSilenceCount() >= 2 {
 		h.log.Infof("连续检测到两次静音，结束对话")
-		h.closeAfterChat = true
+		atomic.StoreInt32(&h.closeAfterChat, 1)
 		atomic.StoreInt32(&h.stopRecv, 1)
 		state = asr.StateCompleted
 		result = "长时间未检测到用户说话，请礼貌的结束对话"
@@ -209,50 +630,135 @@ func (h *Handler) OnAsrResult(ctx context.Context, result string, state asr.Stat
 		return false
 	}
 
+	// 逐词结果在 OnAsrResultDetail 中暂存，仅用于本次结果
+	words := h.asrWords
+	h.asrWords = nil
+
 	// 非系统消息则向客户端发送ASR结果
 	if !isSystemMsg {
-		if err := h.sendAsrMessage(result, int(state)); err != nil {
+		if err := h.sendAsrMessage(result, int(state), words); err != nil {
 			return true
 		}
 	}
 
+	if state == asr.StateProcessing {
+		h.observeEndpoint(result, words)
+	} else {
+		h.resetEndpoint()
+	}
+
 	switch state {
 	case asr.StateSentenceEnd:
-		if err := h.handleChatMessage(ctx, result); err != nil {
-			h.log.Errorf("failed to handle chat message: %v", err)
-		}
+		h.sleep()
+		h.submitAsrResult(ctx, result)
 		return false
 	case asr.StateCompleted:
 		_ = h.asrProvider.Reset() // 重置ASR，准备下一次识别
-		if err := h.handleChatMessage(ctx, result); err != nil {
-			h.log.Errorf("failed to handle chat message: %v", err)
-		}
+		h.sleep()
+		h.submitAsrResult(ctx, result)
 		return true
 	default:
 		// 如果有新的语音识别结果，则应该打断当前的对话
-		if atomic.LoadInt32(&h.interrupt) == 0 {
+		if atomic.LoadInt32(&h.interrupt) == 0 && !h.inInterruptCooldown() {
 			_ = h.handleAbortChat()
 		}
 	}
 	return false
 }
 
+// inInterruptCooldown 距上一次中断对话是否不足冷却时间，冷却期间的语音打断合并至上一次中断而不再重复中止对话，
+// 用户持续说话时，冷却结束后的下一个识别结果仍会打断
+func (h *Handler) inInterruptCooldown() bool {
+	cooldown := h.cfg.Server.InterruptCooldown
+	if cooldown <= 0 {
+		return false
+	}
+	since := time.Since(time.Unix(0, atomic.LoadInt64(&h.lastAbortTime)))
+	if since >= cooldown {
+		return false
+	}
+	h.log.Debugf("ignore barge-in %v after last abort, cooldown: %v", since, cooldown)
+	return true
+}
+
+// submitAsrResult 将一句完整的识别结果提交为一轮对话
+// 用户未说话即被判停等情况下识别结果为空，此时忽略该结果，不打断进行中的对话，也不浪费一轮对话
+func (h *Handler) submitAsrResult(ctx context.Context, result string) {
+	if strings.TrimSpace(util.RemoveAllPunctuation(result)) == "" {
+		h.log.Infof("empty asr result %q, skip chat", result)
+		return
+	}
+	if err := h.handleChatMessage(ctx, result); err != nil {
+		h.log.Errorf("failed to handle chat message: %v", err)
+	}
+}
+
+func (h *Handler) OnAsrResultDetail(ctx context.Context, detail asr.Detail) {
+	h.asrWords = detail.Words
+	// 仅在分句结束或识别结束时记录，便于按轮次区分音频质量问题与模型识别问题
+	if detail.State == asr.StateProcessing || detail.Result == "" {
+		return
+	}
+	h.log.Infof("asr result detail, chat round: %d, language: %s, confidence: %.2f, result: %s",
+		atomic.LoadInt32(&h.chatRound)+1, detail.Language, detail.Confidence, detail.Result)
+	h.trackLanguage(detail)
+}
+
 func (h *Handler) OnAgentResult(ctx context.Context, text string, state agent.State) bool {
 	if text == "" && state != agent.StateCompleted {
 		return false
 	}
-	// 向客户端发送回复消息
-	if err := h.sendChatMessage(text); err != nil {
+	h.touch()
+	// 超出字数限制的回复不再下发与合成，未结束的句子暂存至句子结束
+	text = h.replyLimiter.Write(text)
+	if state == agent.StateCompleted {
+		text += h.replyLimiter.Flush()
+	}
+	if text == "" && state != agent.StateCompleted {
+		return false
+	}
+	if text != "" {
+		h.replyBuf = append(h.replyBuf, text)
+	}
+	if state == agent.StateCompleted && len(h.replyBuf) > 0 {
+		h.lastReply = strings.Join(h.replyBuf, "")
+	}
+	// 向客户端发送回复消息，开启完整回复时，剩余的片段照常下发，最后一个消息改为携带本轮的完整回复
+	chatText := text
+	if state == agent.StateCompleted && h.fullReply {
+		if text != "" {
+			if err := h.sendChatMessage(text, false); err != nil {
+				h.log.Errorf("failed to send chat message: %v", err)
+				return true
+			}
+		}
+		chatText = strings.Join(h.replyBuf, "")
+	}
+	if err := h.sendChatMessage(chatText, state == agent.StateCompleted); err != nil {
 		h.log.Errorf("failed to send chat message: %v", err)
 		return true
 	}
 
 	// 向TTS服务发送文本
 	if h.ttsProvider != nil {
-		if err := h.ttsProvider.ToTTS(ctx, text); err != nil {
+		// 须在提交前标记，部分服务商在 ToTTS 返回前即已回调合成结束
+		if text != "" {
+			h.markTtsPending()
+		}
+		err := h.ttsProvider.ToTTS(ctx, text)
+		h.checkAvailable(ctx, err, tts.ErrUnavailable, &h.ttsUnavailable, errcode.ErrTtsUnavailable)
+		if err != nil {
 			h.log.Errorf("failed to convert text to tts: %v", err)
+			h.markTtsDrained()
 			return false
 		}
+		// 本轮回复的文本已全部发送，通知服务商合成剩余的文本并结束本次合成
+		if state == agent.StateCompleted {
+			if err = h.ttsProvider.ToSessionFinish(); err != nil {
+				h.log.Errorf("failed to finish tts session: %v", err)
+				h.markTtsDrained() // 合成无法正常结束，关闭会话时无需等待
+			}
+		}
 	}
 
 	if state == agent.StateCompleted {
@@ -262,6 +768,45 @@ func (h *Handler) OnAgentResult(ctx context.Context, text string, state agent.St
 	return false
 }
 
+// OnAgentTerminate 开启结束前确认时，用户确认没有其他需要或超时未回复，结束会话
+func (h *Handler) OnAgentTerminate(ctx context.Context, timeout bool) {
+	if !timeout {
+		// 本轮对话的告别语下发后关闭连接
+		atomic.StoreInt32(&h.closeAfterChat, 1)
+		atomic.StoreInt32(&h.stopRecv, 1)
+		h.log.Info("user confirmed to end, close after chat")
+		return
+	}
+	if h.isChatRunning() {
+		return
+	}
+	h.log.Info("no reply to terminate confirmation, close connection")
+	_ = h.sendGoodbyeMessage("confirm timeout")
+	h.close()
+}
+
+// OnAgentToolCall 客户端开启时下发工具调用事件
+func (h *Handler) OnAgentToolCall(ctx context.Context, call agent.ToolCall) {
+	if !h.toolEvents || atomic.LoadInt32(&h.interrupt) == 1 {
+		return
+	}
+	h.touch()
+	if err := h.sendToolCallMessage(call); err != nil {
+		h.log.Errorf("failed to send tool call message: %v", err)
+	}
+}
+
+// OnAgentReasoning 客户端开启时下发推理模型的思考过程，思考过程不会送入TTS
+func (h *Handler) OnAgentReasoning(ctx context.Context, text string) {
+	if atomic.LoadInt32(&h.interrupt) == 1 {
+		return
+	}
+	h.touch()
+	if err := h.sendReasoningMessage(text); err != nil {
+		h.log.Errorf("failed to send reasoning message: %v", err)
+	}
+}
+
 func (h *Handler) OnTtsResult(data []byte, state tts.State) bool {
 	// 检测到中断信号，不再下发tts数据
 	if atomic.LoadInt32(&h.interrupt) == 1 {
@@ -271,23 +816,71 @@ func (h *Handler) OnTtsResult(data []byte, state tts.State) bool {
 	if len(data) == 0 && state != tts.StateCompleted {
 		return false
 	}
-	if err := h.sendTtsMessage(string(data), int(state)); err != nil {
+	h.touch()
+	if err := h.deliverTts(data, state); err != nil {
 		h.log.Errorf("failed to send tts message: %v", err)
 	}
 	if state == tts.StateCompleted {
+		h.markTtsDrained()
+		if h.ttsPersistent {
+			// 保留连接，继续接收下一轮的合成结果
+			return false
+		}
 		_ = h.ttsProvider.Reset()
 		return true
 	}
 	return false
 }
 
+// deliverTts 按配置的分片大小重新切分音频后下发，本次合成结束时下发暂存的剩余音频
+// @param data base64编码的音频
+func (h *Handler) deliverTts(data []byte, state tts.State) error {
+	if h.ttsChunker == nil {
+		return h.sendTtsMessage(string(data), int(state))
+	}
+	audio, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		h.ttsChunker.Reset()
+		return h.sendTtsMessage(string(data), int(state))
+	}
+	for _, chunk := range h.ttsChunker.Write(audio) {
+		if err = h.sendTtsMessage(base64.StdEncoding.EncodeToString(chunk), int(tts.StateProcessing)); err != nil {
+			return err
+		}
+	}
+	if state == tts.StateCompleted {
+		return h.sendTtsMessage(base64.StdEncoding.EncodeToString(h.ttsChunker.Flush()), int(state))
+	}
+	return nil
+}
+
+func (h *Handler) OnTtsTimestamp(timestamp tts.Timestamp) {
+	// 检测到中断信号，不再下发时间戳
+	if atomic.LoadInt32(&h.interrupt) == 1 {
+		return
+	}
+	if err := h.sendTtsTimestampMessage(timestamp); err != nil {
+		h.log.Errorf("failed to send tts timestamp message: %v", err)
+	}
+}
+
 func (h *Handler) isExit(text string) bool {
-	if len(h.cfg.CMDExit) == 0 {
+	return matchCommand(text, h.cfg.CMDExit)
+}
+
+// isRepeat 是否为重复上一次回复的指令
+func (h *Handler) isRepeat(text string) bool {
+	return matchCommand(text, h.cfg.CMDRepeat)
+}
+
+// matchCommand 移除标点符号后与任意一条指令相同
+func matchCommand(text string, cmds []string) bool {
+	if len(cmds) == 0 {
 		return false
 	}
 	// 移除标点符号
 	text = util.RemoveAllPunctuation(text)
-	for _, cmd := range h.cfg.CMDExit {
+	for _, cmd := range cmds {
 		if text == cmd {
 			return true
 		}
@@ -296,8 +889,16 @@ func (h *Handler) isExit(text string) bool {
 }
 
 func (h *Handler) close() {
+	h.closeWithReason(CloseCodeNormal, "connection closed")
+}
+
+// closeWithReason 携带关闭码和原因关闭会话，仅首次调用生效
+func (h *Handler) closeWithReason(code int, reason string) {
 	h.once.Do(func() {
-		_ = h.conn.Close()
+		if err := h.sendSessionEndMessage(code, reason); err != nil {
+			h.log.Debugf("%v", err)
+		}
+		_ = h.conn.CloseWithReason(code, reason)
 		close(h.stopChan)
 
 		if h.asrProvider != nil {
@@ -309,11 +910,21 @@ func (h *Handler) close() {
 			if err := h.agentProvider.Reset(); err != nil {
 				h.log.Errorf("failed to reset agent provider: %v", err)
 			}
+			if closer, ok := h.agentProvider.(agent.Closer); ok {
+				if err := closer.Close(); err != nil {
+					h.log.Errorf("failed to close agent provider: %v", err)
+				}
+			}
 		}
 		if h.ttsProvider != nil {
 			if err := h.ttsProvider.Reset(); err != nil {
 				h.log.Errorf("failed to reset tts provider: %v", err)
 			}
 		}
+		if h.wakeDetector != nil {
+			if err := h.wakeDetector.Reset(); err != nil {
+				h.log.Errorf("failed to reset wake word detector: %v", err)
+			}
+		}
 	})
 }
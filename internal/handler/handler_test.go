@@ -0,0 +1,341 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"crow/internal/agent"
+	"crow/internal/agent/schema"
+	"crow/internal/asr"
+	"crow/internal/config"
+	"crow/pkg/fakeredis"
+	"crow/pkg/log"
+	"crow/pkg/redis"
+)
+
+// fakeConn 测试用的连接，客户端消息经 incoming 依次读取，服务端下发的文本消息按顺序记录
+type fakeConn struct {
+	incoming chan []byte
+
+	lock   sync.Mutex
+	sent   []map[string]any
+	closed bool
+	code   int
+}
+
+func newFakeConn(messages ...any) *fakeConn {
+	c := &fakeConn{incoming: make(chan []byte, 16)}
+	for _, m := range messages {
+		c.push(m)
+	}
+	return c
+}
+
+// push 模拟客户端发送一条文本消息
+func (c *fakeConn) push(m any) {
+	data, _ := json.Marshal(m)
+	c.incoming <- data
+}
+
+func (c *fakeConn) ReadMessage() (int, []byte, error) {
+	data, ok := <-c.incoming
+	if !ok {
+		return 0, nil, errors.New("connection closed")
+	}
+	return websocket.TextMessage, data, nil
+}
+
+func (c *fakeConn) WriteMessage(messageType int, data []byte) error {
+	if messageType != websocket.TextMessage {
+		return nil
+	}
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.sent = append(c.sent, m)
+	return nil
+}
+
+func (c *fakeConn) Close() error {
+	return c.CloseWithReason(CloseCodeNormal, "connection closed")
+}
+
+func (c *fakeConn) CloseWithReason(code int, _ string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.closed {
+		c.closed = true
+		c.code = code
+		close(c.incoming)
+	}
+	return nil
+}
+
+func (c *fakeConn) IsClosed() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.closed
+}
+
+// messages 已下发的指定类型的消息
+func (c *fakeConn) messages(typ string) []map[string]any {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var result []map[string]any
+	for _, m := range c.sent {
+		if m["type"] == typ {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// waitFor 等待下发指定类型的第 n 条消息
+func (c *fakeConn) waitFor(t *testing.T, typ string, n int) []map[string]any {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if msgs := c.messages(typ); len(msgs) >= n {
+			return msgs
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d %q messages, got %v", n, typ, c.messages(typ))
+	return nil
+}
+
+func newTestLogger() *log.Logger {
+	return log.NewLogger(&log.Option{Hook: io.Discard, Mode: "debug"})
+}
+
+// newTestHandler 创建未选择任何ASR、TTS服务的处理器，并完成hello握手
+func newTestHandler(t *testing.T, cfg *config.Config, hello map[string]any) (*Handler, *fakeConn) {
+	t.Helper()
+	if hello == nil {
+		hello = map[string]any{}
+	}
+	hello["type"] = "hello"
+	conn := newFakeConn(hello)
+	h := NewHandler(cfg, newTestLogger(), conn)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		_ = conn.Close()
+	})
+	if err := h.handleHelloMessage(ctx); err != nil {
+		t.Fatalf("hello: %v", err)
+	}
+	return h, conn
+}
+
+// fakeAgent 测试用的agent，每轮对话记录用户输入，收到 release 后以固定回复结束，被中止时返回 ctx 的错误
+type fakeAgent struct {
+	listener agent.Listener
+	release  chan struct{}
+
+	lock    sync.Mutex
+	prompts []string
+	aborted int
+}
+
+func newFakeAgent() *fakeAgent {
+	return &fakeAgent{release: make(chan struct{}, 16)}
+}
+
+func (a *fakeAgent) SetConfig(any) {}
+
+func (a *fakeAgent) SetListener(listener agent.Listener) {
+	a.listener = listener
+}
+
+func (a *fakeAgent) Run(ctx context.Context, prompt string) error {
+	a.lock.Lock()
+	a.prompts = append(a.prompts, prompt)
+	a.lock.Unlock()
+	select {
+	case <-a.release:
+	case <-ctx.Done():
+		a.lock.Lock()
+		a.aborted++
+		a.lock.Unlock()
+		return ctx.Err()
+	}
+	a.listener.OnAgentResult(ctx, "reply", agent.StateCompleted)
+	return nil
+}
+
+func (a *fakeAgent) Reset() error { return nil }
+
+// waitPrompts 等待开始第 n 轮对话
+func (a *fakeAgent) waitPrompts(t *testing.T, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		a.lock.Lock()
+		prompts := slices.Clone(a.prompts)
+		a.lock.Unlock()
+		if len(prompts) >= n {
+			return prompts
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d chat rounds", n)
+	return nil
+}
+
+func TestHandleHelloCloseCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		selected map[string]string
+		hello    any
+		code     int
+	}{
+		{"malformed", nil, "hello", CloseCodeBadRequest},
+		{"module not allowed", nil, map[string]any{"type": "hello", "asr_provider": "doubao"}, CloseCodeBadRequest},
+		{"user context too long", nil, map[string]any{"type": "hello", "user_context": strings.Repeat("长", defaultMaxUserContextChars+1)}, CloseCodeBadRequest},
+		// 服务端配置的服务商不受支持，属于服务端内部错误
+		{"unsupported provider", map[string]string{"asr": "unknown"}, map[string]any{"type": "hello", "enable_asr": true}, CloseCodeServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{SelectedModule: tt.selected}
+			conn := newFakeConn(tt.hello)
+			h := NewHandler(cfg, newTestLogger(), conn)
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				h.Handle(t.Context())
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("handle did not return")
+			}
+			conn.lock.Lock()
+			defer conn.lock.Unlock()
+			if conn.code != tt.code {
+				t.Fatalf("close code = %d, want %d", conn.code, tt.code)
+			}
+		})
+	}
+}
+
+func TestChatRoundReadFromAsrCallback(t *testing.T) {
+	h, _ := newTestHandler(t, &config.Config{}, nil)
+	a := newFakeAgent()
+	a.SetListener(h)
+	h.agentProvider = a
+
+	// 对话协程递增轮次的同时，ASR回调读取轮次，-race 下不应报告数据竞争
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			h.OnAsrResultDetail(t.Context(), asr.Detail{Result: "hi", State: asr.StateSentenceEnd})
+		}
+	}()
+	for i := 1; i <= 3; i++ {
+		if err := h.handleChatMessage(t.Context(), "hi"); err != nil {
+			t.Fatal(err)
+		}
+		a.waitPrompts(t, i)
+		a.release <- struct{}{}
+	}
+	<-done
+	if got := h.Info().ChatRounds; got != 3 {
+		t.Fatalf("chat rounds = %d, want 3", got)
+	}
+}
+
+// fakeAsr 测试用的ASR服务商，仅实现识别结果回调及调整配置时用到的方法
+type fakeAsr struct {
+	asr.Provider
+}
+
+func (fakeAsr) SetConfig(cfg *asr.Config) *asr.Config { return cfg }
+func (fakeAsr) GetSilenceCount() int                  { return 0 }
+func (fakeAsr) Reset() error                          { return nil }
+
+func TestIgnoreEmptyAsrResult(t *testing.T) {
+	h, _ := newTestHandler(t, &config.Config{}, nil)
+	h.asrProvider = fakeAsr{}
+	a := newFakeAgent()
+	a.SetListener(h)
+	h.agentProvider = a
+
+	if err := h.handleChatMessage(t.Context(), "first"); err != nil {
+		t.Fatal(err)
+	}
+	a.waitPrompts(t, 1)
+
+	// 空的或仅有标点的识别结果既不打断进行中的对话，也不作为新一轮对话
+	h.OnAsrResult(t.Context(), "", asr.StateSentenceEnd)
+	h.OnAsrResult(t.Context(), "。", asr.StateCompleted)
+	h.OnAsrResult(t.Context(), "你好", asr.StateSentenceEnd)
+	a.release <- struct{}{}
+	if got := a.waitPrompts(t, 2); !slices.Equal(got, []string{"first", "你好"}) {
+		t.Fatalf("prompts = %q, want [first 你好]", got)
+	}
+	a.release <- struct{}{}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.aborted != 0 {
+		t.Fatalf("aborted %d rounds, want 0", a.aborted)
+	}
+}
+
+func TestNewMemorySharedRedisClient(t *testing.T) {
+	s, err := fakeredis.NewServer("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	cfg := &config.Config{}
+	cfg.Memory.Backend = "redis"
+	cfg.Memory.Redis.Addr = s.Addr()
+	cfg.Memory.Redis.Password = "secret"
+	h, _ := newTestHandler(t, cfg, nil)
+	h.conversationID = "c1"
+
+	client := func() *redis.Client {
+		redisLock.Lock()
+		defer redisLock.Unlock()
+		for key := range redisClients {
+			if strings.Contains(key, "secret") {
+				t.Fatalf("client key %q contains the password", key)
+			}
+		}
+		return redisClients[s.Addr()+"/0"].client
+	}
+
+	h.newMemory().AddMessage(schema.UserMessage("你好", ""))
+	if _, ok := s.Get(h.memoryKey()); !ok {
+		t.Fatal("memory is not saved to redis")
+	}
+	first := client()
+	h.newMemory()
+	if client() != first {
+		t.Fatal("sessions with the same redis config do not share the client")
+	}
+
+	// 修改密码后新会话使用新的客户端
+	cfg.Memory.Redis.Password = "changed"
+	h.newMemory()
+	if client() == first {
+		t.Fatal("client is reused after the password changed")
+	}
+}
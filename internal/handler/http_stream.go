@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrInputEnded 客户端已结束上传，不会再有新的消息，但连接仍可继续下发消息
+var ErrInputEnded = errors.New("client input is ended")
+
+// httpStreamReadSize 每次从请求体读取的最大字节数，16k采样率的16位pcm音频约100ms
+const httpStreamReadSize = 3200
+
+// defaultStreamHello 未携带 hello 消息时使用的默认配置，开启语音识别与合成，其余参数使用服务端配置
+const defaultStreamHello = `{"type":"hello","enable_asr":true,"enable_tts":true}`
+
+// httpStreamConn 基于 HTTP 分块传输的连接，供无法使用 websocket 的集成方（如电话网关）使用
+// 请求体为连续上传的音频，hello 消息通过 hello 查询参数或 X-Crow-Hello 请求头携带，请求体结束时视为用户结束说话；
+// 下发的消息以 SSE 事件写入响应，文本消息即 data 中的 JSON，二进制消息以 base64 编码、事件名为 audio，关闭时下发 close 事件
+type httpStreamConn struct {
+	w           http.ResponseWriter
+	rc          *http.ResponseController
+	body        io.Reader
+	hello       []byte
+	readTimeout time.Duration
+
+	// 以下字段仅在读取协程中使用
+	helloSent bool // 是否已读取 hello 消息
+	bodyEnded bool // 请求体是否已读取完毕
+
+	lock     sync.Mutex
+	isClosed int32 // 连接状态标记: 0:open, 1:closed
+}
+
+// newHTTPStreamConn 创建 HTTP 分块传输连接，请求与响应需同时进行，因此开启全双工
+func newHTTPStreamConn(w http.ResponseWriter, r *http.Request, readTimeout time.Duration) (*httpStreamConn, error) {
+	rc := http.NewResponseController(w)
+	if err := rc.EnableFullDuplex(); err != nil {
+		return nil, fmt.Errorf("failed to enable full duplex: %v", err)
+	}
+	hello := r.URL.Query().Get("hello")
+	if hello == "" {
+		hello = r.Header.Get("X-Crow-Hello")
+	}
+	if hello == "" {
+		hello = defaultStreamHello
+	}
+	if readTimeout <= 0 {
+		readTimeout = time.Minute
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to flush response: %v", err)
+	}
+	return &httpStreamConn{
+		w:           w,
+		rc:          rc,
+		body:        r.Body,
+		hello:       []byte(hello),
+		readTimeout: readTimeout,
+	}, nil
+}
+
+func (c *httpStreamConn) ReadMessage() (messageType int, p []byte, err error) {
+	if atomic.LoadInt32(&c.isClosed) == 1 {
+		return 0, nil, ErrConnectionClosed
+	}
+	if !c.helloSent {
+		c.helloSent = true
+		return websocket.TextMessage, c.hello, nil
+	}
+	if c.bodyEnded {
+		return 0, nil, ErrInputEnded
+	}
+
+	_ = c.rc.SetReadDeadline(time.Now().Add(c.readTimeout))
+	buf := make([]byte, httpStreamReadSize)
+	n, err := c.body.Read(buf)
+	if n > 0 {
+		return websocket.BinaryMessage, buf[:n], nil
+	}
+	if errors.Is(err, io.EOF) {
+		// 请求体结束即用户结束说话，通知ASR立即结束识别
+		c.bodyEnded = true
+		return websocket.TextMessage, []byte(`{"type":"audio_end"}`), nil
+	}
+	atomic.StoreInt32(&c.isClosed, 1)
+	return 0, nil, ErrConnectionClosed
+}
+
+func (c *httpStreamConn) WriteMessage(messageType int, data []byte) error {
+	if atomic.LoadInt32(&c.isClosed) == 1 {
+		return ErrConnectionClosed
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if atomic.LoadInt32(&c.isClosed) == 1 {
+		return ErrConnectionClosed
+	}
+
+	var event string
+	if messageType == websocket.BinaryMessage {
+		event = "event: audio\ndata: " + base64.StdEncoding.EncodeToString(data) + "\n\n"
+	} else {
+		event = "data: " + string(data) + "\n\n"
+	}
+	sentBytesCounter.Add(float64(len(data)), messageTypeLabel(messageType), strconv.FormatBool(false))
+
+	_ = c.rc.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	if _, err := io.WriteString(c.w, event); err != nil {
+		atomic.StoreInt32(&c.isClosed, 1)
+		return ErrConnectionClosed
+	}
+	if err := c.rc.Flush(); err != nil {
+		atomic.StoreInt32(&c.isClosed, 1)
+		return ErrConnectionClosed
+	}
+	return nil
+}
+
+func (c *httpStreamConn) Close() error {
+	return c.CloseWithReason(CloseCodeNormal, "connection closed")
+}
+
+func (c *httpStreamConn) CloseWithReason(code int, reason string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if atomic.CompareAndSwapInt32(&c.isClosed, 0, 1) {
+		_ = c.rc.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		_, _ = fmt.Fprintf(c.w, "event: close\ndata: {\"code\":%d,\"reason\":%q}\n\n", code, reason)
+		_ = c.rc.Flush()
+	}
+	// 立即结束阻塞中的请求体读取
+	_ = c.rc.SetReadDeadline(time.Now())
+	return nil
+}
+
+func (c *httpStreamConn) IsClosed() bool {
+	return atomic.LoadInt32(&c.isClosed) == 1
+}
@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHTTPStreamConn(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := newHTTPStreamConn(w, r, 5*time.Second)
+		if err != nil {
+			t.Errorf("new http stream conn: %v", err)
+			return
+		}
+		read := func(wantType int, want string) {
+			typ, data, err := conn.ReadMessage()
+			if err != nil || typ != wantType || string(data) != want {
+				t.Errorf("read = %d %q %v, want %d %q", typ, data, err, wantType, want)
+			}
+		}
+
+		// 未携带 hello 时使用默认配置
+		read(websocket.TextMessage, defaultStreamHello)
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"tts"}`)); err != nil {
+			t.Errorf("write text: %v", err)
+		}
+		if err := conn.WriteMessage(websocket.BinaryMessage, []byte{1, 2}); err != nil {
+			t.Errorf("write binary: %v", err)
+		}
+		// 请求体为音频，结束时转为 audio_end 消息，之后不再有客户端消息
+		read(websocket.BinaryMessage, "abc")
+		read(websocket.TextMessage, `{"type":"audio_end"}`)
+		if _, _, err := conn.ReadMessage(); !errors.Is(err, ErrInputEnded) {
+			t.Errorf("read after body end = %v, want ErrInputEnded", err)
+		}
+
+		_ = conn.CloseWithReason(CloseCodeNormal, "bye")
+		if !conn.IsClosed() {
+			t.Error("IsClosed = false after close")
+		}
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(`{}`)); !errors.Is(err, ErrConnectionClosed) {
+			t.Errorf("write after close = %v, want ErrConnectionClosed", err)
+		}
+	}))
+	defer ts.Close()
+
+	body, upload := io.Pipe()
+	resp, err := http.Post(ts.URL, "application/octet-stream", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("content type = %q", got)
+	}
+	// 响应头下发后仍可继续上传
+	if _, err := upload.Write([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	_ = upload.Close()
+
+	events, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "data: {\"type\":\"tts\"}\n\n" +
+		"event: audio\ndata: " + base64.StdEncoding.EncodeToString([]byte{1, 2}) + "\n\n" +
+		"event: close\ndata: {\"code\":1000,\"reason\":\"bye\"}\n\n"
+	if string(events) != want {
+		t.Fatalf("events = %q, want %q", events, want)
+	}
+}
+
+func TestHTTPStreamHello(t *testing.T) {
+	// 服务端将读取到的 hello 消息原样下发
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := newHTTPStreamConn(w, r, time.Second)
+		if err != nil {
+			t.Errorf("new http stream conn: %v", err)
+			return
+		}
+		if _, data, err := conn.ReadMessage(); err == nil {
+			_ = conn.WriteMessage(websocket.TextMessage, data)
+		}
+	}))
+	defer ts.Close()
+
+	hello := `{"type":"hello","enable_asr":true}`
+	tests := []struct {
+		name   string
+		query  string
+		header string
+		want   string
+	}{
+		{"default", "", "", defaultStreamHello},
+		{"query", hello, "", hello},
+		{"header", "", hello, hello},
+		{"query first", hello, `{"type":"hello"}`, hello},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"?hello="+url.QueryEscape(tt.query), http.NoBody)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.header != "" {
+				req.Header.Set("X-Crow-Hello", tt.header)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			events, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "data: " + tt.want + "\n\n"; string(events) != want {
+				t.Fatalf("events = %q, want %q", events, want)
+			}
+		})
+	}
+}
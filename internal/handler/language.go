@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"strings"
+
+	"crow/internal/agent/prompt"
+	"crow/internal/asr"
+)
+
+const (
+	languageModeFollow = "follow" // 跟随用户的语种切换
+	languageModeLock   = "lock"   // 切换到首个稳定识别出的语种后不再切换
+
+	defaultSwitchAfter = 2
+)
+
+// trackLanguage 根据识别出的语种切换TTS的语种与发音人
+// 连续 SwitchAfter 句识别为同一语种才切换，仅在ASR结果回调中调用
+func (h *Handler) trackLanguage(detail asr.Detail) {
+	h.cfgLock.Lock()
+	defer h.cfgLock.Unlock()
+	mode := h.cfg.Language.Mode
+	if (mode != languageModeFollow && mode != languageModeLock) || h.langLocked || h.ttsCfg == nil {
+		return
+	}
+	language := normalizeLanguage(detail.Language)
+	if language == "" {
+		return
+	}
+	if language == normalizeLanguage(h.ttsCfg.Language) && mode == languageModeFollow {
+		h.langCandidate, h.langCount = "", 0
+		return
+	}
+
+	if language != h.langCandidate {
+		h.langCandidate, h.langCount = language, 0
+	}
+	h.langCount++
+	switchAfter := h.cfg.Language.SwitchAfter
+	if switchAfter <= 0 {
+		switchAfter = defaultSwitchAfter
+	}
+	if h.langCount < switchAfter {
+		return
+	}
+
+	h.langCandidate, h.langCount = "", 0
+	h.langLocked = mode == languageModeLock
+	if language == normalizeLanguage(h.ttsCfg.Language) {
+		h.log.Infof("tts language locked: %s", language)
+		return
+	}
+	cfg := *h.ttsCfg
+	cfg.Language = language
+	if speaker, ok := h.cfg.Language.Speakers[language]; ok && speaker != "" {
+		cfg.Speaker = speaker
+	}
+	h.ttsCfg = h.ttsProvider.SetConfig(&cfg)
+	h.updateInfo(func(info *SessionInfo) {
+		info.TtsLanguage = h.ttsCfg.Language
+		info.TtsSpeaker = h.ttsCfg.Speaker
+	})
+	h.log.Infof("switch tts language to %s, speaker: %s, locked: %v", h.ttsCfg.Language, h.ttsCfg.Speaker, h.langLocked)
+}
+
+// voiceContext 本次会话实际生效的识别与合成语种，每轮对话开始时提供给大模型，使回复的语种与语音合成一致
+// 语种可能在ASR回调中切换，因此从会话概要信息中读取
+func (h *Handler) voiceContext() string {
+	h.infoLock.Lock()
+	asrLanguage, ttsLanguage := h.info.AsrLanguage, h.info.TtsLanguage
+	h.infoLock.Unlock()
+	return prompt.WithVoiceContext("", asrLanguage, ttsLanguage)
+}
+
+// localizedReply 按本次会话的语音合成语种选择回复，k: 语种，优先使用配置的回复，未配置该语种时使用内置的回复，
+// 内置回复也不支持该语种或未开启TTS时使用中文
+func (h *Handler) localizedReply(configured, defaults map[string]string) string {
+	language := "zh"
+	if cfg := h.ttsConfig(); cfg != nil && cfg.Language != "" {
+		language = normalizeLanguage(cfg.Language)
+	}
+	if reply := configured[language]; reply != "" {
+		return reply
+	}
+	if reply := defaults[language]; reply != "" {
+		return reply
+	}
+	return defaults["zh"]
+}
+
+// normalizeLanguage 统一不同服务商的语种标识，如 zh-CN、zh_cn 均视为 zh
+func normalizeLanguage(language string) string {
+	language = strings.ToLower(strings.TrimSpace(language))
+	if i := strings.IndexAny(language, "-_"); i > 0 {
+		language = language[:i]
+	}
+	return language
+}
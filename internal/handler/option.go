@@ -0,0 +1,28 @@
+package handler
+
+import (
+	"crow/internal/agent/llm"
+	"crow/internal/asr"
+	"crow/internal/tts"
+)
+
+// Option 创建 Handler 时的可选配置
+type Option func(h *Handler)
+
+// Providers 替代按配置创建的服务商，用于重放录制的会话等不访问服务商的场景
+// 使用替代的服务商时不开启唤醒词检测与本地端点检测，决定工具调用时不区分 agent.tool_llm，且不提供任何工具
+type Providers interface {
+	// Asr 本次会话的ASR服务，为 nil 表示不支持识别
+	Asr() asr.Provider
+	// LLM 本次会话的大模型
+	LLM() llm.LLM
+	// Tts 本次会话的TTS服务，为 nil 表示不支持合成
+	Tts() tts.Provider
+}
+
+// WithProviders 使用 providers 提供的服务商，而非按配置创建
+func WithProviders(providers Providers) Option {
+	return func(h *Handler) {
+		h.providers = providers
+	}
+}
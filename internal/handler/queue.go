@@ -0,0 +1,80 @@
+package handler
+
+import "crow/pkg/metrics"
+
+const (
+	defaultClientQueueSize = 100
+
+	queuePolicyBlock      = "block"
+	queuePolicyDropOldest = "drop_oldest"
+)
+
+var (
+	clientQueueLength = metrics.NewHistogramVec("crow_client_queue_length",
+		"Messages already waiting in the per-session client queue when a new one arrives.",
+		[]float64{0, 1, 2, 5, 10, 20, 50, 100, 200, 500}, "queue")
+	clientQueueFullCounter = metrics.NewCounterVec("crow_client_queue_full_total",
+		"Client messages that arrived while the per-session queue was full.", "queue", "policy")
+	clientQueueDroppedCounter = metrics.NewCounterVec("crow_client_queue_dropped_total",
+		"Client messages dropped from a full per-session queue under the drop_oldest policy.", "queue")
+)
+
+// queueSize 客户端消息队列的容量，未配置时使用默认值
+func queueSize(size int) int {
+	if size <= 0 {
+		return defaultClientQueueSize
+	}
+	return size
+}
+
+// enqueueAudio 将客户端音频放入队列，nil 为客户端结束说话的标记，队列已满时也会等待放入
+func (h *Handler) enqueueAudio(audio []byte) {
+	policy := h.cfg.Server.ClientQueuePolicy
+	if audio == nil {
+		policy = queuePolicyBlock
+	}
+	enqueue(h, h.clientAudioQueue, audio, "audio", policy)
+}
+
+// enqueueText 将客户端文本消息放入队列
+func (h *Handler) enqueueText(text string) {
+	enqueue(h, h.clientTextQueue, text, "text", h.cfg.Server.ClientQueuePolicy)
+}
+
+// enqueue 放入队列，队列已满时按策略等待或丢弃最早的消息，会话结束时放弃等待
+func enqueue[T any](h *Handler, queue chan T, v T, name, policy string) {
+	clientQueueLength.Observe(float64(len(queue)), name)
+	select {
+	case queue <- v:
+		return
+	default:
+	}
+
+	if policy != queuePolicyDropOldest {
+		policy = queuePolicyBlock
+	}
+	clientQueueFullCounter.Inc(name, policy)
+	if policy == queuePolicyDropOldest {
+		h.log.Warnf("client %s queue is full, drop the oldest message", name)
+		// 消费者可能同时取出消息，丢弃一条后仍放不进时再次尝试
+		for range 3 {
+			select {
+			case <-queue:
+				clientQueueDroppedCounter.Inc(name)
+			default:
+			}
+			select {
+			case queue <- v:
+				return
+			default:
+			}
+		}
+	} else {
+		h.log.Warnf("client %s queue is full, wait for the queue to drain", name)
+	}
+
+	select {
+	case queue <- v:
+	case <-h.stopChan:
+	}
+}
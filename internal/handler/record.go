@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 录制的消息方向
+const (
+	RecordDirIn  = "in"  // 客户端上行
+	RecordDirOut = "out" // 服务端下行
+)
+
+// 录制的消息类型
+const (
+	RecordTypeText   = "text"
+	RecordTypeBinary = "binary"
+	RecordTypeClose  = "close" // 连接关闭，上行为客户端断开，下行为服务端携带关闭码关闭
+)
+
+// RecordEntry 会话录制中的一条消息，录制文件每行为一条 JSON 格式的消息
+type RecordEntry struct {
+	Time   int64  `json:"time"` // 相对会话开始的时间，单位毫秒
+	Dir    string `json:"dir"`
+	Type   string `json:"type"`
+	Text   string `json:"text,omitempty"`   // 文本消息，其中的敏感字段已脱敏
+	Data   []byte `json:"data,omitempty"`   // 二进制消息，即客户端上传的音频
+	Code   int    `json:"code,omitempty"`   // 关闭码，仅服务端关闭时有值
+	Reason string `json:"reason,omitempty"` // 关闭原因
+}
+
+// startRecording 配置了录制目录时录制本次会话，须在开始处理会话前调用
+func (h *Handler) startRecording() {
+	dir := h.cfg.Server.RecordDir
+	if dir == "" {
+		return
+	}
+	conn, err := newRecordConn(h.conn, dir, h.sessionID, h.cfg.Tools.RedactKeys)
+	if err != nil {
+		h.log.Errorf("failed to record session: %v", err)
+		return
+	}
+	h.conn = conn
+	h.log.Infof("recording session %s to %s", h.sessionID, conn.file.Name())
+}
+
+// recordConn 录制经过连接的所有消息，用于复现问题：录制文件可随问题反馈提交，通过 cmd/session-replay 重放
+// 文本消息中的敏感字段（如令牌、密码）按 tools.redact_keys 脱敏后再写入
+type recordConn struct {
+	Connection
+	start      time.Time
+	redactKeys []string
+
+	lock   sync.Mutex
+	file   *os.File
+	closed bool
+}
+
+// newRecordConn 在 dir 下创建以会话ID命名的录制文件
+func newRecordConn(conn Connection, dir, sessionID string, redactKeys []string) (*recordConn, error) {
+	if len(redactKeys) == 0 {
+		redactKeys = defaultRedactKeys
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create record dir: %v", err)
+	}
+	file, err := os.OpenFile(filepath.Join(dir, sessionID+".jsonl"), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create record file: %v", err)
+	}
+	return &recordConn{
+		Connection: conn,
+		start:      time.Now(),
+		redactKeys: redactKeys,
+		file:       file,
+	}, nil
+}
+
+func (c *recordConn) ReadMessage() (messageType int, p []byte, err error) {
+	messageType, p, err = c.Connection.ReadMessage()
+	switch {
+	case errors.Is(err, ErrInputEnded):
+	case err != nil:
+		c.record(RecordEntry{Dir: RecordDirIn, Type: RecordTypeClose})
+	default:
+		c.record(NewRecordEntry(RecordDirIn, messageType, p, c.redactKeys))
+	}
+	return messageType, p, err
+}
+
+func (c *recordConn) WriteMessage(messageType int, data []byte) error {
+	err := c.Connection.WriteMessage(messageType, data)
+	if err == nil {
+		c.record(NewRecordEntry(RecordDirOut, messageType, data, c.redactKeys))
+	}
+	return err
+}
+
+func (c *recordConn) Close() error {
+	return c.CloseWithReason(CloseCodeNormal, "connection closed")
+}
+
+func (c *recordConn) CloseWithReason(code int, reason string) error {
+	wasClosed := c.Connection.IsClosed()
+	err := c.Connection.CloseWithReason(code, reason)
+	if !wasClosed {
+		c.record(RecordEntry{Dir: RecordDirOut, Type: RecordTypeClose, Code: code, Reason: reason})
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.closed {
+		c.closed = true
+		_ = c.file.Close()
+	}
+	return err
+}
+
+// newRecordEntry 生成一条消息的录制，文本消息为 JSON 时脱敏后重新编码
+func NewRecordEntry(dir string, messageType int, data []byte, redactKeys []string) RecordEntry {
+	if messageType == websocket.BinaryMessage {
+		return RecordEntry{Dir: dir, Type: RecordTypeBinary, Data: data}
+	}
+	text := string(data)
+	var value any
+	if json.Unmarshal(data, &value) == nil {
+		if redacted, err := json.Marshal(redactValue(value, redactKeys)); err == nil {
+			text = string(redacted)
+		}
+	}
+	return RecordEntry{Dir: dir, Type: RecordTypeText, Text: text}
+}
+
+func (c *recordConn) record(entry RecordEntry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return
+	}
+	entry.Time = time.Since(c.start).Milliseconds()
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	// 逐条写入文件而不缓冲，进程异常退出时录制仍可用于复现
+	_, _ = c.file.Write(append(data, '\n'))
+}
+
+// ReadRecording 读取会话录制文件的全部消息
+func ReadRecording(r io.Reader) ([]RecordEntry, error) {
+	var entries []RecordEntry
+	scanner := bufio.NewScanner(r)
+	// 单条消息可能是较大的音频
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry RecordEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("failed to parse record entry %d: %v", len(entries)+1, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read recording: %v", err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// redactedValue 敏感参数脱敏后的值
+	redactedValue = "***"
+	// toolResultPreviewRunes 工具调用事件中执行结果预览的最大字符数
+	toolResultPreviewRunes = 200
+)
+
+// defaultRedactKeys 未配置时视为敏感的参数名
+var defaultRedactKeys = []string{"password", "passwd", "secret", "token", "api_key", "apikey", "authorization", "cookie"}
+
+// redactArguments 将工具参数中的敏感字段替换为 redactedValue，参数名包含任意敏感词（忽略大小写）即视为敏感，嵌套的对象与数组同样处理
+// @return 脱敏后的参数对象，参数不是合法的JSON时返回 nil
+func redactArguments(arguments string, keys []string) any {
+	if len(keys) == 0 {
+		keys = defaultRedactKeys
+	}
+	if strings.TrimSpace(arguments) == "" {
+		return map[string]any{}
+	}
+	var value any
+	if err := json.Unmarshal([]byte(arguments), &value); err != nil {
+		return nil
+	}
+	return redactValue(value, keys)
+}
+
+func redactValue(value any, keys []string) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for k, item := range v {
+			if isSensitiveKey(k, keys) {
+				v[k] = redactedValue
+				continue
+			}
+			v[k] = redactValue(item, keys)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = redactValue(item, keys)
+		}
+	}
+	return value
+}
+
+func isSensitiveKey(key string, keys []string) bool {
+	key = strings.ToLower(key)
+	for _, k := range keys {
+		if k != "" && strings.Contains(key, strings.ToLower(k)) {
+			return true
+		}
+	}
+	return false
+}
+
+// previewResult 截取执行结果的开头部分用于展示，不截断多字节字符
+func previewResult(result string) string {
+	if utf8.RuneCountInString(result) <= toolResultPreviewRunes {
+		return result
+	}
+	runes := []rune(result)
+	return string(runes[:toolResultPreviewRunes]) + "..."
+}
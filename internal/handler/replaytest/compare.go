@@ -0,0 +1,90 @@
+package replaytest
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"crow/internal/handler"
+)
+
+// signature 下发消息中用于比对的部分，忽略会话ID、分片序号等每次会话都不同的字段
+func signature(entry handler.RecordEntry) (kind, sig string) {
+	switch entry.Type {
+	case handler.RecordTypeClose:
+		return "close", fmt.Sprintf("close %d %s", entry.Code, entry.Reason)
+	case handler.RecordTypeBinary:
+		return "binary", fmt.Sprintf("binary %d bytes", len(entry.Data))
+	}
+	var msg struct {
+		Type      string `json:"type"`
+		ErrorCode int    `json:"error_code"`
+		Result    string `json:"result"`
+		Text      string `json:"text"`
+		State     int    `json:"state"`
+		IsFinal   bool   `json:"is_final"`
+	}
+	if err := json.Unmarshal([]byte(entry.Text), &msg); err != nil {
+		return "text", entry.Text
+	}
+	switch msg.Type {
+	case "asr":
+		return msg.Type, fmt.Sprintf("asr %q final=%v", msg.Result, msg.IsFinal)
+	case "chat":
+		return msg.Type, fmt.Sprintf("chat %q final=%v", msg.Text, msg.IsFinal)
+	case "tts":
+		// 音频内容来自录制，仅比对合成状态
+		return msg.Type, fmt.Sprintf("tts state=%d final=%v", msg.State, msg.IsFinal)
+	}
+	return msg.Type, fmt.Sprintf("%s error_code=%d", msg.Type, msg.ErrorCode)
+}
+
+// Summary 下发消息的概要，即比对时使用的部分
+func Summary(entry handler.RecordEntry) string {
+	_, sig := signature(entry)
+	return sig
+}
+
+// Compare 比对录制与重放中下发的消息，返回每种消息的首个差异，为空表示一致
+// 按消息类型分别比对下发顺序，不同类型的消息之间的先后受时序影响，不作比对
+func Compare(recorded, replayed []handler.RecordEntry) []string {
+	group := func(entries []handler.RecordEntry) (map[string][]string, []string) {
+		groups := make(map[string][]string)
+		var kinds []string
+		for _, entry := range entries {
+			kind, sig := signature(entry)
+			if _, ok := groups[kind]; !ok {
+				kinds = append(kinds, kind)
+			}
+			groups[kind] = append(groups[kind], sig)
+		}
+		return groups, kinds
+	}
+	want, kinds := group(recorded)
+	got, gotKinds := group(replayed)
+	for _, kind := range gotKinds {
+		if _, ok := want[kind]; !ok {
+			kinds = append(kinds, kind)
+		}
+	}
+
+	var diffs []string
+	for _, kind := range kinds {
+		w, g := want[kind], got[kind]
+		// 每种消息只报告首个差异，之后的消息通常随之错位
+		for i := 0; i < max(len(w), len(g)); i++ {
+			if i < len(w) && i < len(g) && w[i] == g[i] {
+				continue
+			}
+			switch {
+			case i >= len(w):
+				diffs = append(diffs, fmt.Sprintf("%s #%d: unexpected %s", kind, i+1, g[i]))
+			case i >= len(g):
+				diffs = append(diffs, fmt.Sprintf("%s #%d: missing %s", kind, i+1, w[i]))
+			default:
+				diffs = append(diffs, fmt.Sprintf("%s #%d: recorded %s, replayed %s", kind, i+1, w[i], g[i]))
+			}
+			break
+		}
+	}
+	return diffs
+}
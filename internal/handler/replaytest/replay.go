@@ -0,0 +1,487 @@
+// Package replaytest 重放录制的会话，ASR、大模型与TTS均替换为回放录制结果的模拟服务，用于确定性地复现问题
+package replaytest
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"crow/internal/agent/llm"
+	"crow/internal/asr"
+	"crow/internal/config"
+	"crow/internal/handler"
+	"crow/internal/model"
+	"crow/internal/tts"
+	"crow/pkg/lifecycle"
+	"crow/pkg/log"
+)
+
+// replayEndGrace 录制的消息全部重放后，等待会话自行结束的时间
+const replayEndGrace = time.Second
+
+// Replay 将录制的会话重放给 handler.Handler，用于确定性地复现问题
+// 客户端消息按录制的时间点送入，ASR、大模型与TTS均替换为按录制的下发结果回放的模拟服务，不访问任何服务商；
+// 重放时不调用工具，不开启唤醒词检测，会话记忆仅保存在进程内
+// @param speed 重放速度的倍数，不大于0时按原速重放
+// @param out 接收重放过程中下发的消息，时间已换算为录制中的时间
+func Replay(ctx context.Context, cfg *config.Config, log *log.Logger, entries []handler.RecordEntry, speed float64, out func(handler.RecordEntry)) {
+	if speed <= 0 {
+		speed = 1
+	}
+	replayCfg := *cfg
+	replayCfg.Server.RecordDir = ""
+	replayCfg.Memory.Backend = ""
+
+	clock := &replayClock{start: time.Now(), speed: speed, done: make(chan struct{})}
+	defer clock.stop()
+	stop := context.AfterFunc(ctx, clock.stop)
+	defer stop()
+
+	conn := newReplayConn(clock, entries, replayCfg.Tools.RedactKeys, out)
+	h := handler.NewHandler(&replayCfg, log, conn, handler.WithProviders(newReplaySource(clock, entries)))
+	h.Handle(ctx)
+}
+
+// replayClock 重放的时钟，将录制中的时间点按重放速度换算为实际时间
+type replayClock struct {
+	start time.Time
+	speed float64
+	done  chan struct{} // 重放结束的信号
+	once  sync.Once
+}
+
+func (c *replayClock) scale(ms int64) time.Duration {
+	return time.Duration(float64(ms) * float64(time.Millisecond) / c.speed)
+}
+
+// now 当前对应录制中的时间，单位毫秒
+func (c *replayClock) now() int64 {
+	return int64(float64(time.Since(c.start).Milliseconds()) * c.speed)
+}
+
+// waitUntil 等待至录制中的时间点 ms
+// @return 重放已结束或 cancel 已关闭时返回 false
+func (c *replayClock) waitUntil(ms int64, cancel <-chan struct{}) bool {
+	return c.sleep(time.Until(c.start.Add(c.scale(ms))), cancel)
+}
+
+func (c *replayClock) sleep(d time.Duration, cancel <-chan struct{}) bool {
+	if d <= 0 {
+		d = 0
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-c.done:
+		return false
+	case <-cancel:
+		return false
+	}
+}
+
+func (c *replayClock) stop() {
+	c.once.Do(func() { close(c.done) })
+}
+
+// replayConn 重放录制的客户端消息，并将下发的消息交给 out
+type replayConn struct {
+	clock      *replayClock
+	inbound    []handler.RecordEntry
+	end        int64 // 录制中最后一条消息的时间
+	next       int   // 下一条待重放的客户端消息，仅在读取协程中使用
+	redactKeys []string
+
+	lock   sync.Mutex
+	out    func(handler.RecordEntry)
+	closed bool
+}
+
+// newReplayConn 创建重放的连接，下发的消息与录制时同样脱敏，以便与录制比对
+func newReplayConn(clock *replayClock, entries []handler.RecordEntry, redactKeys []string, out func(handler.RecordEntry)) *replayConn {
+	c := &replayConn{clock: clock, redactKeys: redactKeys, out: out}
+	for _, entry := range entries {
+		if entry.Dir == handler.RecordDirIn {
+			c.inbound = append(c.inbound, entry)
+		}
+		c.end = max(c.end, entry.Time)
+	}
+	return c
+}
+
+func (c *replayConn) ReadMessage() (messageType int, p []byte, err error) {
+	if c.next >= len(c.inbound) {
+		// 客户端消息已全部重放，等待至录制结束时断开
+		c.clock.waitUntil(c.end+replayEndGrace.Milliseconds(), nil)
+		c.disconnect()
+		return 0, nil, handler.ErrConnectionClosed
+	}
+	entry := c.inbound[c.next]
+	c.next++
+	if !c.clock.waitUntil(entry.Time, nil) {
+		return 0, nil, handler.ErrConnectionClosed
+	}
+	switch entry.Type {
+	case handler.RecordTypeClose:
+		c.disconnect()
+		return 0, nil, handler.ErrConnectionClosed
+	case handler.RecordTypeBinary:
+		return websocket.BinaryMessage, entry.Data, nil
+	}
+	return websocket.TextMessage, []byte(entry.Text), nil
+}
+
+func (c *replayConn) WriteMessage(messageType int, data []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return handler.ErrConnectionClosed
+	}
+	entry := handler.NewRecordEntry(handler.RecordDirOut, messageType, data, c.redactKeys)
+	entry.Time = c.clock.now()
+	c.out(entry)
+	return nil
+}
+
+func (c *replayConn) Close() error {
+	return c.CloseWithReason(handler.CloseCodeNormal, "connection closed")
+}
+
+func (c *replayConn) CloseWithReason(code int, reason string) error {
+	c.lock.Lock()
+	if !c.closed {
+		c.closed = true
+		c.out(handler.RecordEntry{Time: c.clock.now(), Dir: handler.RecordDirOut, Type: handler.RecordTypeClose, Code: code, Reason: reason})
+	}
+	c.lock.Unlock()
+	c.clock.stop()
+	return nil
+}
+
+// disconnect 客户端断开，与 websocket 连接相同，之后服务端关闭连接时不再下发关闭帧
+func (c *replayConn) disconnect() {
+	c.lock.Lock()
+	c.closed = true
+	c.lock.Unlock()
+}
+
+func (c *replayConn) IsClosed() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.closed
+}
+
+// replayChunk 录制中下发的一条识别结果、回复分片或音频分片
+type replayChunk struct {
+	time   int64 // ASR为录制中的时间，回复与音频为相对本轮首个分片的时间，单位毫秒
+	text   string
+	state  int
+	words  []asr.Word
+	isLast bool // 是否为本轮的最后一个分片
+}
+
+// replaySource 重放时替代服务商的模拟服务
+type replaySource struct {
+	asr *replayAsr
+	llm *replayLLM
+	tts *replayTts
+}
+
+func (s *replaySource) Asr() asr.Provider { return s.asr }
+func (s *replaySource) LLM() llm.LLM      { return s.llm }
+func (s *replaySource) Tts() tts.Provider { return s.tts }
+
+// newReplaySource 从录制的下发消息中还原各服务商的结果
+// 回复与音频按轮次还原：本轮的最后一个分片、新的一句识别结果或新的客户端文本消息均视为上一轮结束，
+// 录制中被打断且未下发任何回复的轮次无法还原，此后的轮次会错位
+func newReplaySource(clock *replayClock, entries []handler.RecordEntry) *replaySource {
+	var (
+		asrResults           []replayChunk
+		replies, audios      [][]replayChunk
+		reply, audio         []replayChunk
+		replyStart, ttsStart int64
+		replyOpen, audioOpen bool
+	)
+	endReply := func() {
+		if replyOpen {
+			replies, reply, replyOpen = append(replies, reply), nil, false
+		}
+	}
+	endAudio := func() {
+		if audioOpen {
+			audios, audio, audioOpen = append(audios, audio), nil, false
+		}
+	}
+
+	for _, entry := range entries {
+		if entry.Type != handler.RecordTypeText {
+			continue
+		}
+		if entry.Dir == handler.RecordDirIn {
+			endReply()
+			endAudio()
+			continue
+		}
+		var msg struct {
+			Type    string          `json:"type"`
+			Result  string          `json:"result"`
+			Text    string          `json:"text"`
+			Audio   string          `json:"audio"`
+			State   int             `json:"state"`
+			IsFinal bool            `json:"is_final"`
+			Words   []model.AsrWord `json:"words"`
+		}
+		if json.Unmarshal([]byte(entry.Text), &msg) != nil {
+			continue
+		}
+		switch msg.Type {
+		case "asr":
+			chunk := replayChunk{time: entry.Time, text: msg.Result, state: msg.State}
+			for _, w := range msg.Words {
+				chunk.words = append(chunk.words, asr.Word{Text: w.Text, Punctuation: w.Punctuation, BeginTime: w.BeginTime, EndTime: w.EndTime})
+			}
+			asrResults = append(asrResults, chunk)
+			if msg.IsFinal {
+				endReply()
+				endAudio()
+			}
+		case "chat":
+			if !replyOpen {
+				replyStart, replyOpen = entry.Time, true
+			}
+			if msg.Text != "" {
+				reply = append(reply, replayChunk{time: entry.Time - replyStart, text: msg.Text})
+			}
+			if msg.IsFinal {
+				endReply()
+			}
+		case "tts":
+			if !audioOpen {
+				ttsStart, audioOpen = entry.Time, true
+			}
+			audio = append(audio, replayChunk{time: entry.Time - ttsStart, text: msg.Audio, state: msg.State, isLast: msg.IsFinal})
+			if msg.IsFinal {
+				endAudio()
+			}
+		}
+	}
+	endReply()
+	endAudio()
+
+	return &replaySource{
+		asr: &replayAsr{clock: clock, results: asrResults},
+		llm: &replayLLM{clock: clock, replies: replies, streams: make(chan chan string)},
+		tts: &replayTts{clock: clock, rounds: audios},
+	}
+}
+
+// replayProvider 模拟服务的公共部分，不建立任何连接
+type replayProvider struct{}
+
+func (replayProvider) Start(ctx context.Context) error { return nil }
+func (replayProvider) Stop() error                     { return nil }
+func (replayProvider) Status() lifecycle.Status        { return lifecycle.StatusRunning }
+func (replayProvider) Name() string                    { return "replay" }
+
+// replayAsr 按录制的时间点回放识别结果，与客户端实际发送的音频无关
+type replayAsr struct {
+	replayProvider
+	clock    *replayClock
+	results  []replayChunk
+	listener asr.Listener
+	once     sync.Once
+}
+
+func (a *replayAsr) SetConfig(cfg *asr.Config) *asr.Config { return cfg }
+func (a *replayAsr) SetListener(listener asr.Listener)     { a.listener = listener }
+func (a *replayAsr) GetSilenceCount() int                  { return 0 }
+func (a *replayAsr) Finalize() error                       { return nil }
+func (a *replayAsr) Abort() error                          { return nil }
+func (a *replayAsr) Reset() error                          { return nil }
+
+// SendAudio 收到首帧音频时开始回放识别结果
+func (a *replayAsr) SendAudio(ctx context.Context, data []byte) error {
+	a.once.Do(func() {
+		go a.play(ctx)
+	})
+	return nil
+}
+
+func (a *replayAsr) play(ctx context.Context) {
+	for _, result := range a.results {
+		if !a.clock.waitUntil(result.time, ctx.Done()) {
+			return
+		}
+		asr.NotifyDetail(ctx, a.listener, asr.Detail{Result: result.text, State: asr.State(result.state), Words: result.words})
+		a.listener.OnAsrResult(ctx, result.text, asr.State(result.state))
+	}
+}
+
+// replayLLM 每次请求按顺序回放录制中一轮的回复，不返回工具调用
+type replayLLM struct {
+	clock   *replayClock
+	replies [][]replayChunk
+
+	lock sync.Mutex
+	next int
+
+	streams chan chan string // 每次请求的回复分片，交给接收协程
+	cur     chan string      // 正在接收的回复分片，仅在接收协程中使用
+}
+
+func (l *replayLLM) Name() string { return "replay" }
+
+func (l *replayLLM) Handle(ctx context.Context, request *llm.Request) (*llm.Response, error) {
+	l.lock.Lock()
+	var reply []replayChunk
+	if l.next < len(l.replies) {
+		reply = l.replies[l.next]
+		l.next++
+	}
+	l.lock.Unlock()
+
+	stream := make(chan string)
+	select {
+	case l.streams <- stream:
+	case <-l.clock.done:
+		return nil, handler.ErrConnectionClosed
+	}
+	// 结束时关闭分片，接收协程据此返回 io.EOF
+	defer close(stream)
+
+	start := time.Now()
+	var content strings.Builder
+	for _, chunk := range reply {
+		if !l.clock.sleep(l.clock.scale(chunk.time)-time.Since(start), ctx.Done()) {
+			return &llm.Response{Content: content.String()}, ctx.Err()
+		}
+		select {
+		case stream <- chunk.text:
+			content.WriteString(chunk.text)
+		case <-ctx.Done():
+			return &llm.Response{Content: content.String()}, ctx.Err()
+		case <-l.clock.done:
+			return &llm.Response{Content: content.String()}, handler.ErrConnectionClosed
+		}
+	}
+	return &llm.Response{Content: content.String()}, nil
+}
+
+func (l *replayLLM) Recv() (string, error) {
+	if l.cur == nil {
+		select {
+		case l.cur = <-l.streams:
+		case <-l.clock.done:
+			return "", io.EOF
+		}
+	}
+	text, ok := <-l.cur
+	if !ok {
+		l.cur = nil
+		return "", io.EOF
+	}
+	return text, nil
+}
+
+func (l *replayLLM) Reset() error { return nil }
+
+// replayTts 每轮合成按顺序回放录制中一轮的音频，与实际合成的文本无关
+type replayTts struct {
+	replayProvider
+	clock    *replayClock
+	rounds   [][]replayChunk
+	listener tts.Listener
+
+	lock sync.Mutex
+	next int
+	cur  *replayTtsRun // 正在回放的一轮合成，为 nil 表示没有进行中的合成
+}
+
+// replayTtsRun 一轮合成的回放
+type replayTtsRun struct {
+	finish   chan struct{} // 本轮需要合成的文本已发送完毕
+	abort    chan struct{}
+	finished bool
+}
+
+func (t *replayTts) SetConfig(cfg *tts.Config) *tts.Config { return cfg }
+func (t *replayTts) SetListener(listener tts.Listener)     { t.listener = listener }
+
+// ToTTS 本轮首段文本到达时开始回放音频
+func (t *replayTts) ToTTS(ctx context.Context, text string) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.cur != nil {
+		return nil
+	}
+	var audio []replayChunk
+	if t.next < len(t.rounds) {
+		audio = t.rounds[t.next]
+		t.next++
+	}
+	t.cur = &replayTtsRun{finish: make(chan struct{}), abort: make(chan struct{})}
+	go t.play(t.cur, audio)
+	return nil
+}
+
+func (t *replayTts) play(run *replayTtsRun, audio []replayChunk) {
+	start := time.Now()
+	for _, chunk := range audio {
+		if chunk.isLast {
+			break
+		}
+		if !t.clock.sleep(t.clock.scale(chunk.time)-time.Since(start), run.abort) {
+			return
+		}
+		t.listener.OnTtsResult([]byte(chunk.text), tts.State(chunk.state))
+	}
+	// 文本全部发送后才结束本轮合成，录制中被打断的轮次同样在此时结束
+	select {
+	case <-run.finish:
+	case <-run.abort:
+		return
+	case <-t.clock.done:
+		return
+	}
+	var last []byte
+	if n := len(audio); n > 0 && audio[n-1].isLast {
+		last = []byte(audio[n-1].text)
+	}
+	t.lock.Lock()
+	if t.cur == run {
+		t.cur = nil
+	}
+	t.lock.Unlock()
+	t.listener.OnTtsResult(last, tts.StateCompleted)
+}
+
+func (t *replayTts) ToSessionFinish() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.cur != nil && !t.cur.finished {
+		t.cur.finished = true
+		close(t.cur.finish)
+	}
+	return nil
+}
+
+// Abort 中止本轮回放，录制中该轮剩余的音频不再回放
+func (t *replayTts) Abort() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.cur != nil {
+		close(t.cur.abort)
+		t.cur = nil
+	}
+	return nil
+}
+
+func (t *replayTts) Reset() error {
+	return t.Abort()
+}
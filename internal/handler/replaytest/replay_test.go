@@ -0,0 +1,98 @@
+package replaytest
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"crow/internal/config"
+	"crow/internal/handler"
+	"crow/pkg/log"
+)
+
+func newTestLogger() *log.Logger {
+	return log.NewLogger(&log.Option{Hook: io.Discard, Mode: "debug"})
+}
+
+// script 合成的会话：客户端发送 hello 与一段音频，识别出一句话后回复并合成两段音频，随后客户端断开
+var script = []handler.RecordEntry{
+	{Time: 0, Dir: handler.RecordDirIn, Type: handler.RecordTypeText,
+		Text: `{"type":"hello","enable_asr":true,"enable_tts":true,"asr_params":{"format":"pcm","sample_rate":16000,"channels":1},"token":"s3cret"}`},
+	{Time: 50, Dir: handler.RecordDirIn, Type: handler.RecordTypeBinary, Data: make([]byte, 640)},
+	{Time: 10, Dir: handler.RecordDirOut, Type: handler.RecordTypeText, Text: `{"type":"hello"}`},
+	{Time: 10, Dir: handler.RecordDirOut, Type: handler.RecordTypeText, Text: `{"type":"session_start"}`},
+	{Time: 100, Dir: handler.RecordDirOut, Type: handler.RecordTypeText, Text: `{"type":"asr","result":"你好","state":0,"is_final":false}`},
+	{Time: 150, Dir: handler.RecordDirOut, Type: handler.RecordTypeText, Text: `{"type":"asr","result":"你好。","state":1,"is_final":true}`},
+	{Time: 200, Dir: handler.RecordDirOut, Type: handler.RecordTypeText, Text: `{"type":"chat","text":"你好，","is_final":false}`},
+	{Time: 220, Dir: handler.RecordDirOut, Type: handler.RecordTypeText, Text: `{"type":"tts","audio":"AAEC","state":0,"is_final":false}`},
+	{Time: 250, Dir: handler.RecordDirOut, Type: handler.RecordTypeText, Text: `{"type":"chat","text":"有什么可以帮你？","is_final":false}`},
+	{Time: 260, Dir: handler.RecordDirOut, Type: handler.RecordTypeText, Text: `{"type":"chat","text":"","is_final":true}`},
+	{Time: 280, Dir: handler.RecordDirOut, Type: handler.RecordTypeText, Text: `{"type":"tts","audio":"AwQF","state":0,"is_final":false}`},
+	{Time: 300, Dir: handler.RecordDirOut, Type: handler.RecordTypeText, Text: `{"type":"tts","audio":"","state":1,"is_final":true}`},
+	{Time: 600, Dir: handler.RecordDirIn, Type: handler.RecordTypeClose},
+}
+
+// outbound 下发的消息
+func outbound(entries []handler.RecordEntry) []handler.RecordEntry {
+	var out []handler.RecordEntry
+	for _, entry := range entries {
+		if entry.Dir == handler.RecordDirOut {
+			out = append(out, entry)
+		}
+	}
+	return out
+}
+
+// record 以模拟服务运行合成的会话，返回 Handler 写入的录制
+func record(t *testing.T, cfg config.Config) []handler.RecordEntry {
+	t.Helper()
+	dir := t.TempDir()
+	cfg.Server.RecordDir = dir
+
+	clock := &replayClock{start: time.Now(), speed: 1, done: make(chan struct{})}
+	defer clock.stop()
+	conn := newReplayConn(clock, script, nil, func(handler.RecordEntry) {})
+	h := handler.NewHandler(&cfg, newTestLogger(), conn, handler.WithProviders(newReplaySource(clock, script)))
+	h.Handle(context.Background())
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("recording files = %v, %v", files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 客户端消息中的敏感字段脱敏后才写入
+	if strings.Contains(string(data), "s3cret") {
+		t.Fatalf("recording contains the token:\n%s", data)
+	}
+	entries, err := handler.ReadRecording(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestRecordReplay(t *testing.T) {
+	var cfg config.Config
+	recorded := record(t, cfg)
+	if !strings.Contains(recorded[0].Text, `"token":"***"`) {
+		t.Fatalf("hello is not redacted: %s", recorded[0].Text)
+	}
+	if diffs := Compare(outbound(script), outbound(recorded)); len(diffs) > 0 {
+		t.Fatalf("recording differs from the script:\n%s", strings.Join(diffs, "\n"))
+	}
+
+	var replayed []handler.RecordEntry
+	Replay(context.Background(), &cfg, newTestLogger(), recorded, 2, func(entry handler.RecordEntry) {
+		replayed = append(replayed, entry)
+	})
+	if diffs := Compare(outbound(recorded), replayed); len(diffs) > 0 {
+		t.Fatalf("replay differs from recording:\n%s", strings.Join(diffs, "\n"))
+	}
+}
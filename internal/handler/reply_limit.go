@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"strings"
+	"unicode"
+)
+
+// defaultReplyTruncatedSuffixes 未配置时回复被截断后追加的续说提示，k: 语音合成的语种
+var defaultReplyTruncatedSuffixes = map[string]string{
+	"zh": "……需要我继续说吗？",
+	"en": "... Shall I go on?",
+}
+
+// replyLimiter 限制每轮回复的字数，超出时在句子边界截断并追加续说提示，以控制按字数计费的TTS成本
+// 为保证只在句子边界截断，未结束的句子会暂不输出，直至遇到句末标点或本轮回复结束，仅在对话协程中使用
+type replyLimiter struct {
+	max       int
+	suffix    string
+	count     int    // 已输出的字数
+	pending   []rune // 尚未结束的句子
+	truncated bool   // 是否已截断，截断后丢弃本轮剩余的回复
+}
+
+// newReplyLimiter 创建回复字数限制器，max 不大于0时返回 nil，nil 限制器原样输出回复
+func newReplyLimiter(max int, suffix string) *replyLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &replyLimiter{max: max, suffix: suffix}
+}
+
+// Write 写入一段回复
+// @return 可以输出的内容，可能为空
+func (l *replyLimiter) Write(text string) string {
+	if l == nil {
+		return text
+	}
+	if l.truncated {
+		return ""
+	}
+	l.pending = append(l.pending, []rune(text)...)
+	end := lastSentenceEnd(l.pending)
+	if end == 0 {
+		return ""
+	}
+	sentences, rest := l.pending[:end], l.pending[end:]
+	l.pending = rest
+	return l.emit(sentences)
+}
+
+// Flush 本轮回复结束时输出尚未结束的句子
+func (l *replyLimiter) Flush() string {
+	if l == nil || l.truncated {
+		return ""
+	}
+	text := l.pending
+	l.pending = nil
+	return l.emit(text)
+}
+
+// emit 输出若干完整的句子，超出字数限制时只输出能完整容纳的句子并追加续说提示
+func (l *replyLimiter) emit(text []rune) string {
+	if l.count+len(text) <= l.max {
+		l.count += len(text)
+		return string(text)
+	}
+
+	var b strings.Builder
+	for len(text) > 0 {
+		end := firstSentenceEnd(text)
+		if l.count+end > l.max {
+			break
+		}
+		b.WriteString(string(text[:end]))
+		l.count += end
+		text = text[end:]
+	}
+	// 第一句就超出限制时无法在句子边界截断，只能按字数截断
+	if l.count == 0 {
+		b.WriteString(string(text[:l.max]))
+		l.count = l.max
+	}
+	l.truncated = true
+	l.pending = nil
+	b.WriteString(l.suffix)
+	return b.String()
+}
+
+// isSentenceEnd 第 i 个字符是否为句末标点，英文句号后须紧跟空白字符，避免截断小数
+func isSentenceEnd(text []rune, i int) bool {
+	switch text[i] {
+	case '。', '！', '？', '；', '!', '?', ';', '\n':
+		return true
+	case '.':
+		return i+1 < len(text) && unicode.IsSpace(text[i+1])
+	}
+	return false
+}
+
+// firstSentenceEnd 第一个句子结束后的位置，没有句末标点时为全部内容
+func firstSentenceEnd(text []rune) int {
+	for i := range text {
+		if isSentenceEnd(text, i) {
+			return i + 1
+		}
+	}
+	return len(text)
+}
+
+// lastSentenceEnd 最后一个句子结束后的位置，没有句末标点时返回0
+func lastSentenceEnd(text []rune) int {
+	for i := len(text) - 1; i >= 0; i-- {
+		if isSentenceEnd(text, i) {
+			return i + 1
+		}
+	}
+	return 0
+}
@@ -3,10 +3,15 @@ package handler
 import (
 	"encoding/json"
 	"fmt"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 
+	"crow/internal/agent"
+	"crow/internal/asr"
 	"crow/internal/model"
+	"crow/internal/tts"
 )
 
 func (h *Handler) sendErrorMessage(code int, msg string) error {
@@ -47,14 +52,45 @@ func (h *Handler) sendHelloMessage(msg model.HelloResponse) error {
 	return nil
 }
 
-func (h *Handler) sendAsrMessage(result string, state int) error {
+func (h *Handler) sendSetConfigMessage(msg model.SetConfigResponse) error {
+	msg.BaseResponse.Type = "set_config"
+	msg.BaseResponse.SessionID = h.sessionID
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal set_config message: %v", err)
+	}
+	if err = h.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		if h.conn.IsClosed() {
+			h.close()
+			return nil
+		}
+		return fmt.Errorf("failed to send set_config message: %v", err)
+	}
+	return nil
+}
+
+func (h *Handler) sendAsrMessage(result string, state int, words []asr.Word) error {
 	msg := model.AsrResponse{
 		BaseResponse: model.BaseResponse{
 			Type:      "asr",
 			SessionID: h.sessionID,
 		},
-		Result: result,
-		State:  state,
+		Result:    result,
+		State:     state,
+		IsFinal:   state != int(asr.StateProcessing),
+		SegmentID: h.asrSegment + 1,
+	}
+	// 本句结束后，之后的结果属于下一句
+	if msg.IsFinal {
+		h.asrSegment++
+	}
+	for _, w := range words {
+		msg.Words = append(msg.Words, model.AsrWord{
+			Text:        w.Text,
+			Punctuation: w.Punctuation,
+			BeginTime:   w.BeginTime,
+			EndTime:     w.EndTime,
+		})
 	}
 	data, err := json.Marshal(msg)
 	if err != nil {
@@ -70,13 +106,16 @@ func (h *Handler) sendAsrMessage(result string, state int) error {
 	return nil
 }
 
-func (h *Handler) sendChatMessage(text string) error {
+func (h *Handler) sendChatMessage(text string, isFinal bool) error {
 	msg := model.ChatResponse{
 		BaseResponse: model.BaseResponse{
 			Type:      "chat",
 			SessionID: h.sessionID,
 		},
-		Text: text,
+		Text:      text,
+		Seq:       atomic.AddInt64(&h.chatSeq, 1),
+		IsFinal:   isFinal,
+		MessageID: h.currentMessageID(),
 	}
 	data, err := json.Marshal(msg)
 	if err != nil {
@@ -92,14 +131,23 @@ func (h *Handler) sendChatMessage(text string) error {
 	return nil
 }
 
+// currentMessageID 本轮回复的标识，尚未开始对话时为空
+func (h *Handler) currentMessageID() string {
+	id, _ := h.messageID.Load().(string)
+	return id
+}
+
 func (h *Handler) sendTtsMessage(audio string, state int) error {
 	msg := model.TtsResponse{
 		BaseResponse: model.BaseResponse{
 			Type:      "tts",
 			SessionID: h.sessionID,
 		},
-		Audio: audio,
-		State: state,
+		Audio:     audio,
+		State:     state,
+		Seq:       atomic.AddInt64(&h.ttsSeq, 1),
+		IsFinal:   state == int(tts.StateCompleted),
+		MessageID: h.currentMessageID(),
 	}
 	data, err := json.Marshal(msg)
 	if err != nil {
@@ -114,3 +162,177 @@ func (h *Handler) sendTtsMessage(audio string, state int) error {
 	}
 	return nil
 }
+
+func (h *Handler) sendGoodbyeMessage(reason string) error {
+	msg := model.GoodbyeResponse{
+		BaseResponse: model.BaseResponse{
+			Type:      "goodbye",
+			SessionID: h.sessionID,
+		},
+		Reason: reason,
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal goodbye message: %v", err)
+	}
+	if err = h.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		if h.conn.IsClosed() {
+			h.close()
+			return nil
+		}
+		return fmt.Errorf("failed to send goodbye message: %v", err)
+	}
+	return nil
+}
+
+func (h *Handler) sendToolCallMessage(call agent.ToolCall) error {
+	msg := model.ToolCallResponse{
+		BaseResponse: model.BaseResponse{
+			Type:      "tool_call",
+			SessionID: h.sessionID,
+		},
+		ID:        call.ID,
+		Name:      call.Name,
+		Arguments: redactArguments(call.Arguments, h.cfg.Tools.RedactKeys),
+		Status:    "started",
+	}
+	if call.Finished {
+		msg.Status = "finished"
+		msg.IsError = call.Failed
+		msg.ResultPreview = previewResult(call.Result)
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal tool call message: %v", err)
+	}
+	if err = h.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		if h.conn.IsClosed() {
+			h.close()
+			return nil
+		}
+		return fmt.Errorf("failed to send tool call message: %v", err)
+	}
+	return nil
+}
+
+func (h *Handler) sendReasoningMessage(text string) error {
+	msg := model.ReasoningResponse{
+		BaseResponse: model.BaseResponse{
+			Type:      "reasoning",
+			SessionID: h.sessionID,
+		},
+		Text: text,
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal reasoning message: %v", err)
+	}
+	if err = h.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		if h.conn.IsClosed() {
+			h.close()
+			return nil
+		}
+		return fmt.Errorf("failed to send reasoning message: %v", err)
+	}
+	return nil
+}
+
+func (h *Handler) sendDegradeMessage(module, fallback string, switched bool) error {
+	msg := model.DegradeResponse{
+		BaseResponse: model.BaseResponse{
+			Type:      "degrade",
+			SessionID: h.sessionID,
+		},
+		Module:   module,
+		Fallback: fallback,
+		Switched: switched,
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal degrade message: %v", err)
+	}
+	if err = h.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		if h.conn.IsClosed() {
+			h.close()
+			return nil
+		}
+		return fmt.Errorf("failed to send degrade message: %v", err)
+	}
+	return nil
+}
+
+func (h *Handler) sendSessionStartMessage(capabilities model.Capabilities) error {
+	msg := model.SessionStartResponse{
+		BaseResponse: model.BaseResponse{
+			Type:      "session_start",
+			SessionID: h.sessionID,
+		},
+		Capabilities: capabilities,
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session start message: %v", err)
+	}
+	if err = h.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		if h.conn.IsClosed() {
+			h.close()
+			return nil
+		}
+		return fmt.Errorf("failed to send session start message: %v", err)
+	}
+	atomic.StoreInt32(&h.sessionStarted, 1)
+	return nil
+}
+
+// sendSessionEndMessage 在关闭连接前下发会话结束消息，仅在下发过 session_start 时下发
+// 由 closeWithReason 调用，发送失败时不能再调用 close
+func (h *Handler) sendSessionEndMessage(code int, reason string) error {
+	if atomic.LoadInt32(&h.sessionStarted) == 0 || h.conn.IsClosed() {
+		return nil
+	}
+	info := h.Info()
+	msg := model.SessionEndResponse{
+		BaseResponse: model.BaseResponse{
+			Type:      "session_end",
+			SessionID: h.sessionID,
+		},
+		Reason:     reason,
+		CloseCode:  code,
+		ChatRounds: info.ChatRounds,
+		DurationMs: time.Since(info.StartTime).Milliseconds(),
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session end message: %v", err)
+	}
+	if err = h.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		return fmt.Errorf("failed to send session end message: %v", err)
+	}
+	return nil
+}
+
+func (h *Handler) sendTtsTimestampMessage(timestamp tts.Timestamp) error {
+	msg := model.TtsTimestampResponse{
+		BaseResponse: model.BaseResponse{
+			Type:      "tts_timestamp",
+			SessionID: h.sessionID,
+		},
+		SentenceIndex: timestamp.SentenceIndex,
+		Words:         make([]model.WordTime, 0, len(timestamp.Words)),
+	}
+	for _, word := range timestamp.Words {
+		msg.Words = append(msg.Words, model.WordTime{Text: word.Text, BeginTime: word.BeginTime, EndTime: word.EndTime})
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal tts timestamp message: %v", err)
+	}
+	if err = h.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		if h.conn.IsClosed() {
+			h.close()
+			return nil
+		}
+		return fmt.Errorf("failed to send tts timestamp message: %v", err)
+	}
+	return nil
+}
@@ -2,34 +2,111 @@ package handler
 
 import (
 	"fmt"
+	"net/http"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"crow/internal/auth"
 	"crow/internal/config"
 	"crow/pkg/log"
 )
 
 type WebsocketServer struct {
-	cfg *config.Config
 	log *log.Logger
+
+	authenticator auth.Authenticator // authenticator 自定义的连接认证，为空时使用配置的静态令牌
+
+	sessions sync.Map // 活跃会话，k: sessionID, v: *Handler
 }
 
-func NewWebsocketServer(cfg *config.Config, log *log.Logger) *WebsocketServer {
+func NewWebsocketServer(log *log.Logger) *WebsocketServer {
 	return &WebsocketServer{
-		cfg: cfg,
 		log: log,
 	}
 }
 
+// SetAuthenticator 设置自定义的连接认证，如对接 JWT、OAuth 等，须在开始服务前设置
+func (w *WebsocketServer) SetAuthenticator(a auth.Authenticator) {
+	w.authenticator = a
+}
+
+// authenticate 认证建立连接的请求，未配置认证时返回 nil 身份
+func (w *WebsocketServer) authenticate(cfg *config.Config, r *http.Request) (*auth.Identity, error) {
+	authenticator := w.authenticator
+	if authenticator == nil {
+		if len(cfg.Server.AuthTokens) == 0 {
+			return nil, nil
+		}
+		authenticator = auth.StaticTokens(cfg.Server.AuthTokens)
+	}
+	return authenticator.Authenticate(r)
+}
+
 func (w *WebsocketServer) Server(ctx *gin.Context) {
-	conn, err := newWebsocketConn(ctx.Writer, ctx.Request)
+	// 每个会话使用建立时的配置快照，配置重载仅对新会话生效，避免会话中途新旧配置混用
+	cfg := config.Snapshot()
+	identity, authErr := w.authenticate(cfg, ctx.Request)
+
+	// 读取超时略大于会话空闲超时，保证由会话空闲检测优先下发goodbye后关闭连接
+	conn, err := newWebsocketConn(ctx.Writer, ctx.Request, idleTimeout(cfg)+10*time.Second,
+		cfg.Server.WsCompression, cfg.Server.WsCompressionLevel)
 	if err != nil {
 		w.log.Errorf("failed to create websocket connection: %v", err)
 		return
 	}
 
-	w.log.Infof("client %s connected", fmt.Sprintf("%p", conn))
+	// 浏览器无法获取握手失败的状态码，因此认证失败时先完成握手，再以关闭码告知客户端
+	if authErr != nil {
+		w.log.Warnf("client %s unauthorized: %v", fmt.Sprintf("%p", conn), authErr)
+		_ = conn.CloseWithReason(CloseCodeUnauthorized, "unauthorized")
+		return
+	}
+
+	w.log.Infof("client %s connected, compression: %v", fmt.Sprintf("%p", conn), conn.compress)
+
+	handler := NewHandler(cfg, w.log, conn)
+	handler.setIdentity(identity)
+	w.sessions.Store(handler.sessionID, handler)
+	defer w.sessions.Delete(handler.sessionID)
 
-	handler := NewHandler(w.cfg, w.log, conn)
 	handler.Handle(ctx.Request.Context())
 }
+
+// Stream 以 HTTP 分块传输的方式建立会话，请求体为连续上传的音频，响应为 SSE 事件流，消息格式与 websocket 相同
+func (w *WebsocketServer) Stream(ctx *gin.Context) {
+	cfg := config.Snapshot()
+	identity, err := w.authenticate(cfg, ctx.Request)
+	if err != nil {
+		w.log.Warnf("http stream client unauthorized: %v", err)
+		ctx.Status(http.StatusUnauthorized)
+		return
+	}
+
+	conn, err := newHTTPStreamConn(ctx.Writer, ctx.Request, idleTimeout(cfg)+10*time.Second)
+	if err != nil {
+		w.log.Errorf("failed to create http stream connection: %v", err)
+		ctx.Status(http.StatusInternalServerError)
+		return
+	}
+
+	w.log.Infof("client %s connected over http stream", fmt.Sprintf("%p", conn))
+
+	handler := NewHandler(cfg, w.log, conn)
+	handler.setIdentity(identity)
+	w.sessions.Store(handler.sessionID, handler)
+	defer w.sessions.Delete(handler.sessionID)
+
+	handler.Handle(ctx.Request.Context())
+}
+
+// Shutdown 服务关闭时通知并关闭所有活跃会话，可通过 http.Server.RegisterOnShutdown 注册
+func (w *WebsocketServer) Shutdown() {
+	w.sessions.Range(func(key, value any) bool {
+		handler := value.(*Handler)
+		_ = handler.sendGoodbyeMessage("server shutdown")
+		handler.closeWithReason(CloseCodeServerShutdown, "server shutdown")
+		return true
+	})
+}
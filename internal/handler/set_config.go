@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"fmt"
+	"time"
+
+	"crow/internal/asr"
+	"crow/internal/config"
+	"crow/internal/model"
+	"crow/internal/tts"
+	errcode "crow/pkg/err-code"
+)
+
+// handleSetConfig 在会话中调整ASR、TTS参数，如放慢语速、更换发音人，仅非零值的字段生效，并下发实际生效的参数
+// 音频格式与采样率在hello中协商后不能修改，客户端的编解码依赖于此
+func (h *Handler) handleSetConfig(data model.ClientTextMessage) error {
+	var empty model.ClientTextMessage
+	setAsr, setTts := data.AsrParams != empty.AsrParams, data.TtsParams != empty.TtsParams
+	msg, err := h.applySetConfig(data, setAsr, setTts)
+	if err != nil {
+		_ = h.sendErrorMessage(errcode.ErrInvalidParams.Code(), fmt.Sprintf("%s：%v", errcode.ErrInvalidParams.Msg(), err))
+		return fmt.Errorf("failed to set config: %v", err)
+	}
+	// 识别参数在建立识别任务时发送，因此重置ASR使其在下一句生效，未识别完的当前句将被丢弃
+	// 重置会关闭识别连接，不在 cfgLock 内进行，以免与ASR回调中的语种切换互相等待
+	if setAsr {
+		if err := h.asrProvider.Reset(); err != nil {
+			h.log.Warnf("failed to reset asr provider: %v", err)
+		}
+	}
+	h.updateInfo(func(info *SessionInfo) {
+		info.AsrLanguage = msg.AsrParams.Language
+		info.TtsLanguage = msg.TtsParams.Language
+		info.TtsSpeaker = msg.TtsParams.Speaker
+	})
+	return h.sendSetConfigMessage(msg)
+}
+
+// applySetConfig 校验并应用调整的参数，返回实际生效的参数
+// 配置整体替换而非原地修改，服务商与各协程读到的总是某一份完整的配置
+func (h *Handler) applySetConfig(data model.ClientTextMessage, setAsr, setTts bool) (model.SetConfigResponse, error) {
+	h.cfgLock.Lock()
+	defer h.cfgLock.Unlock()
+	msg := model.SetConfigResponse{}
+	if err := h.checkSetConfig(data, setAsr, setTts); err != nil {
+		return msg, err
+	}
+	if h.asrCfg != nil {
+		if setAsr {
+			h.setAsrConfig(data)
+		}
+		msg.AsrParams = asrParams(h.asrCfg)
+	}
+	if h.ttsCfg != nil {
+		if setTts {
+			h.setTtsConfig(data)
+		}
+		msg.TtsParams = ttsParams(h.ttsCfg)
+	}
+	return msg, nil
+}
+
+// checkSetConfig 校验调整的参数，未启用的模块及已协商的音频格式不能调整
+func (h *Handler) checkSetConfig(data model.ClientTextMessage, setAsr, setTts bool) error {
+	if setAsr {
+		if h.asrCfg == nil {
+			return fmt.Errorf("asr is not enabled")
+		}
+		if v := data.AsrParams.Format; v != "" && v != h.asrCfg.Format {
+			return fmt.Errorf("asr format cannot be changed")
+		}
+		if v := data.AsrParams.SampleRate; v > 0 && v != h.asrCfg.SampleRate {
+			return fmt.Errorf("asr sample rate cannot be changed")
+		}
+	}
+	if setTts {
+		if h.ttsCfg == nil {
+			return fmt.Errorf("tts is not enabled")
+		}
+		if v := data.TtsParams.Format; v != "" && v != h.ttsCfg.Format {
+			return fmt.Errorf("tts format cannot be changed")
+		}
+		if v := data.TtsParams.SampleRate; v > 0 && v != h.ttsCfg.SampleRate {
+			return fmt.Errorf("tts sample rate cannot be changed")
+		}
+		if data.TtsParams.Speed < 0 || data.TtsParams.Pitch < 0 || data.TtsParams.Volume < 0 {
+			return fmt.Errorf("tts speed, pitch and volume cannot be negative")
+		}
+	}
+	return nil
+}
+
+// setAsrConfig 调整ASR参数，在下一个识别任务生效，调用方需持有 cfgLock
+func (h *Handler) setAsrConfig(data model.ClientTextMessage) {
+	cfg := *h.asrCfg
+	if v := data.AsrParams.Language; v != "" {
+		cfg.Language = v
+	}
+	if v := data.AsrParams.Accent; v != "" {
+		cfg.Accent = v
+	}
+	if v := data.AsrParams.VadEos; v > 0 {
+		cfg.VadEos = v
+	}
+	if data.AsrParams.EnablePunc {
+		cfg.EnablePunc = true
+	}
+	// 与hello相同，只能在服务端配置的上限内调整单句最大时长与空闲超时
+	serverCfg := h.cfg.Asr[h.selectedModule["asr"]]
+	if v, limit := data.AsrParams.MaxUtteranceMs, serverCfg.MaxUtteranceMs; v > 0 && (limit <= 0 || v < limit) {
+		cfg.MaxUtteranceMs = v
+	}
+	if v := clientIdleTimeout(data.AsrParams.IdleTimeoutMs, serverCfg); v > 0 {
+		cfg.IdleTimeout = v
+	}
+	h.asrCfg = h.asrProvider.SetConfig(&cfg)
+	h.log.Infof("set asr config, language: %s, vad_eos: %d", h.asrCfg.Language, h.asrCfg.VadEos)
+}
+
+// setTtsConfig 调整TTS参数，合成参数在每轮合成开始时发送，因此在下一轮回复生效，保留的连接也无需重建
+// 客户端指定了语种或发音人后不再按识别出的语种自动切换，以免覆盖用户的选择，调用方需持有 cfgLock
+func (h *Handler) setTtsConfig(data model.ClientTextMessage) {
+	cfg := *h.ttsCfg
+	if v := data.TtsParams.Speaker; v != "" {
+		cfg.Speaker = v
+	}
+	if v := data.TtsParams.Language; v != "" {
+		cfg.Language = v
+	}
+	if v := data.TtsParams.Speed; v > 0 {
+		cfg.Speed = v
+	}
+	if v := data.TtsParams.Volume; v > 0 {
+		cfg.Volume = v
+	}
+	if v := data.TtsParams.Pitch; v > 0 {
+		cfg.Pitch = v
+	}
+	if data.TtsParams.Speaker != "" || data.TtsParams.Language != "" {
+		h.langLocked = true
+	}
+	h.ttsCfg = h.ttsProvider.SetConfig(&cfg)
+	h.log.Infof("set tts config, speaker: %s, language: %s, speed: %v", h.ttsCfg.Speaker, h.ttsCfg.Language, h.ttsCfg.Speed)
+}
+
+// ttsConfig 当前实际使用的TTS配置，供对话协程读取
+func (h *Handler) ttsConfig() *tts.Config {
+	h.cfgLock.Lock()
+	defer h.cfgLock.Unlock()
+	return h.ttsCfg
+}
+
+// clientIdleTimeout 客户端设置的空闲超时，不超过服务端配置的上限，客户端未设置时返回0
+func clientIdleTimeout(ms int, cfg config.AsrConfig) time.Duration {
+	if ms <= 0 {
+		return 0
+	}
+	limit := time.Duration(cfg.MaxIdleTimeoutMs) * time.Millisecond
+	if limit <= 0 {
+		limit = asr.DefaultMaxIdleTimeout
+	}
+	return min(time.Duration(ms)*time.Millisecond, limit)
+}
+
+// asrParams 实际生效的ASR参数
+func asrParams(cfg *asr.Config) model.AsrParams {
+	return model.AsrParams{
+		Language:       cfg.Language,
+		Accent:         cfg.Accent,
+		SampleRate:     cfg.SampleRate,
+		Format:         cfg.Format,
+		EnablePunc:     cfg.EnablePunc,
+		VadEos:         cfg.VadEos,
+		MaxUtteranceMs: cfg.MaxUtteranceMs,
+		IdleTimeoutMs:  int(cfg.IdleTimeout.Milliseconds()),
+	}
+}
+
+// ttsParams 实际生效的TTS参数
+func ttsParams(cfg *tts.Config) model.TtsParams {
+	return model.TtsParams{
+		Speaker:         cfg.Speaker,
+		Speed:           cfg.Speed,
+		Volume:          cfg.Volume,
+		Pitch:           cfg.Pitch,
+		SampleRate:      cfg.SampleRate,
+		Format:          cfg.Format,
+		Language:        cfg.Language,
+		EnableTimestamp: cfg.EnableTimestamp,
+	}
+}
@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"testing"
+
+	"crow/internal/asr"
+	"crow/internal/config"
+	"crow/internal/model"
+	"crow/internal/tts"
+)
+
+func TestSetConfigConcurrentWithLanguageSwitch(t *testing.T) {
+	cfg := &config.Config{Language: config.LanguageConfig{Mode: languageModeFollow, SwitchAfter: 1}}
+	h, conn := newTestHandler(t, cfg, nil)
+	h.ttsProvider = fakeTts{}
+	h.ttsCfg = &tts.Config{Language: "zh", Speaker: "zh_speaker", Speed: 1}
+
+	// ASR回调按识别出的语种切换配置的同时，客户端调整语速，-race 下不应报告数据竞争
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			language := []string{"en", "zh"}[i%2]
+			h.OnAsrResultDetail(t.Context(), asr.Detail{Result: "hi", Language: language, State: asr.StateSentenceEnd})
+			_ = h.ttsConfig().Language
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		var data model.ClientTextMessage
+		data.TtsParams.Speed = 1.5
+		if err := h.handleSetConfig(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+
+	// 客户端指定发音人后不再自动切换，后续的语种切换不能覆盖用户的选择
+	var data model.ClientTextMessage
+	data.TtsParams.Speaker = "user_speaker"
+	if err := h.handleSetConfig(data); err != nil {
+		t.Fatal(err)
+	}
+	h.OnAsrResultDetail(t.Context(), asr.Detail{Result: "hi", Language: "en", State: asr.StateSentenceEnd})
+	if got := h.ttsConfig(); got.Speaker != "user_speaker" || got.Speed != 1.5 {
+		t.Fatalf("tts config = %+v, want speaker user_speaker with speed 1.5", got)
+	}
+	if msgs := conn.messages("set_config"); len(msgs) != 51 {
+		t.Fatalf("set_config responses = %d, want 51", len(msgs))
+	}
+}
+
+func TestIdleTimeoutClamped(t *testing.T) {
+	tests := []struct {
+		name      string
+		limit     int // 服务端配置的上限，0为使用默认上限
+		hello     int // hello 中设置的空闲超时
+		setConfig int // set_config 中设置的空闲超时
+		wantHello int
+		wantSet   int
+	}{
+		{"within limit", 60000, 10000, 20000, 10000, 20000},
+		{"over limit", 60000, 600000, 90000, 60000, 60000},
+		{"default limit", 0, 3600000, 5000, int(asr.DefaultMaxIdleTimeout.Milliseconds()), 5000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				SelectedModule: map[string]string{"asr": "fake"},
+				Asr:            map[string]config.AsrConfig{"fake": {MaxIdleTimeoutMs: tt.limit}},
+			}
+			conn := newFakeConn(map[string]any{
+				"type":       "hello",
+				"enable_asr": true,
+				"asr_params": map[string]any{"idle_timeout_ms": tt.hello},
+			})
+			h := NewHandler(cfg, newTestLogger(), conn)
+			h.asrProvider = fakeAsr{}
+			t.Cleanup(func() { _ = conn.Close() })
+			if err := h.handleHelloMessage(t.Context()); err != nil {
+				t.Fatalf("hello: %v", err)
+			}
+			hello := conn.waitFor(t, "hello", 1)[0]["asr_params"].(map[string]any)
+			if got := int(hello["idle_timeout_ms"].(float64)); got != tt.wantHello {
+				t.Fatalf("hello idle_timeout_ms = %d, want %d", got, tt.wantHello)
+			}
+
+			var data model.ClientTextMessage
+			data.AsrParams.IdleTimeoutMs = tt.setConfig
+			if err := h.handleSetConfig(data); err != nil {
+				t.Fatal(err)
+			}
+			set := conn.waitFor(t, "set_config", 1)[0]["asr_params"].(map[string]any)
+			if got := int(set["idle_timeout_ms"].(float64)); got != tt.wantSet {
+				t.Fatalf("set_config idle_timeout_ms = %d, want %d", got, tt.wantSet)
+			}
+		})
+	}
+}
@@ -5,12 +5,21 @@ package model
 // Type 为 hello 时，用于初始化连接
 // Type 为 chat 时，用于发送聊天文本，需要带上 ChatText 字段
 // Type 为 abort 时，用于终止当前的对话，不需要其他字段
+// Type 为 audio_end 时，用于通知服务端用户已结束说话，立即结束语音识别，不需要其他字段
+// Type 为 tool_choice 时，用于指定下一轮对话的工具选择方式，需要带上 ToolChoice 字段
+// Type 为 set_config 时，用于在会话中调整ASR、TTS参数，需要带上 AsrParams 或 TtsParams 字段，仅非零值的字段生效
 type ClientTextMessage struct {
 	Type      string `json:"type"`
 	ChatText  string `json:"chat_text,omitempty"`
 	EnableAsr bool   `json:"enable_asr,omitempty"`
 	EnableTts bool   `json:"enable_tts,omitempty"`
-	AsrParams struct {
+	// ToolChoice 下一轮对话的工具选择方式：none、auto、required 或工具名称（强制调用该工具），chat 消息携带时对本次对话生效
+	ToolChoice string `json:"tool_choice,omitempty"`
+	// 以下为可选的模块选择，需在服务端 allowed_module 配置的范围内，为空则使用服务端默认配置
+	AsrProvider string `json:"asr_provider,omitempty"` // ASR服务商，如 "doubao"
+	TtsProvider string `json:"tts_provider,omitempty"` // TTS服务商，如 "cosy_voice"
+	LLMModel    string `json:"llm_model,omitempty"`    // 大模型，对应配置文件中llm下的名称
+	AsrParams   struct {
 		Format     string `json:"format,omitempty"`      // 音频格式，如 "pcm"
 		SampleRate int    `json:"sample_rate,omitzero"`  // 采样率，如 16000
 		Channels   int    `json:"channels,omitzero"`     // 声道数，如 1: 单声道，2: 双声道
@@ -18,6 +27,10 @@ type ClientTextMessage struct {
 		EnablePunc bool   `json:"enable_punc,omitempty"` // 是否启用标点符号，默认false
 		Language   string `json:"language,omitempty"`    // 语言，如 "zh"
 		Accent     string `json:"accent,omitempty"`      // 口音，如 "mandarin"
+		// MaxUtteranceMs 单句语音的最大时长，单位毫秒，不能超过服务端配置的上限
+		MaxUtteranceMs int `json:"max_utterance_ms,omitzero"`
+		// IdleTimeoutMs 开始监听后无识别文本的空闲超时，超时后的空结果计为静音，默认30000，单位毫秒
+		IdleTimeoutMs int `json:"idle_timeout_ms,omitzero"`
 	} `json:"asr_params,omitzero"`
 	TtsParams struct {
 		Speaker    string  `json:"speaker,omitempty"`    // 发音人
@@ -27,5 +40,31 @@ type ClientTextMessage struct {
 		Pitch      float32 `json:"pitch,omitzero"`       // 语调，默认为1.0
 		SampleRate int     `json:"sample_rate,omitzero"` // 采样率，默认为16000
 		Language   string  `json:"language,omitempty"`   // 语言，如 "zh"
+		// EnableTimestamp 是否下发字词时间戳，用于字幕与口型同步，需TTS服务商支持
+		EnableTimestamp bool `json:"enable_timestamp,omitempty"`
 	} `json:"tts_params,omitzero"`
+	// ConversationID 要恢复的会话记忆标识，即之前hello响应中的 conversation_id，仅在服务端将记忆持久化到Redis时生效
+	ConversationID string `json:"conversation_id,omitempty"`
+	// UserContext 应用已知的用户信息，如姓名、偏好，作为独立的章节加入系统提示词，长度不能超过服务端配置
+	UserContext string `json:"user_context,omitempty"`
+	// MaxChatRounds 本次会话最多的对话轮次，不能超过服务端配置
+	MaxChatRounds int `json:"max_chat_rounds,omitzero"`
+	// MaxReplyChars 每轮回复最多的字数，超出时在句子边界截断，不能超过服务端配置
+	MaxReplyChars int `json:"max_reply_chars,omitzero"`
+	// EnableReasoning 是否下发推理模型的思考过程，仅用于展示，不会被播报
+	EnableReasoning bool `json:"enable_reasoning,omitempty"`
+	// EnableToolEvents 是否下发 tool_call 事件，用于在界面上展示正在使用的工具
+	EnableToolEvents bool `json:"enable_tool_events,omitempty"`
+	// EnableFullReply 每轮回复的最后一个 chat 消息是否携带本轮的完整回复，而非最后一个分片，适合只关心最终文本或需要保存对话记录的客户端
+	EnableFullReply bool `json:"enable_full_reply,omitempty"`
+	// LLMParams 大模型设置参数
+	LLMParams struct {
+		// ReasoningEffort 推理强度：low、medium、high，不能高于服务端配置，交互场景可使用 low 以尽快得到回复
+		ReasoningEffort string `json:"reasoning_effort,omitempty"`
+	} `json:"llm_params,omitzero"`
+	// WakeWord 唤醒词设置（enable_asr为true时生效），未设置的字段使用服务端配置
+	WakeWord struct {
+		Enable *bool    `json:"enable,omitempty"` // 是否开启唤醒词检测，开启后检测到唤醒词才会识别用户的语音
+		Words  []string `json:"words,omitempty"`  // 唤醒词
+	} `json:"wake_word,omitzero"`
 }
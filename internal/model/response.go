@@ -9,39 +9,160 @@ type BaseResponse struct {
 
 type HelloResponse struct {
 	BaseResponse
-	AsrParams struct {
-		Format     string `json:"format,omitempty"`      // 音频格式，如 "pcm"
-		SampleRate int    `json:"sample_rate,omitzero"`  // 采样率，如 16000
-		Channels   int    `json:"channels,omitzero"`     // 声道数，如 1: 单声道，2: 双声道
-		VadEos     int    `json:"vad_eos,omitempty"`     // VAD后端点，默认800，单位毫秒
-		EnablePunc bool   `json:"enable_punc,omitempty"` // 是否启用标点符号，默认false
-		Language   string `json:"language,omitempty"`    // 语言，如 "zh"
-		Accent     string `json:"accent,omitempty"`      // 口音，如 "mandarin"
-	} `json:"asr_params,omitzero"`
-	TtsParams struct {
-		Speaker    string  `json:"speaker,omitempty"`    // 发音人
-		Format     string  `json:"format,omitempty"`     // 音频格式，如 "mp3"
-		Speed      float32 `json:"speed,omitzero"`       // 语速，默认为1.0
-		Volume     int     `json:"volume,omitzero"`      // 音量，默认为50
-		Pitch      float32 `json:"pitch,omitzero"`       // 语调，默认为1.0
-		SampleRate int     `json:"sample_rate,omitzero"` // 采样率，默认为16000
-		Language   string  `json:"language,omitempty"`   // 语言，如 "zh"
-	} `json:"tts_params,omitzero"`
+	AsrProvider string `json:"asr_provider,omitempty"` // 本次会话实际使用的ASR服务商
+	TtsProvider string `json:"tts_provider,omitempty"` // 本次会话实际使用的TTS服务商
+	LLMModel    string `json:"llm_model,omitempty"`    // 本次会话实际使用的大模型
+	// ConversationID 会话记忆标识，仅在服务端将记忆持久化到Redis时返回，断线重连时在hello中携带即可恢复上下文
+	ConversationID string `json:"conversation_id,omitempty"`
+	MaxChatRounds  int    `json:"max_chat_rounds,omitzero"` // 本次会话实际生效的最大对话轮次，0为不限制
+	MaxReplyChars  int    `json:"max_reply_chars,omitzero"` // 本次会话实际生效的每轮回复最多字数，0为不限制
+	LLMParams      struct {
+		ReasoningEffort string `json:"reasoning_effort,omitempty"` // 实际使用的推理强度
+	} `json:"llm_params,omitzero"`
+	AsrParams AsrParams `json:"asr_params,omitzero"`
+	TtsParams TtsParams `json:"tts_params,omitzero"`
+	WakeWord  struct {
+		Enable bool     `json:"enable,omitempty"` // 实际是否开启唤醒词检测
+		Words  []string `json:"words,omitempty"`  // 实际使用的唤醒词
+	} `json:"wake_word,omitzero"`
+	// Capabilities 服务端支持的功能，客户端可据此调整交互，而非假定服务端的能力
+	Capabilities Capabilities `json:"capabilities"`
+}
+
+// AsrParams 本次会话实际生效的ASR参数
+type AsrParams struct {
+	Format     string `json:"format,omitempty"`      // 音频格式，如 "pcm"
+	SampleRate int    `json:"sample_rate,omitzero"`  // 采样率，如 16000
+	Channels   int    `json:"channels,omitzero"`     // 声道数，如 1: 单声道，2: 双声道
+	VadEos     int    `json:"vad_eos,omitempty"`     // VAD后端点，默认800，单位毫秒
+	EnablePunc bool   `json:"enable_punc,omitempty"` // 是否启用标点符号，默认false
+	Language   string `json:"language,omitempty"`    // 语言，如 "zh"
+	Accent     string `json:"accent,omitempty"`      // 口音，如 "mandarin"
+	// MaxUtteranceMs 单句语音的最大时长，单位毫秒，不能超过服务端配置的上限
+	MaxUtteranceMs int `json:"max_utterance_ms,omitzero"`
+	// IdleTimeoutMs 开始监听后无识别文本的空闲超时，单位毫秒
+	IdleTimeoutMs int `json:"idle_timeout_ms,omitzero"`
+}
+
+// TtsParams 本次会话实际生效的TTS参数
+type TtsParams struct {
+	Speaker    string  `json:"speaker,omitempty"`    // 发音人
+	Format     string  `json:"format,omitempty"`     // 音频格式，如 "mp3"
+	Speed      float32 `json:"speed,omitzero"`       // 语速，默认为1.0
+	Volume     int     `json:"volume,omitzero"`      // 音量，默认为50
+	Pitch      float32 `json:"pitch,omitzero"`       // 语调，默认为1.0
+	SampleRate int     `json:"sample_rate,omitzero"` // 采样率，默认为16000
+	Language   string  `json:"language,omitempty"`   // 语言，如 "zh"
+	// EnableTimestamp 是否下发字词时间戳，用于字幕与口型同步，需TTS服务商支持
+	EnableTimestamp bool `json:"enable_timestamp,omitempty"`
+}
+
+// SetConfigResponse 会话中调整参数后实际生效的参数，未启用的模块不返回
+type SetConfigResponse struct {
+	BaseResponse
+	AsrParams AsrParams `json:"asr_params,omitzero"`
+	TtsParams TtsParams `json:"tts_params,omitzero"`
+}
+
+// Capabilities 服务端支持的功能及可供选择的模块，新增需要客户端开启的功能时应同步在此列出
+type Capabilities struct {
+	MessageTypes []string `json:"message_types"`           // 服务端可处理的客户端消息类型，如 chat、abort
+	Features     []string `json:"features"`                // 已启用的功能，如 binary_audio、tool_events
+	AsrProviders []string `json:"asr_providers,omitempty"` // 可在hello中选择的ASR服务商
+	TtsProviders []string `json:"tts_providers,omitempty"` // 可在hello中选择的TTS服务商
+	LLMModels    []string `json:"llm_models,omitempty"`    // 可在hello中选择的大模型
 }
 
 type AsrResponse struct {
 	BaseResponse
 	Result string `json:"result"`
 	State  int    `json:"state"`
+	// IsFinal 本句的识别结果是否已确定，为 false 时为中间结果，之后可能被同一 SegmentID 的结果替换
+	IsFinal bool `json:"is_final"`
+	// SegmentID 句子的序号，从1开始，同一句的中间结果与最终结果相同，客户端可据此原地更新字幕
+	SegmentID int       `json:"segment_id"`
+	Words     []AsrWord `json:"words,omitempty"` // 逐词的识别结果，仅在服务商提供时返回
+}
+
+// AsrWord 逐词的识别结果，时间为词在本次识别音频中的位置，单位毫秒
+type AsrWord struct {
+	Text        string `json:"text"`
+	Punctuation string `json:"punctuation,omitempty"`
+	BeginTime   int64  `json:"begin_time"`
+	EndTime     int64  `json:"end_time,omitempty"` // 词尚未确定结束时不返回
 }
 
 type ChatResponse struct {
 	BaseResponse
-	Text string `json:"text"`
+	Text    string `json:"text"`
+	Seq     int64  `json:"seq"`      // 分片序号，会话内从1开始逐条递增，客户端可据此重排及检测丢失
+	IsFinal bool   `json:"is_final"` // 是否为本轮回复的最后一个分片
+	// MessageID 所属回复的标识，同一轮回复的 chat 与 tts 消息相同，客户端可据此关联文本与音频
+	MessageID string `json:"message_id,omitempty"`
 }
 
 type TtsResponse struct {
 	BaseResponse
-	Audio string `json:"audio"` // base64编码的音频数据
-	State int    `json:"state"`
+	Audio   string `json:"audio"` // base64编码的音频数据
+	State   int    `json:"state"`
+	Seq     int64  `json:"seq"`      // 分片序号，会话内从1开始逐条递增，客户端可据此重排及检测丢失
+	IsFinal bool   `json:"is_final"` // 是否为本轮合成的最后一个分片
+	// MessageID 音频所属回复的标识，与该回复的 chat 消息相同
+	MessageID string `json:"message_id,omitempty"`
+}
+
+type TtsTimestampResponse struct {
+	BaseResponse
+	SentenceIndex int        `json:"sentence_index"` // 句子序号，同一句子可能多次下发，以最后一次为准
+	Words         []WordTime `json:"words"`
+}
+
+// WordTime 字词时间戳
+type WordTime struct {
+	Text      string `json:"text"`
+	BeginTime int64  `json:"begin_time"` // 在本句音频中的开始时间，单位毫秒
+	EndTime   int64  `json:"end_time"`   // 在本句音频中的结束时间，单位毫秒
+}
+
+type ToolCallResponse struct {
+	BaseResponse
+	ID            string `json:"id"`                       // 工具调用ID，同一次调用的 started 与 finished 事件相同
+	Name          string `json:"name"`                     // 工具名称
+	Arguments     any    `json:"arguments"`                // 调用参数，敏感字段已脱敏
+	Status        string `json:"status"`                   // started：开始执行，finished：执行结束
+	IsError       bool   `json:"is_error,omitempty"`       // 是否执行失败，仅 finished 时有效
+	ResultPreview string `json:"result_preview,omitempty"` // 执行结果的开头部分，仅 finished 时有效
+}
+
+type ReasoningResponse struct {
+	BaseResponse
+	Text string `json:"text"` // 思考过程的分片
+}
+
+// DegradeResponse 服务降级通知，如语音识别不可用时建议客户端改用文字输入
+type DegradeResponse struct {
+	BaseResponse
+	Module   string `json:"module"`   // 不可用的模块，如 asr
+	Fallback string `json:"fallback"` // 建议改用的交互方式，如 text
+	Switched bool   `json:"switched"` // 服务端是否已切换，为 true 时本次会话不再处理该模块的输入
+}
+
+type GoodbyeResponse struct {
+	BaseResponse
+	Reason string `json:"reason"` // 服务端关闭会话的原因
+}
+
+// SessionStartResponse 会话开始，紧随hello响应下发，之后即可开始交互
+type SessionStartResponse struct {
+	BaseResponse
+	Capabilities Capabilities `json:"capabilities"` // 同hello响应中的 capabilities
+}
+
+// SessionEndResponse 会话结束，服务端关闭连接前下发，客户端据此区分正常结束与异常断开
+type SessionEndResponse struct {
+	BaseResponse
+	Reason     string `json:"reason"`      // 结束原因，同关闭连接时携带的原因
+	CloseCode  int    `json:"close_code"`  // 随后关闭连接使用的关闭码
+	ChatRounds int    `json:"chat_rounds"` // 本次会话已开始的对话轮次
+	DurationMs int64  `json:"duration_ms"` // 会话持续的时长，单位毫秒
 }
@@ -1,25 +1,99 @@
 package router
 
 import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
 	"crow/internal/handler"
 
 	"github.com/gin-gonic/gin"
 
 	"crow/internal/config"
 	"crow/pkg/log"
+	"crow/pkg/metrics"
 )
 
-func NewRouter(cfg *config.Config) *gin.Engine {
+// NewRouter 创建路由，同时返回 websocket 服务，以便服务关闭时通知活跃会话
+func NewRouter(cfg *config.Config) (*gin.Engine, *handler.WebsocketServer) {
 	gin.SetMode(cfg.Server.Mode)
 
-	r := gin.Default()
+	r := gin.New()
+	r.Use(gin.LoggerWithFormatter(logFormatter), gin.Recovery())
 
-	ws := handler.NewWebsocketServer(cfg, log.NewLogger(&log.Option{
+	ws := handler.NewWebsocketServer(log.NewLogger(&log.Option{
 		Hook:        nil,
 		Mode:        cfg.Server.Mode,
 		ServiceName: "crow",
-		EncodeType:  log.EncodeTypeJson,
+		EncodeType:  log.ParseEncodeType(cfg.Log.Encoding, log.EncodeTypeJson),
 	}))
 	r.GET("/crow/v1", ws.Server)
-	return r
+	r.POST("/crow/v1/stream", ws.Stream)
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
+
+	admin := r.Group("/admin", adminAuth)
+	admin.GET("/sessions", ws.ListSessions)
+	admin.POST("/sessions/:id/close", ws.CloseSession)
+	return r, ws
+}
+
+// adminAuth 校验管理接口的令牌，未配置令牌时不开放管理接口；每次请求读取最新配置，令牌变更后重载配置即可生效
+func adminAuth(ctx *gin.Context) {
+	token := config.Snapshot().Server.AdminToken
+	if token == "" {
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	scheme, got, _ := strings.Cut(ctx.GetHeader("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") || subtle.ConstantTimeCompare([]byte(strings.TrimSpace(got)), []byte(token)) != 1 {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	ctx.Next()
+}
+
+// logFormatter 与 gin 默认的访问日志格式一致，但会脱敏查询参数中的令牌
+func logFormatter(param gin.LogFormatterParams) string {
+	var statusColor, methodColor, resetColor string
+	if param.IsOutputColor() {
+		statusColor = param.StatusCodeColor()
+		methodColor = param.MethodColor()
+		resetColor = param.ResetColor()
+	}
+	if param.Latency > time.Minute {
+		param.Latency = param.Latency.Truncate(time.Second)
+	}
+	return fmt.Sprintf("[GIN] %v |%s %3d %s| %13v | %15s |%s %-7s %s %#v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		statusColor, param.StatusCode, resetColor,
+		param.Latency,
+		param.ClientIP,
+		methodColor, param.Method, resetColor,
+		redactQuery(param.Path),
+		param.ErrorMessage,
+	)
+}
+
+// redactQuery 将请求路径中 token 查询参数的值替换为***，其余部分保持原样
+func redactQuery(path string) string {
+	p, rawQuery, ok := strings.Cut(path, "?")
+	if !ok {
+		return path
+	}
+	params := strings.Split(rawQuery, "&")
+	redacted := false
+	for i, param := range params {
+		key, _, _ := strings.Cut(param, "=")
+		if k, err := url.QueryUnescape(key); err == nil && k == "token" {
+			params[i] = key + "=***"
+			redacted = true
+		}
+	}
+	if !redacted {
+		return path
+	}
+	return p + "?" + strings.Join(params, "&")
 }
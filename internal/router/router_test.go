@@ -0,0 +1,26 @@
+package router
+
+import "testing"
+
+func TestRedactQuery(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"no query", "/crow/v1", "/crow/v1"},
+		{"no token", "/crow/v1?lang=zh", "/crow/v1?lang=zh"},
+		{"token", "/crow/v1?token=secret", "/crow/v1?token=***"},
+		{"token among others", "/crow/v1?a=1&token=secret&b=2", "/crow/v1?a=1&token=***&b=2"},
+		{"repeated token", "/crow/v1?token=a&token=b", "/crow/v1?token=***&token=***"},
+		{"escaped key", "/crow/v1?%74oken=secret", "/crow/v1?%74oken=***"},
+		{"similar key", "/crow/v1?token_type=bearer", "/crow/v1?token_type=bearer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactQuery(tt.path); got != tt.want {
+				t.Fatalf("redactQuery(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package tts
+
+import "sync"
+
+// Chunker 将服务商下发的音频重新切分为固定大小的分片，使不同服务商下发给客户端的分片大小一致，播放更平稳
+// 不足一个分片的音频会暂存，直至凑满一个分片或本次合成结束，并发安全
+type Chunker struct {
+	size int
+	lock sync.Mutex
+	buf  []byte
+}
+
+// NewChunker 创建音频分片器，size 不大于0时返回 nil，nil 分片器原样输出音频
+func NewChunker(size int) *Chunker {
+	if size <= 0 {
+		return nil
+	}
+	return &Chunker{size: size}
+}
+
+// ChunkSize 根据配置计算分片的字节数
+// pcm 音频可按时长切分，按16位单声道换算为字节数；其他格式为压缩音频，只能按字节数切分
+// @param ms 分片的时长，单位毫秒，0为不按时长切分
+// @param bytes 分片的字节数，0为不切分
+func ChunkSize(cfg *Config, ms, bytes int) int {
+	if ms > 0 && cfg.Format == "pcm" && cfg.SampleRate > 0 {
+		return cfg.SampleRate * 2 * ms / 1000 &^ 1 // 保证不截断采样点
+	}
+	return bytes
+}
+
+// Write 写入一段音频
+// @return 已凑满的分片，可能为空
+func (c *Chunker) Write(data []byte) [][]byte {
+	if c == nil {
+		if len(data) == 0 {
+			return nil
+		}
+		return [][]byte{data}
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.buf = append(c.buf, data...)
+	var chunks [][]byte
+	for len(c.buf) >= c.size {
+		chunks = append(chunks, c.buf[:c.size:c.size])
+		c.buf = c.buf[c.size:]
+	}
+	if len(c.buf) == 0 {
+		c.buf = nil
+	}
+	return chunks
+}
+
+// Flush 本次合成结束时取出暂存的音频
+func (c *Chunker) Flush() []byte {
+	if c == nil {
+		return nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	data := c.buf
+	c.buf = nil
+	return data
+}
+
+// Reset 丢弃暂存的音频，用于中止合成
+func (c *Chunker) Reset() {
+	_ = c.Flush()
+}
@@ -9,36 +9,64 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 
 	"crow/internal/tts"
+	"crow/pkg/connlimit"
+	"crow/pkg/lifecycle"
 	"crow/pkg/log"
+	"crow/pkg/netproxy"
 )
 
 // 阿里语音合成 CosyVoice WebSocket API 文档
 // https://help.aliyun.com/zh/model-studio/cosyvoice-websocket-api
 
 const (
-	wsURL = "wss://dashscope.aliyuncs.com/api-ws/v1/inference/" // WebSocket服务端地址
+	wsURL           = "wss://dashscope.aliyuncs.com/api-ws/v1/inference/" // WebSocket服务端地址
+	taskWaitTimeout = 5 * time.Second                                     // 复用连接时等待任务结束或开始的超时时间
+	resumeTimeout   = 10 * time.Second                                    // 连接中断后重新建立连接并开始任务的超时时间
 )
 
 type CosyVoice struct {
-	cfg *tts.Config
+	cfg atomic.Pointer[tts.Config] // 当前生效的配置，会话中可被整体替换，通过 config 读取
 	log *log.Logger
 
 	conn     *websocket.Conn
+	release  func() // 释放占用的连接名额
 	listener tts.Listener
 
 	lock sync.Mutex
 
-	isRunning   bool
+	state       lifecycle.State // 连接的生命周期状态
 	sendDataCnt int
 	connectID   string
 	reqID       string
 	taskID      string
+
+	connectCost     time.Duration // 建立连接的耗时
+	firstSendTime   int64         // 本次会话首次发送文本的时间，UnixNano
+	firstResultCost int64         // 首次发送文本到收到首个音频的耗时，单位纳秒
+	sendBytes       int64         // 本次会话发送的文本字节数
+	recvBytes       int64         // 本次会话收到的音频字节数
+
+	aborting  bool          // 当前任务已中止，等待服务端结束任务，期间的合成结果将被丢弃
+	taskIdle  chan struct{} // 已中止的任务结束后关闭
+	taskReady chan struct{} // 复用连接开始新任务后，收到task-started事件时关闭
+	idleTimer *time.Timer   // 开启 PersistentSession 时，任务结束后连接空闲超时则关闭
+
+	// 以下用于连接中断后的续传，仅在配置了 MaxResumeRetries 时记录
+	taskText      string // 本次任务已发送的全部文本
+	doneLen       int    // taskText 中已确认合成完毕的字节数
+	sentenceIndex int    // 当前正在合成的句子序号
+	sentenceEnd   int    // 当前句子已合成到的 taskText 位置
+	finishSent    bool   // 是否已发送finish-task指令
+	resumeCnt     int    // 本次任务已续传的次数
+	resuming      bool   // 正在重新建立连接，期间 ToTTS 写入的文本仅做记录，连接建立后一并发送
 }
 
 func NewCosyVoice(log *log.Logger) *CosyVoice {
@@ -48,6 +76,10 @@ func NewCosyVoice(log *log.Logger) *CosyVoice {
 	}
 }
 
+func (c *CosyVoice) Name() string {
+	return "cosy_voice"
+}
+
 func (c *CosyVoice) SetConfig(cfg *tts.Config) *tts.Config {
 	if cfg.Speaker == "" {
 		cfg.Speaker = "longlaotie_v2"
@@ -67,8 +99,22 @@ func (c *CosyVoice) SetConfig(cfg *tts.Config) *tts.Config {
 	if cfg.SampleRate <= 0 {
 		cfg.SampleRate = 16000
 	}
-	c.cfg = cfg
-	return c.cfg
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = wsURL
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = tts.DefaultMaxRetries
+	}
+	if cfg.SessionIdleTimeout <= 0 {
+		cfg.SessionIdleTimeout = tts.DefaultSessionIdleTimeout
+	}
+	c.cfg.Store(cfg)
+	return cfg
+}
+
+// config 当前生效的配置，SetConfig 整体替换配置，因此可与各协程并发读取
+func (c *CosyVoice) config() *tts.Config {
+	return c.cfg.Load()
 }
 
 func (c *CosyVoice) SetListener(listener tts.Listener) {
@@ -77,25 +123,40 @@ func (c *CosyVoice) SetListener(listener tts.Listener) {
 
 func (c *CosyVoice) ToTTS(ctx context.Context, text string) error {
 	c.lock.Lock()
-	isRunning := c.isRunning
+	c.stopIdleTimerLocked()
+	isRunning := c.state.Running()
+	needNewTask := c.state.Running() && (c.aborting || c.taskID == "")
 	c.lock.Unlock()
 
 	if !isRunning {
-		if err := c.initConnection(ctx); err != nil {
+		if err := c.Start(ctx); err != nil {
+			return err
+		}
+	} else if needNewTask {
+		if err := c.restartTask(ctx); err != nil {
 			return err
 		}
 	}
 
-	if len(text) > 0 && c.isRunning {
-		// 直接发送文本数据
-		err := c.sendTextData(text)
+	if len(text) > 0 && c.state.Running() {
+		// 直接发送文本数据，先记录文本，发送失败时可在续传中重新合成
+		c.lock.Lock()
+		if c.config().MaxResumeRetries > 0 {
+			c.taskText += text
+		}
+		var err error
+		if !c.resuming {
+			err = c.sendTextData(text)
+		}
+		if err == nil {
+			c.sendDataCnt++
+		}
+		c.lock.Unlock()
 		if err != nil {
 			return err
 		}
-		c.sendDataCnt++
-		if c.sendDataCnt%20 == 0 {
-			c.log.Debugf("send text data cnt: %d", c.sendDataCnt)
-		}
+		atomic.AddInt64(&c.sendBytes, int64(len(text)))
+		atomic.CompareAndSwapInt64(&c.firstSendTime, 0, time.Now().UnixNano())
 	}
 	return nil
 }
@@ -118,6 +179,7 @@ type Payload struct {
 	Parameters Params     `json:"parameters"`
 	Resources  []Resource `json:"resources"`
 	Input      Input      `json:"input"`
+	Output     *Output    `json:"output,omitempty"` // 仅服务端的result-generated事件携带
 }
 
 type Params struct {
@@ -128,6 +190,21 @@ type Params struct {
 	Volume     int     `json:"volume"`
 	Rate       float32 `json:"rate"`
 	Pitch      float32 `json:"pitch"`
+	// WordTimestampEnabled 是否开启字级别时间戳，开启后result-generated事件会携带时间戳
+	WordTimestampEnabled bool `json:"word_timestamp_enabled,omitempty"`
+}
+
+type Output struct {
+	Sentence struct {
+		Index int    `json:"index"`
+		Words []Word `json:"words"`
+	} `json:"sentence"`
+}
+
+type Word struct {
+	Text      string `json:"text"`
+	BeginTime int64  `json:"begin_time"`
+	EndTime   int64  `json:"end_time"`
 }
 
 type Resource struct {
@@ -146,77 +223,163 @@ type Event struct {
 
 func (c *CosyVoice) initConnection(ctx context.Context) error {
 	c.log.Info("start tts")
+	start := time.Now()
 
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
-	if c.isRunning {
+	if c.state.Running() {
 		return nil
 	}
+	c.state.Start()
+	// 建立失败时回到 StatusStopped，建立成功时已流转为 StatusRunning，不受影响
+	defer c.state.Transition(lifecycle.StatusStarting, lifecycle.StatusStopped)
 
 	if c.conn != nil {
 		c.closeConnection()
 	}
 
+	// 占用连接名额，避免超出服务商的并发配额，建立连接失败时释放
+	release, err := connlimit.Get("tts", c.Name(), c.config().MaxConcurrency, c.config().ConcurrencyTimeout).Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("%w, %v", tts.ErrUnavailable, err)
+	}
+	defer func() {
+		if c.state.Running() {
+			c.release = release
+		} else {
+			release()
+		}
+	}()
+
+	conn, taskID, err := c.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.conn = conn
+	c.taskID = taskID
+	c.state.Transition(lifecycle.StatusStarting, lifecycle.StatusRunning)
+	c.resetProgressLocked()
+	c.reqID = fmt.Sprintf("%d", time.Now().UnixNano())
+
+	c.connectCost = time.Since(start)
+	c.sendDataCnt = 0
+	atomic.StoreInt64(&c.firstSendTime, 0)
+	atomic.StoreInt64(&c.firstResultCost, 0)
+	atomic.StoreInt64(&c.sendBytes, 0)
+	atomic.StoreInt64(&c.recvBytes, 0)
+	c.log.WithFields(log.Fields{
+		"connect_id": c.connectID,
+		"req_id":     c.reqID,
+		"connect_ms": c.connectCost.Milliseconds(),
+	}).Debug("init tts succeed")
+
+	go c.readMessage()
+	return nil
+}
+
+// connect 建立连接并开始新的任务，等待task-started事件后返回连接及任务ID
+func (c *CosyVoice) connect(ctx context.Context) (*websocket.Conn, string, error) {
+	cfg := c.config()
 	header := make(http.Header)
 	header.Add("X-DashScope-DataInspection", "enable")
-	header.Add("Authorization", fmt.Sprintf("bearer %s", c.cfg.ApiKey))
+	header.Add("Authorization", fmt.Sprintf("bearer %s", cfg.ApiKey))
 
 	var (
 		conn *websocket.Conn
 		resp *http.Response
 		err  error
 	)
-	maxRetries := 2 // 最大重试次数
-	for i := 0; i < maxRetries; i++ {
-		dialer := websocket.DefaultDialer
-		conn, resp, err = dialer.DialContext(ctx, wsURL, header)
-		if err == nil {
-			break
-		}
-		if i+1 < maxRetries {
-			backoffTime := time.Duration(500*(i+1)) * time.Millisecond
-			c.log.Warnf("failed to connect to the websocket, try %d/%d: %v, will try again %v", i+1, maxRetries+1, err, backoffTime)
-			time.Sleep(backoffTime)
-		}
-	}
+	dialer := *websocket.DefaultDialer
+	dialer.Proxy = netproxy.Func(cfg.Proxy)
+	err = lifecycle.Retry(ctx, cfg.MaxRetries, func() error {
+		conn, resp, err = dialer.DialContext(ctx, cfg.Endpoint, header)
+		return err
+	}, func(attempt int, err error, backoff time.Duration) {
+		c.log.Warnf("failed to connect to the websocket, try %d/%d: %v, will try again %v", attempt, cfg.MaxRetries, err, backoff)
+	})
 	if err != nil {
 		statusCode := 0
 		if resp != nil {
 			statusCode = resp.StatusCode
 		}
-		return fmt.Errorf("falied to connect(status_code:%d): %v", statusCode, err)
+		return nil, "", fmt.Errorf("%w, falied to connect(status_code:%d): %v", tts.ErrUnavailable, statusCode, err)
 	}
 
 	// 发送run-task指令
-	c.taskID, err = c.sendRunTaskCmd(conn)
+	taskID, err := c.sendRunTaskCmd(conn)
 	if err != nil {
-		return fmt.Errorf("send run task cmd error: %v", err)
+		_ = conn.Close()
+		return nil, "", fmt.Errorf("send run task cmd error: %v", err)
 	}
 	// 等待task-started事件
 	msgType, message, err := conn.ReadMessage()
 	if err != nil {
-		return fmt.Errorf("get task-started event message error: %v", err)
+		_ = conn.Close()
+		return nil, "", fmt.Errorf("get task-started event message error: %v", err)
 	}
 	if msgType != websocket.TextMessage {
-		return fmt.Errorf("unexpected message type: %v", msgType)
+		_ = conn.Close()
+		return nil, "", fmt.Errorf("unexpected message type: %v", msgType)
 	}
 	var event Event
 	if err = json.Unmarshal(message, &event); err != nil {
-		return fmt.Errorf("error unmarshaling task-started event message: %v", err)
+		_ = conn.Close()
+		return nil, "", fmt.Errorf("error unmarshaling task-started event message: %v", err)
 	}
 	if event.Header.Event != "task-started" {
-		return fmt.Errorf("unexpected task-started event, got: %s", event.Header.Event)
+		_ = conn.Close()
+		return nil, "", fmt.Errorf("unexpected task-started event, got: %s", event.Header.Event)
 	}
+	return conn, taskID, nil
+}
 
-	c.conn = conn
-	c.isRunning = true
-	c.reqID = fmt.Sprintf("%d", time.Now().UnixNano())
+// restartTask 复用已有连接开始新的任务，若已中止的任务尚未结束，则先等待其结束
+func (c *CosyVoice) restartTask(ctx context.Context) error {
+	c.lock.Lock()
+	idle := c.taskIdle
+	c.lock.Unlock()
 
-	c.log.Debugf("init tts succeed, connect_id: %s, req_id: %s", c.connectID, c.reqID)
+	if idle != nil {
+		select {
+		case <-idle:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(taskWaitTimeout):
+			c.log.Warn("wait for aborted task finished timeout, reconnect")
+			_ = c.Reset()
+			return c.initConnection(ctx)
+		}
+	}
 
-	go c.readMessage()
-	return nil
+	c.lock.Lock()
+	if !c.state.Running() || c.conn == nil {
+		c.lock.Unlock()
+		return c.initConnection(ctx)
+	}
+	ready := make(chan struct{})
+	c.taskReady = ready
+	taskID, err := c.sendRunTaskCmd(c.conn)
+	if err == nil {
+		c.taskID = taskID
+		c.resetProgressLocked()
+	}
+	c.lock.Unlock()
+	if err != nil {
+		return fmt.Errorf("send run task cmd error: %v", err)
+	}
+
+	select {
+	case <-ready:
+		c.log.Debugf("restart tts task succeed, task_id: %s", taskID)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(taskWaitTimeout):
+		_ = c.Reset()
+		return errors.New("wait for task-started event timeout")
+	}
 }
 
 func (c *CosyVoice) sendTextData(text string) error {
@@ -249,8 +412,10 @@ func (c *CosyVoice) readMessage() {
 		if err := recover(); err != nil {
 			c.log.Errorf("tts read message panic: %v", err)
 		}
+		c.logStats()
 		c.lock.Lock()
-		c.isRunning = false
+		c.state.Stop()
+		c.finishAbortLocked()
 		if c.conn != nil {
 			c.closeConnection()
 		}
@@ -261,7 +426,7 @@ func (c *CosyVoice) readMessage() {
 	for {
 		// 检查连接状态，避免在连接关闭后继续读取
 		c.lock.Lock()
-		if !c.isRunning || c.conn == nil {
+		if !c.state.Running() || c.conn == nil {
 			c.lock.Unlock()
 			c.log.Info("流式识别已结束或连接已关闭，退出读取循环")
 			return
@@ -271,11 +436,22 @@ func (c *CosyVoice) readMessage() {
 
 		msgType, message, err := conn.ReadMessage()
 		if err != nil {
+			if c.resume(err) {
+				continue
+			}
 			c.setErrorAndStop(err)
 			return
 		}
 
 		if msgType == websocket.BinaryMessage {
+			// 已中止任务的音频直接丢弃
+			c.lock.Lock()
+			aborting := c.aborting
+			c.lock.Unlock()
+			if aborting {
+				continue
+			}
+			c.markResult(len(message))
 			// 处理二进制音频流
 			base64Message := base64.StdEncoding.EncodeToString(message)
 			if finished := c.listener.OnTtsResult([]byte(base64Message), tts.StateProcessing); finished {
@@ -306,6 +482,7 @@ func (c *CosyVoice) sendRunTaskCmd(conn *websocket.Conn) (string, error) {
 
 // 生成run-task指令
 func (c *CosyVoice) generateRunTaskCmd() (string, string, error) {
+	cfg := c.config()
 	taskID := uuid.New().String()
 	runTaskCmd := Event{
 		Header: Header{
@@ -320,12 +497,15 @@ func (c *CosyVoice) generateRunTaskCmd() (string, string, error) {
 			Model:     "cosyvoice-v2",
 			Parameters: Params{
 				TextType:   "PlainText",
-				Voice:      c.cfg.Speaker,
-				Format:     c.cfg.Format,
-				SampleRate: c.cfg.SampleRate,
-				Volume:     c.cfg.Volume,
-				Rate:       c.cfg.Speed,
-				Pitch:      c.cfg.Pitch,
+				Voice:      cfg.Speaker,
+				Format:     cfg.Format,
+				SampleRate: cfg.SampleRate,
+				Volume:     cfg.Volume,
+				Rate:       cfg.Speed,
+				Pitch:      cfg.Pitch,
+
+				// 续传需根据字词时间戳确认已合成的文本
+				WordTimestampEnabled: cfg.EnableTimestamp || cfg.MaxResumeRetries > 0,
 			},
 			Input: Input{},
 		},
@@ -354,20 +534,69 @@ func (c *CosyVoice) generateContinueTaskCmd(text string) (string, error) {
 
 // 处理事件
 func (c *CosyVoice) handleEvent(event Event) bool {
+	cfg := c.config()
 	switch event.Header.Event {
 	case "result-generated":
-		// 忽略result-generated事件
+		if event.Payload.Output == nil || len(event.Payload.Output.Sentence.Words) == 0 {
+			return false
+		}
+		sentence := event.Payload.Output.Sentence
+		c.lock.Lock()
+		aborting := c.aborting
+		if !aborting {
+			c.trackProgressLocked(sentence.Index, sentence.Words)
+		}
+		c.lock.Unlock()
+		// 未开启时间戳时，无需下发
+		if aborting || !cfg.EnableTimestamp {
+			return false
+		}
+		timestamp := tts.Timestamp{SentenceIndex: sentence.Index, Words: make([]tts.Word, 0, len(sentence.Words))}
+		for _, word := range sentence.Words {
+			timestamp.Words = append(timestamp.Words, tts.Word{Text: word.Text, BeginTime: word.BeginTime, EndTime: word.EndTime})
+		}
+		tts.NotifyTimestamp(c.listener, timestamp)
+		return false
+	case "task-started":
+		// 复用连接开始新任务
+		c.lock.Lock()
+		if c.taskReady != nil {
+			close(c.taskReady)
+			c.taskReady = nil
+		}
+		c.lock.Unlock()
 		return false
 	case "task-finished":
-		c.listener.OnTtsResult(nil, tts.StateCompleted)
-		return true
+		c.lock.Lock()
+		if c.aborting {
+			// 已中止的任务结束，保留连接以便开始新任务
+			c.finishAbortLocked()
+			c.taskID = ""
+			c.lock.Unlock()
+			return false
+		}
+		persistent := cfg.PersistentSession
+		if persistent {
+			// 保留连接，下一轮合成时开始新的任务
+			c.taskID = ""
+			c.resetProgressLocked()
+			c.idleTimer = time.AfterFunc(cfg.SessionIdleTimeout, c.closeIfIdle)
+		}
+		c.lock.Unlock()
+		finished := c.listener.OnTtsResult(nil, tts.StateCompleted)
+		return finished || !persistent
 	case "task-failed":
+		c.lock.Lock()
+		aborting := c.aborting
+		c.lock.Unlock()
 		if event.Header.ErrorMessage != "" {
 			c.setErrorAndStop(errors.New(event.Header.ErrorMessage))
 		} else {
 			c.setErrorAndStop(errors.New("未知原因导致任务失败"))
 		}
-		c.listener.OnTtsResult(nil, tts.StateCompleted)
+		if !aborting {
+			c.listener.OnTtsResult(nil, tts.StateCompleted)
+		}
 		return true
 	default:
 		fmt.Printf("预料之外的事件：%v\n", event)
@@ -379,7 +608,8 @@ func (c *CosyVoice) setErrorAndStop(err error) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
-	c.isRunning = false
+	c.state.Stop()
+	c.finishAbortLocked()
 
 	if strings.Contains(err.Error(), "use of closed network connection") {
 		c.log.Debugf("setErrorAndStop: %v, sendDataCnt=%d", err, c.sendDataCnt)
@@ -393,6 +623,12 @@ func (c *CosyVoice) setErrorAndStop(err error) {
 }
 
 func (c *CosyVoice) ToSessionFinish() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.aborting {
+		return nil
+	}
+	c.finishSent = true
 	if err := c.sendFinishTaskCmd(); err != nil {
 		c.log.Errorf("send finish task cmd error: %v", err)
 		return err
@@ -408,6 +644,10 @@ func (c *CosyVoice) closeConnection() {
 		}
 	}()
 
+	if c.release != nil {
+		c.release()
+		c.release = nil
+	}
 	if c.conn != nil {
 		_ = c.conn.Close()
 		c.conn = nil
@@ -416,7 +656,7 @@ func (c *CosyVoice) closeConnection() {
 
 // 发送finish-task指令
 func (c *CosyVoice) sendFinishTaskCmd() error {
-	if c.conn == nil || !c.isRunning {
+	if c.conn == nil || !c.state.Running() {
 		return nil
 	}
 	finishTaskCmd, err := c.generateFinishTaskCmd()
@@ -443,15 +683,229 @@ func (c *CosyVoice) generateFinishTaskCmd() (string, error) {
 	return string(finishTaskCmdJSON), err
 }
 
+// trackProgressLocked 根据result-generated事件的字词记录已合成的文本位置，调用方需持有锁
+// 同一句子可能多次回调，每次均从已确认的位置重新匹配；句子序号变化时，上一句视为合成完毕
+func (c *CosyVoice) trackProgressLocked(index int, words []Word) {
+	if c.config().MaxResumeRetries <= 0 {
+		return
+	}
+	if index != c.sentenceIndex {
+		c.doneLen = c.sentenceEnd
+		c.sentenceIndex = index
+	}
+	pos := c.doneLen
+	for _, word := range words {
+		if i := strings.Index(c.taskText[pos:], word.Text); i >= 0 {
+			pos += i + len(word.Text)
+		}
+	}
+	c.sentenceEnd = pos
+}
+
+// resume 合成过程中连接中断时，重新建立连接并开始新的任务，仅重新合成尚未合成完毕的句子
+// 返回 false 表示无需或无法续传
+func (c *CosyVoice) resume(cause error) bool {
+	cfg := c.config()
+	c.lock.Lock()
+	if !c.state.Running() || c.aborting || c.taskID == "" || c.resumeCnt >= cfg.MaxResumeRetries {
+		c.lock.Unlock()
+		return false
+	}
+	c.resumeCnt++
+
+	// 跳过上一句末尾的标点与空白
+	remaining := strings.TrimLeftFunc(c.taskText[c.doneLen:], func(r rune) bool {
+		return unicode.IsPunct(r) || unicode.IsSpace(r)
+	})
+	c.doneLen = len(c.taskText) - len(remaining)
+	c.log.Warnf("tts connection dropped: %v, resume %d/%d, remaining text: %s", cause, c.resumeCnt, cfg.MaxResumeRetries, remaining)
+
+	c.closeConnection()
+	c.resuming = true
+	taskID := c.taskID
+	c.lock.Unlock()
+
+	// 等待连接名额及建立连接可能耗时较长，期间不持有锁，以免阻塞 ToTTS、Abort、Reset 等调用
+	conn, release, newTaskID, err := c.redial(cfg)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.resuming = false
+	if err != nil {
+		c.log.Errorf("failed to resume tts: %v", err)
+		return false
+	}
+	// 建立连接期间任务可能已被中止、重置或开始了新的任务，此时放弃新的连接
+	if !c.state.Running() || c.aborting || c.taskID != taskID || c.conn != nil {
+		_ = conn.Close()
+		release()
+		c.log.Info("tts task changed while resuming, discard the new connection")
+		return false
+	}
+	c.release = release
+	c.conn = conn
+	c.taskID = newTaskID
+	c.sentenceIndex = 0
+	c.sentenceEnd = c.doneLen
+
+	// 续传期间通过 ToTTS 写入的文本仅做了记录，与未合成完毕的文本一并发送
+	if err = c.sendTextData(c.taskText[c.doneLen:]); err != nil {
+		c.log.Errorf("failed to resume tts: %v", err)
+		return false
+	}
+	if c.finishSent {
+		if err = c.sendFinishTaskCmd(); err != nil {
+			c.log.Errorf("failed to resume tts: send finish task cmd error: %v", err)
+			return false
+		}
+	}
+	return true
+}
+
+// redial 占用连接名额并重新建立连接，开始新的任务，失败时释放名额
+func (c *CosyVoice) redial(cfg *tts.Config) (*websocket.Conn, func(), string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), resumeTimeout)
+	defer cancel()
+	release, err := connlimit.Get("tts", c.Name(), cfg.MaxConcurrency, cfg.ConcurrencyTimeout).Acquire(ctx)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	conn, taskID, err := c.connect(ctx)
+	if err != nil {
+		release()
+		return nil, nil, "", err
+	}
+	return conn, release, taskID, nil
+}
+
+// resetProgressLocked 开始新任务时清空续传记录，调用方需持有锁
+func (c *CosyVoice) resetProgressLocked() {
+	c.taskText = ""
+	c.doneLen = 0
+	c.sentenceIndex = 0
+	c.sentenceEnd = 0
+	c.finishSent = false
+	c.resumeCnt = 0
+}
+
+// markResult 记录收到的音频数据量及首个音频的延迟
+func (c *CosyVoice) markResult(size int) {
+	atomic.AddInt64(&c.recvBytes, int64(size))
+	start := atomic.LoadInt64(&c.firstSendTime)
+	if start == 0 || atomic.LoadInt64(&c.firstResultCost) != 0 {
+		return
+	}
+	atomic.StoreInt64(&c.firstResultCost, time.Now().UnixNano()-start)
+}
+
+// logStats 以结构化字段输出本次会话的耗时与数据量统计
+func (c *CosyVoice) logStats() {
+	c.log.WithFields(log.Fields{
+		"provider":        c.Name(),
+		"connect_id":      c.connectID,
+		"req_id":          c.reqID,
+		"connect_ms":      c.connectCost.Milliseconds(),
+		"first_result_ms": time.Duration(atomic.LoadInt64(&c.firstResultCost)).Milliseconds(),
+		"send_bytes":      atomic.LoadInt64(&c.sendBytes),
+		"recv_bytes":      atomic.LoadInt64(&c.recvBytes),
+		"send_cnt":        c.sendDataCnt,
+	}).Info("tts session stats")
+}
+
+func (c *CosyVoice) Abort() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if !c.state.Running() || c.taskID == "" || c.aborting {
+		return nil
+	}
+	if c.resuming {
+		// 正在续传，续传完成时发现已中止会放弃新的连接，待读取循环退出后即可开始新任务
+		c.aborting = true
+		c.taskIdle = make(chan struct{})
+		c.log.Info("cosy voice abort while resuming")
+		return nil
+	}
+	if c.conn == nil {
+		return nil
+	}
+
+	// CosyVoice 没有取消任务的指令，发送finish-task后丢弃剩余的合成结果，待任务结束后复用连接
+	if err := c.sendFinishTaskCmd(); err != nil {
+		c.state.Stop()
+		c.closeConnection()
+		return fmt.Errorf("send finish task cmd error: %v", err)
+	}
+	c.aborting = true
+	c.taskIdle = make(chan struct{})
+	c.log.Info("cosy voice abort")
+	return nil
+}
+
+// finishAbortLocked 结束中止状态并唤醒等待者，调用方需持有锁
+func (c *CosyVoice) finishAbortLocked() {
+	c.aborting = false
+	if c.taskIdle != nil {
+		close(c.taskIdle)
+		c.taskIdle = nil
+	}
+	if c.taskReady != nil {
+		close(c.taskReady)
+		c.taskReady = nil
+	}
+}
+
+// closeIfIdle 保留的连接空闲超时后关闭，期间已开始新任务时不做处理
+func (c *CosyVoice) closeIfIdle() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.state.Running() || c.taskID != "" || c.aborting {
+		return
+	}
+	c.log.Info("tts connection idle timeout, close")
+	c.state.Transition(lifecycle.StatusRunning, lifecycle.StatusStopping)
+	c.closeConnection()
+	c.state.Stop()
+}
+
+// stopIdleTimerLocked 停止空闲计时，调用方需持有锁
+func (c *CosyVoice) stopIdleTimerLocked() {
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+		c.idleTimer = nil
+	}
+}
+
+// Start 建立连接，已建立时直接返回，可用于预先建连以降低首次合成的延迟
+func (c *CosyVoice) Start(ctx context.Context) error {
+	if c.state.Running() {
+		return nil
+	}
+	return c.initConnection(ctx)
+}
+
+// Stop 关闭连接并重置状态，同 Reset
+func (c *CosyVoice) Stop() error {
+	return c.Reset()
+}
+
+func (c *CosyVoice) Status() lifecycle.Status {
+	return c.state.Status()
+}
+
 func (c *CosyVoice) Reset() error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
-	c.isRunning = false
+	c.state.Transition(lifecycle.StatusRunning, lifecycle.StatusStopping)
+	c.stopIdleTimerLocked()
+	c.finishAbortLocked()
 	c.closeConnection()
+	c.state.Stop()
 
 	c.taskID = ""
 	c.sendDataCnt = 0
+	c.resetProgressLocked()
 
 	c.log.Info("cosy voice reset")
 	return nil
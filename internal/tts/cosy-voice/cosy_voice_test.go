@@ -0,0 +1,311 @@
+package cosy_voice
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"crow/internal/config"
+	"crow/internal/tts"
+	"crow/pkg/fakews"
+	"crow/pkg/lifecycle"
+	"crow/pkg/log"
+)
+
+// result 一次合成结果回调
+type result struct {
+	data  string
+	state tts.State
+}
+
+// fakeListener 记录合成结果回调，合成结束时关闭 done
+type fakeListener struct {
+	lock    sync.Mutex
+	results []result
+	done    chan struct{}
+}
+
+func newFakeListener() *fakeListener {
+	return &fakeListener{done: make(chan struct{})}
+}
+
+func (l *fakeListener) OnTtsResult(data []byte, state tts.State) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.results = append(l.results, result{string(data), state})
+	if state == tts.StateCompleted {
+		close(l.done)
+		return true
+	}
+	return false
+}
+
+func (l *fakeListener) get() []result {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return append([]result(nil), l.results...)
+}
+
+// wait 等待合成结束
+func (l *fakeListener) wait(t *testing.T) []result {
+	t.Helper()
+	select {
+	case <-l.done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("synthesis not completed, results: %v", l.get())
+	}
+	return l.get()
+}
+
+// event 服务端下发的事件
+func event(name string) fakews.Frame {
+	return fakews.DashScopeEvent(name, "task", nil)
+}
+
+func newTestCosyVoice(t *testing.T, endpoint string, listener tts.Listener) *CosyVoice {
+	t.Helper()
+	c := NewCosyVoice(log.NewLogger(&log.Option{Hook: io.Discard, Mode: "debug"}))
+	c.SetConfig(&tts.Config{TtsConfig: config.TtsConfig{ApiKey: "key", Endpoint: endpoint}})
+	c.SetListener(listener)
+	t.Cleanup(func() { _ = c.Reset() })
+	return c
+}
+
+// waitStopped 等待读取循环退出后连接关闭
+func waitStopped(t *testing.T, c *CosyVoice) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Status() != lifecycle.StatusStopped {
+		if time.Now().After(deadline) {
+			t.Fatal("still running, want stopped")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestStart(t *testing.T) {
+	server := fakews.NewServer(fakews.Step{Wait: 1, Frames: []fakews.Frame{event("task-started")}})
+	defer server.Close()
+	c := newTestCosyVoice(t, server.URL(), newFakeListener())
+
+	if err := c.Start(t.Context()); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if got := c.Status(); got != lifecycle.StatusRunning {
+		t.Fatalf("status = %v after start, want running", got)
+	}
+	if got := server.Header().Get("Authorization"); got != "bearer key" {
+		t.Fatalf("authorization = %q", got)
+	}
+
+	// run-task 指令携带默认的合成参数
+	received := server.Received()
+	if len(received) != 1 {
+		t.Fatalf("received %d messages, want 1", len(received))
+	}
+	var cmd Event
+	if err := json.Unmarshal(received[0], &cmd); err != nil {
+		t.Fatal(err)
+	}
+	params := cmd.Payload.Parameters
+	if cmd.Header.Action != "run-task" || cmd.Header.TaskID != c.taskID || params.Voice != "longlaotie_v2" || params.Format != "mp3" || params.SampleRate != 16000 {
+		t.Fatalf("run-task = %+v", cmd)
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	server := fakews.NewServer(fakews.Step{Wait: 1, Frames: []fakews.Frame{event("task-started")}})
+	defer server.Close()
+	c := newTestCosyVoice(t, server.URL(), newFakeListener())
+
+	if err := c.Start(t.Context()); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	// 已建立连接时再次 Start 不会重新建连
+	if err := c.Start(t.Context()); err != nil {
+		t.Fatalf("start again: %v", err)
+	}
+	if n := len(server.Received()); n != 1 {
+		t.Fatalf("received %d messages, want 1", n)
+	}
+	if err := c.Stop(); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	if got := c.Status(); got != lifecycle.StatusStopped {
+		t.Fatalf("status = %v after stop, want stopped", got)
+	}
+}
+
+func TestStartFailed(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame fakews.Frame
+	}{
+		{"task failed", fakews.DashScopeTaskFailed("task", "InvalidParameter", "invalid voice")},
+		{"binary message", fakews.Binary([]byte{1, 2})},
+		{"invalid json", fakews.Truncate(event("task-started"), 10)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := fakews.NewServer(fakews.Step{Wait: 1, Frames: []fakews.Frame{tt.frame}})
+			defer server.Close()
+			c := newTestCosyVoice(t, server.URL(), newFakeListener())
+
+			if err := c.Start(t.Context()); err == nil {
+				t.Fatal("start succeeded, want error")
+			}
+			if got := c.Status(); got != lifecycle.StatusStopped {
+				t.Fatalf("status = %v after failed start, want stopped", got)
+			}
+		})
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	server := fakews.NewServer(
+		fakews.Step{Wait: 1, Frames: []fakews.Frame{event("task-started")}},
+		fakews.Step{Wait: 1, Frames: []fakews.Frame{
+			fakews.Binary([]byte{1, 2, 3}),
+			{MessageType: websocket.TextMessage, Data: []byte(`not json`)}, // 无法解析的事件被跳过
+			fakews.Binary([]byte{4, 5}),
+			event("task-finished"),
+		}},
+	)
+	defer server.Close()
+	listener := newFakeListener()
+	c := newTestCosyVoice(t, server.URL(), listener)
+
+	if err := c.ToTTS(t.Context(), "你好"); err != nil {
+		t.Fatalf("to tts: %v", err)
+	}
+	got := listener.wait(t)
+	want := []result{
+		{base64.StdEncoding.EncodeToString([]byte{1, 2, 3}), tts.StateProcessing},
+		{base64.StdEncoding.EncodeToString([]byte{4, 5}), tts.StateProcessing},
+		{"", tts.StateCompleted},
+	}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Fatalf("results = %v, want %v", got, want)
+	}
+
+	// 文本以 continue-task 指令发送
+	var cmd Event
+	if err := json.Unmarshal(server.Received()[1], &cmd); err != nil {
+		t.Fatal(err)
+	}
+	if cmd.Header.Action != "continue-task" || cmd.Payload.Input.Text != "你好" {
+		t.Fatalf("continue-task = %+v", cmd)
+	}
+	// 任务结束后关闭连接
+	waitStopped(t, c)
+}
+
+func TestReadMessageTaskFailed(t *testing.T) {
+	server := fakews.NewServer(
+		fakews.Step{Wait: 1, Frames: []fakews.Frame{event("task-started")}},
+		fakews.Step{Wait: 1, Frames: []fakews.Frame{fakews.Binary([]byte{1}), fakews.DashScopeTaskFailed("task", "InternalError", "internal error")}},
+	)
+	defer server.Close()
+	listener := newFakeListener()
+	c := newTestCosyVoice(t, server.URL(), listener)
+
+	if err := c.ToTTS(t.Context(), "你好"); err != nil {
+		t.Fatalf("to tts: %v", err)
+	}
+	// 任务失败时以空的最终结果结束合成，并关闭连接
+	got := listener.wait(t)
+	if last := got[len(got)-1]; last != (result{"", tts.StateCompleted}) {
+		t.Fatalf("last result = %v, want empty completed", last)
+	}
+	waitStopped(t, c)
+}
+
+func TestReadMessageConnectionClosed(t *testing.T) {
+	server := fakews.NewServer(fakews.Step{Wait: 1, Frames: []fakews.Frame{event("task-started")}, Close: true})
+	defer server.Close()
+	c := newTestCosyVoice(t, server.URL(), newFakeListener())
+
+	if err := c.Start(t.Context()); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	// 服务端断开后读取循环退出，下一次合成时重新建连
+	waitStopped(t, c)
+}
+
+// sentence 构造 result-generated 事件，words 为句子中已合成的字词
+func sentence(index int, words ...string) fakews.Frame {
+	output := Output{}
+	output.Sentence.Index = index
+	for _, w := range words {
+		output.Sentence.Words = append(output.Sentence.Words, Word{Text: w})
+	}
+	return fakews.DashScopeEvent("result-generated", "task", output)
+}
+
+func TestResume(t *testing.T) {
+	server := fakews.NewSequenceServer(
+		// 第一句合成完毕、第二句合成到一半时连接中断
+		[]fakews.Step{
+			{Wait: 1, Frames: []fakews.Frame{event("task-started")}},
+			{Wait: 1, Frames: []fakews.Frame{sentence(0, "你", "好"), fakews.Binary([]byte{1}), sentence(1, "再")}, Close: true},
+		},
+		// 续传的连接延迟开始任务，期间 ToTTS 不应被阻塞
+		[]fakews.Step{
+			{Wait: 1, Frames: []fakews.Frame{{MessageType: websocket.TextMessage, Data: event("task-started").Data, Delay: 300 * time.Millisecond}}},
+			{Wait: 1, Frames: []fakews.Frame{fakews.Binary([]byte{2}), event("task-finished")}},
+		},
+	)
+	defer server.Close()
+	listener := newFakeListener()
+	c := newTestCosyVoice(t, server.URL(), listener)
+	cfg := *c.config()
+	cfg.MaxResumeRetries = 1
+	c.SetConfig(&cfg)
+
+	if err := c.ToTTS(t.Context(), "你好。再见。"); err != nil {
+		t.Fatalf("to tts: %v", err)
+	}
+	// 等待续传的连接发送 run-task 指令
+	deadline := time.Now().Add(5 * time.Second)
+	for len(server.Received()) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("received %d messages, want resume run-task", len(server.Received()))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	start := time.Now()
+	if err := c.ToTTS(t.Context(), "还有"); err != nil {
+		t.Fatalf("to tts while resuming: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Fatalf("to tts blocked %v while resuming", elapsed)
+	}
+
+	got := listener.wait(t)
+	want := []result{
+		{base64.StdEncoding.EncodeToString([]byte{1}), tts.StateProcessing},
+		{base64.StdEncoding.EncodeToString([]byte{2}), tts.StateProcessing},
+		{"", tts.StateCompleted},
+	}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Fatalf("results = %v, want %v", got, want)
+	}
+
+	// 续传的任务只重新合成尚未合成完毕的句子，以及续传期间写入的文本
+	received := server.Received()
+	if len(received) != 4 {
+		t.Fatalf("received %d messages, want 4", len(received))
+	}
+	var cmd Event
+	if err := json.Unmarshal(received[3], &cmd); err != nil {
+		t.Fatal(err)
+	}
+	if cmd.Header.Action != "continue-task" || cmd.Payload.Input.Text != "再见。还有" {
+		t.Fatalf("resumed continue-task = %+v", cmd)
+	}
+}
@@ -1,24 +1,27 @@
 package doubao
 
 import (
-	"bytes"
-	"compress/gzip"
 	"context"
 	"encoding/base64"
-	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 
 	"crow/internal/tts"
+	"crow/pkg/connlimit"
+	"crow/pkg/lifecycle"
 	"crow/pkg/log"
+	"crow/pkg/netproxy"
+	"crow/pkg/volcproto"
 )
 
 // 豆包语音合成大模型 WebSocket 接口
@@ -31,15 +34,22 @@ const (
 var splitPunctuation = map[rune]bool{',': true, '.': true, '!': true, '?': true, ';': true, ':': true, '，': true, '。': true, '！': true, '？': true, '；': true, '：': true}
 
 type Doubao struct {
-	cfg *tts.Config
+	cfg atomic.Pointer[tts.Config] // 当前生效的配置，会话中可被整体替换，通过 config 读取
 	log *log.Logger
 
 	listener tts.Listener
 
+	lock sync.Mutex
+	conn *websocket.Conn // 当前正在合成的连接
+	// cancelQuery 取消当前正在进行的 query 合成请求
+	cancelQuery context.CancelFunc
+
 	connectID string
 	reqID     string
 
-	text string
+	text    string
+	gen     int32 // 合成批次，Abort 后递增，旧批次的合成结果将被丢弃
+	textGen int32 // text 所属的合成批次
 }
 
 func NewDoubao(log *log.Logger) *Doubao {
@@ -49,6 +59,10 @@ func NewDoubao(log *log.Logger) *Doubao {
 	}
 }
 
+func (d *Doubao) Name() string {
+	return "doubao"
+}
+
 func (d *Doubao) SetConfig(cfg *tts.Config) *tts.Config {
 	if cfg.Speaker == "" {
 		cfg.Speaker = "zh_male_guangxiyuanzhou_moon_bigtts"
@@ -68,14 +82,30 @@ func (d *Doubao) SetConfig(cfg *tts.Config) *tts.Config {
 	if cfg.SampleRate < 8000 || cfg.SampleRate > 24000 {
 		cfg.SampleRate = 16000
 	}
-	d.cfg = cfg
-	if d.cfg.Volume < 5 {
-		d.cfg.Volume = 5
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = wsURL
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = tts.DefaultMaxRetries
+	}
+	if cfg.Operation != operationQuery {
+		cfg.Operation = operationSubmit
 	}
-	d.cfg.Volume /= 50 // 豆包音量范围是[0.1~3.0]，而我们的音量范围是0-100，最大按2处理，
+	cfg.EnableTimestamp = false   // 暂不支持下发字词时间戳
+	cfg.PersistentSession = false // 每段文本单独建立连接，不支持保留连接
+	if cfg.Volume < 5 {
+		cfg.Volume = 5
+	}
+	cfg.Volume /= 50 // 豆包音量范围是[0.1~3.0]，而我们的音量范围是0-100，最大按2处理，
+	d.cfg.Store(cfg)
 	return cfg
 }
 
+// config 当前生效的配置，SetConfig 整体替换配置，因此可与各协程并发读取
+func (d *Doubao) config() *tts.Config {
+	return d.cfg.Load()
+}
+
 func (d *Doubao) SetListener(listener tts.Listener) {
 	d.listener = listener
 }
@@ -84,30 +114,31 @@ func (d *Doubao) ToTTS(ctx context.Context, text string) error {
 	if text == "" {
 		return nil
 	}
+	// 已中止的批次中尚未合成的文本需要丢弃
+	if gen := atomic.LoadInt32(&d.gen); gen != d.textGen {
+		d.text = ""
+		d.textGen = gen
+	}
 	// 拼接文本，按标点分割语句后再进行tts
-	var builder strings.Builder
+	var (
+		builder strings.Builder
+		lastErr error
+	)
 	for _, v := range []rune(text) {
 		builder.WriteRune(v)
 		if splitPunctuation[v] {
 			d.text += builder.String()
-			_ = d.sendMessage(ctx, d.text)
+			if err := d.sendMessage(ctx, d.text); err != nil {
+				lastErr = err
+			}
 			d.text = ""
 			builder.Reset()
 		}
 	}
 	d.text += builder.String()
-	return nil
+	return lastErr
 }
 
-// version: b0001 (4 bits)
-// header size: b0001 (4 bits)
-// message type: b0001 (Full client request) (4bits)
-// message type specific flags: b0000 (none) (4bits)
-// message serialization method: b0001 (JSON) (4 bits)
-// message compression: b0001 (gzip) (4bits)
-// reserved data: 0x00 (1 byte)
-var defaultHeader = []byte{0x11, 0x10, 0x11, 0x00}
-
 type serverResponse struct {
 	Reqid    string `json:"reqid"`    // 请求ID，与传入的参数中reqid一致
 	Code     int    `json:"code"`     // 请求状态码
@@ -127,90 +158,63 @@ type synResp struct {
 }
 
 func (d *Doubao) setupInput(text string) []byte {
+	cfg := d.config()
 	params := map[string]any{
 		"app": map[string]any{
-			"appid":   d.cfg.AppID,
-			"token":   d.cfg.Token,
-			"cluster": d.cfg.Cluster,
+			"appid":   cfg.AppID,
+			"token":   cfg.Token,
+			"cluster": cfg.Cluster,
 		},
 		"user": map[string]any{
 			"uid": d.connectID,
 		},
 		"audio": map[string]any{
-			"voice_type":        d.cfg.Speaker,
-			"encoding":          d.cfg.Format,
-			"speed_ratio":       d.cfg.Speed,
-			"loudness_ratio":    d.cfg.Volume,
-			"pitch_ratio":       d.cfg.Pitch,
-			"rate":              d.cfg.SampleRate,
+			"voice_type":        cfg.Speaker,
+			"encoding":          cfg.Format,
+			"speed_ratio":       cfg.Speed,
+			"loudness_ratio":    cfg.Volume,
+			"pitch_ratio":       cfg.Pitch,
+			"rate":              cfg.SampleRate,
 			"explicit_language": "zh",
 		},
 		"request": map[string]any{
 			"reqid": d.reqID,
 			"text":  text,
 			// "text_type": "plain",
-			"operation": "submit", // submit 流式，query 非流式(一次性合成)
+			"operation": cfg.Operation, // submit 流式，query 非流式(一次性合成)
 		},
 	}
 	resStr, _ := json.Marshal(params)
 	return resStr
 }
 
-func (d *Doubao) gzipCompress(input []byte) []byte {
-	var b bytes.Buffer
-	w := gzip.NewWriter(&b)
-	_, _ = w.Write(input)
-	_ = w.Close()
-	return b.Bytes()
-}
-
-func (d *Doubao) gzipDecompress(input []byte) []byte {
-	b := bytes.NewBuffer(input)
-	r, _ := gzip.NewReader(b)
-	out, _ := io.ReadAll(r)
-	_ = r.Close()
-	return out
-}
-
 func (d *Doubao) parseResponse(res []byte) (synResp, error) {
-	headSize := res[0] & 0x0f
-	messageType := res[1] >> 4
-	messageTypeSpecificFlags := res[1] & 0x0f
-	messageCompression := res[2] & 0x0f
-	messageSerializationMethod := res[2] >> 4
-
-	payload := res[headSize*4:]
-
 	var resp synResp
-	switch messageType {
-	case 0xb: // audio-only server response
-		// no sequence number as ACK
-		if messageTypeSpecificFlags != 0 {
-			sequenceNumber := int32(binary.BigEndian.Uint32(payload[0:4]))
-			payload = payload[8:]
-			if sequenceNumber < 0 {
-				resp.IsLast = true
-			}
-		} else {
-			payload = payload[4:]
-		}
-	case 0xf: // error message from server
-		code := int32(binary.BigEndian.Uint32(payload[0:4]))
-		errMsg := payload[8:]
-		if messageCompression == 1 {
-			errMsg = d.gzipDecompress(errMsg)
+	msg, err := volcproto.Parse(res)
+	if err != nil {
+		return resp, err
+	}
+
+	switch msg.MsgType {
+	case volcproto.MsgTypeAudioOnlyServer: // 无序号的为ACK
+		resp.IsLast = msg.IsLast()
+	case volcproto.MsgTypeError: // error message from server
+		errMsg, err := msg.DecodedPayload()
+		if err != nil {
+			errMsg = msg.Payload
 		}
-		return resp, fmt.Errorf("error code: %d, msg: %s", code, string(errMsg))
+		return resp, fmt.Errorf("error code: %d, msg: %s", int32(msg.ErrorCode), string(errMsg))
 	default:
 		return resp, errors.New("wrong message type")
 	}
 
-	if len(payload) != 0 {
-		if messageCompression == 0x1 { // 经过gzip压缩的消息
-			payload = d.gzipDecompress(payload)
+	if len(msg.Payload) != 0 {
+		payload, err := msg.DecodedPayload()
+		if err != nil {
+			return resp, err
 		}
 
-		if messageSerializationMethod == 0x1 { // json格式
+		if msg.Serialization == volcproto.SerializationJSON {
 			var jsonData serverResponse
 			if err := json.Unmarshal(payload, &jsonData); err != nil {
 				return resp, fmt.Errorf("failed to parse the JSON response: %v", err)
@@ -219,7 +223,7 @@ func (d *Doubao) parseResponse(res []byte) (synResp, error) {
 			resp.ErrMsg = jsonData.Message
 			resp.IsLast = jsonData.Sequence < 0
 			resp.Audio = append(resp.Audio, []byte(jsonData.Data)...)
-		} else if messageSerializationMethod == 0x0 { // 无序列化
+		} else if msg.Serialization == volcproto.SerializationRaw {
 			audio := base64.StdEncoding.EncodeToString(payload)
 			resp.Audio = append(resp.Audio, audio...)
 		}
@@ -229,70 +233,119 @@ func (d *Doubao) parseResponse(res []byte) (synResp, error) {
 }
 
 func (d *Doubao) sendMessage(ctx context.Context, text string) error {
+	cfg := d.config()
+	if cfg.Operation == operationQuery {
+		return d.query(ctx, text)
+	}
 	d.log.Info("start tts")
+	start := time.Now()
 
 	header := make(http.Header)
-	header.Add("Authorization", fmt.Sprintf("Bearer;%s", d.cfg.Token))
-
-	input := d.setupInput(text)
-	input = d.gzipCompress(input)
-	payloadSize := len(input)
-	payloadArr := make([]byte, 4)
-	binary.BigEndian.PutUint32(payloadArr, uint32(payloadSize))
-	clientRequest := make([]byte, len(defaultHeader))
-	copy(clientRequest, defaultHeader)
-	clientRequest = append(clientRequest, payloadArr...)
-	clientRequest = append(clientRequest, input...)
+	header.Add("Authorization", fmt.Sprintf("Bearer;%s", cfg.Token))
+
+	msg := volcproto.NewMessage(volcproto.MsgTypeFullClientRequest, volcproto.MsgTypeFlagNoSeq)
+	msg.Compression = volcproto.CompressionGzip
+	input, err := volcproto.GzipCompress(d.setupInput(text))
+	if err != nil {
+		return err
+	}
+	msg.Payload = input
+	clientRequest, err := msg.Marshal()
+	if err != nil {
+		return err
+	}
+
+	// 占用连接名额，避免超出服务商的并发配额
+	release, err := connlimit.Get("tts", d.Name(), cfg.MaxConcurrency, cfg.ConcurrencyTimeout).Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("%w, %v", tts.ErrUnavailable, err)
+	}
+	defer release()
 
 	var (
 		conn *websocket.Conn
 		resp *http.Response
-		err  error
 	)
-	maxRetries := 2 // 最大重试次数
-	for i := 0; i < maxRetries; i++ {
-		dialer := websocket.DefaultDialer
-		conn, resp, err = dialer.DialContext(ctx, wsURL, header)
-		if err == nil {
-			break
-		}
-		if i+1 < maxRetries {
-			backoffTime := time.Duration(500*(i+1)) * time.Millisecond
-			d.log.Warnf("failed to connect to the websocket, try %d/%d: %v, will try again %v", i+1, maxRetries+1, err, backoffTime)
-			time.Sleep(backoffTime)
-		}
-	}
+	dialer := *websocket.DefaultDialer
+	dialer.Proxy = netproxy.Func(cfg.Proxy)
+	err = lifecycle.Retry(ctx, cfg.MaxRetries, func() error {
+		conn, resp, err = dialer.DialContext(ctx, cfg.Endpoint, header)
+		return err
+	}, func(attempt int, err error, backoff time.Duration) {
+		d.log.Warnf("failed to connect to the websocket, try %d/%d: %v, will try again %v", attempt, cfg.MaxRetries, err, backoff)
+	})
 	if err != nil {
 		statusCode := 0
 		if resp != nil {
 			statusCode = resp.StatusCode
 		}
-		return fmt.Errorf("falied to connect(status_code:%d): %v", statusCode, err)
+		return fmt.Errorf("%w, falied to connect(status_code:%d): %v", tts.ErrUnavailable, statusCode, err)
 	}
 
+	connectCost := time.Since(start)
+
 	if err = conn.WriteMessage(websocket.BinaryMessage, clientRequest); err != nil {
 		return fmt.Errorf("failed to send client request: %v", err)
 	}
+	sendTime := time.Now()
 
 	d.reqID = uuid.New().String()
-	d.log.Debugf("init tts succeed, connect_id: %s, req_id: %s", d.connectID, d.reqID)
+	d.log.WithFields(log.Fields{
+		"connect_id": d.connectID,
+		"req_id":     d.reqID,
+		"connect_ms": connectCost.Milliseconds(),
+	}).Debug("init tts succeed")
+
+	gen := atomic.LoadInt32(&d.gen)
+	d.lock.Lock()
+	d.conn = conn
+	d.lock.Unlock()
+	defer func() {
+		d.lock.Lock()
+		if d.conn == conn {
+			d.conn = nil
+		}
+		d.lock.Unlock()
+	}()
 
-	d.readMessage(conn)
+	recvBytes, firstResultCost := d.readMessage(conn, gen, sendTime)
+	d.log.WithFields(log.Fields{
+		"provider":        d.Name(),
+		"connect_id":      d.connectID,
+		"req_id":          d.reqID,
+		"connect_ms":      connectCost.Milliseconds(),
+		"first_result_ms": firstResultCost.Milliseconds(),
+		"send_bytes":      len(text),
+		"recv_bytes":      recvBytes,
+	}).Info("tts session stats")
 	return nil
 }
 
-func (d *Doubao) readMessage(conn *websocket.Conn) {
+// readMessage 读取合成结果，返回收到的音频字节数及首个音频相对请求发送的延迟
+// 未收到最后一帧音频连接就已断开或出错时，同样会回调 StateCompleted，保证监听者能够结束本次合成
+func (d *Doubao) readMessage(conn *websocket.Conn, gen int32, sendTime time.Time) (recvBytes int, firstResultCost time.Duration) {
 	d.log.Info("doubao tts start read message")
 
+	// finished 监听者已不再监听，或已回调过 StateCompleted
+	finished := false
 	defer func() {
 		if err := recover(); err != nil {
 			d.log.Errorf("tts read message panic: %v", err)
 		}
+		if !finished && atomic.LoadInt32(&d.gen) == gen {
+			d.log.Warn("doubao tts stopped before the last audio, complete the synthesis")
+			d.listener.OnTtsResult(nil, tts.StateCompleted)
+		}
 		d.log.Info("doubao tts read message stopped")
 	}()
 
 	for {
 		_, message, err := conn.ReadMessage()
+		if atomic.LoadInt32(&d.gen) != gen {
+			// 本次合成已被中止，丢弃结果
+			d.closeConnection(conn)
+			return
+		}
 		if err != nil {
 			d.setErrorAndClose(conn, err)
 			return
@@ -315,14 +368,27 @@ func (d *Doubao) readMessage(conn *websocket.Conn) {
 			state = tts.StateCompleted
 		}
 
-		if finished := d.listener.OnTtsResult(result.Audio, state); finished {
+		if len(result.Audio) > 0 {
+			if firstResultCost == 0 {
+				firstResultCost = time.Since(sendTime)
+			}
+			recvBytes += len(result.Audio)
+		}
+		finished = state == tts.StateCompleted
+		if d.listener.OnTtsResult(result.Audio, state) {
+			finished = true
+			return
+		}
+		if finished {
+			// 最后一帧之后服务端会关闭连接，无需继续读取
+			d.closeConnection(conn)
 			return
 		}
 	}
 }
 
 func (d *Doubao) setErrorAndClose(conn *websocket.Conn, err error) {
-	if strings.Contains(err.Error(), "use of closed network connection") {
+	if isNormalClose(err) {
 		d.log.Debugf("setErrorAndStop: %v", err)
 	} else {
 		d.log.Errorf("setErrorAndStop: %v", err)
@@ -331,10 +397,17 @@ func (d *Doubao) setErrorAndClose(conn *websocket.Conn, err error) {
 	d.closeConnection(conn)
 }
 
+// isNormalClose 连接被本端关闭，或服务端正常关闭连接，不属于合成出错
+func isNormalClose(err error) bool {
+	return errors.Is(err, io.EOF) ||
+		websocket.IsCloseError(err, websocket.CloseNormalClosure) ||
+		strings.Contains(err.Error(), "use of closed network connection")
+}
+
 func (d *Doubao) ToSessionFinish() error {
 	// 如果还有文本没有发送，需要将剩余的文本继续发送
 	if d.text != "" {
-		_ = d.sendMessage(context.Background(), d.text)
+		return d.sendMessage(context.Background(), d.text)
 	}
 	return nil
 }
@@ -351,6 +424,43 @@ func (d *Doubao) closeConnection(conn *websocket.Conn) {
 	}
 }
 
+func (d *Doubao) Abort() error {
+	// 每句话独立建立连接或请求，中止时丢弃未合成的文本并关闭正在合成的连接、取消正在进行的请求即可
+	atomic.AddInt32(&d.gen, 1)
+	d.lock.Lock()
+	conn := d.conn
+	d.conn = nil
+	cancel := d.cancelQuery
+	d.cancelQuery = nil
+	d.lock.Unlock()
+	d.closeConnection(conn)
+	if cancel != nil {
+		cancel()
+	}
+	d.log.Info("doubao tts abort")
+	return nil
+}
+
+// Start 每句话单独建立连接，无需预先建立
+func (d *Doubao) Start(ctx context.Context) error {
+	return nil
+}
+
+// Stop 同 Reset
+func (d *Doubao) Stop() error {
+	return d.Reset()
+}
+
+// Status 正在合成某句话时为 StatusRunning，否则为 StatusStopped
+func (d *Doubao) Status() lifecycle.Status {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.conn != nil || d.cancelQuery != nil {
+		return lifecycle.StatusRunning
+	}
+	return lifecycle.StatusStopped
+}
+
 func (d *Doubao) Reset() error {
 	return nil
 }
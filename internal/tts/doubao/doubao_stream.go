@@ -8,13 +8,18 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 
 	"crow/internal/tts"
+	"crow/pkg/connlimit"
+	"crow/pkg/lifecycle"
 	"crow/pkg/log"
+	"crow/pkg/netproxy"
+	"crow/pkg/volcproto"
 )
 
 // 豆包双向流式websocket-V3-支持复刻2.0/混音mix WebSocket 接口
@@ -22,24 +27,37 @@ import (
 // 对接大语言模型时，推荐用此接口，不要额外增加切句或者攒句的逻辑，且更为自然，情绪更饱满
 
 const (
-	wsStreamURL = "wss://openspeech.bytedance.com/api/v3/tts/bidirection" // WebSocket服务端地址
+	wsStreamURL        = "wss://openspeech.bytedance.com/api/v3/tts/bidirection" // WebSocket服务端地址
+	sessionWaitTimeout = 5 * time.Second                                         // 复用连接时等待会话取消或开始的超时时间
 )
 
 type DoubaoStream struct {
-	cfg *tts.Config
+	cfg atomic.Pointer[tts.Config] // 当前生效的配置，会话中可被整体替换，通过 config 读取
 	log *log.Logger
 
 	conn     *websocket.Conn
+	release  func() // 释放占用的连接名额
 	listener tts.Listener
 
 	lock sync.Mutex
 
-	isRunning   bool
+	state       lifecycle.State // 连接的生命周期状态
 	sendDataCnt int
 	connectID   string
 	reqID       string
 	taskID      string
 	sessionID   string
+
+	connectCost     time.Duration // 建立连接的耗时
+	firstSendTime   int64         // 本次会话首次发送文本的时间，UnixNano
+	firstResultCost int64         // 首次发送文本到收到首个音频的耗时，单位纳秒
+	sendBytes       int64         // 本次会话发送的文本字节数
+	recvBytes       int64         // 本次会话收到的音频字节数
+
+	aborting     bool          // 当前会话已取消，等待服务端确认，期间的合成结果将被丢弃
+	sessionIdle  chan struct{} // 已取消的会话结束后关闭
+	sessionReady chan struct{} // 复用连接开始新会话后，收到SessionStarted事件时关闭
+	idleTimer    *time.Timer   // 开启 PersistentSession 时，会话结束后连接空闲超时则关闭
 }
 
 func NewDoubaoStream(log *log.Logger) *DoubaoStream {
@@ -49,6 +67,10 @@ func NewDoubaoStream(log *log.Logger) *DoubaoStream {
 	}
 }
 
+func (d *DoubaoStream) Name() string {
+	return "doubao_stream"
+}
+
 func (d *DoubaoStream) SetConfig(cfg *tts.Config) *tts.Config {
 	if cfg.Speaker == "" {
 		cfg.Speaker = "zh_male_guangxiyuanzhou_moon_bigtts"
@@ -68,83 +90,115 @@ func (d *DoubaoStream) SetConfig(cfg *tts.Config) *tts.Config {
 	if cfg.SampleRate <= 0 {
 		cfg.SampleRate = 16000
 	}
-	d.cfg = cfg
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = wsStreamURL
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = tts.DefaultMaxRetries
+	}
+	if cfg.SessionIdleTimeout <= 0 {
+		cfg.SessionIdleTimeout = tts.DefaultSessionIdleTimeout
+	}
+	cfg.EnableTimestamp = false // 暂不支持下发字词时间戳
+	d.cfg.Store(cfg)
 	return cfg
 }
 
+// config 当前生效的配置，SetConfig 整体替换配置，因此可与各协程并发读取
+func (d *DoubaoStream) config() *tts.Config {
+	return d.cfg.Load()
+}
+
 func (d *DoubaoStream) SetListener(listener tts.Listener) {
 	d.listener = listener
 }
 
 func (d *DoubaoStream) ToTTS(ctx context.Context, text string) error {
 	d.lock.Lock()
-	isRunning := d.isRunning
+	d.stopIdleTimerLocked()
+	isRunning := d.state.Running()
+	needNewSession := d.state.Running() && (d.aborting || d.sessionID == "")
 	d.lock.Unlock()
 
 	if !isRunning {
-		if err := d.initConnection(ctx); err != nil {
+		if err := d.Start(ctx); err != nil {
+			return err
+		}
+	} else if needNewSession {
+		if err := d.restartSession(ctx); err != nil {
 			return err
 		}
 	}
 
-	if len(text) > 0 && d.isRunning {
+	if len(text) > 0 && d.state.Running() {
 		// 直接发送文本数据
 		err := d.sendTextData(text)
 		if err != nil {
 			return err
 		}
 		d.sendDataCnt++
-		if d.sendDataCnt%20 == 0 {
-			d.log.Debugf("send text data cnt: %d", d.sendDataCnt)
-		}
+		atomic.AddInt64(&d.sendBytes, int64(len(text)))
+		atomic.CompareAndSwapInt64(&d.firstSendTime, 0, time.Now().UnixNano())
 	}
 	return nil
 }
 
 func (d *DoubaoStream) initConnection(ctx context.Context) error {
+	cfg := d.config()
 	d.log.Info("start tts")
+	start := time.Now()
 
 	d.lock.Lock()
 	defer d.lock.Unlock()
 
-	if d.isRunning {
+	if d.state.Running() {
 		return nil
 	}
+	d.state.Start()
+	// 建立失败时回到 StatusStopped，建立成功时已流转为 StatusRunning，不受影响
+	defer d.state.Transition(lifecycle.StatusStarting, lifecycle.StatusStopped)
 
 	if d.conn != nil {
 		d.closeConnection()
 	}
 
 	header := make(http.Header)
-	header.Add("X-Api-App-Key", d.cfg.AppID)
-	header.Add("X-Api-Access-Key", d.cfg.Token)
-	header.Add("X-Api-Resource-Id", d.cfg.ResourceID)
+	header.Add("X-Api-App-Key", cfg.AppID)
+	header.Add("X-Api-Access-Key", cfg.Token)
+	header.Add("X-Api-Resource-Id", cfg.ResourceID)
 	header.Add("X-Api-Connect-Id", fmt.Sprintf("%d", time.Now().UnixNano()))
 
+	// 占用连接名额，避免超出服务商的并发配额，建立连接失败时释放
+	release, err := connlimit.Get("tts", d.Name(), cfg.MaxConcurrency, cfg.ConcurrencyTimeout).Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("%w, %v", tts.ErrUnavailable, err)
+	}
+	defer func() {
+		if d.state.Running() {
+			d.release = release
+		} else {
+			release()
+		}
+	}()
+
 	var (
 		conn *websocket.Conn
 		resp *http.Response
-		err  error
 	)
-	maxRetries := 2 // 最大重试次数
-	for i := 0; i < maxRetries; i++ {
-		dialer := websocket.DefaultDialer
-		conn, resp, err = dialer.DialContext(ctx, wsStreamURL, header)
-		if err == nil {
-			break
-		}
-		if i+1 < maxRetries {
-			backoffTime := time.Duration(500*(i+1)) * time.Millisecond
-			d.log.Warnf("failed to connect to the websocket, try %d/%d: %v, will try again %v", i+1, maxRetries+1, err, backoffTime)
-			time.Sleep(backoffTime)
-		}
-	}
+	dialer := *websocket.DefaultDialer
+	dialer.Proxy = netproxy.Func(cfg.Proxy)
+	err = lifecycle.Retry(ctx, cfg.MaxRetries, func() error {
+		conn, resp, err = dialer.DialContext(ctx, cfg.Endpoint, header)
+		return err
+	}, func(attempt int, err error, backoff time.Duration) {
+		d.log.Warnf("failed to connect to the websocket, try %d/%d: %v, will try again %v", attempt, cfg.MaxRetries, err, backoff)
+	})
 	if err != nil {
 		statusCode := 0
 		if resp != nil {
 			statusCode = resp.StatusCode
 		}
-		return fmt.Errorf("falied to connect(status_code:%d): %v", statusCode, err)
+		return fmt.Errorf("%w, falied to connect(status_code:%d): %v", tts.ErrUnavailable, statusCode, err)
 	}
 
 	// start connection
@@ -153,35 +207,94 @@ func (d *DoubaoStream) initConnection(ctx context.Context) error {
 	}
 
 	// wait connection
-	_, err = waitForEvent(conn, MsgTypeFullServerResponse, EventType_ConnectionStarted)
+	_, err = waitForEvent(conn, volcproto.MsgTypeFullServerResponse, volcproto.EventType_ConnectionStarted)
 	if err != nil {
 		return fmt.Errorf("wait for connection started event error: %v", err)
 	}
 
-	payload := d.setupInput(int(EventType_StartSession), "")
+	payload := d.setupInput(int(volcproto.EventType_StartSession), "")
 	// start session
 	sessionID := uuid.New().String()
 	if err := startSession(conn, payload, sessionID); err != nil {
 		return fmt.Errorf("start session error: %v", err)
 	}
 	// wait session started
-	msg, err := waitForEvent(conn, MsgTypeFullServerResponse, EventType_SessionStarted)
+	msg, err := waitForEvent(conn, volcproto.MsgTypeFullServerResponse, volcproto.EventType_SessionStarted)
 	if err != nil {
 		return fmt.Errorf("wait for session started event error: %v, msg: %v", err, msg)
 	}
 
 	d.conn = conn
-	d.isRunning = true
+	d.state.Transition(lifecycle.StatusStarting, lifecycle.StatusRunning)
 	d.reqID = fmt.Sprintf("%d", time.Now().UnixNano())
 	d.sessionID = sessionID
 
-	d.log.Debugf("init tts succeed, connect_id: %s, req_id: %s, log_id: %s", d.connectID, d.reqID, resp.Header.Get("x-tt-logid"))
+	d.connectCost = time.Since(start)
+	d.sendDataCnt = 0
+	atomic.StoreInt64(&d.firstSendTime, 0)
+	atomic.StoreInt64(&d.firstResultCost, 0)
+	atomic.StoreInt64(&d.sendBytes, 0)
+	atomic.StoreInt64(&d.recvBytes, 0)
+	d.log.WithFields(log.Fields{
+		"connect_id": d.connectID,
+		"req_id":     d.reqID,
+		"log_id":     resp.Header.Get("x-tt-logid"),
+		"connect_ms": d.connectCost.Milliseconds(),
+	}).Debug("init tts succeed")
 
 	go d.readMessage()
 	return nil
 }
 
+// restartSession 复用已有连接开始新的会话，若已取消的会话尚未结束，则先等待其结束
+func (d *DoubaoStream) restartSession(ctx context.Context) error {
+	d.lock.Lock()
+	idle := d.sessionIdle
+	d.lock.Unlock()
+
+	if idle != nil {
+		select {
+		case <-idle:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(sessionWaitTimeout):
+			d.log.Warn("wait for canceled session finished timeout, reconnect")
+			_ = d.Reset()
+			return d.initConnection(ctx)
+		}
+	}
+
+	d.lock.Lock()
+	if !d.state.Running() || d.conn == nil {
+		d.lock.Unlock()
+		return d.initConnection(ctx)
+	}
+	ready := make(chan struct{})
+	d.sessionReady = ready
+	sessionID := uuid.New().String()
+	err := startSession(d.conn, d.setupInput(int(volcproto.EventType_StartSession), ""), sessionID)
+	if err == nil {
+		d.sessionID = sessionID
+	}
+	d.lock.Unlock()
+	if err != nil {
+		return fmt.Errorf("start session error: %v", err)
+	}
+
+	select {
+	case <-ready:
+		d.log.Debugf("restart tts session succeed, session_id: %s", sessionID)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(sessionWaitTimeout):
+		_ = d.Reset()
+		return fmt.Errorf("wait for session started event timeout")
+	}
+}
+
 func (d *DoubaoStream) setupInput(event int, text string) []byte {
+	cfg := d.config()
 	params := map[string]any{
 		"user": map[string]any{
 			"uid": uuid.New().String(),
@@ -190,13 +303,13 @@ func (d *DoubaoStream) setupInput(event int, text string) []byte {
 		"namespace": "BidirectionalTTS",
 		"req_params": map[string]any{
 			"text":    text,
-			"speaker": d.cfg.Speaker,
+			"speaker": cfg.Speaker,
 			"audio_params": map[string]any{
-				"format":           d.cfg.Format,     // mp3/ogg_opus/pcm
-				"sample_rate":      d.cfg.SampleRate, // 8000, 16000, 22050, 24000, 32000, 44100, 48000
-				"speech_rate":      d.cfg.Speed,      // 取值范围[-50,100]，100代表2.0倍速，-50代表0.5倍数
-				"loudness_rate":    d.cfg.Volume,     // 取值范围[-50,100]，100代表2.0倍音量，-50代表0.5倍音量（mix音色暂不支持）
-				"enable_timestamp": true,             // true 返回字与音素时间戳，默认false
+				"format":           cfg.Format,     // mp3/ogg_opus/pcm
+				"sample_rate":      cfg.SampleRate, // 8000, 16000, 22050, 24000, 32000, 44100, 48000
+				"speech_rate":      cfg.Speed,      // 取值范围[-50,100]，100代表2.0倍速，-50代表0.5倍数
+				"loudness_rate":    cfg.Volume,     // 取值范围[-50,100]，100代表2.0倍音量，-50代表0.5倍音量（mix音色暂不支持）
+				"enable_timestamp": true,           // true 返回字与音素时间戳，默认false
 			},
 			"additions": func() string {
 				str, _ := json.Marshal(map[string]any{
@@ -207,7 +320,7 @@ func (d *DoubaoStream) setupInput(event int, text string) []byte {
 					"mute_cut_remain_ms":               "",    // 该参数需配合mute_cut_threshold参数一起使用，其中："mute_cut_threshold": "400", --静音判断的阈值（音量小于该值时判定为静音） "mute_cut_remain_ms": "50", --需要保留的静音长度 注：参数和value都为string格式
 					"max_length_to_filter_parenthesis": 0,     // 是否过滤括号内的部分，0为不过滤，100为过滤
 					"post_process": map[string]any{
-						"pitch": int(d.cfg.Pitch), // 音调取值范围是[-12,12]
+						"pitch": int(cfg.Pitch), // 音调取值范围是[-12,12]
 					},
 				})
 				return string(str)
@@ -224,11 +337,11 @@ func (d *DoubaoStream) sendTextData(text string) error {
 		return nil
 	}
 
-	if d.conn == nil || !d.isRunning {
+	if d.conn == nil || !d.state.Running() {
 		return fmt.Errorf("tts connection is not running")
 	}
 
-	payload := d.setupInput(int(EventType_TaskRequest), text)
+	payload := d.setupInput(int(volcproto.EventType_TaskRequest), text)
 	// send task request
 	if err := taskRequest(d.conn, payload, d.sessionID); err != nil {
 		return fmt.Errorf("task request error: %v", err)
@@ -244,8 +357,10 @@ func (d *DoubaoStream) readMessage() {
 		if err := recover(); err != nil {
 			d.log.Errorf("tts read message panic: %v", err)
 		}
+		d.logStats()
 		d.lock.Lock()
-		d.isRunning = false
+		d.state.Stop()
+		d.finishAbortLocked()
 		if d.conn != nil {
 			d.closeConnection()
 		}
@@ -256,7 +371,7 @@ func (d *DoubaoStream) readMessage() {
 	for {
 		// 检查连接状态，避免在连接关闭后继续读取
 		d.lock.Lock()
-		if !d.isRunning || d.conn == nil {
+		if !d.state.Running() || d.conn == nil {
 			d.lock.Unlock()
 			d.log.Info("流式识别已结束或连接已关闭，退出读取循环")
 			return
@@ -275,26 +390,70 @@ func (d *DoubaoStream) readMessage() {
 			return
 		}
 
-		newMsg, err := newMessageFromBytes(message)
+		newMsg, err := volcproto.Parse(message)
 		if err != nil {
 			d.setErrorAndStop(err)
 			return
 		}
+		d.lock.Lock()
+		aborting := d.aborting
+		d.lock.Unlock()
+		if aborting {
+			// 已取消会话的结果直接丢弃，会话结束后保留连接以便开始新会话
+			if newMsg.EventType == volcproto.EventType_SessionCanceled || newMsg.EventType == volcproto.EventType_SessionFinished {
+				d.lock.Lock()
+				d.finishAbortLocked()
+				d.sessionID = ""
+				d.lock.Unlock()
+			}
+			continue
+		}
+
 		switch newMsg.MsgType {
-		case MsgTypeFullServerResponse:
-		case MsgTypeAudioOnlyServer:
-			base64Message := base64.StdEncoding.EncodeToString(newMsg.Payload)
+		case volcproto.MsgTypeFullServerResponse:
+			if newMsg.EventType == volcproto.EventType_SessionStarted {
+				d.lock.Lock()
+				if d.sessionReady != nil {
+					close(d.sessionReady)
+					d.sessionReady = nil
+				}
+				d.lock.Unlock()
+				continue
+			}
+		case volcproto.MsgTypeAudioOnlyServer:
+			// 按帧头的压缩方式解压，损坏的帧直接报错结束本次合成，而非下发无法播放的音频
+			audio, err := newMsg.DecodedPayload()
+			if err != nil {
+				d.setErrorAndStop(fmt.Errorf("failed to decode audio frame: %v", err))
+				return
+			}
+			d.markResult(len(audio))
+			base64Message := base64.StdEncoding.EncodeToString(audio)
 			if finished := d.listener.OnTtsResult([]byte(base64Message), tts.StateProcessing); finished {
 				return
 			}
+		case volcproto.MsgTypeError:
+			errMsg, err := newMsg.DecodedPayload()
+			if err != nil {
+				errMsg = newMsg.Payload
+			}
+			d.setErrorAndStop(fmt.Errorf("error code: %d, msg: %s", int32(newMsg.ErrorCode), string(errMsg)))
+			return
 		default:
 			return
 		}
-		if newMsg.EventType == EventType_SessionFinished {
-			if finished := d.listener.OnTtsResult(nil, tts.StateCompleted); finished {
+		if newMsg.EventType == volcproto.EventType_SessionFinished {
+			d.lock.Lock()
+			d.sessionID = ""
+			persistent := d.config().PersistentSession
+			if persistent {
+				// 保留连接，下一轮合成时开始新的会话
+				d.idleTimer = time.AfterFunc(d.config().SessionIdleTimeout, d.closeIfIdle)
+			}
+			d.lock.Unlock()
+			if finished := d.listener.OnTtsResult(nil, tts.StateCompleted); finished || !persistent {
 				return
 			}
-			return
 		}
 	}
 }
@@ -303,7 +462,8 @@ func (d *DoubaoStream) setErrorAndStop(err error) {
 	d.lock.Lock()
 	defer d.lock.Unlock()
 
-	d.isRunning = false
+	d.state.Stop()
+	d.finishAbortLocked()
 
 	if strings.Contains(err.Error(), "use of closed network connection") {
 		d.log.Debugf("setErrorAndStop: %v, sendDataCnt=%d", err, d.sendDataCnt)
@@ -317,6 +477,15 @@ func (d *DoubaoStream) setErrorAndStop(err error) {
 }
 
 func (d *DoubaoStream) ToSessionFinish() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if !d.state.Running() || d.conn == nil || d.sessionID == "" || d.aborting {
+		return nil
+	}
+	// 结束会话，服务端合成完剩余的文本后下发SessionFinished事件
+	if err := finishSession(d.conn, d.sessionID); err != nil {
+		return fmt.Errorf("finish session error: %v", err)
+	}
 	return nil
 }
 
@@ -328,14 +497,20 @@ func (d *DoubaoStream) closeConnection() {
 		}
 	}()
 
+	if d.release != nil {
+		d.release()
+		d.release = nil
+	}
 	if d.conn == nil {
 		return
 	}
 
 	// finish session
-	if err := finishSession(d.conn, d.sessionID); err != nil {
-		d.log.Errorf("finish session error: %v", err)
-		return
+	if d.sessionID != "" {
+		if err := finishSession(d.conn, d.sessionID); err != nil {
+			d.log.Errorf("finish session error: %v", err)
+			return
+		}
 	}
 
 	// finish connection
@@ -344,7 +519,7 @@ func (d *DoubaoStream) closeConnection() {
 		return
 	}
 	// wait connection finished
-	msg, err := waitForEvent(d.conn, MsgTypeFullServerResponse, EventType_ConnectionFinished)
+	msg, err := waitForEvent(d.conn, volcproto.MsgTypeFullServerResponse, volcproto.EventType_ConnectionFinished)
 	if err != nil {
 		d.log.Errorf("wait finish connect error: %v, msg: %v", err, msg)
 		return
@@ -354,12 +529,110 @@ func (d *DoubaoStream) closeConnection() {
 	d.conn = nil
 }
 
+// markResult 记录收到的音频数据量及首个音频的延迟
+func (d *DoubaoStream) markResult(size int) {
+	atomic.AddInt64(&d.recvBytes, int64(size))
+	start := atomic.LoadInt64(&d.firstSendTime)
+	if start == 0 || atomic.LoadInt64(&d.firstResultCost) != 0 {
+		return
+	}
+	atomic.StoreInt64(&d.firstResultCost, time.Now().UnixNano()-start)
+}
+
+// logStats 以结构化字段输出本次会话的耗时与数据量统计
+func (d *DoubaoStream) logStats() {
+	d.log.WithFields(log.Fields{
+		"provider":        d.Name(),
+		"connect_id":      d.connectID,
+		"req_id":          d.reqID,
+		"connect_ms":      d.connectCost.Milliseconds(),
+		"first_result_ms": time.Duration(atomic.LoadInt64(&d.firstResultCost)).Milliseconds(),
+		"send_bytes":      atomic.LoadInt64(&d.sendBytes),
+		"recv_bytes":      atomic.LoadInt64(&d.recvBytes),
+		"send_cnt":        d.sendDataCnt,
+	}).Info("tts session stats")
+}
+
+func (d *DoubaoStream) Abort() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if !d.state.Running() || d.conn == nil || d.sessionID == "" || d.aborting {
+		return nil
+	}
+
+	// 取消当前会话并丢弃剩余的合成结果，待服务端确认后复用连接
+	if err := CancelSession(d.conn, d.sessionID); err != nil {
+		d.state.Stop()
+		d.closeConnection()
+		return fmt.Errorf("cancel session error: %v", err)
+	}
+	d.aborting = true
+	d.sessionIdle = make(chan struct{})
+	d.log.Info("doubao stream tts abort")
+	return nil
+}
+
+// finishAbortLocked 结束取消状态并唤醒等待者，调用方需持有锁
+func (d *DoubaoStream) finishAbortLocked() {
+	d.aborting = false
+	if d.sessionIdle != nil {
+		close(d.sessionIdle)
+		d.sessionIdle = nil
+	}
+	if d.sessionReady != nil {
+		close(d.sessionReady)
+		d.sessionReady = nil
+	}
+}
+
+// closeIfIdle 保留的连接空闲超时后关闭，期间已开始新会话时不做处理
+func (d *DoubaoStream) closeIfIdle() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if !d.state.Running() || d.sessionID != "" || d.aborting {
+		return
+	}
+	d.log.Info("tts connection idle timeout, close")
+	d.state.Transition(lifecycle.StatusRunning, lifecycle.StatusStopping)
+	d.closeConnection()
+	d.state.Stop()
+}
+
+// stopIdleTimerLocked 停止空闲计时，调用方需持有锁
+func (d *DoubaoStream) stopIdleTimerLocked() {
+	if d.idleTimer != nil {
+		d.idleTimer.Stop()
+		d.idleTimer = nil
+	}
+}
+
+// Start 建立连接，已建立时直接返回，可用于预先建连以降低首次合成的延迟
+func (d *DoubaoStream) Start(ctx context.Context) error {
+	if d.state.Running() {
+		return nil
+	}
+	return d.initConnection(ctx)
+}
+
+// Stop 关闭连接并重置状态，同 Reset
+func (d *DoubaoStream) Stop() error {
+	return d.Reset()
+}
+
+func (d *DoubaoStream) Status() lifecycle.Status {
+	return d.state.Status()
+}
+
 func (d *DoubaoStream) Reset() error {
 	d.lock.Lock()
 	defer d.lock.Unlock()
 
-	d.isRunning = false
+	d.state.Transition(lifecycle.StatusRunning, lifecycle.StatusStopping)
+	d.stopIdleTimerLocked()
+	d.finishAbortLocked()
 	d.closeConnection()
+	d.state.Stop()
 
 	d.taskID = ""
 	d.sendDataCnt = 0
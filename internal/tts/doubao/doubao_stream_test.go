@@ -0,0 +1,126 @@
+package doubao
+
+import (
+	"encoding/base64"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"crow/internal/config"
+	"crow/internal/tts"
+	"crow/pkg/fakews"
+	"crow/pkg/lifecycle"
+	"crow/pkg/log"
+	"crow/pkg/volcproto"
+)
+
+// fakeListener 记录合成结果回调
+type fakeListener struct {
+	lock    sync.Mutex
+	results []string
+}
+
+func (l *fakeListener) OnTtsResult(data []byte, state tts.State) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if state == tts.StateProcessing {
+		l.results = append(l.results, string(data))
+	}
+	return state == tts.StateCompleted
+}
+
+func (l *fakeListener) get() []string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return append([]string(nil), l.results...)
+}
+
+// eventFrame 服务端下发的事件帧
+func eventFrame(t *testing.T, event volcproto.EventType, sessionID string) fakews.Frame {
+	t.Helper()
+	msg := volcproto.NewMessage(volcproto.MsgTypeFullServerResponse, volcproto.MsgTypeFlagWithEvent)
+	msg.EventType = event
+	msg.SessionID = sessionID
+	msg.Payload = []byte("{}")
+	data, err := msg.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fakews.Frame{MessageType: websocket.BinaryMessage, Data: data}
+}
+
+// audioFrame 服务端下发的音频帧，compression 为 gzip 时 payload 需为压缩后的数据
+func audioFrame(t *testing.T, payload []byte, compression volcproto.CompressionBits) fakews.Frame {
+	t.Helper()
+	msg := volcproto.NewMessage(volcproto.MsgTypeAudioOnlyServer, volcproto.MsgTypeFlagWithEvent)
+	msg.EventType = volcproto.EventType_TTSResponse
+	msg.SessionID = "session"
+	msg.Serialization = volcproto.SerializationRaw
+	msg.Compression = compression
+	msg.Payload = payload
+	data, err := msg.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fakews.Frame{MessageType: websocket.BinaryMessage, Data: data}
+}
+
+func TestReadMessage(t *testing.T) {
+	audio := []byte{1, 2, 3, 4}
+	compressed, err := volcproto.GzipCompress(audio)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		frame    fakews.Frame
+		want     []string
+		finished bool // 是否正常结束会话，否则因无法解码或服务端报错而结束
+	}{
+		{"raw", audioFrame(t, audio, volcproto.CompressionNone), []string{base64.StdEncoding.EncodeToString(audio)}, true},
+		{"gzip", audioFrame(t, compressed, volcproto.CompressionGzip), []string{base64.StdEncoding.EncodeToString(audio)}, true},
+		{"corrupt gzip", audioFrame(t, audio, volcproto.CompressionGzip), nil, false},
+		{"error frame", fakews.DoubaoError(45000001, "invalid speaker"), nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 会话开始后即下发音频；结束时客户端依次发送结束会话与结束连接
+			frames := []fakews.Frame{tt.frame}
+			wait := 2
+			if tt.finished {
+				frames = append(frames, eventFrame(t, volcproto.EventType_SessionFinished, "session"))
+				wait = 1
+			}
+			server := fakews.NewServer(
+				fakews.Step{Wait: 1, Frames: []fakews.Frame{eventFrame(t, volcproto.EventType_ConnectionStarted, "")}},
+				fakews.Step{Wait: 1, Frames: append([]fakews.Frame{eventFrame(t, volcproto.EventType_SessionStarted, "session")}, frames...)},
+				fakews.Step{Wait: wait, Frames: []fakews.Frame{eventFrame(t, volcproto.EventType_ConnectionFinished, "")}},
+			)
+			defer server.Close()
+			listener := &fakeListener{}
+			d := NewDoubaoStream(log.NewLogger(&log.Option{Hook: io.Discard, Mode: "debug"}))
+			d.SetConfig(&tts.Config{TtsConfig: config.TtsConfig{Endpoint: server.URL(), MaxRetries: 1}})
+			d.SetListener(listener)
+			t.Cleanup(func() { _ = d.Reset() })
+
+			if err := d.Start(t.Context()); err != nil {
+				t.Fatalf("start: %v", err)
+			}
+			// 无法解码的音频帧及错误帧结束本次合成，而非下发无法播放的音频
+			deadline := time.Now().Add(5 * time.Second)
+			for d.Status() != lifecycle.StatusStopped {
+				if time.Now().After(deadline) {
+					t.Fatalf("status = %v, want stopped", d.Status())
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			got := listener.get()
+			if len(got) != len(tt.want) || (len(got) > 0 && got[0] != tt.want[0]) {
+				t.Fatalf("audio = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package doubao
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+
+	"crow/internal/tts"
+	"crow/pkg/connlimit"
+	"crow/pkg/lifecycle"
+	"crow/pkg/log"
+	"crow/pkg/netproxy"
+)
+
+// 豆包语音合成大模型 HTTP 接口，即 query 非流式合成，一次请求返回整句话的完整音频
+// https://www.volcengine.com/docs/6561/1257584
+
+const (
+	operationSubmit = "submit" // 流式合成，通过 WebSocket 边合成边下发
+	operationQuery  = "query"  // 非流式合成，通过 HTTP 一次性返回完整音频，适用于较短的固定话术
+
+	maxQueryResponseBytes = 32 << 20 // 单次合成响应的最大字节数，避免异常响应占用过多内存
+)
+
+var (
+	queryClientsLock sync.Mutex
+	// queryClients 按代理配置复用的 HTTP 客户端，使各会话共用连接池，省去每句话重新建连的耗时，k: 代理地址
+	queryClients = map[string]*http.Client{}
+)
+
+func queryClient(proxy string) *http.Client {
+	queryClientsLock.Lock()
+	defer queryClientsLock.Unlock()
+	if client, ok := queryClients[proxy]; ok {
+		return client
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = netproxy.Func(proxy)
+	client := &http.Client{Transport: transport}
+	queryClients[proxy] = client
+	return client
+}
+
+// queryURL 由 WebSocket 地址推导出同一服务的 HTTP 地址，使私有化部署只需配置一个 endpoint
+func queryURL(endpoint string) string {
+	switch {
+	case strings.HasPrefix(endpoint, "wss://"):
+		endpoint = "https://" + strings.TrimPrefix(endpoint, "wss://")
+	case strings.HasPrefix(endpoint, "ws://"):
+		endpoint = "http://" + strings.TrimPrefix(endpoint, "ws://")
+	}
+	return strings.TrimSuffix(endpoint, "/ws_binary")
+}
+
+// query 以非流式的方式合成一句话，收到完整音频后一次性回调 StateCompleted
+// 请求未能发出时返回错误，服务端返回错误时同样回调 StateCompleted，保证监听者能够结束本次合成
+func (d *Doubao) query(ctx context.Context, text string) error {
+	cfg := d.config()
+	d.log.Info("start tts")
+	start := time.Now()
+
+	// 占用连接名额，避免超出服务商的并发配额
+	release, err := connlimit.Get("tts", d.Name(), cfg.MaxConcurrency, cfg.ConcurrencyTimeout).Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("%w, %v", tts.ErrUnavailable, err)
+	}
+	defer release()
+
+	d.reqID = uuid.New().String()
+	body := d.setupInput(text)
+
+	// 中止时取消正在进行的请求
+	gen := atomic.LoadInt32(&d.gen)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	d.lock.Lock()
+	d.cancelQuery = cancel
+	d.lock.Unlock()
+	defer func() {
+		d.lock.Lock()
+		d.cancelQuery = nil
+		d.lock.Unlock()
+	}()
+
+	var resp *http.Response
+	client := queryClient(cfg.Proxy)
+	err = lifecycle.Retry(ctx, cfg.MaxRetries, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, queryURL(cfg.Endpoint), bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer;%s", cfg.Token))
+		resp, err = client.Do(req)
+		if err != nil {
+			return err
+		}
+		// 服务端过载或网关错误时重试，其余状态码由响应中的错误码说明原因
+		if resp.StatusCode >= http.StatusInternalServerError {
+			_ = resp.Body.Close()
+			return fmt.Errorf("status code %d", resp.StatusCode)
+		}
+		return nil
+	}, func(attempt int, err error, backoff time.Duration) {
+		d.log.Warnf("failed to request the tts, try %d/%d: %v, will try again %v", attempt, cfg.MaxRetries, err, backoff)
+	})
+	if err != nil {
+		if atomic.LoadInt32(&d.gen) != gen {
+			// 本次合成已被中止
+			return nil
+		}
+		return fmt.Errorf("%w, failed to request: %v", tts.ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxQueryResponseBytes))
+	firstResultCost := time.Since(start)
+	if atomic.LoadInt32(&d.gen) != gen {
+		// 本次合成已被中止，丢弃结果
+		return nil
+	}
+
+	var result serverResponse
+	if err == nil {
+		err = json.Unmarshal(data, &result)
+	}
+	switch {
+	case err != nil:
+		d.log.Errorf("failed to read the tts response(status_code:%d): %v", resp.StatusCode, err)
+		result.Data = ""
+	case result.Code != 3000:
+		d.log.Errorf("TTS server side error: code = %d, msg: %s", result.Code, result.Message)
+		result.Data = ""
+	}
+	d.listener.OnTtsResult([]byte(result.Data), tts.StateCompleted)
+
+	d.log.WithFields(log.Fields{
+		"provider":        d.Name(),
+		"connect_id":      d.connectID,
+		"req_id":          d.reqID,
+		"operation":       operationQuery,
+		"first_result_ms": firstResultCost.Milliseconds(),
+		"audio_ms":        result.Addition.Duration,
+		"send_bytes":      len(text),
+		"recv_bytes":      len(result.Data),
+	}).Info("tts session stats")
+	return nil
+}
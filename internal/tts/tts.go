@@ -2,10 +2,24 @@ package tts
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"crow/internal/config"
+	"crow/pkg/lifecycle"
 )
 
+const (
+	// DefaultMaxRetries 未配置时建立连接的最大尝试次数
+	DefaultMaxRetries = 2
+	// DefaultSessionIdleTimeout 开启 PersistentSession 且未配置时，连接在两轮合成之间的最长空闲时间
+	DefaultSessionIdleTimeout = 60 * time.Second
+)
+
+// ErrUnavailable 服务暂时不可用，即多次尝试后仍无法与服务商建立连接，或连接数已满等待超时
+// Provider 返回的错误可通过 errors.Is 判断，以便告知客户端而不只是记录日志
+var ErrUnavailable = errors.New("tts service unavailable")
+
 // State tts合成状态
 type State int
 
@@ -25,6 +39,33 @@ type Listener interface {
 	OnTtsResult(data []byte, state State) bool
 }
 
+// Word 合成音频中单个字词的时间戳
+type Word struct {
+	Text      string // 字词
+	BeginTime int64  // 在本句音频中的开始时间，单位毫秒
+	EndTime   int64  // 在本句音频中的结束时间，单位毫秒
+}
+
+// Timestamp 合成音频的字词时间戳，可用于字幕与口型同步
+type Timestamp struct {
+	SentenceIndex int    // 句子序号，同一句子可能多次回调，以最后一次为准
+	Words         []Word // 字词时间戳
+}
+
+// TimestampListener 可选的语音合成事件监听者，用于获取合成音频的字词时间戳
+// 仅在 Config.EnableTimestamp 为 true 且服务商支持时回调
+type TimestampListener interface {
+	// OnTtsTimestamp 字词时间戳回调
+	OnTtsTimestamp(timestamp Timestamp)
+}
+
+// NotifyTimestamp 若监听者实现了 TimestampListener，则回调字词时间戳
+func NotifyTimestamp(listener Listener, timestamp Timestamp) {
+	if l, ok := listener.(TimestampListener); ok {
+		l.OnTtsTimestamp(timestamp)
+	}
+}
+
 // Config 需要请求tts的相关配置
 type Config struct {
 	config.TtsConfig
@@ -36,9 +77,15 @@ type Config struct {
 	Format     string  // 合成音频的格式
 	SampleRate int     // 合成音频的采样率
 	Language   string  // 合成的语言
+
+	EnableTimestamp bool // 是否返回字词时间戳，需服务商支持
 }
 
 type Provider interface {
+	// Lifecycle 连接的生命周期，Start 可用于预先建连，Stop 同 Reset
+	lifecycle.Lifecycle
+	// Name 服务商的稳定标识，如 cosy_voice、doubao、doubao_stream，用于日志与监控
+	Name() string
 	// SetConfig 设置 Provider 的配置
 	// @param cfg: 客户端需求的配置
 	// @return *Config: 实际请求的配置
@@ -50,6 +97,9 @@ type Provider interface {
 	ToTTS(ctx context.Context, text string) error
 	// ToSessionFinish 发送会话结束消息，即需要合成的文本已发送结束
 	ToSessionFinish() error
-	// Reset 重置 Provider
+	// Abort 中止当前的合成，不再回调本次合成的结果，并尽可能保留连接，
+	// 使下一次 ToTTS 无需重新建立连接；与 Reset 不同，Abort 不会触发 StateCompleted
+	Abort() error
+	// Reset 重置 Provider，开启 PersistentSession 时，监听者在合成结束后无需调用，连接将保留至下一轮合成
 	Reset() error
 }
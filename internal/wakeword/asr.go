@@ -0,0 +1,91 @@
+package wakeword
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+
+	"crow/internal/asr"
+	"crow/pkg/log"
+	"crow/pkg/util"
+)
+
+// AsrDetector 基于语音识别结果的唤醒词检测器，使用独立的ASR会话识别音频，识别文本中包含唤醒词即视为唤醒
+// 无需额外的唤醒模型，但未唤醒期间会持续占用ASR服务
+type AsrDetector struct {
+	log      *log.Logger
+	provider asr.Provider
+	words    []string
+
+	detected int32 // detected 是否已检测到唤醒词，0：否，1：是
+}
+
+// NewAsrDetector 创建基于语音识别的唤醒词检测器，provider 需已完成配置，且不能与对话使用的ASR为同一实例
+func NewAsrDetector(log *log.Logger, provider asr.Provider, words []string) *AsrDetector {
+	d := &AsrDetector{
+		log:      log,
+		provider: provider,
+		words:    make([]string, 0, len(words)),
+	}
+	for _, word := range words {
+		if word = normalize(word); word != "" {
+			d.words = append(d.words, word)
+		}
+	}
+	provider.SetListener(d)
+	return d
+}
+
+func (d *AsrDetector) Name() string {
+	return "asr"
+}
+
+func (d *AsrDetector) Detect(ctx context.Context, audio []byte) (bool, error) {
+	if atomic.LoadInt32(&d.detected) == 1 {
+		return true, nil
+	}
+	if err := d.provider.SendAudio(ctx, audio); err != nil {
+		return false, err
+	}
+	return atomic.LoadInt32(&d.detected) == 1, nil
+}
+
+func (d *AsrDetector) OnAsrResult(ctx context.Context, result string, state asr.State) bool {
+	if atomic.LoadInt32(&d.detected) == 1 {
+		return true
+	}
+	if d.match(result) {
+		d.log.Infof("wake word detected: %s", result)
+		atomic.StoreInt32(&d.detected, 1)
+		// 已唤醒，结束本次识别，后续音频交由对话使用的ASR识别
+		return true
+	}
+	if state == asr.StateCompleted {
+		_ = d.provider.Reset()
+		return true
+	}
+	return false
+}
+
+func (d *AsrDetector) Reset() error {
+	atomic.StoreInt32(&d.detected, 0)
+	return d.provider.Reset()
+}
+
+// match 忽略标点与大小写，判断识别文本中是否包含唤醒词
+func (d *AsrDetector) match(result string) bool {
+	result = normalize(result)
+	if result == "" {
+		return false
+	}
+	for _, word := range d.words {
+		if strings.Contains(result, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalize(text string) string {
+	return strings.ReplaceAll(strings.ToLower(util.RemoveAllPunctuation(text)), " ", "")
+}
@@ -0,0 +1,14 @@
+// Package wakeword 唤醒词检测，适用于常开的智能音箱类设备：检测到唤醒词之前，用户的语音不会进入识别与对话
+package wakeword
+
+import "context"
+
+// Detector 唤醒词检测器，可接入端侧或服务端的唤醒引擎
+type Detector interface {
+	// Name 检测器的稳定标识，用于日志与监控
+	Name() string
+	// Detect 输入一帧音频，返回截至当前是否已检测到唤醒词
+	Detect(ctx context.Context, audio []byte) (bool, error)
+	// Reset 重置检测状态，准备下一次唤醒
+	Reset() error
+}
@@ -0,0 +1,133 @@
+// Package connlimit 限制同一服务商跨会话同时建立的上游连接数，避免超出服务商的并发配额而被限流
+package connlimit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"crow/pkg/metrics"
+)
+
+// defaultWaitTimeout 连接数已满时的默认最长等待时间
+const defaultWaitTimeout = 3 * time.Second
+
+var (
+	inUseGauge = metrics.NewGaugeVec("crow_upstream_connections",
+		"Number of upstream connections currently held.", "kind", "provider")
+	waitCounter = metrics.NewCounterVec("crow_upstream_connection_waits_total",
+		"Number of connection attempts that had to wait for a free slot.", "kind", "provider")
+	waitSeconds = metrics.NewCounterVec("crow_upstream_connection_wait_seconds_total",
+		"Total time spent waiting for a free slot.", "kind", "provider")
+	timeoutCounter = metrics.NewCounterVec("crow_upstream_connection_wait_timeouts_total",
+		"Number of connection attempts that gave up waiting for a free slot.", "kind", "provider")
+)
+
+// Limiter 某个服务商的连接数限制
+type Limiter struct {
+	kind     string // asr 或 tts
+	provider string
+
+	lock    sync.Mutex
+	max     int           // 最大连接数，0为不限制
+	timeout time.Duration // 连接数已满时的最长等待时间
+	inUse   int
+	notify  chan struct{} // 有连接释放时关闭，用于唤醒等待者
+}
+
+var (
+	limitersLock sync.Mutex
+	limiters     = map[string]*Limiter{}
+)
+
+// Get 获取服务商的连接数限制，同一服务商在所有会话间共享
+// 每次调用都会以传入的配置更新上限，配置热更新后对新的连接生效
+// @param max 最大连接数，0为不限制
+// @param timeout 连接数已满时的最长等待时间，0则使用默认值
+func Get(kind, provider string, max int, timeout time.Duration) *Limiter {
+	limitersLock.Lock()
+	defer limitersLock.Unlock()
+
+	key := kind + "/" + provider
+	l, ok := limiters[key]
+	if !ok {
+		l = &Limiter{kind: kind, provider: provider}
+		limiters[key] = l
+	}
+	if timeout <= 0 {
+		timeout = defaultWaitTimeout
+	}
+
+	l.lock.Lock()
+	l.max = max
+	l.timeout = timeout
+	l.wakeLocked() // 上限可能被调大
+	l.lock.Unlock()
+	return l
+}
+
+// Acquire 占用一个连接名额，连接数已满时等待，超时或 ctx 结束则返回错误
+// 返回的 release 用于释放名额，可安全地多次调用
+func (l *Limiter) Acquire(ctx context.Context) (release func(), err error) {
+	var (
+		start   time.Time
+		timer   *time.Timer
+		waiting bool
+	)
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		if waiting {
+			waitSeconds.Add(time.Since(start).Seconds(), l.kind, l.provider)
+		}
+	}()
+
+	for {
+		l.lock.Lock()
+		if l.max <= 0 || l.inUse < l.max {
+			l.inUse++
+			l.lock.Unlock()
+			inUseGauge.Add(1, l.kind, l.provider)
+			var once sync.Once
+			return func() { once.Do(l.release) }, nil
+		}
+		if l.notify == nil {
+			l.notify = make(chan struct{})
+		}
+		notify := l.notify
+		if !waiting {
+			waiting = true
+			start = time.Now()
+			timer = time.NewTimer(l.timeout)
+			waitCounter.Inc(l.kind, l.provider)
+		}
+		l.lock.Unlock()
+
+		select {
+		case <-notify:
+		case <-timer.C:
+			timeoutCounter.Inc(l.kind, l.provider)
+			return nil, fmt.Errorf("too many concurrent %s connections to %s, wait for a free slot timeout", l.kind, l.provider)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (l *Limiter) release() {
+	l.lock.Lock()
+	l.inUse--
+	l.wakeLocked()
+	l.lock.Unlock()
+	inUseGauge.Add(-1, l.kind, l.provider)
+}
+
+// wakeLocked 唤醒全部等待者重新竞争名额，调用方需持有锁
+func (l *Limiter) wakeLocked() {
+	if l.notify != nil {
+		close(l.notify)
+		l.notify = nil
+	}
+}
@@ -0,0 +1,137 @@
+package connlimit
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"crow/pkg/metrics"
+)
+
+// inUse 导出的指标中服务商当前占用的连接数
+func inUse(provider string) string {
+	return exported("crow_upstream_connections", provider)
+}
+
+// exported 导出的指标中服务商对应的值
+func exported(name, provider string) string {
+	prefix := name + `{kind="test",provider="` + provider + `"} `
+	for _, line := range strings.Split(metrics.Export(), "\n") {
+		if strings.HasPrefix(line, prefix) {
+			return strings.TrimPrefix(line, prefix)
+		}
+	}
+	return ""
+}
+
+func TestAcquire(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	l := Get("test", "acquire", 1, time.Second)
+	release, err := l.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	if got := inUse("acquire"); got != "1" {
+		t.Fatalf("in use = %q, want 1", got)
+	}
+
+	// 名额释放后唤醒等待者
+	acquired := make(chan error, 1)
+	go func() {
+		release, err := l.Acquire(context.Background())
+		if err == nil {
+			defer release()
+		}
+		acquired <- err
+		<-done
+	}()
+	select {
+	case err = <-acquired:
+		t.Fatalf("acquired while full: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	release()
+	release() // 重复释放不会多释放名额
+	select {
+	case err = <-acquired:
+		if err != nil {
+			t.Fatalf("acquire after release: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter not woken after release")
+	}
+	if got := inUse("acquire"); got != "1" {
+		t.Fatalf("in use = %q after release twice, want 1", got)
+	}
+}
+
+func TestAcquireTimeout(t *testing.T) {
+	l := Get("test", "timeout", 1, 50*time.Millisecond)
+	release, err := l.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	defer release()
+
+	timeouts := exported("crow_upstream_connection_wait_timeouts_total", "timeout")
+	start := time.Now()
+	if _, err = l.Acquire(context.Background()); err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Fatalf("acquire while full: err = %v, want timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("gave up after %v, want about 50ms", elapsed)
+	}
+	if got := exported("crow_upstream_connection_wait_timeouts_total", "timeout"); got == timeouts {
+		t.Fatalf("timeout is not counted: %q", got)
+	}
+}
+
+func TestAcquireCanceled(t *testing.T) {
+	l := Get("test", "canceled", 1, time.Minute)
+	release, err := l.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err = l.Acquire(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("acquire with canceled context: err = %v, want context.Canceled", err)
+	}
+}
+
+func TestRaiseLimit(t *testing.T) {
+	l := Get("test", "raise", 1, time.Minute)
+	release, err := l.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	defer release()
+
+	done := make(chan struct{})
+	defer close(done)
+	acquired := make(chan error, 1)
+	go func() {
+		release, err := l.Acquire(context.Background())
+		if err == nil {
+			defer release()
+		}
+		acquired <- err
+		<-done
+	}()
+	time.Sleep(20 * time.Millisecond)
+	// 配置热更新调大上限后，等待者立即获得名额
+	Get("test", "raise", 2, time.Minute)
+	select {
+	case err = <-acquired:
+		if err != nil {
+			t.Fatalf("acquire after raising the limit: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter not woken after raising the limit")
+	}
+}
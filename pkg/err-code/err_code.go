@@ -4,7 +4,13 @@ import "fmt"
 
 var (
 	ErrInvalidDataType = NewError(10400, "无效的数据类型")
+	ErrNotAllowed      = NewError(10403, "不允许选择该模块")
+	ErrTooLarge        = NewError(10413, "用户信息过长")
+	ErrAudioFormat     = NewError(10415, "音频格式与协商的格式不一致")
+	ErrInvalidParams   = NewError(10422, "参数无效")
 	ErrInternal        = NewError(10500, "内部错误")
+	ErrAsrUnavailable  = NewError(10503, "语音识别服务暂时不可用，请稍后再试")
+	ErrTtsUnavailable  = NewError(10504, "语音合成服务暂时不可用，请稍后再试")
 )
 
 type Error struct {
@@ -0,0 +1,272 @@
+// Package fakeredis 提供一个基于 RESP 协议的内存 Redis 服务端
+// 将 Redis 配置中的地址指向 Server.Addr()，即可在不依赖真实 Redis 的情况下，
+// 确定性地复现客户端的认证、读写及断线重连等行为（包括错误回复、连接被服务端断开等异常情况）
+package fakeredis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server 内存 Redis 服务端，支持 AUTH、SELECT、GET、SET（含 PX）及 DEL 命令
+type Server struct {
+	listener net.Listener
+	password string
+
+	lock     sync.Mutex
+	data     map[string]string        // k: 数据库编号/键
+	ttl      map[string]time.Duration // 键设置的过期时间，仅记录不生效
+	fails    map[string][]string      // k: 命令名称，v: 依次返回的错误回复
+	commands [][]string               // 收到的全部命令
+	conns    map[net.Conn]struct{}
+	dials    int // 累计建立的连接数
+}
+
+// NewServer 创建并启动服务端，password 不为空时须先认证
+func NewServer(password string) (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		listener: listener,
+		password: password,
+		data:     map[string]string{},
+		ttl:      map[string]time.Duration{},
+		fails:    map[string][]string{},
+		conns:    map[net.Conn]struct{}{},
+	}
+	go s.serve()
+	return s, nil
+}
+
+// Addr 返回可直接用于 Redis 配置的地址
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close 关闭服务端及全部连接
+func (s *Server) Close() {
+	_ = s.listener.Close()
+	s.DropConns()
+}
+
+// DropConns 断开当前全部连接，模拟 Redis 重启或网络中断，之后仍可建立新连接
+func (s *Server) DropConns() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for conn := range s.conns {
+		_ = conn.Close()
+		delete(s.conns, conn)
+	}
+}
+
+// FailNext 指定命令的下一次执行返回错误回复，如 FailNext("GET", "ERR boom")
+func (s *Server) FailNext(command, message string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	command = strings.ToUpper(command)
+	s.fails[command] = append(s.fails[command], message)
+}
+
+// Get 返回 0 号数据库中键的值
+func (s *Server) Get(key string) (string, bool) {
+	return s.GetDB(0, key)
+}
+
+// GetDB 返回指定数据库中键的值
+func (s *Server) GetDB(db int, key string) (string, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	value, ok := s.data[dbKey(db, key)]
+	return value, ok
+}
+
+// Set 设置 0 号数据库中键的值，用于预置数据
+func (s *Server) Set(key, value string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.data[dbKey(0, key)] = value
+}
+
+// TTL 返回 0 号数据库中键最近一次设置的过期时间，未设置为0
+func (s *Server) TTL(key string) time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.ttl[dbKey(0, key)]
+}
+
+// Commands 返回收到的全部命令
+func (s *Server) Commands() [][]string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	commands := make([][]string, len(s.commands))
+	copy(commands, s.commands)
+	return commands
+}
+
+// Dials 返回累计建立的连接数
+func (s *Server) Dials() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.dials
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.lock.Lock()
+		s.conns[conn] = struct{}{}
+		s.dials++
+		s.lock.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+		s.lock.Lock()
+		delete(s.conns, conn)
+		s.lock.Unlock()
+	}()
+
+	reader := bufio.NewReader(conn)
+	authed := s.password == ""
+	db := 0
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		s.lock.Lock()
+		s.commands = append(s.commands, args)
+		s.lock.Unlock()
+
+		reply := s.exec(args, &authed, &db)
+		if _, err = io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// exec 执行一条命令，返回 RESP 编码的回复
+func (s *Server) exec(args []string, authed *bool, db *int) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	command := strings.ToUpper(args[0])
+	if fails := s.fails[command]; len(fails) > 0 {
+		s.fails[command] = fails[1:]
+		return "-" + fails[0] + "\r\n"
+	}
+	if command == "AUTH" {
+		if len(args) != 2 || args[1] != s.password {
+			return "-WRONGPASS invalid password\r\n"
+		}
+		*authed = true
+		return "+OK\r\n"
+	}
+	if !*authed {
+		return "-NOAUTH Authentication required.\r\n"
+	}
+
+	switch command {
+	case "SELECT":
+		if len(args) != 2 {
+			return "-ERR wrong number of arguments for 'select' command\r\n"
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			return "-ERR invalid DB index\r\n"
+		}
+		*db = n
+		return "+OK\r\n"
+	case "GET":
+		if len(args) != 2 {
+			return "-ERR wrong number of arguments for 'get' command\r\n"
+		}
+		value, ok := s.data[dbKey(*db, args[1])]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+	case "SET":
+		if len(args) != 3 && len(args) != 5 {
+			return "-ERR syntax error\r\n"
+		}
+		key := dbKey(*db, args[1])
+		s.data[key] = args[2]
+		delete(s.ttl, key)
+		if len(args) == 5 {
+			ms, err := strconv.ParseInt(args[4], 10, 64)
+			if !strings.EqualFold(args[3], "PX") || err != nil {
+				return "-ERR syntax error\r\n"
+			}
+			s.ttl[key] = time.Duration(ms) * time.Millisecond
+		}
+		return "+OK\r\n"
+	case "DEL":
+		deleted := 0
+		for _, key := range args[1:] {
+			if _, ok := s.data[dbKey(*db, key)]; ok {
+				delete(s.data, dbKey(*db, key))
+				deleted++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", deleted)
+	}
+	return fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+}
+
+func dbKey(db int, key string) string {
+	return strconv.Itoa(db) + "/" + key
+}
+
+// readCommand 读取一条以批量字符串数组编码的命令
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := readLine(reader)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("invalid command: %q", line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("invalid command length: %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = readLine(reader); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimPrefix(line, "$"))
+		if !strings.HasPrefix(line, "$") || err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid bulk length: %q", line)
+		}
+		data := make([]byte, size+2)
+		if _, err = io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
@@ -0,0 +1,145 @@
+// Package fakews 提供一个按脚本回放预设帧的 WebSocket 服务端
+// 将 ASR/TTS 配置中的 endpoint 指向 Server.URL()，即可在不访问真实服务商的情况下，
+// 确定性地复现各服务商的建连、协议解析及读取循环的行为（包括错误帧、截断帧等异常情况）
+package fakews
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Frame 服务端下发的一帧数据
+type Frame struct {
+	MessageType int           // websocket.TextMessage 或 websocket.BinaryMessage
+	Data        []byte        // 帧内容
+	Delay       time.Duration // 下发前的等待时间
+}
+
+// Step 回放脚本中的一步：先等待客户端发送 Wait 条消息，再依次下发 Frames
+type Step struct {
+	Wait   int     // 下发前需要收到的客户端消息数
+	Frames []Frame // 依次下发的帧
+	Close  bool    // 下发完成后是否直接断开连接，用于模拟服务端异常断连
+}
+
+// Server 回放预设帧的 WebSocket 服务端，每个连接都会从头执行一遍脚本
+type Server struct {
+	scripts [][]Step // 第 i 个连接执行的脚本，超出时重复执行最后一个
+	server  *httptest.Server
+	discard bool // 脚本执行完毕后收到的消息不再记录
+
+	lock     sync.Mutex
+	conns    int         // 已建立的连接数
+	header   http.Header // 最近一次握手的请求头
+	received [][]byte    // 收到的全部客户端消息
+}
+
+// NewServer 创建并启动回放服务
+func NewServer(script ...Step) *Server {
+	s := &Server{scripts: [][]Step{script}}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// NewDiscardServer 创建并启动回放服务，脚本执行完毕后收到的消息直接丢弃，
+// 用于基准测试等持续发送大量数据的场景，避免记录的消息占用内存
+func NewDiscardServer(script ...Step) *Server {
+	s := &Server{scripts: [][]Step{script}, discard: true}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// NewSequenceServer 创建并启动回放服务，第 i 个连接执行 scripts[i]，超出时重复执行最后一个脚本，
+// 用于断线重连等每个连接的行为不同的场景
+func NewSequenceServer(scripts ...[]Step) *Server {
+	s := &Server{scripts: scripts}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// URL 返回可直接用于 endpoint 配置的 ws 地址
+func (s *Server) URL() string {
+	return "ws" + strings.TrimPrefix(s.server.URL, "http")
+}
+
+// Close 关闭回放服务
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Header 返回最近一次握手的请求头，可用于校验鉴权信息
+func (s *Server) Header() http.Header {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.header.Clone()
+}
+
+// Received 返回收到的全部客户端消息
+func (s *Server) Received() [][]byte {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	received := make([][]byte, len(s.received))
+	copy(received, s.received)
+	return received
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	s.lock.Lock()
+	s.header = r.Header.Clone()
+	script := s.scripts[min(s.conns, len(s.scripts)-1)]
+	s.conns++
+	s.lock.Unlock()
+
+	for _, step := range script {
+		for i := 0; i < step.Wait; i++ {
+			if !s.read(conn) {
+				return
+			}
+		}
+		for _, frame := range step.Frames {
+			if frame.Delay > 0 {
+				time.Sleep(frame.Delay)
+			}
+			if err = conn.WriteMessage(frame.MessageType, frame.Data); err != nil {
+				return
+			}
+		}
+		if step.Close {
+			return
+		}
+	}
+
+	// 脚本执行完毕后继续接收，直到客户端断开
+	if s.discard {
+		for {
+			if _, _, err = conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}
+	for s.read(conn) {
+	}
+}
+
+func (s *Server) read(conn *websocket.Conn) bool {
+	_, message, err := conn.ReadMessage()
+	if err != nil {
+		return false
+	}
+	s.lock.Lock()
+	s.received = append(s.received, message)
+	s.lock.Unlock()
+	return true
+}
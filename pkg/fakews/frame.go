@@ -0,0 +1,114 @@
+package fakews
+
+import (
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+
+	"crow/pkg/volcproto"
+)
+
+// Text 构造一个 JSON 文本帧
+func Text(v any) Frame {
+	data, _ := json.Marshal(v)
+	return Frame{MessageType: websocket.TextMessage, Data: data}
+}
+
+// Binary 构造一个二进制帧
+func Binary(data []byte) Frame {
+	return Frame{MessageType: websocket.BinaryMessage, Data: data}
+}
+
+// Truncate 截断帧内容，仅保留前 n 个字节，用于模拟不完整的帧
+func Truncate(frame Frame, n int) Frame {
+	if n < len(frame.Data) {
+		frame.Data = frame.Data[:n]
+	}
+	return frame
+}
+
+// DashScopeEvent 构造阿里 DashScope（paraformer/cosy-voice）的事件帧
+// 如 task-started、result-generated、task-finished，output 为 payload.output 的内容，可为 nil
+func DashScopeEvent(event, taskID string, output any) Frame {
+	payload := map[string]any{}
+	if output != nil {
+		payload["output"] = output
+	}
+	return Text(map[string]any{
+		"header": map[string]any{
+			"event":      event,
+			"task_id":    taskID,
+			"attributes": map[string]any{},
+		},
+		"payload": payload,
+	})
+}
+
+// DashScopeTaskFailed 构造阿里 DashScope 的 task-failed 事件帧
+func DashScopeTaskFailed(taskID, code, message string) Frame {
+	return Text(map[string]any{
+		"header": map[string]any{
+			"event":         "task-failed",
+			"task_id":       taskID,
+			"error_code":    code,
+			"error_message": message,
+			"attributes":    map[string]any{},
+		},
+		"payload": map[string]any{},
+	})
+}
+
+// DoubaoResponse 构造豆包（流式语音识别、语音合成V1）服务端响应帧
+// messageType 为消息类型，如 0x9 full server response，0xb audio-only server response；
+// sequence 为负数表示最后一包；payload 为 JSON 序列化后 gzip 压缩的内容，若 payload 为 []byte 则视为无序列化的原始数据
+func DoubaoResponse(messageType uint8, sequence int32, payload any) Frame {
+	flag := volcproto.MsgTypeFlagPositiveSeq
+	if sequence < 0 {
+		flag = volcproto.MsgTypeFlagNegativeSeq // 负序号，表示最后一包
+	}
+	msg := volcproto.NewMessage(volcproto.MsgType(messageType), flag)
+	msg.Sequence = sequence
+	msg.Compression = volcproto.CompressionGzip
+
+	var data []byte
+	if raw, ok := payload.([]byte); ok {
+		msg.Serialization = volcproto.SerializationRaw
+		data = raw
+	} else {
+		data, _ = json.Marshal(payload)
+	}
+	msg.Payload, _ = volcproto.GzipCompress(data)
+	return marshal(msg)
+}
+
+// DoubaoError 构造豆包服务端错误帧
+func DoubaoError(code uint32, message string) Frame {
+	msg := volcproto.NewMessage(volcproto.MsgTypeError, volcproto.MsgTypeFlagNoSeq)
+	msg.ErrorCode = code
+	msg.Payload = []byte(message)
+	return marshal(msg)
+}
+
+// VolcEvent 构造豆包双向流式语音合成（V3）带事件号的服务端帧
+// messageType 为消息类型，如 0x9 full server response，0xb audio-only server response；
+// id 对连接级事件（ConnectionStarted 等）为 connect id，对会话级事件为 session id
+func VolcEvent(messageType uint8, event int32, id string, payload []byte) Frame {
+	msg := volcproto.NewMessage(volcproto.MsgType(messageType), volcproto.MsgTypeFlagWithEvent)
+	if msg.MsgType == volcproto.MsgTypeAudioOnlyServer {
+		msg.Serialization = volcproto.SerializationRaw
+	}
+	msg.EventType = volcproto.EventType(event)
+	switch msg.EventType {
+	case volcproto.EventType_ConnectionStarted, volcproto.EventType_ConnectionFailed, volcproto.EventType_ConnectionFinished:
+		msg.ConnectID = id
+	default:
+		msg.SessionID = id
+	}
+	msg.Payload = payload
+	return marshal(msg)
+}
+
+func marshal(msg *volcproto.Message) Frame {
+	data, _ := msg.Marshal()
+	return Binary(data)
+}
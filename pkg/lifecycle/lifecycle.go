@@ -0,0 +1,132 @@
+// Package lifecycle 统一 ASR/TTS 等长连接服务的生命周期：状态机、建立连接的重试及对外的 Start/Stop/Status 约定
+// 各服务商只需关注自身协议的握手与收发，连接状态的流转与重试策略由该包统一维护，避免各自实现后行为不一致
+package lifecycle
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Status 连接的生命周期状态
+type Status int32
+
+const (
+	// StatusStopped 未建立连接，或连接已断开
+	StatusStopped Status = iota
+	// StatusStarting 正在建立连接
+	StatusStarting
+	// StatusRunning 连接已就绪，可以收发数据
+	StatusRunning
+	// StatusStopping 正在关闭连接
+	StatusStopping
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusStopped:
+		return "stopped"
+	case StatusStarting:
+		return "starting"
+	case StatusRunning:
+		return "running"
+	case StatusStopping:
+		return "stopping"
+	}
+	return "unknown"
+}
+
+// Lifecycle 长连接服务的生命周期约定
+type Lifecycle interface {
+	// Start 建立连接，已建立时直接返回，可重复调用
+	Start(ctx context.Context) error
+	// Stop 关闭连接并重置状态，未建立时直接返回，可重复调用
+	Stop() error
+	// Status 当前的生命周期状态
+	Status() Status
+}
+
+// transitions 允许的状态流转，其余流转均视为非法
+var transitions = map[Status][]Status{
+	StatusStopped:  {StatusStarting},
+	StatusStarting: {StatusRunning, StatusStopped},
+	StatusRunning:  {StatusStopping, StatusStopped},
+	StatusStopping: {StatusStopped},
+}
+
+// State 并发安全的生命周期状态机，零值为 StatusStopped，可直接内嵌于服务商的结构体中
+type State struct {
+	status int32
+}
+
+// Status 当前状态
+func (s *State) Status() Status {
+	return Status(atomic.LoadInt32(&s.status))
+}
+
+// Running 连接是否已就绪
+func (s *State) Running() bool {
+	return s.Status() == StatusRunning
+}
+
+// Transition 当前状态为 from 且允许流转到 to 时切换状态
+// @return 是否切换成功，状态已被其他协程修改或流转非法时返回 false
+func (s *State) Transition(from, to Status) bool {
+	if !CanTransition(from, to) {
+		return false
+	}
+	return atomic.CompareAndSwapInt32(&s.status, int32(from), int32(to))
+}
+
+// Start 开始建立连接，仅在 StatusStopped 时成功
+func (s *State) Start() bool {
+	return s.Transition(StatusStopped, StatusStarting)
+}
+
+// Stop 无论当前处于何种状态，均强制回到 StatusStopped，用于连接异常断开或重置
+// @return 之前的状态
+func (s *State) Stop() Status {
+	return Status(atomic.SwapInt32(&s.status, int32(StatusStopped)))
+}
+
+// CanTransition 是否允许从 from 流转到 to
+func CanTransition(from, to Status) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Retry 执行 fn 直至成功或达到最大尝试次数，每次失败后等待递增的间隔（500ms、1s、1.5s……），ctx 结束时立即返回
+// @param attempts 最大尝试次数，不大于0时只尝试一次
+// @param onRetry 每次失败且将要重试时回调，可为 nil，attempt 从1开始
+// @return 最后一次尝试的错误
+func Retry(ctx context.Context, attempts int, fn func() error, onRetry func(attempt int, err error, backoff time.Duration)) error {
+	if attempts <= 0 {
+		attempts = 1
+	}
+	var err error
+	for i := 0; i < attempts; i++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if i+1 >= attempts {
+			break
+		}
+
+		backoff := time.Duration(500*(i+1)) * time.Millisecond
+		if onRetry != nil {
+			onRetry(i+1, err, backoff)
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+	return err
+}
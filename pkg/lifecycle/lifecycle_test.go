@@ -0,0 +1,154 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCanTransition(t *testing.T) {
+	allowed := map[[2]Status]bool{
+		{StatusStopped, StatusStarting}:  true,
+		{StatusStarting, StatusRunning}:  true,
+		{StatusStarting, StatusStopped}:  true,
+		{StatusRunning, StatusStopping}:  true,
+		{StatusRunning, StatusStopped}:   true,
+		{StatusStopping, StatusStopped}:  true,
+		{StatusStopped, StatusRunning}:   false,
+		{StatusStopping, StatusRunning}:  false,
+		{StatusRunning, StatusStarting}:  false,
+		{StatusStopped, StatusStopped}:   false,
+		{StatusStarting, StatusStopping}: false,
+	}
+	for _, from := range []Status{StatusStopped, StatusStarting, StatusRunning, StatusStopping} {
+		for _, to := range []Status{StatusStopped, StatusStarting, StatusRunning, StatusStopping} {
+			if got, want := CanTransition(from, to), allowed[[2]Status{from, to}]; got != want {
+				t.Errorf("CanTransition(%v, %v) = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+}
+
+func TestState(t *testing.T) {
+	var s State
+	if got := s.Status(); got != StatusStopped {
+		t.Fatalf("zero value status = %v, want stopped", got)
+	}
+
+	steps := []struct {
+		name string
+		do   func() bool
+		want bool
+		then Status
+	}{
+		{"start", s.Start, true, StatusStarting},
+		{"start again", s.Start, false, StatusStarting},
+		{"illegal transition", func() bool { return s.Transition(StatusStarting, StatusStopping) }, false, StatusStarting},
+		{"stale from", func() bool { return s.Transition(StatusRunning, StatusStopping) }, false, StatusStarting},
+		{"running", func() bool { return s.Transition(StatusStarting, StatusRunning) }, true, StatusRunning},
+		{"stopping", func() bool { return s.Transition(StatusRunning, StatusStopping) }, true, StatusStopping},
+		{"stopped", func() bool { return s.Transition(StatusStopping, StatusStopped) }, true, StatusStopped},
+	}
+	for _, step := range steps {
+		if got := step.do(); got != step.want {
+			t.Fatalf("%s = %v, want %v", step.name, got, step.want)
+		}
+		if got := s.Status(); got != step.then {
+			t.Fatalf("status after %s = %v, want %v", step.name, got, step.then)
+		}
+	}
+
+	// Stop 无论当前处于何种状态均回到 StatusStopped，并返回之前的状态
+	s.Start()
+	s.Transition(StatusStarting, StatusRunning)
+	if !s.Running() {
+		t.Fatal("running = false after transition to running")
+	}
+	if prev := s.Stop(); prev != StatusRunning {
+		t.Fatalf("stop returned %v, want running", prev)
+	}
+	if prev := s.Stop(); prev != StatusStopped || s.Status() != StatusStopped {
+		t.Fatalf("stop again returned %v with status %v, want stopped", prev, s.Status())
+	}
+}
+
+func TestStateConcurrentStart(t *testing.T) {
+	var (
+		s       State
+		started int32
+		wg      sync.WaitGroup
+	)
+	// 并发建立连接时只有一个协程能够开始，其余协程应直接返回
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if s.Start() {
+				atomic.AddInt32(&started, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if started != 1 {
+		t.Fatalf("started %d times, want 1", started)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	errFailed := errors.New("failed")
+	tests := []struct {
+		name        string
+		attempts    int
+		failures    int // fn 前几次返回错误
+		wantCalls   int
+		wantRetries int
+		wantErr     bool
+	}{
+		{"success", 3, 0, 1, 0, false},
+		{"success after retry", 3, 1, 2, 1, false},
+		{"exhausted", 2, 5, 2, 1, true},
+		{"no attempts", 0, 5, 1, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls, retries int
+			err := Retry(context.Background(), tt.attempts, func() error {
+				calls++
+				if calls <= tt.failures {
+					return errFailed
+				}
+				return nil
+			}, func(attempt int, err error, backoff time.Duration) {
+				retries++
+				if attempt != retries || backoff != time.Duration(500*attempt)*time.Millisecond {
+					t.Errorf("retry %d: attempt %d, backoff %v", retries, attempt, backoff)
+				}
+			})
+			if (err != nil) != tt.wantErr || calls != tt.wantCalls || retries != tt.wantRetries {
+				t.Fatalf("retry = %v after %d calls and %d retries, want error %v after %d calls and %d retries",
+					err, calls, retries, tt.wantErr, tt.wantCalls, tt.wantRetries)
+			}
+		})
+	}
+}
+
+func TestRetryCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var calls int
+	start := time.Now()
+	err := Retry(ctx, 5, func() error {
+		calls++
+		return errors.New("failed")
+	}, nil)
+	// ctx 结束后不再等待重试间隔，返回最后一次尝试的错误
+	if err == nil || calls != 1 {
+		t.Fatalf("retry = %v after %d calls, want error after 1 call", err, calls)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("retry took %v after ctx canceled", elapsed)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"io"
 	"os"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 
@@ -30,6 +31,18 @@ const (
 	EncodeTypeJson
 )
 
+// ParseEncodeType 解析配置中的日志输出类型，console 或 json，其他值返回 def
+func ParseEncodeType(encoding string, def EncodeType) EncodeType {
+	switch strings.ToLower(encoding) {
+	case "console":
+		return EncodeTypeConsole
+	case "json":
+		return EncodeTypeJson
+	default:
+		return def
+	}
+}
+
 type Option struct {
 	Hook        io.Writer
 	Mode        string
@@ -110,8 +123,10 @@ func (l *Logger) clone() *Logger {
 
 func (l *Logger) WithFields(f Fields) *Logger {
 	ll := l.clone()
-	if ll.fields == nil {
-		ll.fields = make(Fields)
+	// 复制一份字段，避免修改原 Logger 的字段
+	ll.fields = make(Fields, len(l.fields)+len(f))
+	for k, v := range l.fields {
+		ll.fields[k] = v
 	}
 	for k, v := range f {
 		ll.fields[k] = v
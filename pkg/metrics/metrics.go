@@ -0,0 +1,272 @@
+// Package metrics 轻量的进程内指标，以 Prometheus 文本格式对外暴露，无需引入额外依赖
+// 指标在包初始化或首次使用时注册，通过 Handler 挂载到 HTTP 路由（如 /metrics）即可被采集
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type metricType string
+
+const (
+	typeCounter   metricType = "counter"
+	typeGauge     metricType = "gauge"
+	typeHistogram metricType = "histogram"
+)
+
+// DefaultBuckets 直方图默认的桶上限，单位秒，覆盖毫秒级到数十秒的耗时
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// vec 一组同名、不同标签值的指标
+type vec struct {
+	name   string
+	help   string
+	typ    metricType
+	labels []string
+	bounds []float64 // 直方图的桶上限，升序
+
+	lock   sync.Mutex
+	values map[string]*value // key 为标签值以 \xff 拼接
+}
+
+type value struct {
+	labelValues []string
+	lock        sync.Mutex
+	v           float64  // 计数器及仪表盘的值，直方图为观测值之和
+	counts      []uint64 // 直方图各个桶的观测次数，非累计
+	count       uint64   // 直方图的观测总次数
+}
+
+var (
+	registryLock sync.Mutex
+	registry     = map[string]*vec{}
+)
+
+func register(name, help string, typ metricType, labels []string, bounds ...float64) *vec {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if v, ok := registry[name]; ok {
+		if v.typ != typ || len(v.labels) != len(labels) {
+			panic(fmt.Sprintf("metric %s registered with a different type or labels", name))
+		}
+		return v
+	}
+	v := &vec{name: name, help: help, typ: typ, labels: labels, bounds: bounds, values: map[string]*value{}}
+	registry[name] = v
+	return v
+}
+
+func (m *vec) with(labelValues []string) *value {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", m.name, len(m.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	v, ok := m.values[key]
+	if !ok {
+		v = &value{labelValues: append([]string(nil), labelValues...)}
+		if m.typ == typeHistogram {
+			v.counts = make([]uint64, len(m.bounds))
+		}
+		m.values[key] = v
+	}
+	return v
+}
+
+func (v *value) add(delta float64) {
+	v.lock.Lock()
+	v.v += delta
+	v.lock.Unlock()
+}
+
+func (v *value) set(val float64) {
+	v.lock.Lock()
+	v.v = val
+	v.lock.Unlock()
+}
+
+func (v *value) observe(bounds []float64, val float64) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.v += val
+	v.count++
+	if i := sort.SearchFloat64s(bounds, val); i < len(bounds) {
+		v.counts[i]++
+	}
+}
+
+func (v *value) get() float64 {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return v.v
+}
+
+// CounterVec 只增不减的计数器
+type CounterVec struct{ vec *vec }
+
+// NewCounterVec 注册计数器，同名指标重复注册时返回已有的指标
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{vec: register(name, help, typeCounter, labels)}
+}
+
+// Inc 计数加一
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.vec.with(labelValues).add(1)
+}
+
+// Add 计数增加 delta，delta 不能为负数
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		return
+	}
+	c.vec.with(labelValues).add(delta)
+}
+
+// GaugeVec 可增可减的仪表盘
+type GaugeVec struct{ vec *vec }
+
+// NewGaugeVec 注册仪表盘，同名指标重复注册时返回已有的指标
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{vec: register(name, help, typeGauge, labels)}
+}
+
+// Set 设置当前值
+func (g *GaugeVec) Set(val float64, labelValues ...string) {
+	g.vec.with(labelValues).set(val)
+}
+
+// Add 当前值增加 delta，可为负数
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.vec.with(labelValues).add(delta)
+}
+
+// HistogramVec 直方图，用于统计耗时等数值的分布
+type HistogramVec struct{ vec *vec }
+
+// NewHistogramVec 注册直方图，同名指标重复注册时返回已有的指标
+// @param buckets 桶上限，为空则使用 DefaultBuckets
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	bounds := append([]float64(nil), buckets...)
+	sort.Float64s(bounds)
+	return &HistogramVec{vec: register(name, help, typeHistogram, labels, bounds...)}
+}
+
+// Observe 记录一次观测值
+func (h *HistogramVec) Observe(val float64, labelValues ...string) {
+	h.vec.with(labelValues).observe(h.vec.bounds, val)
+}
+
+// Handler 以 Prometheus 文本格式输出全部指标
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = w.Write([]byte(Export()))
+	})
+}
+
+// Export 以 Prometheus 文本格式导出全部指标
+func Export() string {
+	registryLock.Lock()
+	vecs := make([]*vec, 0, len(registry))
+	for _, v := range registry {
+		vecs = append(vecs, v)
+	}
+	registryLock.Unlock()
+	sort.Slice(vecs, func(i, j int) bool { return vecs[i].name < vecs[j].name })
+
+	var b strings.Builder
+	for _, m := range vecs {
+		m.lock.Lock()
+		values := make([]*value, 0, len(m.values))
+		for _, v := range m.values {
+			values = append(values, v)
+		}
+		m.lock.Unlock()
+		if len(values) == 0 {
+			continue
+		}
+		sort.Slice(values, func(i, j int) bool {
+			return strings.Join(values[i].labelValues, "\xff") < strings.Join(values[j].labelValues, "\xff")
+		})
+
+		fmt.Fprintf(&b, "# HELP %s %s\n", m.name, helpEscaper.Replace(m.help))
+		fmt.Fprintf(&b, "# TYPE %s %s\n", m.name, m.typ)
+		for _, v := range values {
+			if m.typ == typeHistogram {
+				writeHistogram(&b, m, v)
+				continue
+			}
+			writeSample(&b, m.name, m.labels, v.labelValues, "", v.get())
+		}
+	}
+	return b.String()
+}
+
+// writeHistogram 输出直方图的累计桶、观测值之和及观测次数
+func writeHistogram(b *strings.Builder, m *vec, v *value) {
+	v.lock.Lock()
+	counts := append([]uint64(nil), v.counts...)
+	sum, count := v.v, v.count
+	v.lock.Unlock()
+
+	var cumulative uint64
+	for i, bound := range m.bounds {
+		cumulative += counts[i]
+		writeSample(b, m.name+"_bucket", m.labels, v.labelValues, formatFloat(bound), float64(cumulative))
+	}
+	writeSample(b, m.name+"_bucket", m.labels, v.labelValues, "+Inf", float64(count))
+	writeSample(b, m.name+"_sum", m.labels, v.labelValues, "", sum)
+	writeSample(b, m.name+"_count", m.labels, v.labelValues, "", float64(count))
+}
+
+// writeSample 输出一行样本，le 不为空时追加直方图的桶标签
+func writeSample(b *strings.Builder, name string, labels, labelValues []string, le string, val float64) {
+	b.WriteString(name)
+	if len(labels) > 0 || le != "" {
+		b.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(b, "%s=\"%s\"", label, labelEscaper.Replace(labelValues[i]))
+		}
+		if le != "" {
+			if len(labels) > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(b, "le=\"%s\"", le)
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatFloat(val))
+	b.WriteByte('\n')
+}
+
+// Prometheus 文本格式仅转义以下字符，其余字符（包括非 ASCII 字符）原样输出
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
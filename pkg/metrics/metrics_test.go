@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// reset 注销指标，以便重复运行测试时从零开始计数
+func reset(names ...string) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	for _, name := range names {
+		delete(registry, name)
+	}
+}
+
+// exported 返回导出内容中属于指定指标的行
+func exported(name string) string {
+	var lines []string
+	for _, line := range strings.SplitAfter(Export(), "\n") {
+		if strings.HasPrefix(line, name) || strings.HasPrefix(line, "# HELP "+name+" ") || strings.HasPrefix(line, "# TYPE "+name+" ") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+func TestExport(t *testing.T) {
+	reset("test_requests_total", "test_sessions", "test_latency_seconds")
+	counter := NewCounterVec("test_requests_total", "Number of requests.", "code")
+	counter.Inc("200")
+	counter.Add(2, "200")
+	counter.Add(-1, "200") // 计数器不能减少
+	counter.Inc("500")
+
+	gauge := NewGaugeVec("test_sessions", "Active sessions.")
+	gauge.Set(3)
+	gauge.Add(-1)
+
+	histogram := NewHistogramVec("test_latency_seconds", "Latency.", []float64{1, 0.1}, "kind")
+	histogram.Observe(0.05, "asr")
+	histogram.Observe(0.5, "asr")
+	histogram.Observe(5, "asr")
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"test_requests_total", `# HELP test_requests_total Number of requests.
+# TYPE test_requests_total counter
+test_requests_total{code="200"} 3
+test_requests_total{code="500"} 1
+`},
+		{"test_sessions", `# HELP test_sessions Active sessions.
+# TYPE test_sessions gauge
+test_sessions 2
+`},
+		{"test_latency_seconds", `# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{kind="asr",le="0.1"} 1
+test_latency_seconds_bucket{kind="asr",le="1"} 2
+test_latency_seconds_bucket{kind="asr",le="+Inf"} 3
+test_latency_seconds_sum{kind="asr"} 5.55
+test_latency_seconds_count{kind="asr"} 3
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exported(tt.name); got != tt.want {
+				t.Fatalf("export:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExportEscaping(t *testing.T) {
+	reset("test_escape_total")
+	// 标签值仅转义反斜杠、双引号及换行，其余字符原样输出
+	counter := NewCounterVec("test_escape_total", "Help with \\ and\nnewline.", "provider")
+	counter.Inc("a\"b\\c\nd\te 中文")
+	want := `# HELP test_escape_total Help with \\ and\nnewline.
+# TYPE test_escape_total counter
+test_escape_total{provider="a\"b\\c\nd` + "\t" + `e 中文"} 1
+`
+	if got := exported("test_escape_total"); got != want {
+		t.Fatalf("export:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	NewGaugeVec("test_handler", "Handler test.").Set(1)
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if got := w.Header().Get("Content-Type"); got != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Fatalf("content type = %q", got)
+	}
+	body, _ := io.ReadAll(w.Body)
+	if !strings.Contains(string(body), "\ntest_handler 1\n") {
+		t.Fatalf("body does not contain the gauge:\n%s", body)
+	}
+}
@@ -0,0 +1,95 @@
+// Package netproxy 出站连接的代理设置，供各服务商的 WebSocket 拨号器及模型的 HTTP 客户端使用
+// 未配置时与标准库一致，使用 HTTP_PROXY、HTTPS_PROXY、NO_PROXY 环境变量
+package netproxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// Direct 单个服务商的代理配置为该值时，不使用任何代理直接连接
+const Direct = "direct"
+
+type proxyFunc = func(*url.URL) (*url.URL, error)
+
+// defaultProxy 全局的代理设置，为 nil 时使用环境变量
+var defaultProxy atomic.Pointer[proxyFunc]
+
+// SetDefault 设置全局的代理，均为空时恢复为使用环境变量
+// @param proxyURL 代理地址，支持 http、https 及 socks5，为空则使用环境变量中的代理地址
+// @param noProxy 不经过代理的主机，逗号分隔，格式同 NO_PROXY 环境变量，为空则使用环境变量
+func SetDefault(proxyURL, noProxy string) error {
+	if proxyURL == "" && noProxy == "" {
+		defaultProxy.Store(nil)
+		return nil
+	}
+	cfg := httpproxy.FromEnvironment()
+	if proxyURL != "" {
+		if err := validate(proxyURL); err != nil {
+			return err
+		}
+		cfg.HTTPProxy, cfg.HTTPSProxy = proxyURL, proxyURL
+	}
+	if noProxy != "" {
+		cfg.NoProxy = noProxy
+	}
+	f := cfg.ProxyFunc()
+	defaultProxy.Store(&f)
+	return nil
+}
+
+// Func 获取代理函数，可直接用于 websocket.Dialer.Proxy 及 http.Transport.Proxy
+// @param override 单个服务商的代理地址，为空则使用全局的代理设置，为 Direct 则不使用代理；无效的地址在请求时返回错误
+func Func(override string) func(*http.Request) (*url.URL, error) {
+	switch override {
+	case "":
+		return fromDefault
+	case Direct:
+		return nil
+	}
+	if err := validate(override); err != nil {
+		return func(*http.Request) (*url.URL, error) {
+			return nil, err
+		}
+	}
+	cfg := &httpproxy.Config{HTTPProxy: override, HTTPSProxy: override}
+	f := cfg.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return f(req.URL)
+	}
+}
+
+// Validate 校验单个服务商的代理配置
+func Validate(override string) error {
+	if override == "" || override == Direct {
+		return nil
+	}
+	return validate(override)
+}
+
+func fromDefault(req *http.Request) (*url.URL, error) {
+	if f := defaultProxy.Load(); f != nil {
+		return (*f)(req.URL)
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+func validate(proxyURL string) error {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return fmt.Errorf("invalid proxy url %q: %v", proxyURL, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return fmt.Errorf("unsupported proxy scheme %q, expect http, https or socks5", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid proxy url %q: missing host", proxyURL)
+	}
+	return nil
+}
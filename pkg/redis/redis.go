@@ -0,0 +1,218 @@
+// Package redis 精简的 Redis 客户端，基于 RESP 协议实现，仅支持会话记忆等场景所需的少量命令，无需引入额外依赖
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// ErrNil 键不存在
+var ErrNil = errors.New("redis: nil")
+
+const (
+	defaultDialTimeout = 3 * time.Second
+	defaultMaxIdle     = 8
+)
+
+// Option 客户端配置
+type Option struct {
+	Addr     string // 地址，如 127.0.0.1:6379
+	Password string // 密码，为空则不认证
+	DB       int    // 数据库编号
+	MaxIdle  int    // 最大空闲连接数，默认8
+}
+
+// Client Redis 客户端，并发安全，内部维护一个简单的连接池
+type Client struct {
+	opt  Option
+	idle chan *conn
+}
+
+type conn struct {
+	netConn net.Conn
+	reader  *bufio.Reader
+}
+
+// NewClient 创建客户端，连接在首次执行命令时建立
+func NewClient(opt Option) *Client {
+	if opt.MaxIdle <= 0 {
+		opt.MaxIdle = defaultMaxIdle
+	}
+	return &Client{opt: opt, idle: make(chan *conn, opt.MaxIdle)}
+}
+
+// Get 获取字符串值，键不存在时返回 ErrNil
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	reply, err := c.do(ctx, "GET", key)
+	if err != nil {
+		return "", err
+	}
+	if reply == nil {
+		return "", ErrNil
+	}
+	return reply.(string), nil
+}
+
+// Set 设置字符串值，ttl 大于0时同时设置过期时间
+func (c *Client) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	args := []string{"SET", key, value}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	}
+	_, err := c.do(ctx, args...)
+	return err
+}
+
+// Del 删除键
+func (c *Client) Del(ctx context.Context, keys ...string) error {
+	_, err := c.do(ctx, append([]string{"DEL"}, keys...)...)
+	return err
+}
+
+// Close 关闭全部空闲连接
+func (c *Client) Close() error {
+	for {
+		select {
+		case cn := <-c.idle:
+			_ = cn.netConn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (c *Client) do(ctx context.Context, args ...string) (any, error) {
+	for retried := false; ; retried = true {
+		cn, pooled, err := c.get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			_ = cn.netConn.SetDeadline(deadline)
+		} else {
+			_ = cn.netConn.SetDeadline(time.Time{})
+		}
+
+		reply, err := cn.do(args...)
+		var replyErr replyError
+		if err != nil && !errors.As(err, &replyErr) {
+			// 网络错误后连接状态未知，直接丢弃
+			_ = cn.netConn.Close()
+			// 空闲连接可能已被服务端断开（如 Redis 重启），超时以外的错误重新建立连接后重试一次
+			var netErr net.Error
+			if pooled && !retried && ctx.Err() == nil && !(errors.As(err, &netErr) && netErr.Timeout()) {
+				continue
+			}
+			return nil, err
+		}
+		c.put(cn)
+		return reply, err
+	}
+}
+
+// get 获取连接，优先使用空闲连接，pooled 表示是否为空闲连接
+func (c *Client) get(ctx context.Context) (cn *conn, pooled bool, err error) {
+	select {
+	case cn = <-c.idle:
+		return cn, true, nil
+	default:
+	}
+
+	dialer := net.Dialer{Timeout: defaultDialTimeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", c.opt.Addr)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to connect to redis: %v", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = netConn.SetDeadline(deadline)
+	}
+	cn = &conn{netConn: netConn, reader: bufio.NewReader(netConn)}
+	if c.opt.Password != "" {
+		if _, err = cn.do("AUTH", c.opt.Password); err != nil {
+			_ = netConn.Close()
+			return nil, false, fmt.Errorf("failed to auth redis: %v", err)
+		}
+	}
+	if c.opt.DB != 0 {
+		if _, err = cn.do("SELECT", strconv.Itoa(c.opt.DB)); err != nil {
+			_ = netConn.Close()
+			return nil, false, fmt.Errorf("failed to select redis db: %v", err)
+		}
+	}
+	return cn, false, nil
+}
+
+func (c *Client) put(cn *conn) {
+	select {
+	case c.idle <- cn:
+	default:
+		_ = cn.netConn.Close()
+	}
+}
+
+// replyError Redis 返回的错误回复，连接仍然可用
+type replyError string
+
+func (e replyError) Error() string {
+	return "redis: " + string(e)
+}
+
+// do 发送命令并读取回复
+func (cn *conn) do(args ...string) (any, error) {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	if _, err := cn.netConn.Write(buf); err != nil {
+		return nil, err
+	}
+	return cn.readReply()
+}
+
+// readReply 读取一个 RESP 回复，支持简单字符串、错误、整数及批量字符串
+func (cn *conn) readReply() (any, error) {
+	line, err := cn.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("invalid redis reply: %q", line)
+	}
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, replyError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis bulk length: %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err = io.ReadFull(cn.reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	}
+	return nil, fmt.Errorf("unsupported redis reply: %q", line)
+}
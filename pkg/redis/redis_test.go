@@ -0,0 +1,150 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"crow/pkg/fakeredis"
+)
+
+func newTestServer(t *testing.T, password string) *fakeredis.Server {
+	t.Helper()
+	s, err := fakeredis.NewServer(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestGetSetDel(t *testing.T) {
+	s := newTestServer(t, "")
+	c := NewClient(Option{Addr: s.Addr()})
+	defer c.Close()
+	ctx := testContext(t)
+
+	// 键不存在时返回 nil 批量字符串
+	if _, err := c.Get(ctx, "k"); !errors.Is(err, ErrNil) {
+		t.Fatalf("get missing key: err = %v, want ErrNil", err)
+	}
+	// 值中包含换行等字符时按长度读取
+	value := "多行\r\n内容"
+	if err := c.Set(ctx, "k", value, 30*time.Second); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if ttl := s.TTL("k"); ttl != 30*time.Second {
+		t.Fatalf("ttl = %v, want 30s", ttl)
+	}
+	if got, err := c.Get(ctx, "k"); err != nil || got != value {
+		t.Fatalf("get = %q, %v, want %q", got, err, value)
+	}
+	if err := c.Set(ctx, "empty", "", 0); err != nil {
+		t.Fatalf("set empty: %v", err)
+	}
+	if got, err := c.Get(ctx, "empty"); err != nil || got != "" {
+		t.Fatalf("get empty = %q, %v", got, err)
+	}
+	if err := c.Del(ctx, "k", "empty"); err != nil {
+		t.Fatalf("del: %v", err)
+	}
+	if _, ok := s.Get("k"); ok {
+		t.Fatal("key still exists after del")
+	}
+	// 全部命令复用同一连接
+	if n := s.Dials(); n != 1 {
+		t.Fatalf("dials = %d, want 1", n)
+	}
+}
+
+func TestAuthSelect(t *testing.T) {
+	s := newTestServer(t, "secret")
+	ctx := testContext(t)
+
+	wrong := NewClient(Option{Addr: s.Addr(), Password: "wrong"})
+	defer wrong.Close()
+	if err := wrong.Set(ctx, "k", "v", 0); err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Fatalf("set with wrong password: err = %v, want WRONGPASS", err)
+	}
+
+	c := NewClient(Option{Addr: s.Addr(), Password: "secret", DB: 2})
+	defer c.Close()
+	if err := c.Set(ctx, "k", "v", 0); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if got, ok := s.GetDB(2, "k"); !ok || got != "v" {
+		t.Fatalf("db 2 value = %q, %v, want v", got, ok)
+	}
+	commands := s.Commands()
+	want := [][]string{{"AUTH", "secret"}, {"SELECT", "2"}, {"SET", "k", "v"}}
+	if got := commands[len(commands)-3:]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("commands = %q, want %q", got, want)
+	}
+}
+
+func TestErrorReply(t *testing.T) {
+	s := newTestServer(t, "")
+	c := NewClient(Option{Addr: s.Addr()})
+	defer c.Close()
+	ctx := testContext(t)
+
+	s.FailNext("GET", "ERR boom")
+	_, err := c.Get(ctx, "k")
+	var replyErr replyError
+	if !errors.As(err, &replyErr) || err.Error() != "redis: ERR boom" {
+		t.Fatalf("get: err = %v, want reply error", err)
+	}
+	// 错误回复后连接仍然可用，不会重新建立连接
+	if _, err = c.Get(ctx, "k"); !errors.Is(err, ErrNil) {
+		t.Fatalf("get after error reply: err = %v, want ErrNil", err)
+	}
+	if n := s.Dials(); n != 1 {
+		t.Fatalf("dials = %d, want 1", n)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	s := newTestServer(t, "secret")
+	c := NewClient(Option{Addr: s.Addr(), Password: "secret", DB: 1})
+	defer c.Close()
+	ctx := testContext(t)
+
+	if err := c.Set(ctx, "k", "v", 0); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	// 服务端断开空闲连接后，下一条命令重新建立连接并重新认证
+	s.DropConns()
+	if got, err := c.Get(ctx, "k"); err != nil || got != "v" {
+		t.Fatalf("get after reconnect = %q, %v, want v", got, err)
+	}
+	if n := s.Dials(); n != 2 {
+		t.Fatalf("dials = %d, want 2", n)
+	}
+
+	// 服务端不可用时返回错误
+	s.Close()
+	if _, err := c.Get(ctx, "k"); err == nil {
+		t.Fatal("get with server down succeeded")
+	}
+}
+
+func TestContextDeadline(t *testing.T) {
+	s := newTestServer(t, "")
+	c := NewClient(Option{Addr: s.Addr()})
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.Get(ctx, "k"); err == nil {
+		t.Fatal("get with canceled context succeeded")
+	}
+}
@@ -0,0 +1,59 @@
+package volcproto
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// gzip.Writer 内部的压缩状态约1MB，逐帧创建会带来大量的内存分配与GC压力，因此在帧之间复用
+var (
+	gzipWriterPool = sync.Pool{
+		New: func() any { return gzip.NewWriter(nil) },
+	}
+	gzipReaderPool sync.Pool // 首次使用时须通过 gzip.NewReader 创建，之后通过 Reset 复用
+)
+
+// GzipCompress gzip 压缩负载
+func GzipCompress(data []byte) ([]byte, error) {
+	var b bytes.Buffer
+	b.Grow(len(data)/2 + 64)
+	w := gzipWriterPool.Get().(*gzip.Writer)
+	w.Reset(&b)
+	_, err := w.Write(data)
+	if err == nil {
+		err = w.Close()
+	}
+	// 放回前解除对输出的引用，避免池中的 Writer 持有已返回给调用方的内存
+	w.Reset(nil)
+	gzipWriterPool.Put(w)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compress payload: %v", err)
+	}
+	return b.Bytes(), nil
+}
+
+// GzipDecompress 解压 gzip 压缩的负载
+func GzipDecompress(data []byte) ([]byte, error) {
+	var (
+		r   *gzip.Reader
+		err error
+	)
+	if v := gzipReaderPool.Get(); v != nil {
+		r = v.(*gzip.Reader)
+		err = r.Reset(bytes.NewReader(data))
+	} else {
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress payload: %v", err)
+	}
+	defer gzipReaderPool.Put(r)
+	out, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress payload: %v", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,149 @@
+package volcproto
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestGzipRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", []byte{}},
+		{"json", []byte(`{"text":"你好"}`)},
+		{"audio", bytes.Repeat([]byte{0x00, 0x7f, 0x80, 0xff}, 4096)},
+		{"text", []byte(strings.Repeat("crow ", 10000))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 多次往返以覆盖池中复用的 Writer 与 Reader
+			for i := 0; i < 3; i++ {
+				compressed, err := GzipCompress(tt.data)
+				if err != nil {
+					t.Fatalf("compress: %v", err)
+				}
+				got, err := GzipDecompress(compressed)
+				if err != nil {
+					t.Fatalf("decompress: %v", err)
+				}
+				if !bytes.Equal(got, tt.data) {
+					t.Fatalf("round trip = %d bytes, want %d bytes", len(got), len(tt.data))
+				}
+			}
+		})
+	}
+}
+
+func TestGzipConcurrent(t *testing.T) {
+	data := bytes.Repeat([]byte{0x00, 0x10, 0x20, 0x10, 0x00, 0xf0, 0xe0, 0xf0}, 400)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// 多个会话并发使用池中的 Writer 与 Reader
+			for j := 0; j < 50; j++ {
+				compressed, err := GzipCompress(data)
+				if err != nil {
+					t.Errorf("compress: %v", err)
+					return
+				}
+				got, err := GzipDecompress(compressed)
+				if err != nil || !bytes.Equal(got, data) {
+					t.Errorf("round trip = %d bytes (%v), want %d bytes", len(got), err, len(data))
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestGzipDecompressCorrupt(t *testing.T) {
+	valid, err := GzipCompress([]byte(strings.Repeat("crow ", 100)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flipped := bytes.Clone(valid)
+	flipped[len(flipped)-5] ^= 0xff // 破坏长度校验
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"not gzip", []byte(`{"text":"plain"}`)},
+		{"truncated header", valid[:5]},
+		{"truncated body", valid[:len(valid)/2]},
+		{"checksum mismatch", flipped},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := GzipDecompress(tt.data); err == nil {
+				t.Fatal("decompress succeeded, want error")
+			}
+		})
+	}
+	// 解压失败后放回池中的 Reader 仍可正常使用
+	if got, err := GzipDecompress(valid); err != nil || !bytes.HasPrefix(got, []byte("crow ")) {
+		t.Fatalf("decompress after corrupt input = %q, %v", got, err)
+	}
+}
+
+func TestDecodedPayload(t *testing.T) {
+	compressed, err := GzipCompress([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		msg     *Message
+		want    string
+		wantErr bool
+	}{
+		{"none", &Message{Compression: CompressionNone, Payload: []byte("hello")}, "hello", false},
+		{"gzip", &Message{Compression: CompressionGzip, Payload: compressed}, "hello", false},
+		{"gzip empty", &Message{Compression: CompressionGzip}, "", false},
+		{"gzip corrupt", &Message{Compression: CompressionGzip, Payload: []byte("hello")}, "", true},
+		{"custom", &Message{Compression: CompressionCustom, Payload: []byte("hello")}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.msg.DecodedPayload()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decoded payload error = %v, want error %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Fatalf("decoded payload = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkGzip(b *testing.B) {
+	data := bytes.Repeat([]byte{0x00, 0x10, 0x20, 0x10, 0x00, 0xf0, 0xe0, 0xf0}, 400) // 3200 字节的音频帧
+	compressed, err := GzipCompress(data)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run("compress", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := GzipCompress(data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("decompress", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := GzipDecompress(compressed); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
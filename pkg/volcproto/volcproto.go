@@ -0,0 +1,591 @@
+// Package volcproto 火山引擎（豆包）语音服务的二进制帧协议，仅负责帧的编解码，不涉及网络读写
+package volcproto
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+type (
+	// EventType defines the event type which determines the event of the message.
+	EventType int32
+	// MsgType defines message type which determines how the message will be
+	// serialized with the protocol.
+	MsgType uint8
+	// MsgTypeFlagBits defines the 4-bit message-type specific flags. The specific
+	// values should be defined in each specific usage scenario.
+	MsgTypeFlagBits uint8
+	// VersionBits defines the 4-bit version type.
+	VersionBits uint8
+	// HeaderSizeBits defines the 4-bit header-size type.
+	HeaderSizeBits uint8
+	// SerializationBits defines the 4-bit serialization method type.
+	SerializationBits uint8
+	// CompressionBits defines the 4-bit compression method type.
+	CompressionBits uint8
+)
+
+const (
+	MsgTypeFlagNoSeq       MsgTypeFlagBits = 0     // Non-terminal packet with no sequence
+	MsgTypeFlagPositiveSeq MsgTypeFlagBits = 0b1   // Non-terminal packet with sequence > 0
+	MsgTypeFlagLastNoSeq   MsgTypeFlagBits = 0b10  // last packet with no sequence
+	MsgTypeFlagNegativeSeq MsgTypeFlagBits = 0b11  // last packet with sequence < 0
+	MsgTypeFlagWithEvent   MsgTypeFlagBits = 0b100 // Payload contains event number (int32)
+)
+
+const (
+	Version1 VersionBits = iota + 1
+	Version2
+	Version3
+	Version4
+)
+
+const (
+	HeaderSize4 HeaderSizeBits = iota + 1
+	HeaderSize8
+	HeaderSize12
+	HeaderSize16
+)
+
+const (
+	SerializationRaw    SerializationBits = 0
+	SerializationJSON   SerializationBits = 0b1
+	SerializationThrift SerializationBits = 0b11
+	SerializationCustom SerializationBits = 0b1111
+)
+
+const (
+	CompressionNone   CompressionBits = 0
+	CompressionGzip   CompressionBits = 0b1
+	CompressionCustom CompressionBits = 0b1111
+)
+
+const (
+	MsgTypeInvalid              MsgType = 0
+	MsgTypeFullClientRequest    MsgType = 0b1
+	MsgTypeAudioOnlyClient      MsgType = 0b10
+	MsgTypeFullServerResponse   MsgType = 0b1001
+	MsgTypeAudioOnlyServer      MsgType = 0b1011
+	MsgTypeFrontEndResultServer MsgType = 0b1100
+	MsgTypeError                MsgType = 0b1111
+
+	MsgTypeServerACK = MsgTypeAudioOnlyServer
+)
+
+func (t MsgType) String() string {
+	switch t {
+	case MsgTypeFullClientRequest:
+		return "MsgType_FullClientRequest"
+	case MsgTypeAudioOnlyClient:
+		return "MsgType_AudioOnlyClient"
+	case MsgTypeFullServerResponse:
+		return "MsgType_FullServerResponse"
+	case MsgTypeAudioOnlyServer:
+		return "MsgType_AudioOnlyServer" // MsgTypeServerACK
+	case MsgTypeError:
+		return "MsgType_Error"
+	case MsgTypeFrontEndResultServer:
+		return "MsgType_FrontEndResultServer"
+	default:
+		return fmt.Sprintf("MsgType_(%d)", t)
+	}
+}
+
+const (
+	// Default event, applicable for scenarios not using events or not requiring event transmission,
+	// or for scenarios using events, non-zero values can be used to validate event legitimacy
+	EventType_None EventType = 0
+	// 1 ~ 49 for upstream Connection events
+	EventType_StartConnection  EventType = 1
+	EventType_StartTask        EventType = 1 // Alias of "startConnection"
+	EventType_FinishConnection EventType = 2
+	EventType_FinishTask       EventType = 2 // Alias of "FinishConnection"
+	// 50 ~ 99 for downstream Connection events
+	// Connection established successfully
+	EventType_ConnectionStarted EventType = 50
+	EventType_TaskStarted       EventType = 50 // Alias of "ConnectionStarted"
+	// Connection failed (possibly due to authentication failure)
+	EventType_ConnectionFailed EventType = 51
+	EventType_TaskFailed       EventType = 51 // Alias of "ConnectionFailed"
+	// Connection ended
+	EventType_ConnectionFinished EventType = 52
+	EventType_TaskFinished       EventType = 52 // Alias of "ConnectionFinished"
+	// 100 ~ 149 for upstream Session events
+	EventType_StartSession  EventType = 100
+	EventType_CancelSession EventType = 101
+	EventType_FinishSession EventType = 102
+	// 150 ~ 199 for downstream Session events
+	EventType_SessionStarted  EventType = 150
+	EventType_SessionCanceled EventType = 151
+	EventType_SessionFinished EventType = 152
+	EventType_SessionFailed   EventType = 153
+	// Usage events
+	EventType_UsageResponse EventType = 154
+	EventType_ChargeData    EventType = 154 // Alias of "UsageResponse"
+	// 200 ~ 249 for upstream general events
+	EventType_TaskRequest  EventType = 200
+	EventType_UpdateConfig EventType = 201
+	// 250 ~ 299 for downstream general events
+	EventType_AudioMuted EventType = 250
+	// 300 ~ 349 for upstream TTS events
+	EventType_SayHello EventType = 300
+	// 350 ~ 399 for downstream TTS events
+	EventType_TTSSentenceStart     EventType = 350
+	EventType_TTSSentenceEnd       EventType = 351
+	EventType_TTSResponse          EventType = 352
+	EventType_TTSEnded             EventType = 359
+	EventType_PodcastRoundStart    EventType = 360
+	EventType_PodcastRoundResponse EventType = 361
+	EventType_PodcastRoundEnd      EventType = 362
+	// 450 ~ 499 for downstream ASR events
+	EventType_ASRInfo     EventType = 450
+	EventType_ASRResponse EventType = 451
+	EventType_ASREnded    EventType = 459
+	// 500 ~ 549 for upstream dialogue events
+	// (Ground-Truth-Alignment) text for speech synthesis
+	EventType_ChatTTSText EventType = 500
+	// 550 ~ 599 for downstream dialogue events
+	EventType_ChatResponse EventType = 550
+	EventType_ChatEnded    EventType = 559
+	// 650 ~ 699 for downstream dialogue events
+	// Events for source (original) language subtitle.
+	EventType_SourceSubtitleStart    EventType = 650
+	EventType_SourceSubtitleResponse EventType = 651
+	EventType_SourceSubtitleEnd      EventType = 652
+	// Events for target (translation) language subtitle.
+	EventType_TranslationSubtitleStart    EventType = 653
+	EventType_TranslationSubtitleResponse EventType = 654
+	EventType_TranslationSubtitleEnd      EventType = 655
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventType_None:
+		return "EventType_None"
+	case EventType_StartConnection:
+		return "EventType_StartConnection"
+	case EventType_FinishConnection:
+		return "EventType_FinishConnection"
+	case EventType_ConnectionStarted:
+		return "EventType_ConnectionStarted"
+	case EventType_ConnectionFailed:
+		return "EventType_ConnectionFailed"
+	case EventType_ConnectionFinished:
+		return "EventType_ConnectionFinished"
+	case EventType_StartSession:
+		return "EventType_StartSession"
+	case EventType_CancelSession:
+		return "EventType_CancelSession"
+	case EventType_FinishSession:
+		return "EventType_FinishSession"
+	case EventType_SessionStarted:
+		return "EventType_SessionStarted"
+	case EventType_SessionCanceled:
+		return "EventType_SessionCanceled"
+	case EventType_SessionFinished:
+		return "EventType_SessionFinished"
+	case EventType_SessionFailed:
+		return "EventType_SessionFailed"
+	case EventType_UsageResponse:
+		return "EventType_UsageResponse"
+	case EventType_TaskRequest:
+		return "EventType_TaskRequest"
+	case EventType_UpdateConfig:
+		return "EventType_UpdateConfig"
+	case EventType_AudioMuted:
+		return "EventType_AudioMuted"
+	case EventType_SayHello:
+		return "EventType_SayHello"
+	case EventType_TTSSentenceStart:
+		return "EventType_TTSSentenceStart"
+	case EventType_TTSSentenceEnd:
+		return "EventType_TTSSentenceEnd"
+	case EventType_TTSResponse:
+		return "EventType_TTSResponse"
+	case EventType_TTSEnded:
+		return "EventType_TTSEnded"
+	case EventType_PodcastRoundStart:
+		return "EventType_PodcastRoundStart"
+	case EventType_PodcastRoundResponse:
+		return "EventType_PodcastRoundResponse"
+	case EventType_PodcastRoundEnd:
+		return "EventType_PodcastRoundEnd"
+	case EventType_ASRInfo:
+		return "EventType_ASRInfo"
+	case EventType_ASRResponse:
+		return "EventType_ASRResponse"
+	case EventType_ASREnded:
+		return "EventType_ASREnded"
+	case EventType_ChatTTSText:
+		return "EventType_ChatTTSText"
+	case EventType_ChatResponse:
+		return "EventType_ChatResponse"
+	case EventType_ChatEnded:
+		return "EventType_ChatEnded"
+	case EventType_SourceSubtitleStart:
+		return "EventType_SourceSubtitleStart"
+	case EventType_SourceSubtitleResponse:
+		return "EventType_SourceSubtitleResponse"
+	case EventType_SourceSubtitleEnd:
+		return "EventType_SourceSubtitleEnd"
+	case EventType_TranslationSubtitleStart:
+		return "EventType_TranslationSubtitleStart"
+	case EventType_TranslationSubtitleResponse:
+		return "EventType_TranslationSubtitleResponse"
+	case EventType_TranslationSubtitleEnd:
+		return "EventType_TranslationSubtitleEnd"
+	default:
+		return fmt.Sprintf("EventType_(%d)", t)
+	}
+}
+
+// ErrPartialFrame 帧不完整，长度不足以解析出协议头或声明的字段
+var ErrPartialFrame = errors.New("partial frame")
+
+// 0                 1                 2                 3
+// | 0 1 2 3 4 5 6 7 | 0 1 2 3 4 5 6 7 | 0 1 2 3 4 5 6 7 | 0 1 2 3 4 5 6 7 |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |    Version      |   Header Size   |     Msg Type    |      Flags      |
+// |   (4 bits)      |    (4 bits)     |     (4 bits)    |     (4 bits)    |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// | Serialization   |   Compression   |           Reserved                |
+// |   (4 bits)      |    (4 bits)     |           (8 bits)                |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                                                                       |
+// |                   Optional Header Extensions                          |
+// |                     (if Header Size > 1)                              |
+// |                                                                       |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                                                                       |
+// |                           Payload                                     |
+// |                      (variable length)                                |
+// |                                                                       |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+
+type Message struct {
+	Version       VersionBits
+	HeaderSize    HeaderSizeBits
+	MsgType       MsgType
+	MsgTypeFlag   MsgTypeFlagBits
+	Serialization SerializationBits
+	Compression   CompressionBits
+
+	EventType EventType
+	SessionID string
+	ConnectID string
+	Sequence  int32
+	ErrorCode uint32
+
+	Payload []byte
+}
+
+// NewMessage 创建消息，默认为版本1、4字节协议头、JSON 序列化且不压缩
+func NewMessage(msgType MsgType, flag MsgTypeFlagBits) *Message {
+	return &Message{
+		MsgType:       msgType,
+		MsgTypeFlag:   flag,
+		Version:       Version1,
+		HeaderSize:    HeaderSize4,
+		Serialization: SerializationJSON,
+		Compression:   CompressionNone,
+	}
+}
+
+// Parse 解析一个完整的帧
+func Parse(data []byte) (*Message, error) {
+	msg := &Message{}
+	if err := msg.Unmarshal(data); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (m *Message) String() string {
+	switch m.MsgType {
+	case MsgTypeAudioOnlyServer, MsgTypeAudioOnlyClient:
+		if m.hasSequence() {
+			return fmt.Sprintf("%s, %s, Sequence: %d, PayloadSize: %d", m.MsgType, m.EventType, m.Sequence, len(m.Payload))
+		}
+		return fmt.Sprintf("%s, %s, PayloadSize: %d", m.MsgType, m.EventType, len(m.Payload))
+	case MsgTypeError:
+		return fmt.Sprintf("%s, %s, ErrorCode: %d, Payload: %s", m.MsgType, m.EventType, m.ErrorCode, string(m.Payload))
+	default:
+		if m.hasSequence() {
+			return fmt.Sprintf("%s, %s, Sequence: %d, Payload: %s",
+				m.MsgType, m.EventType, m.Sequence, string(m.Payload))
+		}
+		return fmt.Sprintf("%s, %s, Payload: %s", m.MsgType, m.EventType, string(m.Payload))
+	}
+}
+
+// IsLast 是否为最后一包，由标志位或负的序号表示
+func (m *Message) IsLast() bool {
+	switch m.MsgTypeFlag {
+	case MsgTypeFlagLastNoSeq, MsgTypeFlagNegativeSeq:
+		return true
+	case MsgTypeFlagPositiveSeq:
+		return m.Sequence < 0
+	}
+	return false
+}
+
+// DecodedPayload 按压缩方式解压后的负载
+func (m *Message) DecodedPayload() ([]byte, error) {
+	switch m.Compression {
+	case CompressionNone:
+		return m.Payload, nil
+	case CompressionGzip:
+		if len(m.Payload) == 0 {
+			return m.Payload, nil
+		}
+		return GzipDecompress(m.Payload)
+	}
+	return nil, fmt.Errorf("unsupported compression: %d", m.Compression)
+}
+
+// Marshal 编码为完整的帧，预先计算帧长度，整个帧只分配一次内存，适合音频帧等高频调用
+func (m *Message) Marshal() ([]byte, error) {
+	var arr [maxFields]fieldKind
+	fields, err := m.fields(arr[:0])
+	if err != nil {
+		return nil, err
+	}
+
+	headerSize := max(4*int(m.HeaderSize), 3)
+	size := headerSize
+	for _, f := range fields {
+		n, err := m.fieldSize(f)
+		if err != nil {
+			return nil, err
+		}
+		size += n
+	}
+
+	buf := make([]byte, headerSize, size)
+	buf[0] = uint8(m.Version)<<4 | uint8(m.HeaderSize)
+	buf[1] = uint8(m.MsgType)<<4 | uint8(m.MsgTypeFlag)
+	buf[2] = uint8(m.Serialization)<<4 | uint8(m.Compression)
+	for _, f := range fields {
+		buf = m.appendField(buf, f)
+	}
+	return buf, nil
+}
+
+func (m *Message) Unmarshal(data []byte) error {
+	if len(data) < 3 {
+		return fmt.Errorf("%w: expected at least 3 bytes, got %d", ErrPartialFrame, len(data))
+	}
+
+	m.Version = VersionBits(data[0] >> 4)
+	m.HeaderSize = HeaderSizeBits(data[0] & 0b00001111)
+	m.MsgType = MsgType(data[1] >> 4)
+	m.MsgTypeFlag = MsgTypeFlagBits(data[1] & 0b00001111)
+	m.Serialization = SerializationBits(data[2] >> 4)
+	m.Compression = CompressionBits(data[2] & 0b00001111)
+
+	headerSize := max(4*int(m.HeaderSize), 3)
+	if len(data) < headerSize {
+		return fmt.Errorf("%w: header of %d bytes, got %d", ErrPartialFrame, headerSize, len(data))
+	}
+
+	var arr [maxFields]fieldKind
+	fields, err := m.fields(arr[:0])
+	if err != nil {
+		return err
+	}
+	r := &reader{data: data[headerSize:]}
+	for _, f := range fields {
+		if err := m.readField(r, f); err != nil {
+			return err
+		}
+	}
+
+	if len(r.data) > 0 {
+		return fmt.Errorf("unexpected %d bytes after message", len(r.data))
+	}
+	return nil
+}
+
+// fieldKind 协议头之后的一个字段，编码与解码使用同一份字段顺序，保证两者一致
+type fieldKind uint8
+
+const (
+	fieldSequence fieldKind = iota
+	fieldErrorCode
+	fieldEvent
+	fieldSessionID
+	fieldConnectID
+	fieldPayload
+)
+
+// maxFields 一个帧最多包含的字段数
+const maxFields = 5
+
+// fields 协议头之后依次出现的字段：序号或错误码、事件号、会话ID、连接ID、负载
+// 字段追加到 dst 中返回，调用方可传入栈上的数组以避免分配
+func (m *Message) fields(dst []fieldKind) ([]fieldKind, error) {
+	switch m.MsgType {
+	case MsgTypeFullClientRequest, MsgTypeFullServerResponse, MsgTypeFrontEndResultServer, MsgTypeAudioOnlyClient, MsgTypeAudioOnlyServer:
+		if m.hasSequence() {
+			dst = append(dst, fieldSequence)
+		}
+	case MsgTypeError:
+		dst = append(dst, fieldErrorCode)
+	default:
+		return nil, fmt.Errorf("unsupported message type: %d", m.MsgType)
+	}
+
+	if m.MsgTypeFlag == MsgTypeFlagWithEvent {
+		// 是否携带会话ID、连接ID取决于事件号，解码时须在读取事件号之后判断
+		dst = append(dst, fieldEvent, fieldSessionID, fieldConnectID)
+	}
+
+	return append(dst, fieldPayload), nil
+}
+
+func (m *Message) hasSequence() bool {
+	return m.MsgTypeFlag == MsgTypeFlagPositiveSeq || m.MsgTypeFlag == MsgTypeFlagNegativeSeq
+}
+
+// hasSessionID 连接级事件不携带会话ID
+func (m *Message) hasSessionID() bool {
+	switch m.EventType {
+	case EventType_StartConnection, EventType_FinishConnection,
+		EventType_ConnectionStarted, EventType_ConnectionFailed,
+		EventType_ConnectionFinished:
+		return false
+	}
+	return true
+}
+
+// hasConnectID 仅服务端下发的连接级事件携带连接ID
+func (m *Message) hasConnectID() bool {
+	switch m.EventType {
+	case EventType_ConnectionStarted, EventType_ConnectionFailed,
+		EventType_ConnectionFinished:
+		return true
+	}
+	return false
+}
+
+// fieldSize 字段编码后的字节数，变长字段为4字节长度加内容
+func (m *Message) fieldSize(f fieldKind) (int, error) {
+	var name string
+	var size int
+	switch f {
+	case fieldSequence, fieldErrorCode, fieldEvent:
+		return 4, nil
+	case fieldSessionID:
+		if !m.hasSessionID() {
+			return 0, nil
+		}
+		name, size = "session ID", len(m.SessionID)
+	case fieldConnectID:
+		if !m.hasConnectID() {
+			return 0, nil
+		}
+		name, size = "connect ID", len(m.ConnectID)
+	default:
+		name, size = "payload", len(m.Payload)
+	}
+	if uint64(size) > math.MaxUint32 {
+		return 0, fmt.Errorf("%s size (%d) exceeds max(uint32)", name, size)
+	}
+	return 4 + size, nil
+}
+
+// appendField 以大端序追加字段，长度已由 fieldSize 校验
+func (m *Message) appendField(buf []byte, f fieldKind) []byte {
+	switch f {
+	case fieldSequence:
+		return binary.BigEndian.AppendUint32(buf, uint32(m.Sequence))
+	case fieldErrorCode:
+		return binary.BigEndian.AppendUint32(buf, m.ErrorCode)
+	case fieldEvent:
+		return binary.BigEndian.AppendUint32(buf, uint32(m.EventType))
+	case fieldSessionID:
+		if !m.hasSessionID() {
+			return buf
+		}
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(m.SessionID)))
+		return append(buf, m.SessionID...)
+	case fieldConnectID:
+		if !m.hasConnectID() {
+			return buf
+		}
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(m.ConnectID)))
+		return append(buf, m.ConnectID...)
+	default:
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(m.Payload)))
+		return append(buf, m.Payload...)
+	}
+}
+
+func (m *Message) readField(r *reader, f fieldKind) error {
+	switch f {
+	case fieldSequence:
+		v, err := r.uint32("sequence")
+		m.Sequence = int32(v)
+		return err
+	case fieldErrorCode:
+		v, err := r.uint32("error code")
+		m.ErrorCode = v
+		return err
+	case fieldEvent:
+		v, err := r.uint32("event")
+		m.EventType = EventType(v)
+		return err
+	case fieldSessionID:
+		if !m.hasSessionID() {
+			return nil
+		}
+		data, err := r.bytes("session ID")
+		m.SessionID = string(data)
+		return err
+	case fieldConnectID:
+		if !m.hasConnectID() {
+			return nil
+		}
+		data, err := r.bytes("connect ID")
+		m.ConnectID = string(data)
+		return err
+	default:
+		data, err := r.bytes("payload")
+		m.Payload = data
+		return err
+	}
+}
+
+// reader 按顺序读取协议头之后的字段，负载直接引用原始数据，不进行复制
+type reader struct {
+	data []byte
+}
+
+// uint32 读取4字节大端整数
+func (r *reader) uint32(name string) (uint32, error) {
+	if len(r.data) < 4 {
+		return 0, fmt.Errorf("%w: %s needs 4 bytes, got %d", ErrPartialFrame, name, len(r.data))
+	}
+	v := binary.BigEndian.Uint32(r.data)
+	r.data = r.data[4:]
+	return v, nil
+}
+
+// bytes 读取4字节大端长度及对应长度的内容，长度为0时返回 nil
+func (r *reader) bytes(name string) ([]byte, error) {
+	size, err := r.uint32(name + " size")
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(r.data)) < uint64(size) {
+		return nil, fmt.Errorf("%w: %s needs %d bytes, got %d", ErrPartialFrame, name, size, len(r.data))
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	data := r.data[:size:size]
+	r.data = r.data[size:]
+	return data, nil
+}
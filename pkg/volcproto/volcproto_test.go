@@ -0,0 +1,208 @@
+package volcproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMarshalUnmarshal(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  *Message
+	}{
+		{"full client request", &Message{MsgType: MsgTypeFullClientRequest, MsgTypeFlag: MsgTypeFlagNoSeq, Payload: []byte(`{"a":1}`)}},
+		{"full client request last", &Message{MsgType: MsgTypeFullClientRequest, MsgTypeFlag: MsgTypeFlagLastNoSeq, Payload: []byte(`{}`)}},
+		{"audio only client positive seq", &Message{MsgType: MsgTypeAudioOnlyClient, MsgTypeFlag: MsgTypeFlagPositiveSeq, Sequence: 7, Payload: []byte{1, 2, 3}}},
+		{"audio only client negative seq", &Message{MsgType: MsgTypeAudioOnlyClient, MsgTypeFlag: MsgTypeFlagNegativeSeq, Sequence: -8, Payload: []byte{4}}},
+		{"full server response", &Message{MsgType: MsgTypeFullServerResponse, MsgTypeFlag: MsgTypeFlagPositiveSeq, Sequence: 1, Payload: []byte(`{"text":"hi"}`)}},
+		{"audio only server", &Message{MsgType: MsgTypeAudioOnlyServer, MsgTypeFlag: MsgTypeFlagNoSeq, Payload: []byte{0xff, 0x00}}},
+		{"front end result", &Message{MsgType: MsgTypeFrontEndResultServer, MsgTypeFlag: MsgTypeFlagNoSeq, Payload: []byte(`{}`)}},
+		{"error", &Message{MsgType: MsgTypeError, MsgTypeFlag: MsgTypeFlagNoSeq, ErrorCode: 45000001, Payload: []byte(`bad request`)}},
+		{"empty payload", &Message{MsgType: MsgTypeFullClientRequest, MsgTypeFlag: MsgTypeFlagNoSeq}},
+		{"session event", &Message{MsgType: MsgTypeFullClientRequest, MsgTypeFlag: MsgTypeFlagWithEvent, EventType: EventType_StartSession, SessionID: "session", Payload: []byte(`{}`)}},
+		{"connection event without session", &Message{MsgType: MsgTypeFullClientRequest, MsgTypeFlag: MsgTypeFlagWithEvent, EventType: EventType_StartConnection, Payload: []byte(`{}`)}},
+		{"connection started with connect id", &Message{MsgType: MsgTypeFullServerResponse, MsgTypeFlag: MsgTypeFlagWithEvent, EventType: EventType_ConnectionStarted, ConnectID: "connect"}},
+		{"audio event", &Message{MsgType: MsgTypeAudioOnlyServer, MsgTypeFlag: MsgTypeFlagWithEvent, EventType: EventType_TTSResponse, SessionID: "session", Payload: []byte{9, 9}}},
+		{"gzip raw serialization", &Message{MsgType: MsgTypeAudioOnlyClient, MsgTypeFlag: MsgTypeFlagNoSeq, Serialization: SerializationRaw, Compression: CompressionGzip, Payload: []byte{1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := *tt.msg
+			want.Version, want.HeaderSize = Version1, HeaderSize4
+			if want.Serialization == 0 && want.Compression == 0 {
+				want.Serialization = SerializationJSON
+			}
+			data, err := want.Marshal()
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			got, err := Parse(data)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if !reflect.DeepEqual(*got, want) {
+				t.Fatalf("round trip = %+v, want %+v", *got, want)
+			}
+		})
+	}
+}
+
+func TestMarshalLayout(t *testing.T) {
+	msg := NewMessage(MsgTypeAudioOnlyClient, MsgTypeFlagNegativeSeq)
+	msg.Sequence = -2
+	msg.Payload = []byte("ab")
+	data, err := msg.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0x11, 0x23, 0x10, 0x00, // 版本1、4字节协议头、仅音频、负序号、JSON、不压缩
+		0xff, 0xff, 0xff, 0xfe, // 序号 -2
+		0x00, 0x00, 0x00, 0x02, 'a', 'b', // 负载
+	}
+	if !bytes.Equal(data, want) {
+		t.Fatalf("marshal = % x, want % x", data, want)
+	}
+	if cap(data) != len(data) {
+		t.Fatalf("marshal allocated %d bytes for a %d-byte frame", cap(data), len(data))
+	}
+}
+
+func TestUnmarshalPartialFrame(t *testing.T) {
+	msg := &Message{Version: Version1, HeaderSize: HeaderSize4, MsgType: MsgTypeFullServerResponse,
+		MsgTypeFlag: MsgTypeFlagWithEvent, EventType: EventType_SessionStarted, SessionID: "session", Payload: []byte(`{}`)}
+	frame, err := msg.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short header", frame[:2]},
+		{"header only", frame[:4]},
+		{"short event", frame[:6]},
+		{"short session id size", frame[:10]},
+		{"short session id", frame[:14]},
+		{"short payload size", frame[:4+4+4+7+2]},
+		{"short payload", frame[:len(frame)-1]},
+		{"header size beyond frame", []byte{0x12, 0x10, 0x10, 0x00, 0x00}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.data); !errors.Is(err, ErrPartialFrame) {
+				t.Fatalf("parse error = %v, want ErrPartialFrame", err)
+			}
+		})
+	}
+}
+
+func TestUnmarshalOversizedLength(t *testing.T) {
+	tests := []struct {
+		name string
+		size uint32
+	}{
+		{"one byte more", 3},
+		{"max int32", 1<<31 - 1},
+		{"max uint32", 1<<32 - 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := []byte{0x11, 0x10, 0x10, 0x00}
+			data = binary.BigEndian.AppendUint32(data, tt.size)
+			data = append(data, "ab"...)
+			if _, err := Parse(data); !errors.Is(err, ErrPartialFrame) {
+				t.Fatalf("parse error = %v, want ErrPartialFrame", err)
+			}
+		})
+	}
+}
+
+func TestUnmarshalTrailingBytes(t *testing.T) {
+	frame, err := NewMessage(MsgTypeFullClientRequest, MsgTypeFlagNoSeq).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Parse(append(frame, 0x00))
+	if err == nil || errors.Is(err, ErrPartialFrame) {
+		t.Fatalf("parse error = %v, want trailing bytes error", err)
+	}
+}
+
+func TestUnsupportedMessageType(t *testing.T) {
+	if _, err := NewMessage(MsgTypeInvalid, MsgTypeFlagNoSeq).Marshal(); err == nil {
+		t.Fatal("marshal invalid message type succeeded")
+	}
+	if _, err := Parse([]byte{0x11, 0x00, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00}); err == nil {
+		t.Fatal("parse invalid message type succeeded")
+	}
+}
+
+func TestIsLast(t *testing.T) {
+	tests := []struct {
+		flag     MsgTypeFlagBits
+		sequence int32
+		want     bool
+	}{
+		{MsgTypeFlagNoSeq, 0, false},
+		{MsgTypeFlagPositiveSeq, 3, false},
+		{MsgTypeFlagPositiveSeq, -3, true},
+		{MsgTypeFlagLastNoSeq, 0, true},
+		{MsgTypeFlagNegativeSeq, -1, true},
+		{MsgTypeFlagWithEvent, 0, false},
+	}
+	for _, tt := range tests {
+		msg := &Message{MsgTypeFlag: tt.flag, Sequence: tt.sequence}
+		if got := msg.IsLast(); got != tt.want {
+			t.Errorf("IsLast(flag=%d, sequence=%d) = %v, want %v", tt.flag, tt.sequence, got, tt.want)
+		}
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	audio := NewMessage(MsgTypeAudioOnlyClient, MsgTypeFlagNoSeq)
+	audio.Serialization = SerializationRaw
+	audio.Payload = make([]byte, 3200) // 100ms 的 16k 16bit 单声道音频
+	event := NewMessage(MsgTypeFullClientRequest, MsgTypeFlagWithEvent)
+	event.EventType = EventType_StartSession
+	event.SessionID = "session"
+	event.Payload = []byte(`{"req_params":{"speaker":"zh_female_cancan_mars_bigtts"}}`)
+	for _, bm := range []struct {
+		name string
+		msg  *Message
+	}{
+		{"audio", audio},
+		{"event", event},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := bm.msg.Marshal(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	msg := NewMessage(MsgTypeFullServerResponse, MsgTypeFlagWithEvent)
+	msg.EventType = EventType_SessionStarted
+	msg.SessionID = "session"
+	msg.Payload = []byte(`{"result":{"text":"今天天气怎么样"}}`)
+	frame, err := msg.Marshal()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Parse(frame); err != nil {
+			b.Fatal(err)
+		}
+	}
+}